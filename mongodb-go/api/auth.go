@@ -0,0 +1,118 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenLeeway tolerates clock skew when checking token lifetimes
+const tokenLeeway = time.Minute
+
+var (
+	// errWritesDisabled is returned for writes while API_WRITES_ENABLED is off
+	errWritesDisabled = errors.New("writes are disabled on this server")
+	// errUnauthorized is returned for writes without a valid bearer token
+	errUnauthorized = errors.New("a valid bearer token is required")
+)
+
+// authorizeWrite checks that a request may change data: writes must be
+// enabled and, when JWT_SECRET is set, the request must carry a bearer token
+// signed with it
+func (s *Server) authorizeWrite(r *http.Request) error {
+	if !s.cfg.WritesEnabled {
+		return errWritesDisabled
+	}
+	if s.cfg.JWTSecret == "" {
+		return nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return errUnauthorized
+	}
+	if err := verifyToken(strings.TrimSpace(token), []byte(s.cfg.JWTSecret), time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", errUnauthorized, err)
+	}
+	return nil
+}
+
+// requireWrite reports whether a request may change data, answering it with
+// 401 or 403 when it may not
+func (s *Server) requireWrite(w http.ResponseWriter, r *http.Request) bool {
+	err := s.authorizeWrite(r)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, errUnauthorized) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="SensorThings"`)
+	}
+	s.writeRepositoryError(w, err)
+	return false
+}
+
+// verifyToken checks a JSON Web Token signed with HMAC SHA-256 and, when it
+// has them, its exp and nbf claims
+func verifyToken(token string, secret []byte, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != "HS256" {
+		return fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed token signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("invalid token signature")
+	}
+
+	var claims struct {
+		ExpiresAt *float64 `json:"exp"`
+		NotBefore *float64 `json:"nbf"`
+	}
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return err
+	}
+	if claims.ExpiresAt != nil && now.Add(-tokenLeeway).After(unixTime(*claims.ExpiresAt)) {
+		return fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(tokenLeeway).Before(unixTime(*claims.NotBefore)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	return nil
+}
+
+// decodeTokenPart decodes a base64url encoded JSON part of a token
+func decodeTokenPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("malformed token: %v", err)
+	}
+	return nil
+}
+
+// unixTime converts a NumericDate claim into a time
+func unixTime(seconds float64) time.Time {
+	return time.UnixMilli(int64(seconds * 1000))
+}
@@ -0,0 +1,421 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BatchPath is the resource of JSON batch requests
+const BatchPath = "$batch"
+
+// maxBatchRequests limits the number of requests in one batch
+const maxBatchRequests = 1000
+
+// batchRequest is one request of a JSON batch. Its url is relative to the
+// service root and may start with $id to address the entity created by an
+// earlier request of the batch.
+type batchRequest struct {
+	ID             string            `json:"id"`
+	AtomicityGroup string            `json:"atomicityGroup,omitempty"`
+	DependsOn      []string          `json:"dependsOn,omitempty"`
+	Method         string            `json:"method"`
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Body           json.RawMessage   `json:"body,omitempty"`
+}
+
+// batchResponse is the response to one request of a JSON batch
+type batchResponse struct {
+	ID             string            `json:"id"`
+	AtomicityGroup string            `json:"atomicityGroup,omitempty"`
+	Status         int               `json:"status"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Body           interface{}       `json:"body,omitempty"`
+}
+
+// batchChange is a write of an atomicity group, kept to build its response after commit
+type batchChange struct {
+	method string
+	t      *entityType
+	id     interface{}
+}
+
+// batchRun is the state of a batch as its requests are processed in order
+type batchRun struct {
+	s    *Server
+	r    *http.Request
+	root string
+	// paths maps the ids of requests that created an entity to its resource path
+	paths map[string]string
+	// failed holds the ids of failed requests and atomicity groups
+	failed map[string]bool
+}
+
+// handleBatch processes a SensorThings JSON batch. Requests run in order;
+// those sharing an atomicityGroup form a change set whose writes commit or
+// roll back together in one transaction. A request whose dependsOn names a
+// failed request or group is not run and reported as 424 Failed Dependency.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWriteBody))
+	if err != nil {
+		s.writeRepositoryError(w, invalidRequest("failed to read request body: %v", err))
+		return
+	}
+	var batch struct {
+		Requests []batchRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		s.writeRepositoryError(w, invalidRequest("request body must be a JSON batch: %v", err))
+		return
+	}
+	if err := validateBatch(batch.Requests); err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	for _, req := range batch.Requests {
+		if !strings.EqualFold(req.Method, http.MethodGet) {
+			if !s.requireWrite(w, r) {
+				return
+			}
+			break
+		}
+	}
+
+	run := &batchRun{
+		s:      s,
+		r:      r,
+		root:   s.serviceRoot(r),
+		paths:  map[string]string{},
+		failed: map[string]bool{},
+	}
+	responses := make([]batchResponse, 0, len(batch.Requests))
+	for i := 0; i < len(batch.Requests); {
+		group := batch.Requests[i].AtomicityGroup
+		if group == "" {
+			responses = append(responses, run.request(&batch.Requests[i]))
+			i++
+			continue
+		}
+		end := i
+		for end < len(batch.Requests) && batch.Requests[end].AtomicityGroup == group {
+			end++
+		}
+		responses = append(responses, run.changeSet(batch.Requests[i:end])...)
+		i = end
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"responses": responses})
+}
+
+// validateBatch checks request ids, that the requests of each atomicity group
+// are adjacent, and that requests only depend on those before them
+func validateBatch(requests []batchRequest) error {
+	if len(requests) == 0 {
+		return invalidRequest("batch has no requests")
+	}
+	if len(requests) > maxBatchRequests {
+		return invalidRequest("batch is limited to %d requests", maxBatchRequests)
+	}
+
+	seen := map[string]bool{}
+	ended := map[string]bool{}
+	group := ""
+	for _, req := range requests {
+		if req.ID == "" {
+			return invalidRequest("every batch request needs an id")
+		}
+		if seen[req.ID] {
+			return invalidRequest("batch request id %q is used twice", req.ID)
+		}
+		if req.AtomicityGroup != group {
+			if group != "" {
+				ended[group] = true
+			}
+			if ended[req.AtomicityGroup] {
+				return invalidRequest("requests of atomicity group %q must be adjacent", req.AtomicityGroup)
+			}
+			group = req.AtomicityGroup
+		}
+		for _, dep := range req.DependsOn {
+			if !seen[dep] && !ended[dep] && dep != group {
+				return invalidRequest("batch request %q depends on %q, which does not precede it", req.ID, dep)
+			}
+		}
+		seen[req.ID] = true
+	}
+	return nil
+}
+
+// request runs a request outside any atomicity group through the router,
+// in a transaction of its own if it writes
+func (b *batchRun) request(req *batchRequest) batchResponse {
+	resp := batchResponse{ID: req.ID}
+	if dep := b.failedDependency(req); dep != "" {
+		b.failed[req.ID] = true
+		return b.errorResponse(resp, http.StatusFailedDependency, fmt.Sprintf("%s failed", dep))
+	}
+
+	httpReq, err := b.httpRequest(req)
+	if err != nil {
+		b.failed[req.ID] = true
+		status, message := b.s.errorStatus(err)
+		return b.errorResponse(resp, status, message)
+	}
+	rec := &batchRecorder{header: http.Header{}}
+	b.s.route(rec, httpReq)
+
+	resp.Status = rec.status
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	if location := rec.header.Get("Location"); location != "" {
+		resp.Headers = map[string]string{"location": location}
+		b.paths[req.ID] = strings.TrimPrefix(location, b.root+"/")
+	}
+	if body := bytes.TrimSpace(rec.body.Bytes()); len(body) > 0 {
+		resp.Body = json.RawMessage(body)
+	}
+	if resp.Status >= http.StatusBadRequest {
+		b.failed[req.ID] = true
+	}
+	return resp
+}
+
+// httpRequest builds the HTTP request routed for a batch request
+func (b *batchRun) httpRequest(req *batchRequest) (*http.Request, error) {
+	path, query, err := b.resolveURL(req.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(path, BatchPath) {
+		return nil, invalidRequest("batches cannot be nested")
+	}
+
+	u := &url.URL{Path: "/" + APIVersion + "/" + path, RawQuery: query}
+	httpReq, err := http.NewRequestWithContext(b.r.Context(), strings.ToUpper(req.Method), u.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, invalidRequest("invalid batch request %q: %v", req.ID, err)
+	}
+	// Links in responses are built from the batch request
+	httpReq.Host = b.r.Host
+	httpReq.TLS = b.r.TLS
+	if proto := b.r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		httpReq.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if auth := b.r.Header.Get("Authorization"); auth != "" {
+		httpReq.Header.Set("Authorization", auth)
+	}
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	return httpReq, nil
+}
+
+// changeSet runs the requests of an atomicity group in one transaction.
+// If one fails, it reports its error and the others 424 Failed Dependency.
+func (b *batchRun) changeSet(requests []batchRequest) []batchResponse {
+	group := requests[0].AtomicityGroup
+	responses := make([]batchResponse, len(requests))
+	for i := range requests {
+		responses[i] = batchResponse{ID: requests[i].ID, AtomicityGroup: group}
+	}
+	// fail reports the failure of the request at index at, or of all if at is -1
+	fail := func(at, status int, message string) []batchResponse {
+		b.failed[group] = true
+		for i := range responses {
+			b.failed[responses[i].ID] = true
+			if at >= 0 && i != at {
+				responses[i] = b.errorResponse(responses[i], http.StatusFailedDependency,
+					fmt.Sprintf("atomicity group %s was rolled back", group))
+				continue
+			}
+			responses[i] = b.errorResponse(responses[i], status, message)
+		}
+		return responses
+	}
+
+	for i := range requests {
+		if dep := b.failedDependency(&requests[i]); dep != "" {
+			return fail(-1, http.StatusFailedDependency, fmt.Sprintf("%s failed", dep))
+		}
+	}
+
+	changes := make([]batchChange, len(requests))
+	var paths map[string]string
+	failedAt := -1
+	err := b.s.transact(b.r.Context(), func(ctx context.Context, tx *writeTx) error {
+		paths = map[string]string{}
+		for i := range requests {
+			failedAt = i
+			change, err := b.change(ctx, tx, &requests[i], paths)
+			if err != nil {
+				return err
+			}
+			changes[i] = change
+			if change.method == http.MethodPost {
+				paths[requests[i].ID] = change.t.SetName + "(" + formatID(change.id) + ")"
+			}
+		}
+		// Failures from here on are in the writes after commit, which belong to no single request
+		failedAt = -1
+		return nil
+	})
+	if err != nil {
+		status, message := b.s.errorStatus(err)
+		return fail(failedAt, status, message)
+	}
+
+	for id, path := range paths {
+		b.paths[id] = path
+	}
+	for i, change := range changes {
+		if change.method == http.MethodDelete {
+			responses[i].Status = http.StatusOK
+			continue
+		}
+		entity, err := b.s.loadEntity(b.r.Context(), b.root, change.t, change.id)
+		if err != nil {
+			status, message := b.s.errorStatus(err)
+			responses[i] = b.errorResponse(responses[i], status, message)
+			continue
+		}
+		responses[i].Status = http.StatusOK
+		if change.method == http.MethodPost {
+			responses[i].Status = http.StatusCreated
+			responses[i].Headers = map[string]string{"location": entity["@iot.selfLink"].(string)}
+		}
+		responses[i].Body = entity
+	}
+	return responses
+}
+
+// change applies one write of an atomicity group within its transaction.
+// paths holds the entities created earlier in the group.
+func (b *batchRun) change(ctx context.Context, tx *writeTx, req *batchRequest, paths map[string]string) (batchChange, error) {
+	method := strings.ToUpper(req.Method)
+	path, _, err := b.resolveURL(req.URL, paths)
+	if err != nil {
+		return batchChange{}, err
+	}
+	segments, err := parsePath(path)
+	if err != nil {
+		return batchChange{}, invalidRequest("%v", err)
+	}
+
+	switch method {
+	case http.MethodPost:
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return batchChange{}, err
+		}
+		t, err := createTarget(path, segments, body)
+		if err != nil {
+			return batchChange{}, err
+		}
+		id, err := b.s.createEntity(ctx, tx, t, body)
+		return batchChange{method: method, t: t, id: id}, err
+	case http.MethodPatch:
+		t, id, err := writeTarget(path, segments)
+		if err != nil {
+			return batchChange{}, err
+		}
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{method: method, t: t, id: id}, b.s.updateEntity(ctx, tx, t, id, body)
+	case http.MethodDelete:
+		t, id, err := writeTarget(path, segments)
+		if err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{method: method, t: t, id: id}, b.s.deleteEntity(ctx, tx, t, id)
+	}
+	return batchChange{}, invalidRequest("atomicity groups may only contain POST, PATCH and DELETE requests, not %s", req.Method)
+}
+
+// resolveURL returns the resource path below the service root and the query
+// of a batch request url, replacing a leading $id with the path of the entity
+// that request created. local holds entities created in the current atomicity group.
+func (b *batchRun) resolveURL(raw string, local map[string]string) (string, string, error) {
+	if strings.HasPrefix(raw, "$") && !strings.HasPrefix(raw, BatchPath) {
+		ref, rest := raw[1:], ""
+		if end := strings.IndexAny(ref, "/?"); end >= 0 {
+			ref, rest = ref[:end], ref[end:]
+		}
+		path, ok := local[ref]
+		if !ok {
+			path, ok = b.paths[ref]
+		}
+		if !ok {
+			return "", "", invalidRequest("url %q refers to %q, which created no entity", raw, ref)
+		}
+		// Entity ids may hold characters such as ? or # that end a path
+		raw = (&url.URL{Path: path}).EscapedPath() + rest
+	}
+
+	// A relative url is read as a path even if it contains a colon
+	if !strings.HasPrefix(raw, "/") && !strings.Contains(raw, "://") {
+		raw = "/" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", invalidRequest("invalid url %q: %v", raw, err)
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == APIVersion {
+		path = ""
+	}
+	return strings.TrimPrefix(path, APIVersion+"/"), u.RawQuery, nil
+}
+
+// failedDependency returns the first request or group a request depends on that failed
+func (b *batchRun) failedDependency(req *batchRequest) string {
+	for _, dep := range req.DependsOn {
+		if b.failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// errorResponse fills in a batch response describing an error
+func (b *batchRun) errorResponse(resp batchResponse, status int, message string) batchResponse {
+	resp.Status = status
+	resp.Headers = nil
+	resp.Body = ErrorResponse{Code: status, Type: "error", Message: message}
+	return resp
+}
+
+// batchRecorder captures the response to a request of a batch
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the response headers
+func (r *batchRecorder) Header() http.Header {
+	return r.header
+}
+
+// Write records the response body
+func (r *batchRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(p)
+}
+
+// WriteHeader records the first status written
+func (r *batchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
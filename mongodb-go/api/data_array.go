@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// CreateObservationsPath is the resource of the dataArray bulk upload extension
+const CreateObservationsPath = "CreateObservations"
+
+// dataArrayError marks a row of a dataArray upload that was not stored
+const dataArrayError = "error"
+
+// dataArrayComponents are the observation properties a dataArray row may carry
+var dataArrayComponents = map[string]bool{
+	"phenomenonTime":       true,
+	"resultTime":           true,
+	"result":               true,
+	"resultQuality":        true,
+	"validTime":            true,
+	"parameters":           true,
+	"FeatureOfInterest/id": true,
+}
+
+// dataArray is the observations of one datastream in the compact dataArray
+// form: the components name the properties, each row lists their values
+type dataArray struct {
+	Datastream json.RawMessage     `json:"Datastream"`
+	Components []string            `json:"components"`
+	Count      *int                `json:"dataArray@iot.count"`
+	Rows       [][]json.RawMessage `json:"dataArray"`
+}
+
+// dataArrayRow is one row of a dataArray upload and why it was rejected, if it was
+type dataArrayRow struct {
+	obs models.Observation
+	err error
+}
+
+// handleCreateObservations stores observations uploaded in the SensorThings
+// dataArray form in one unordered insert. The response lists, row by row over
+// all datastreams, the selfLink of each created observation or "error" for
+// rows that were invalid or that the insert rejected.
+func (s *Server) handleCreateObservations(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWriteBody))
+	if err != nil {
+		s.writeRepositoryError(w, invalidRequest("failed to read request body: %v", err))
+		return
+	}
+	var arrays []dataArray
+	if err := json.Unmarshal(data, &arrays); err != nil {
+		s.writeRepositoryError(w, invalidRequest("request body must be an array of dataArrays: %v", err))
+		return
+	}
+
+	var rows []dataArrayRow
+	now := time.Now().UTC()
+	for i := range arrays {
+		parsed, err := s.parseDataArray(r.Context(), &arrays[i], now)
+		if err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+		rows = append(rows, parsed...)
+	}
+	if err := s.checkFeaturesOfInterest(r.Context(), rows); err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	// Only valid rows are inserted, so the rest of the upload is stored
+	var observations []models.Observation
+	var inserted []int
+	for i := range rows {
+		if rows[i].err == nil {
+			observations = append(observations, rows[i].obs)
+			inserted = append(inserted, i)
+		}
+	}
+	if len(observations) > 0 {
+		err := s.observations.InsertMany(r.Context(), observations)
+		var insertErr *repository.InsertManyError
+		if errors.Is(err, repository.ErrRollupsNotMarked) {
+			// Every row was stored; failing now would make the client store them again
+			s.logger.WithError(err).Warn("Stored a dataArray upload without queueing its rollups")
+		} else if errors.As(err, &insertErr) {
+			for index, rowErr := range insertErr.Failed {
+				rows[inserted[index]].err = rowErr
+			}
+		} else if err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+	}
+
+	root := s.serviceRoot(r)
+	results := make([]string, len(rows))
+	var failed []error
+	for i := range rows {
+		if rows[i].err != nil {
+			results[i] = dataArrayError
+			failed = append(failed, rows[i].err)
+			continue
+		}
+		results[i] = fmt.Sprintf("%s/Observations('%s')", root, rows[i].obs.ID.Hex())
+	}
+	if len(failed) > 0 {
+		s.logger.WithFields(logrus.Fields{
+			"rows":   len(rows),
+			"failed": len(failed),
+		}).WithError(failed[0]).Warn("Rejected rows of a dataArray upload")
+	}
+	writeJSON(w, http.StatusCreated, results)
+}
+
+// parseDataArray converts the rows of a dataArray into observations of its
+// datastream, each carrying the datastream metadata. The error is set only
+// when the dataArray as a whole is malformed; a datastream that does not
+// exist fails every row.
+func (s *Server) parseDataArray(ctx context.Context, array *dataArray, now time.Time) ([]dataArrayRow, error) {
+	seen := map[string]bool{}
+	for _, component := range array.Components {
+		if !dataArrayComponents[component] {
+			return nil, invalidRequest("unsupported dataArray component %q", component)
+		}
+		if seen[component] {
+			return nil, invalidRequest("dataArray component %q is listed twice", component)
+		}
+		seen[component] = true
+	}
+	if !seen["result"] {
+		return nil, invalidRequest("dataArray components must include result")
+	}
+	if array.Count != nil && *array.Count != len(array.Rows) {
+		return nil, invalidRequest("dataArray@iot.count is %d but there are %d rows", *array.Count, len(array.Rows))
+	}
+	if array.Datastream == nil {
+		return nil, invalidRequest("dataArray requires a Datastream")
+	}
+	_, datastreamID, err := nestedEntity("Datastream", array.Datastream)
+	if err != nil {
+		return nil, err
+	}
+	if datastreamID == "" {
+		return nil, invalidRequest("the Datastream of a dataArray must be given by @iot.id")
+	}
+
+	rows := make([]dataArrayRow, len(array.Rows))
+	meta, err := s.datastreams.Meta(ctx, datastreamID)
+	if errors.Is(err, repository.ErrNotFound) {
+		for i := range rows {
+			rows[i].err = fmt.Errorf("datastreams %s: %w", datastreamID, repository.ErrInvalidReference)
+		}
+		return rows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, values := range array.Rows {
+		rows[i].obs = models.Observation{ID: primitive.NewObjectID(), PhenomenonTime: now, Datastream: *meta}
+		rows[i].err = setDataArrayRow(&rows[i].obs, array.Components, values)
+	}
+	return rows, nil
+}
+
+// setDataArrayRow sets the components of an observation from the values of a row and validates it
+func setDataArrayRow(obs *models.Observation, components []string, values []json.RawMessage) error {
+	if len(values) != len(components) {
+		return invalidRequest("row has %d values for %d components", len(values), len(components))
+	}
+	for i, component := range components {
+		var err error
+		switch {
+		case component != "FeatureOfInterest/id":
+			err = setObservationProperty(obs, component, values[i])
+		case !isNull(values[i]):
+			obs.FeatureOfInterestID, err = idValue(values[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return repository.Validate(obs)
+}
+
+// checkFeaturesOfInterest fails the rows whose feature of interest does not
+// exist, looking up every feature of interest in one query
+func (s *Server) checkFeaturesOfInterest(ctx context.Context, rows []dataArrayRow) error {
+	found := map[string]bool{}
+	for i := range rows {
+		if id := rows[i].obs.FeatureOfInterestID; rows[i].err == nil && id != "" {
+			found[id] = false
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	ids := make(bson.A, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+
+	docs, err := s.lookups.Find(ctx, entityTypes["FeatureOfInterest"].Collection, repository.Query{
+		Filter: bson.M{"_id": bson.M{"$in": ids}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if id, ok := doc["_id"].(string); ok {
+			found[id] = true
+		}
+	}
+
+	for i := range rows {
+		if id := rows[i].obs.FeatureOfInterestID; rows[i].err == nil && id != "" && !found[id] {
+			rows[i].err = fmt.Errorf("features_of_interest %s: %w", id, repository.ErrInvalidReference)
+		}
+	}
+	return nil
+}
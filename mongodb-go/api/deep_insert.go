@@ -0,0 +1,891 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// createEntity creates an entity of type t, and the entities nested in it, returning its id
+func (s *Server) createEntity(ctx context.Context, tx *writeTx, t *entityType, body entityBody) (interface{}, error) {
+	switch t.Name {
+	case "Thing":
+		return s.createThing(ctx, tx, body)
+	case "Location":
+		return s.createLocation(ctx, tx, body)
+	case "Sensor":
+		return s.createSensor(ctx, tx, body)
+	case "ObservedProperty":
+		return s.createObservedProperty(ctx, tx, body)
+	case "Datastream":
+		return s.createDatastream(ctx, tx, body)
+	case "FeatureOfInterest":
+		return s.createFeatureOfInterest(ctx, tx, body)
+	case "Observation":
+		return s.createObservation(ctx, tx, body)
+	}
+	return nil, invalidRequest("%s are recorded by the server and cannot be written", t.SetName)
+}
+
+// createThing creates a thing at the locations it lists, then its datastreams.
+// The first location becomes its current location.
+func (s *Server) createThing(ctx context.Context, tx *writeTx, body entityBody) (string, error) {
+	t := entityTypes["Thing"]
+	if _, ok := body["HistoricalLocations"]; ok {
+		return "", invalidRequest("HistoricalLocations are recorded when a Thing moves")
+	}
+	id, err := newEntityID(body)
+	if err != nil {
+		return "", err
+	}
+	thing := &models.Thing{ID: id}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setThingProperty(thing, name, raw)
+	}); err != nil {
+		return "", err
+	}
+
+	// Locations come first so the thing is created where it is
+	items, err := nestedEntities(body, "Locations")
+	if err != nil {
+		return "", err
+	}
+	locationIDs := make([]string, 0, len(items))
+	for _, raw := range items {
+		nested, ref, err := nestedEntity("Locations", raw)
+		if err != nil {
+			return "", err
+		}
+		if ref == "" {
+			// The thing is attached below, once it exists
+			delete(nested, "Things")
+			if ref, err = s.createLocation(ctx, tx, nested); err != nil {
+				return "", err
+			}
+		} else if err := s.checkReference(ctx, entityTypes["Location"], ref); err != nil {
+			return "", err
+		}
+		locationIDs = append(locationIDs, ref)
+	}
+	if len(locationIDs) > 0 {
+		loc, err := s.locations.FindByID(ctx, locationIDs[0])
+		if err != nil {
+			return "", err
+		}
+		thing.CurrentLocation = thingLocation(loc)
+	}
+
+	if err := repository.Validate(thing); err != nil {
+		return "", err
+	}
+	if err := s.things.Create(ctx, thing); err != nil {
+		return "", err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.things.Delete(ctx, thing.ID)
+	})
+
+	if len(locationIDs) > 1 {
+		for _, locationID := range locationIDs[1:] {
+			if err := s.attachThing(ctx, tx, locationID, thing.ID); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := s.createChildren(ctx, tx, t, thing.ID, body, "Datastreams", nil); err != nil {
+		return "", err
+	}
+	return thing.ID, nil
+}
+
+// createLocation creates a location. Things it lists by reference move to it;
+// things nested in full are created at it.
+func (s *Server) createLocation(ctx context.Context, tx *writeTx, body entityBody) (string, error) {
+	t := entityTypes["Location"]
+	if _, ok := body["HistoricalLocations"]; ok {
+		return "", invalidRequest("HistoricalLocations are recorded when a Thing moves")
+	}
+	id, err := newEntityID(body)
+	if err != nil {
+		return "", err
+	}
+	loc := &models.Location{ID: id}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setLocationProperty(loc, name, raw)
+	}); err != nil {
+		return "", err
+	}
+
+	if err := repository.Validate(loc); err != nil {
+		return "", err
+	}
+	if err := s.locations.Create(ctx, loc); err != nil {
+		return "", err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.locations.Delete(ctx, loc.ID)
+	})
+
+	err = s.createChildren(ctx, tx, t, loc.ID, body, "Things", func(thingID string) error {
+		return s.moveThing(ctx, tx, thingID, loc)
+	})
+	if err != nil {
+		return "", err
+	}
+	return loc.ID, nil
+}
+
+// createSensor creates a sensor and the datastreams nested in it
+func (s *Server) createSensor(ctx context.Context, tx *writeTx, body entityBody) (string, error) {
+	t := entityTypes["Sensor"]
+	id, err := newEntityID(body)
+	if err != nil {
+		return "", err
+	}
+	sensor := &models.Sensor{ID: id}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setSensorProperty(sensor, name, raw)
+	}); err != nil {
+		return "", err
+	}
+
+	if err := repository.Validate(sensor); err != nil {
+		return "", err
+	}
+	if err := s.sensors.Create(ctx, sensor); err != nil {
+		return "", err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.sensors.Delete(ctx, sensor.ID)
+	})
+
+	if err := s.createChildren(ctx, tx, t, sensor.ID, body, "Datastreams", nil); err != nil {
+		return "", err
+	}
+	return sensor.ID, nil
+}
+
+// createObservedProperty creates an observed property and the datastreams nested in it
+func (s *Server) createObservedProperty(ctx context.Context, tx *writeTx, body entityBody) (string, error) {
+	t := entityTypes["ObservedProperty"]
+	id, err := newEntityID(body)
+	if err != nil {
+		return "", err
+	}
+	property := &models.ObservedProperty{ID: id}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setObservedPropertyProperty(property, name, raw)
+	}); err != nil {
+		return "", err
+	}
+
+	if err := repository.Validate(property); err != nil {
+		return "", err
+	}
+	if err := s.observedProperties.Create(ctx, property); err != nil {
+		return "", err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.observedProperties.Delete(ctx, property.ID)
+	})
+
+	if err := s.createChildren(ctx, tx, t, property.ID, body, "Datastreams", nil); err != nil {
+		return "", err
+	}
+	return property.ID, nil
+}
+
+// createDatastream creates a datastream, the thing, sensor and observed property
+// nested in it that do not exist yet, and its initial observations
+func (s *Server) createDatastream(ctx context.Context, tx *writeTx, body entityBody) (string, error) {
+	t := entityTypes["Datastream"]
+	id, err := newEntityID(body)
+	if err != nil {
+		return "", err
+	}
+	ds := &models.Datastream{ID: id}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setDatastreamProperty(ds, name, raw)
+	}); err != nil {
+		return "", err
+	}
+
+	if ds.ThingID, _, err = s.related(ctx, tx, t, body, "Thing"); err != nil {
+		return "", err
+	}
+	if ds.SensorID, _, err = s.related(ctx, tx, t, body, "Sensor"); err != nil {
+		return "", err
+	}
+	if ds.ObservedPropertyID, _, err = s.related(ctx, tx, t, body, "ObservedProperty"); err != nil {
+		return "", err
+	}
+
+	if err := repository.Validate(ds); err != nil {
+		return "", err
+	}
+	if err := s.datastreams.Create(ctx, ds); err != nil {
+		return "", err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.datastreams.Delete(ctx, ds.ID)
+	})
+
+	if err := s.createChildren(ctx, tx, t, ds.ID, body, "Observations", nil); err != nil {
+		return "", err
+	}
+	return ds.ID, nil
+}
+
+// createFeatureOfInterest creates a feature of interest and the observations nested in it
+func (s *Server) createFeatureOfInterest(ctx context.Context, tx *writeTx, body entityBody) (string, error) {
+	t := entityTypes["FeatureOfInterest"]
+	id, err := newEntityID(body)
+	if err != nil {
+		return "", err
+	}
+	foi := &models.FeatureOfInterest{ID: id}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setFeatureOfInterestProperty(foi, name, raw)
+	}); err != nil {
+		return "", err
+	}
+
+	if err := repository.Validate(foi); err != nil {
+		return "", err
+	}
+	if err := s.features.Create(ctx, foi); err != nil {
+		return "", err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.features.Delete(ctx, foi.ID)
+	})
+
+	if err := s.createChildren(ctx, tx, t, foi.ID, body, "Observations", nil); err != nil {
+		return "", err
+	}
+	return foi.ID, nil
+}
+
+// createObservation validates an observation and queues it for insertion after
+// commit, with the metadata of its datastream denormalized into it. The
+// phenomenon time defaults to the time the request is processed.
+func (s *Server) createObservation(ctx context.Context, tx *writeTx, body entityBody) (primitive.ObjectID, error) {
+	t := entityTypes["Observation"]
+	obs := models.Observation{ID: primitive.NewObjectID(), PhenomenonTime: time.Now().UTC()}
+	if raw, ok := body["@iot.id"]; ok {
+		id, err := idValue(raw)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if obs.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return primitive.NilObjectID, invalidRequest("invalid Observation id %q", id)
+		}
+	}
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setObservationProperty(&obs, name, raw)
+	}); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	datastreamID, ok, err := s.related(ctx, tx, t, body, "Datastream")
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if !ok {
+		return primitive.NilObjectID, invalidRequest("Observation requires a Datastream")
+	}
+	if obs.FeatureOfInterestID, _, err = s.related(ctx, tx, t, body, "FeatureOfInterest"); err != nil {
+		return primitive.NilObjectID, err
+	}
+	meta, err := s.datastreamMeta(ctx, tx, datastreamID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	obs.Datastream = *meta
+
+	if err := repository.Validate(&obs); err != nil {
+		return primitive.NilObjectID, err
+	}
+	tx.insertObservation(obs)
+	return obs.ID, nil
+}
+
+// updateEntity applies the properties of a body to an existing entity of type t
+func (s *Server) updateEntity(ctx context.Context, tx *writeTx, t *entityType, id interface{}, body entityBody) error {
+	for name, nav := range t.Navigation {
+		if _, ok := body[name]; ok && nav.Many {
+			return invalidRequest("%s of a %s cannot be changed by an update", name, t.Name)
+		}
+	}
+
+	key, _ := id.(string)
+	switch t.Name {
+	case "Thing":
+		return s.updateThing(ctx, tx, key, body)
+	case "Location":
+		return s.updateLocation(ctx, tx, key, body)
+	case "Sensor":
+		return s.updateSensor(ctx, tx, key, body)
+	case "ObservedProperty":
+		return s.updateObservedProperty(ctx, tx, key, body)
+	case "Datastream":
+		return s.updateDatastream(ctx, tx, key, body)
+	case "FeatureOfInterest":
+		return s.updateFeatureOfInterest(ctx, tx, key, body)
+	case "Observation":
+		return s.updateObservation(ctx, tx, id.(primitive.ObjectID), body)
+	}
+	return invalidRequest("%s are recorded by the server and cannot be written", t.SetName)
+}
+
+// updateThing applies the properties of a body to a thing
+func (s *Server) updateThing(ctx context.Context, tx *writeTx, id string, body entityBody) error {
+	thing, err := s.things.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := applyProperties(entityTypes["Thing"], body, func(name string, raw json.RawMessage) error {
+		return setThingProperty(thing, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	if err := repository.Validate(thing); err != nil {
+		return err
+	}
+	// Updating the thing back would record another move, so the thing, the
+	// history recorded from now on and the locations it leaves or joins are
+	// written back instead
+	var locationIDs []string
+	if thing.CurrentLocation != nil && thing.CurrentLocation.LocationID != "" {
+		locationIDs = append(locationIDs, thing.CurrentLocation.LocationID)
+	}
+	undo, err := s.snapshot(ctx, thingDocuments(id, time.Now().UTC(), locationIDs)...)
+	if err != nil {
+		return err
+	}
+	if err := s.things.Update(ctx, thing); err != nil {
+		return err
+	}
+	tx.onRollback(undo)
+	return nil
+}
+
+// updateLocation applies the properties of a body to a location
+func (s *Server) updateLocation(ctx context.Context, tx *writeTx, id string, body entityBody) error {
+	loc, err := s.locations.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	previous := *loc
+	if err := applyProperties(entityTypes["Location"], body, func(name string, raw json.RawMessage) error {
+		return setLocationProperty(loc, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	if err := repository.Validate(loc); err != nil {
+		return err
+	}
+	if err := s.locations.Update(ctx, loc); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.locations.Update(ctx, &previous)
+	})
+	return nil
+}
+
+// updateSensor applies the properties of a body to a sensor
+func (s *Server) updateSensor(ctx context.Context, tx *writeTx, id string, body entityBody) error {
+	sensor, err := s.sensors.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	previous := *sensor
+	if err := applyProperties(entityTypes["Sensor"], body, func(name string, raw json.RawMessage) error {
+		return setSensorProperty(sensor, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	if err := repository.Validate(sensor); err != nil {
+		return err
+	}
+	if err := s.sensors.Update(ctx, sensor); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.sensors.Update(ctx, &previous)
+	})
+	return nil
+}
+
+// updateObservedProperty applies the properties of a body to an observed property
+func (s *Server) updateObservedProperty(ctx context.Context, tx *writeTx, id string, body entityBody) error {
+	property, err := s.observedProperties.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	previous := *property
+	if err := applyProperties(entityTypes["ObservedProperty"], body, func(name string, raw json.RawMessage) error {
+		return setObservedPropertyProperty(property, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	if err := repository.Validate(property); err != nil {
+		return err
+	}
+	if err := s.observedProperties.Update(ctx, property); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.observedProperties.Update(ctx, &previous)
+	})
+	return nil
+}
+
+// updateDatastream applies the properties and links of a body to a datastream.
+// When its thing, sensor, observed property or unit change, the metadata
+// denormalized into its observations follows once the transaction commits.
+func (s *Server) updateDatastream(ctx context.Context, tx *writeTx, id string, body entityBody) error {
+	t := entityTypes["Datastream"]
+	ds, err := s.datastreams.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	previous := *ds
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setDatastreamProperty(ds, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	links := []struct {
+		name  string
+		field *string
+	}{
+		{"Thing", &ds.ThingID},
+		{"Sensor", &ds.SensorID},
+		{"ObservedProperty", &ds.ObservedPropertyID},
+	}
+	for _, link := range links {
+		ref, ok, err := s.linked(ctx, t, body, link.name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*link.field = ref
+		}
+	}
+
+	if err := repository.Validate(ds); err != nil {
+		return err
+	}
+	if err := s.datastreams.Update(ctx, ds); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.datastreams.Update(ctx, &previous)
+	})
+
+	if ds.ThingID != previous.ThingID || ds.SensorID != previous.SensorID ||
+		ds.ObservedPropertyID != previous.ObservedPropertyID ||
+		!reflect.DeepEqual(ds.UnitOfMeasurement, previous.UnitOfMeasurement) {
+		tx.afterCommit(
+			func(ctx context.Context) error { return s.observations.SyncDatastream(ctx, ds) },
+			func(ctx context.Context) error { return s.observations.SyncDatastream(ctx, &previous) },
+		)
+	}
+	return nil
+}
+
+// updateFeatureOfInterest applies the properties of a body to a feature of interest
+func (s *Server) updateFeatureOfInterest(ctx context.Context, tx *writeTx, id string, body entityBody) error {
+	foi, err := s.features.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	previous := *foi
+	if err := applyProperties(entityTypes["FeatureOfInterest"], body, func(name string, raw json.RawMessage) error {
+		return setFeatureOfInterestProperty(foi, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	if err := repository.Validate(foi); err != nil {
+		return err
+	}
+	if err := s.features.Update(ctx, foi); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.features.Update(ctx, &previous)
+	})
+	return nil
+}
+
+// updateObservation applies the properties and links of a body to an observation,
+// validating it now and writing it once the transaction commits
+func (s *Server) updateObservation(ctx context.Context, tx *writeTx, id primitive.ObjectID, body entityBody) error {
+	t := entityTypes["Observation"]
+	obs, err := s.observations.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	previous := *obs
+	if err := applyProperties(t, body, func(name string, raw json.RawMessage) error {
+		return setObservationProperty(obs, name, raw)
+	}); err != nil {
+		return err
+	}
+
+	datastreamID, ok, err := s.linked(ctx, t, body, "Datastream")
+	if err != nil {
+		return err
+	}
+	if ok {
+		meta, err := s.datastreamMeta(ctx, tx, datastreamID)
+		if err != nil {
+			return err
+		}
+		obs.Datastream = *meta
+	}
+	// null unlinks the feature of interest
+	if _, present := body["FeatureOfInterest"]; present {
+		if obs.FeatureOfInterestID, _, err = s.linked(ctx, t, body, "FeatureOfInterest"); err != nil {
+			return err
+		}
+	}
+
+	if err := repository.Validate(obs); err != nil {
+		return err
+	}
+	tx.afterCommit(
+		func(ctx context.Context) error { return s.observations.Update(ctx, obs) },
+		func(ctx context.Context) error { return s.observations.Update(ctx, &previous) },
+	)
+	return nil
+}
+
+// deleteEntity removes an entity of type t. Observations are removed once the
+// transaction commits.
+func (s *Server) deleteEntity(ctx context.Context, tx *writeTx, t *entityType, id interface{}) error {
+	key, _ := id.(string)
+	// Deleted entities are restored from their stored documents, since
+	// creating them again would reset their timestamps
+	selections := []collectionFilter{{t.Collection, bson.M{"_id": key}}}
+	var remove func(ctx context.Context, id string) error
+	switch t.Name {
+	case "Thing":
+		// Deleting a thing also removes its history and detaches it from its locations
+		selections = thingDocuments(key, time.Time{}, nil)
+		remove = s.things.Delete
+	case "Location":
+		remove = s.locations.Delete
+	case "Sensor":
+		remove = s.sensors.Delete
+	case "ObservedProperty":
+		remove = s.observedProperties.Delete
+	case "Datastream":
+		remove = s.datastreams.Delete
+	case "FeatureOfInterest":
+		// Its hierarchy neighbours lose their links to it
+		selections = []collectionFilter{{t.Collection, bson.M{"$or": bson.A{
+			bson.M{"_id": key},
+			bson.M{"hierarchy.parents.foiId": key},
+			bson.M{"hierarchy.children.foiId": key},
+		}}}}
+		remove = s.features.Delete
+	case "Observation":
+		obs, err := s.observations.FindByID(ctx, id.(primitive.ObjectID))
+		if err != nil {
+			return err
+		}
+		tx.afterCommit(
+			func(ctx context.Context) error { return s.observations.Delete(ctx, obs.ID) },
+			// Restoring the observation does not publish it again
+			func(ctx context.Context) error {
+				_, err := s.observations.InsertManyUnpublished(ctx, []models.Observation{*obs})
+				return err
+			},
+		)
+		return nil
+	default:
+		return invalidRequest("%s are recorded by the server and cannot be written", t.SetName)
+	}
+
+	undo, err := s.snapshot(ctx, selections...)
+	if err != nil {
+		return err
+	}
+	if err := remove(ctx, key); err != nil {
+		return err
+	}
+	tx.onRollback(undo)
+	return nil
+}
+
+// collectionFilter selects documents of a collection
+type collectionFilter struct {
+	collection string
+	filter     bson.M
+}
+
+// thingDocuments selects a thing, its historical locations recorded since
+// (all of them if since is zero) and the locations it is placed at or in
+// locationIDs
+func thingDocuments(id string, since time.Time, locationIDs []string) []collectionFilter {
+	history := bson.M{"thingId": id}
+	if !since.IsZero() {
+		history["created_at"] = bson.M{"$gte": since}
+	}
+	locations := bson.M{"things": id}
+	if len(locationIDs) > 0 {
+		locations = bson.M{"$or": bson.A{locations, bson.M{"_id": bson.M{"$in": locationIDs}}}}
+	}
+	return []collectionFilter{
+		{entityTypes["Thing"].Collection, bson.M{"_id": id}},
+		{entityTypes["HistoricalLocation"].Collection, history},
+		{entityTypes["Location"].Collection, locations},
+	}
+}
+
+// snapshot reads the selected documents and returns an undo step that writes
+// them back as they were, without the side effects of the repositories
+func (s *Server) snapshot(ctx context.Context, selections ...collectionFilter) (func(ctx context.Context) error, error) {
+	snapshots := make([]*repository.Snapshot, len(selections))
+	for i, sel := range selections {
+		snapshot, err := s.lookups.Snapshot(ctx, sel.collection, sel.filter)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[i] = snapshot
+	}
+	return func(ctx context.Context) error {
+		for _, snapshot := range snapshots {
+			if err := s.lookups.Restore(ctx, snapshot); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// createChildren creates the entities nested in a multi-valued navigation
+// property of a new entity, linking each back to it. Entities given by
+// reference are passed to link, or rejected if link is nil.
+func (s *Server) createChildren(ctx context.Context, tx *writeTx, t *entityType, id string,
+	body entityBody, name string, link func(ref string) error) error {
+
+	items, err := nestedEntities(body, name)
+	if err != nil {
+		return err
+	}
+	child := entityTypes[t.Navigation[name].Target]
+	for _, raw := range items {
+		nested, ref, err := nestedEntity(name, raw)
+		if err != nil {
+			return err
+		}
+		if ref != "" {
+			if link == nil {
+				return invalidRequest("%s of a %s can only be created with it, not linked", name, t.Name)
+			}
+			if err := link(ref); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := linkParent(child, nested, t, id); err != nil {
+			return err
+		}
+		if _, err := s.createEntity(ctx, tx, child, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// related resolves a single-valued navigation property of a new entity to the id
+// of the entity it refers to, creating that entity if it is given in full.
+// ok is false when the body does not set the property.
+func (s *Server) related(ctx context.Context, tx *writeTx, t *entityType, body entityBody, name string) (string, bool, error) {
+	raw, ok := body[name]
+	if !ok || isNull(raw) {
+		return "", false, nil
+	}
+	target := entityTypes[t.Navigation[name].Target]
+	nested, ref, err := nestedEntity(name, raw)
+	if err != nil {
+		return "", false, err
+	}
+	if ref != "" {
+		return ref, true, s.checkReference(ctx, target, ref)
+	}
+
+	id, err := s.createEntity(ctx, tx, target, nested)
+	if err != nil {
+		return "", false, err
+	}
+	return id.(string), true, nil
+}
+
+// linked resolves a single-valued navigation property of an existing entity,
+// which may only refer to another existing entity
+func (s *Server) linked(ctx context.Context, t *entityType, body entityBody, name string) (string, bool, error) {
+	raw, ok := body[name]
+	if !ok || isNull(raw) {
+		return "", false, nil
+	}
+	_, ref, err := nestedEntity(name, raw)
+	if err != nil {
+		return "", false, err
+	}
+	if ref == "" {
+		return "", false, invalidRequest("%s of an existing %s can only be linked by @iot.id", name, t.Name)
+	}
+	return ref, true, s.checkReference(ctx, entityTypes[t.Navigation[name].Target], ref)
+}
+
+// checkReference verifies that an entity of type t with the id exists
+func (s *Server) checkReference(ctx context.Context, t *entityType, id string) error {
+	docs, err := s.lookups.Find(ctx, t.Collection, repository.Query{
+		Filter: bson.M{"_id": id},
+		Limit:  1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("%s %s: %w", t.Collection, id, repository.ErrInvalidReference)
+	}
+	return nil
+}
+
+// moveThing makes a location the current location of an existing thing
+func (s *Server) moveThing(ctx context.Context, tx *writeTx, thingID string, loc *models.Location) error {
+	previous, err := s.things.FindByID(ctx, thingID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("things %s: %w", thingID, repository.ErrInvalidReference)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.things.MoveTo(ctx, thingID, *thingLocation(loc), time.Now().UTC()); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.things.Update(ctx, previous)
+	})
+	return nil
+}
+
+// attachThing lists a thing among those at a location without making it the thing's current location
+func (s *Server) attachThing(ctx context.Context, tx *writeTx, locationID, thingID string) error {
+	loc, err := s.locations.FindByID(ctx, locationID)
+	if err != nil {
+		return err
+	}
+	for _, id := range loc.Things {
+		if id == thingID {
+			return nil
+		}
+	}
+	previous := *loc
+	loc.Things = append(append([]string{}, loc.Things...), thingID)
+	if err := s.locations.Update(ctx, loc); err != nil {
+		return err
+	}
+	tx.onRollback(func(ctx context.Context) error {
+		return s.locations.Update(ctx, &previous)
+	})
+	return nil
+}
+
+// datastreamMeta returns the metadata of a datastream for its observations,
+// reading each datastream once per request
+func (s *Server) datastreamMeta(ctx context.Context, tx *writeTx, id string) (*models.DatastreamMeta, error) {
+	if meta, ok := tx.meta[id]; ok {
+		return meta, nil
+	}
+	meta, err := s.datastreams.Meta(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	tx.meta[id] = meta
+	return meta, nil
+}
+
+// thingLocation denormalizes a location into a thing
+func thingLocation(loc *models.Location) *models.ThingLocation {
+	return &models.ThingLocation{
+		LocationID:   loc.ID,
+		Name:         loc.Name,
+		EncodingType: loc.EncodingType,
+		Location:     loc.Location,
+	}
+}
+
+// nestedEntities returns the entities of a multi-valued navigation property
+func nestedEntities(body entityBody, name string) ([]json.RawMessage, error) {
+	raw, ok := body[name]
+	if !ok || isNull(raw) {
+		return nil, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, invalidRequest("%s must be an array of entities", name)
+	}
+	return items, nil
+}
+
+// nestedEntity parses an entity nested in a navigation property. An entity
+// with nothing but an @iot.id refers to an existing one, whose id is returned as ref.
+func nestedEntity(name string, raw json.RawMessage) (entityBody, string, error) {
+	var body entityBody
+	if err := json.Unmarshal(raw, &body); err != nil || body == nil {
+		return nil, "", invalidRequest("%s must be an entity", name)
+	}
+	if id, ok := body["@iot.id"]; ok && len(body) == 1 {
+		ref, err := idValue(id)
+		return nil, ref, err
+	}
+	return body, "", nil
+}
+
+// newEntityID returns the @iot.id of a new entity, or generates one
+func newEntityID(body entityBody) (string, error) {
+	raw, ok := body["@iot.id"]
+	if !ok {
+		return primitive.NewObjectID().Hex(), nil
+	}
+	return idValue(raw)
+}
+
+// idValue reads an @iot.id, which may be a string or a number
+func idValue(raw json.RawMessage) (string, error) {
+	var id interface{}
+	if err := json.Unmarshal(raw, &id); err != nil {
+		return "", invalidRequest("invalid @iot.id: %v", err)
+	}
+	switch v := id.(type) {
+	case string:
+		if v != "" {
+			return v, nil
+		}
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", invalidRequest("@iot.id must be a string or a number")
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/odata"
+)
+
+// entityType describes a SensorThings entity type and where it is stored
+type entityType struct {
+	Name        string
+	SetName     string
+	Collection  string
+	Fields      odata.FieldMap
+	Properties  []string
+	DefaultSort bson.D
+	Navigation  map[string]navigation
+}
+
+// navigation describes how a navigation property is resolved.
+// Documents of the target type match when their Foreign field equals
+// (or contains) a value found at the Local field of the source document.
+type navigation struct {
+	Target  string
+	Many    bool
+	Local   string
+	Foreign string
+}
+
+// entityTypes is the SensorThings data model as laid out in the data lake
+var entityTypes = map[string]*entityType{
+	"Observation": {
+		Name:        "Observation",
+		SetName:     "Observations",
+		Collection:  "observations",
+		Fields:      odata.ObservationFields,
+		DefaultSort: bson.D{{Key: "phenomenonTime", Value: -1}},
+		Navigation: map[string]navigation{
+			"Datastream":        {Target: "Datastream", Local: "datastream.datastreamId", Foreign: "_id"},
+			"FeatureOfInterest": {Target: "FeatureOfInterest", Local: "featureOfInterestId", Foreign: "_id"},
+		},
+	},
+	"Datastream": {
+		Name:        "Datastream",
+		SetName:     "Datastreams",
+		Collection:  "datastreams",
+		Fields:      odata.DatastreamFields,
+		Properties:  []string{"name", "description", "observationType", "unitOfMeasurement", "observedArea", "phenomenonTime", "resultTime", "properties"},
+		DefaultSort: bson.D{{Key: "_id", Value: 1}},
+		Navigation: map[string]navigation{
+			"Thing":            {Target: "Thing", Local: "thingId", Foreign: "_id"},
+			"Sensor":           {Target: "Sensor", Local: "sensorId", Foreign: "_id"},
+			"ObservedProperty": {Target: "ObservedProperty", Local: "observedPropertyId", Foreign: "_id"},
+			"Observations":     {Target: "Observation", Many: true, Local: "_id", Foreign: "datastream.datastreamId"},
+		},
+	},
+	"Thing": {
+		Name:        "Thing",
+		SetName:     "Things",
+		Collection:  "things",
+		Fields:      odata.ThingFields,
+		Properties:  []string{"name", "description", "properties"},
+		DefaultSort: bson.D{{Key: "_id", Value: 1}},
+		Navigation: map[string]navigation{
+			"Locations":           {Target: "Location", Many: true, Local: "_id", Foreign: "things"},
+			"HistoricalLocations": {Target: "HistoricalLocation", Many: true, Local: "_id", Foreign: "thingId"},
+			"Datastreams":         {Target: "Datastream", Many: true, Local: "_id", Foreign: "thingId"},
+		},
+	},
+	"Sensor": {
+		Name:        "Sensor",
+		SetName:     "Sensors",
+		Collection:  "sensors",
+		Fields:      odata.SensorFields,
+		Properties:  []string{"name", "description", "encodingType", "metadata", "properties"},
+		DefaultSort: bson.D{{Key: "_id", Value: 1}},
+		Navigation: map[string]navigation{
+			"Datastreams": {Target: "Datastream", Many: true, Local: "_id", Foreign: "sensorId"},
+		},
+	},
+	"ObservedProperty": {
+		Name:        "ObservedProperty",
+		SetName:     "ObservedProperties",
+		Collection:  "observed_properties",
+		Fields:      odata.ObservedPropertyFields,
+		Properties:  []string{"name", "definition", "description", "properties"},
+		DefaultSort: bson.D{{Key: "_id", Value: 1}},
+		Navigation: map[string]navigation{
+			"Datastreams": {Target: "Datastream", Many: true, Local: "_id", Foreign: "observedPropertyId"},
+		},
+	},
+	"Location": {
+		Name:        "Location",
+		SetName:     "Locations",
+		Collection:  "locations",
+		Fields:      odata.LocationFields,
+		Properties:  []string{"name", "description", "encodingType", "location", "properties"},
+		DefaultSort: bson.D{{Key: "_id", Value: 1}},
+		Navigation: map[string]navigation{
+			"Things":              {Target: "Thing", Many: true, Local: "things", Foreign: "_id"},
+			"HistoricalLocations": {Target: "HistoricalLocation", Many: true, Local: "_id", Foreign: "locationId"},
+		},
+	},
+	"HistoricalLocation": {
+		Name:        "HistoricalLocation",
+		SetName:     "HistoricalLocations",
+		Collection:  "historical_locations",
+		Fields:      odata.HistoricalLocationFields,
+		Properties:  []string{"time"},
+		DefaultSort: bson.D{{Key: "time", Value: -1}},
+		Navigation: map[string]navigation{
+			"Thing":     {Target: "Thing", Local: "thingId", Foreign: "_id"},
+			"Locations": {Target: "Location", Many: true, Local: "locationId", Foreign: "_id"},
+		},
+	},
+	"FeatureOfInterest": {
+		Name:        "FeatureOfInterest",
+		SetName:     "FeaturesOfInterest",
+		Collection:  "features_of_interest",
+		Fields:      odata.FeatureOfInterestFields,
+		Properties:  []string{"name", "description", "encodingType", "feature"},
+		DefaultSort: bson.D{{Key: "_id", Value: 1}},
+		Navigation: map[string]navigation{
+			"Observations": {Target: "Observation", Many: true, Local: "_id", Foreign: "featureOfInterestId"},
+		},
+	},
+}
+
+// encode converts a raw document of this type into its SensorThings representation
+func (t *entityType) encode(doc bson.M, root string) (Entity, error) {
+	if t.Name == "Observation" {
+		var obs models.Observation
+		if err := decodeDocument(doc, &obs); err != nil {
+			return nil, err
+		}
+		return observationEntity(&obs, root), nil
+	}
+
+	self := fmt.Sprintf("%s/%s(%s)", root, t.SetName, formatID(doc["_id"]))
+	entity := Entity{
+		"@iot.id":       jsonValue(doc["_id"]),
+		"@iot.selfLink": self,
+	}
+
+	for _, name := range t.Properties {
+		value, ok := doc[name]
+		if !ok {
+			continue
+		}
+		if interval, ok := intervalValue(value); ok {
+			entity[name] = interval
+			continue
+		}
+		entity[name] = jsonValue(value)
+	}
+
+	for name := range t.Navigation {
+		entity[name+"@iot.navigationLink"] = self + "/" + name
+	}
+
+	return entity, nil
+}
+
+// formatID renders an entity id for use in a resource path, doubling the
+// quotes inside string ids as OData requires
+func formatID(id interface{}) string {
+	switch v := id.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case primitive.ObjectID:
+		return "'" + v.Hex() + "'"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// intervalValue renders a stored {start, end} document as an ISO 8601 interval
+func intervalValue(v interface{}) (string, bool) {
+	doc, ok := jsonValue(v).(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	start, ok := doc["start"].(string)
+	if !ok {
+		return "", false
+	}
+	end, ok := doc["end"].(string)
+	if !ok {
+		end = ".."
+	}
+	return start + "/" + end, true
+}
+
+// decodeDocument converts a raw document into a typed model
+func decodeDocument(doc bson.M, v interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	if err := bson.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode document: %w", err)
+	}
+	return nil
+}
+
+// toDocument converts a typed model into a raw document
+func toDocument(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode document: %w", err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,520 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/odata"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// errExpansionTooLarge is returned when $expand would load more entities than allowed
+var errExpansionTooLarge = errors.New("$expand would load too many entities, narrow it with $top or $filter")
+
+// expandItem is one parsed $expand entry with its nested query options
+type expandItem struct {
+	Name    string
+	Nav     navigation
+	Target  *entityType
+	Select  []string
+	Filter  bson.M
+	OrderBy bson.D
+	Top     int64
+	Skip    int64
+	Expand  []*expandItem
+
+	// FilterExpr and OrderByExpr are the $filter and $orderby as given, for nextLinks
+	FilterExpr  string
+	OrderByExpr string
+}
+
+// parseExpand parses an $expand value relative to an entity type.
+// depth is the nesting level of the entity type within the request.
+func (s *Server) parseExpand(t *entityType, expr string, depth int) ([]*expandItem, error) {
+	var items []*expandItem
+
+	for _, raw := range splitTopLevel(expr, ',') {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return nil, fmt.Errorf("empty $expand item")
+		}
+
+		path, options := raw, ""
+		if open := strings.IndexByte(raw, '('); open >= 0 {
+			if !strings.HasSuffix(raw, ")") {
+				return nil, fmt.Errorf("malformed $expand item %q", raw)
+			}
+			path, options = raw[:open], raw[open+1:len(raw)-1]
+		}
+
+		names := strings.Split(strings.TrimSpace(path), "/")
+		if depth+len(names) > s.cfg.MaxExpandDepth {
+			return nil, fmt.Errorf("$expand is limited to a depth of %d", s.cfg.MaxExpandDepth)
+		}
+
+		// Datastream/Thing/Locations is shorthand for nested $expand
+		current, level, siblings := t, depth, &items
+		var item *expandItem
+		for i, name := range names {
+			nav, ok := current.Navigation[name]
+			if !ok {
+				return nil, fmt.Errorf("%s has no navigation property %q", current.Name, name)
+			}
+			item = findOrAddItem(siblings, name, nav, entityTypes[nav.Target], s.cfg.DefaultPageSize)
+			level++
+			if i < len(names)-1 {
+				current, siblings = item.Target, &item.Expand
+			}
+		}
+
+		if options != "" {
+			if err := s.parseExpandOptions(item, options, level); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// findOrAddItem merges repeated navigation properties into a single item
+func findOrAddItem(items *[]*expandItem, name string, nav navigation, target *entityType, top int) *expandItem {
+	for _, item := range *items {
+		if item.Name == name {
+			return item
+		}
+	}
+	item := &expandItem{Name: name, Nav: nav, Target: target, Top: int64(top)}
+	*items = append(*items, item)
+	return item
+}
+
+// parseExpandOptions parses the ';' separated options of a nested $expand item
+func (s *Server) parseExpandOptions(item *expandItem, options string, depth int) error {
+	for _, option := range splitTopLevel(options, ';') {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok {
+			return fmt.Errorf("malformed $expand option %q", option)
+		}
+
+		switch key {
+		case "$select":
+			item.Select = splitList(value)
+		case "$filter":
+			filter, err := odata.CompileFilter(value, item.Target.Fields)
+			if err != nil {
+				return fmt.Errorf("invalid $filter in $expand of %s: %w", item.Name, err)
+			}
+			item.Filter, item.FilterExpr = filter, value
+		case "$orderby":
+			orderBy, err := parseOrderBy(value, item.Target.Fields)
+			if err != nil {
+				return err
+			}
+			item.OrderBy, item.OrderByExpr = orderBy, value
+		case "$top", "$skip":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s value %q in $expand of %s", key, value, item.Name)
+			}
+			if key == "$top" {
+				item.Top = min(n, int64(s.cfg.MaxPageSize))
+			} else {
+				item.Skip = n
+			}
+		case "$expand":
+			nested, err := s.parseExpand(item.Target, value, depth)
+			if err != nil {
+				return err
+			}
+			item.Expand = append(item.Expand, nested...)
+		default:
+			return fmt.Errorf("unsupported option %s in $expand of %s", key, item.Name)
+		}
+	}
+	return nil
+}
+
+// expandOptionOrder is the order in which formatExpand writes nested options
+var expandOptionOrder = []string{"$select", "$filter", "$orderby", "$top", "$skip", "$expand"}
+
+// queryValues returns the query options that select the expanded collection
+// of an item, as they would be given when reading it directly
+func (item *expandItem) queryValues() url.Values {
+	values := url.Values{}
+	if len(item.Select) > 0 {
+		values.Set("$select", strings.Join(item.Select, ","))
+	}
+	if item.FilterExpr != "" {
+		values.Set("$filter", item.FilterExpr)
+	}
+	if item.OrderByExpr != "" {
+		values.Set("$orderby", item.OrderByExpr)
+	}
+	// Paging only applies to collections
+	if item.Nav.Many {
+		values.Set("$top", strconv.FormatInt(item.Top, 10))
+		if item.Skip > 0 {
+			values.Set("$skip", strconv.FormatInt(item.Skip, 10))
+		}
+	}
+	if len(item.Expand) > 0 {
+		values.Set("$expand", formatExpand(item.Expand))
+	}
+	return values
+}
+
+// formatExpand writes parsed $expand items back as an $expand value
+func formatExpand(items []*expandItem) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		values := item.queryValues()
+		var options []string
+		for _, key := range expandOptionOrder {
+			if value := values.Get(key); value != "" {
+				options = append(options, key+"="+value)
+			}
+		}
+		parts[i] = item.Name
+		if len(options) > 0 {
+			parts[i] += "(" + strings.Join(options, ";") + ")"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitTopLevel splits on sep outside of parentheses and quoted strings
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, inQuote, start := 0, false, 0
+
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\'':
+			inQuote = !inQuote
+		case inQuote:
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitList splits a comma separated list, dropping blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// expansion resolves $expand items for a page of entities, bounded by a budget
+// on the number of related entities loaded per request
+type expansion struct {
+	lookups *repository.LookupRepository
+	root    string
+	budget  int64
+}
+
+// newExpansion creates an expansion for one request
+func (s *Server) newExpansion(root string) *expansion {
+	return &expansion{
+		lookups: s.lookups,
+		root:    root,
+		budget:  int64(s.cfg.MaxExpandEntities),
+	}
+}
+
+// apply resolves items for the given documents and attaches them to their entities
+func (x *expansion) apply(ctx context.Context, docs []bson.M, entities []Entity, items []*expandItem) error {
+	for _, item := range items {
+		var err error
+		if item.Nav.Many {
+			err = x.applyMany(ctx, docs, entities, item)
+		} else {
+			err = x.applyOne(ctx, docs, entities, item)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOne resolves a to-one navigation property with a single batched query
+func (x *expansion) applyOne(ctx context.Context, docs []bson.M, entities []Entity, item *expandItem) error {
+	seen := map[interface{}]bool{}
+	var keys bson.A
+	for _, doc := range docs {
+		for _, key := range valuesAt(doc, item.Nav.Local) {
+			if isKey(key) && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if err := x.spend(int64(len(keys))); err != nil {
+		return err
+	}
+
+	related := map[interface{}]Entity{}
+	var relatedDocs []bson.M
+	var relatedEntities []Entity
+
+	if len(keys) > 0 {
+		found, err := x.lookups.Find(ctx, item.Target.Collection, repository.Query{
+			Filter: withFilter(bson.M{item.Nav.Foreign: bson.M{"$in": keys}}, item.Filter),
+			Limit:  int64(len(keys)),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range found {
+			entity, err := item.Target.encode(doc, x.root)
+			if err != nil {
+				return err
+			}
+			entity = applySelect(entity, item.Select)
+			for _, key := range valuesAt(doc, item.Nav.Foreign) {
+				if isKey(key) {
+					related[key] = entity
+				}
+			}
+			relatedDocs = append(relatedDocs, doc)
+			relatedEntities = append(relatedEntities, entity)
+		}
+	}
+
+	for i, doc := range docs {
+		var entity Entity
+		if values := valuesAt(doc, item.Nav.Local); len(values) > 0 && isKey(values[0]) {
+			entity = related[values[0]]
+		}
+		// A missing reference serializes as null
+		entities[i][item.Name] = entity
+	}
+
+	return x.apply(ctx, relatedDocs, relatedEntities, item.Expand)
+}
+
+// applyMany resolves a to-many navigation property for the whole page with a
+// single query. The related documents are ranked per key on the server, so no
+// more than $skip+$top are read for any key, then grouped per entity here.
+func (x *expansion) applyMany(ctx context.Context, docs []bson.M, entities []Entity, item *expandItem) error {
+	// Reserve the worst case up front so an oversized request fails before hitting the database
+	if err := x.spend(int64(len(docs)) * item.Top); err != nil {
+		return err
+	}
+
+	sort := item.OrderBy
+	if len(sort) == 0 {
+		sort = item.Target.DefaultSort
+	}
+	sort = withIDTieBreak(sort)
+
+	// Index the entities by the keys their related documents are found by
+	owners := map[interface{}][]int{}
+	var keys bson.A
+	singleKey := true
+	for i, doc := range docs {
+		n := 0
+		for _, key := range valuesAt(doc, item.Nav.Local) {
+			if !isKey(key) {
+				continue
+			}
+			if _, ok := owners[key]; !ok {
+				keys = append(keys, key)
+			}
+			owners[key] = append(owners[key], i)
+			n++
+		}
+		if n > 1 {
+			singleKey = false
+		}
+	}
+
+	var found []bson.M
+	if len(keys) > 0 && item.Top > 0 {
+		var err error
+		found, err = x.lookups.Aggregate(ctx, item.Target.Collection, expandPipeline(item, keys, sort, singleKey))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The results are in order, so each entity collects its documents in order.
+	// An entity with several keys is paged here, as its documents are ranked per key.
+	grouped := make([][]bson.M, len(docs))
+	var seen []map[interface{}]bool
+	if !singleKey {
+		seen = make([]map[interface{}]bool, len(docs))
+	}
+	for _, doc := range found {
+		key := doc[expandKeyField]
+		delete(doc, expandKeyField)
+		if !isKey(key) {
+			continue
+		}
+		for _, i := range owners[key] {
+			if seen != nil {
+				if seen[i] == nil {
+					seen[i] = map[interface{}]bool{}
+				}
+				if seen[i][doc["_id"]] {
+					continue
+				}
+				seen[i][doc["_id"]] = true
+			}
+			grouped[i] = append(grouped[i], doc)
+		}
+	}
+
+	var relatedDocs []bson.M
+	var relatedEntities []Entity
+
+	for i := range docs {
+		page := grouped[i]
+		if !singleKey {
+			page = page[min(int64(len(page)), item.Skip):]
+			page = page[:min(int64(len(page)), item.Top)]
+		}
+
+		children := []Entity{}
+		for _, child := range page {
+			entity, err := item.Target.encode(child, x.root)
+			if err != nil {
+				return err
+			}
+			entity = applySelect(entity, item.Select)
+			children = append(children, entity)
+			relatedDocs = append(relatedDocs, child)
+			relatedEntities = append(relatedEntities, entity)
+		}
+
+		if item.Top > 0 && int64(len(page)) == item.Top {
+			if link, ok := entities[i][item.Name+"@iot.navigationLink"].(string); ok {
+				// The link carries every nested option so that it pages the same collection
+				values := item.queryValues()
+				values.Set("$skip", strconv.FormatInt(item.Skip+item.Top, 10))
+				entities[i][item.Name+"@iot.nextLink"] = link + "?" + values.Encode()
+			}
+		}
+
+		entities[i][item.Name] = children
+	}
+
+	return x.apply(ctx, relatedDocs, relatedEntities, item.Expand)
+}
+
+const (
+	// expandKeyField carries the key a related document was matched by
+	expandKeyField = "_expandKey"
+	// expandRankField carries the position of a related document within its key
+	expandRankField = "_expandRank"
+)
+
+// expandPipeline finds the related documents of keys in sort order, keeping
+// the first $skip+$top per key, or only the requested page when every entity
+// has a single key. A document related through several keys is returned once
+// for each, with the key in expandKeyField. Ranking needs MongoDB 5.0, which
+// time series collections require anyway.
+func expandPipeline(item *expandItem, keys bson.A, sort bson.D, singleKey bool) mongo.Pipeline {
+	rank := bson.M{"$lte": item.Skip + item.Top}
+	if singleKey {
+		rank["$gt"] = item.Skip
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: withFilter(bson.M{item.Nav.Foreign: bson.M{"$in": keys}}, item.Filter)}},
+		{{Key: "$addFields", Value: bson.M{expandKeyField: "$" + item.Nav.Foreign}}},
+		// Array references relate the document through each of their values
+		{{Key: "$unwind", Value: "$" + expandKeyField}},
+		{{Key: "$match", Value: bson.M{expandKeyField: bson.M{"$in": keys}}}},
+		{{Key: "$setWindowFields", Value: bson.M{
+			"partitionBy": "$" + expandKeyField,
+			"sortBy":      sort,
+			"output":      bson.M{expandRankField: bson.M{"$documentNumber": bson.M{}}},
+		}}},
+		{{Key: "$match", Value: bson.M{expandRankField: rank}}},
+		{{Key: "$sort", Value: sort}},
+		{{Key: "$unset", Value: expandRankField}},
+	}
+}
+
+// spend consumes part of the expansion budget
+func (x *expansion) spend(n int64) error {
+	x.budget -= n
+	if x.budget < 0 {
+		return errExpansionTooLarge
+	}
+	return nil
+}
+
+// withFilter combines a reference filter with an optional user $filter
+func withFilter(filter, extra bson.M) bson.M {
+	if extra == nil {
+		return filter
+	}
+	return bson.M{"$and": bson.A{filter, extra}}
+}
+
+// isKey reports whether a value can identify a referenced document
+func isKey(v interface{}) bool {
+	switch v.(type) {
+	case string, primitive.ObjectID, int32, int64, float64:
+		return true
+	}
+	return false
+}
+
+// valuesAt returns the values at a dotted path, flattening arrays
+func valuesAt(doc bson.M, path string) []interface{} {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case bson.M:
+			current = v[key]
+		case map[string]interface{}:
+			current = v[key]
+		case bson.D:
+			var found interface{}
+			for _, e := range v {
+				if e.Key == key {
+					found = e.Value
+					break
+				}
+			}
+			current = found
+		default:
+			return nil
+		}
+	}
+
+	switch v := current.(type) {
+	case nil:
+		return nil
+	case bson.A:
+		return []interface{}(v)
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
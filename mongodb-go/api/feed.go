@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/services"
+)
+
+// FeedPath serves the real-time observation feed
+const FeedPath = "/feed/observations"
+
+const (
+	// feedKeepAlive is how often an idle feed connection is sent a heartbeat
+	feedKeepAlive = 30 * time.Second
+	// feedWriteTimeout is how long a write to a feed client may block
+	feedWriteTimeout = 30 * time.Second
+)
+
+// feedMessage is a WebSocket message of the observation feed
+type feedMessage struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event"`
+	Data  Entity `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ServeFeed records every observation stored through the observation
+// repository in feed and serves it at FeedPath
+func (s *Server) ServeFeed(feed *services.ObservationFeedService) {
+	s.feed = feed
+	s.observations.AddPublisher(feed)
+}
+
+// handleFeed streams newly stored observations as Server-Sent Events, or over
+// a WebSocket when the client asks for an upgrade. The datastream, thing and
+// featureOfInterest parameters, each repeatable, and bbox select the
+// observations. Clients resume after an event by passing its id as
+// resumeAfter or, for Server-Sent Events, as Last-Event-ID.
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	if s.feed == nil {
+		writeError(w, http.StatusNotFound, "the observation feed is not enabled")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+		return
+	}
+
+	filter, err := parseFeedFilter(r.URL.Query())
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	resumeAfter := r.URL.Query().Get("resumeAfter")
+	if resumeAfter == "" {
+		resumeAfter = r.Header.Get("Last-Event-ID")
+	}
+	sub, err := s.feed.Subscribe(r.Context(), filter, resumeAfter)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	root := s.serviceRoot(r)
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// Any origin may subscribe, as with the rest of the API
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			s.streamWebSocket(ws, sub, root)
+		}}.ServeHTTP(w, r)
+		return
+	}
+	s.streamEvents(w, r, sub, root)
+}
+
+// parseFeedFilter reads the observation filter of a feed request
+func parseFeedFilter(query url.Values) (services.ObservationFeedFilter, error) {
+	filter := services.ObservationFeedFilter{
+		DatastreamIDs:        query["datastream"],
+		ThingIDs:             query["thing"],
+		FeatureOfInterestIDs: query["featureOfInterest"],
+	}
+	value := query.Get("bbox")
+	if value == "" {
+		return filter, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return filter, invalidRequest("bbox must be minx,miny,maxx,maxy")
+	}
+	filter.BBox = make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return filter, invalidRequest("bbox must be minx,miny,maxx,maxy: %v", err)
+		}
+		filter.BBox[i] = v
+	}
+	if filter.BBox[0] > filter.BBox[2] || filter.BBox[1] > filter.BBox[3] {
+		return filter, invalidRequest("bbox minimum exceeds its maximum")
+	}
+	return filter, nil
+}
+
+// streamEvents writes the events of a subscription as Server-Sent Events
+// until the client goes away or the subscription ends. A lagging client is
+// told so before the stream closes; EventSource clients then reconnect and
+// resume from the last event id on their own.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, sub *services.FeedSubscription, root string) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's read timeout
+	rc.SetReadDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		rc.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				if errors.Is(sub.Err(), services.ErrFeedLagged) {
+					rc.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+					fmt.Fprintf(w, "event: lagged\ndata: %q\n\n", sub.Err().Error())
+					rc.Flush()
+				}
+				return
+			}
+			data, err := json.Marshal(observationEntity(&event.Observation, root))
+			if err != nil {
+				s.logger.WithError(err).Error("Failed to encode feed event")
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: observation\ndata: %s\n\n", event.Token, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// streamWebSocket sends the events of a subscription as JSON messages until
+// the client disconnects or the subscription ends
+func (s *Server) streamWebSocket(ws *websocket.Conn, sub *services.FeedSubscription, root string) {
+	// The hijacked connection still carries the server's read deadline
+	ws.SetReadDeadline(time.Time{})
+	disconnected := make(chan struct{})
+	go func() {
+		// Reading also answers pings and notices the client closing
+		io.Copy(io.Discard, ws)
+		close(disconnected)
+	}()
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var msg feedMessage
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				if errors.Is(sub.Err(), services.ErrFeedLagged) {
+					ws.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+					websocket.JSON.Send(ws, feedMessage{Event: "lagged", Error: sub.Err().Error()})
+				}
+				return
+			}
+			msg = feedMessage{
+				ID:    event.Token,
+				Event: "observation",
+				Data:  observationEntity(&event.Observation, root),
+			}
+		case <-keepAlive.C:
+			msg = feedMessage{Event: "keepalive"}
+		case <-disconnected:
+			return
+		}
+
+		ws.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/rdf"
+)
+
+// LinkedDataPath is the path prefix of the RDF exports
+const LinkedDataPath = "/linked-data"
+
+// linkedDataFormats maps the accepted format parameter values to media types
+var linkedDataFormats = map[string]string{
+	"jsonld":  rdf.JSONLDContentType,
+	"json-ld": rdf.JSONLDContentType,
+	"ttl":     rdf.TurtleContentType,
+	"turtle":  rdf.TurtleContentType,
+}
+
+// handleLinkedData serves features of interest and units of measurement as
+// JSON-LD or Turtle:
+//
+//	/linked-data                              everything
+//	/linked-data/FeaturesOfInterest           all features of interest
+//	/linked-data/FeaturesOfInterest('FOI-1')  one feature of interest
+//	/linked-data/UnitsOfMeasurement           all units
+func (s *Server) handleLinkedData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+		return
+	}
+
+	contentType, err := linkedDataFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	segments, err := parsePath(strings.TrimPrefix(r.URL.Path, LinkedDataPath))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	root := s.serviceRoot(r)
+	var g *rdf.Graph
+	switch {
+	case len(segments) == 0:
+		g, err = s.linkedData.Dataset(r.Context(), root)
+	case len(segments) == 1 && segments[0].Name == "FeaturesOfInterest" && !segments[0].HasID:
+		g, err = s.linkedData.FeaturesOfInterest(r.Context(), root)
+	case len(segments) == 1 && segments[0].Name == "FeaturesOfInterest":
+		g, err = s.linkedData.FeatureOfInterest(r.Context(), root, segments[0].ID)
+	case len(segments) == 1 && segments[0].Name == "UnitsOfMeasurement" && !segments[0].HasID:
+		g, err = s.linkedData.Units(r.Context())
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("resource %s not found", r.URL.Path))
+		return
+	}
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	if contentType == rdf.TurtleContentType {
+		err = g.WriteTurtle(w)
+	} else {
+		err = g.WriteJSONLD(w)
+	}
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to write linked data")
+	}
+}
+
+// linkedDataFormat picks the media type of a response from the format parameter,
+// then the Accept header; JSON-LD is the default
+func linkedDataFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		contentType, ok := linkedDataFormats[strings.ToLower(format)]
+		if !ok {
+			return "", fmt.Errorf("unsupported format %q", format)
+		}
+		return contentType, nil
+	}
+	// The first of the two listed wins; quality values are not weighed
+	accept := r.Header.Get("Accept")
+	turtle, jsonld := strings.Index(accept, rdf.TurtleContentType), strings.Index(accept, rdf.JSONLDContentType)
+	if turtle >= 0 && (jsonld < 0 || turtle < jsonld) {
+		return rdf.TurtleContentType, nil
+	}
+	return rdf.JSONLDContentType, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// MQTTPublisher sends a message to the MQTT clients subscribed to a topic.
+// The embedded mqtt.Broker is one; a client of an external broker could be another.
+type MQTTPublisher interface {
+	Publish(topic string, payload []byte)
+}
+
+// PublishTo makes the server publish every observation stored through the
+// observation repository to the SensorThings MQTT topics
+// v1.1/Observations, v1.1/Datastreams(id)/Observations and, when it has one,
+// v1.1/FeaturesOfInterest(id)/Observations
+func (s *Server) PublishTo(publisher MQTTPublisher) {
+	s.mqtt = true
+	s.observations.AddPublisher(&observationPublisher{s: s, mqtt: publisher})
+}
+
+// observationPublisher publishes stored observations as SensorThings entities
+type observationPublisher struct {
+	s    *Server
+	mqtt MQTTPublisher
+}
+
+// PublishObservations publishes each observation on the topics it belongs to
+func (p *observationPublisher) PublishObservations(ctx context.Context, observations []models.Observation) {
+	root := p.s.publishedRoot()
+	for i := range observations {
+		obs := &observations[i]
+		payload, err := json.Marshal(observationEntity(obs, root))
+		if err != nil {
+			p.s.logger.WithError(err).Error("Failed to encode observation for MQTT")
+			continue
+		}
+		for _, topic := range observationTopics(obs) {
+			p.mqtt.Publish(topic, payload)
+		}
+	}
+}
+
+// observationTopics lists the MQTT topics an observation is published on
+func observationTopics(obs *models.Observation) []string {
+	topics := []string{
+		APIVersion + "/Observations",
+		fmt.Sprintf("%s/Datastreams(%s)/Observations", APIVersion, formatID(obs.Datastream.DatastreamID)),
+	}
+	if obs.FeatureOfInterestID != "" {
+		topics = append(topics, fmt.Sprintf("%s/FeaturesOfInterest(%s)/Observations", APIVersion, formatID(obs.FeatureOfInterestID)))
+	}
+	return topics
+}
+
+// publishedRoot is the service root used in the links of published entities,
+// which are not tied to an HTTP request
+func (s *Server) publishedRoot() string {
+	if s.cfg.ServiceRootURL != "" {
+		return strings.TrimRight(s.cfg.ServiceRootURL, "/") + "/" + APIVersion
+	}
+	return fmt.Sprintf("http://localhost:%d/%s", s.cfg.Port, APIVersion)
+}
+
+// AuthenticateMQTT admits an MQTT client when JWT_SECRET is unset, or when
+// its password is a bearer token signed with it; the user name is not used
+func (s *Server) AuthenticateMQTT(username string, password []byte) bool {
+	if s.cfg.JWTSecret == "" {
+		return true
+	}
+	if err := verifyToken(string(password), []byte(s.cfg.JWTSecret), time.Now()); err != nil {
+		s.logger.WithField("username", username).WithError(err).Debug("Rejected MQTT credentials")
+		return false
+	}
+	return true
+}
+
+// HandleMQTTPublish creates an observation from a message published on
+// v1.1/Observations, v1.1/Datastreams(id)/Observations or
+// v1.1/FeaturesOfInterest(id)/Observations. The payload is an observation
+// as it would be posted over HTTP and is validated and stored the same way,
+// and is only accepted while writes are enabled.
+func (s *Server) HandleMQTTPublish(ctx context.Context, topic string, payload []byte) error {
+	if !s.cfg.WritesEnabled {
+		return errWritesDisabled
+	}
+	path, ok := strings.CutPrefix(topic, APIVersion+"/")
+	if !ok {
+		return fmt.Errorf("topic %s: %w", topic, repository.ErrNotFound)
+	}
+	segments, err := parsePath(path)
+	if err != nil {
+		return invalidRequest("topic %s: %v", topic, err)
+	}
+	body, err := decodeBody(payload)
+	if err != nil {
+		return err
+	}
+	t, err := createTarget(topic, segments, body)
+	if err != nil {
+		return err
+	}
+	if t.Name != "Observation" {
+		return invalidRequest("only Observations can be created over MQTT, not %s", t.SetName)
+	}
+
+	return s.transact(ctx, func(ctx context.Context, tx *writeTx) error {
+		_, err := s.createEntity(ctx, tx, t, body)
+		return err
+	})
+}
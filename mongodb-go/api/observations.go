@@ -0,0 +1,218 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// handleListObservations serves the Observations entity set, limited to scope
+// when a navigation property such as Datastreams('DS-001')/Observations lists them
+func (s *Server) handleListObservations(w http.ResponseWriter, r *http.Request, scope bson.M) {
+	opts, err := s.parseQueryOptions(r.URL.Query(), entityTypes["Observation"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := bson.M{}
+	if scope != nil {
+		filter = scope
+	}
+	if opts.Filter != nil {
+		filter = bson.M{"$and": bson.A{filter, opts.Filter}}
+	}
+
+	resp := CollectionResponse{Value: []Entity{}}
+	root := s.serviceRoot(r)
+
+	if opts.Top > 0 {
+		observations, link, err := s.findObservationPage(r, root, filter, opts)
+		if err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+
+		for i := range observations {
+			entity := observationEntity(&observations[i], root)
+			resp.Value = append(resp.Value, applySelect(entity, opts.Select))
+		}
+		if err := s.expandObservations(r, root, observations, resp.Value, opts.Expand); err != nil {
+			s.writeExpandError(w, err)
+			return
+		}
+		resp.NextLink = link
+	}
+
+	if opts.Count {
+		count, err := s.observations.Count(r.Context(), filter)
+		if err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+		resp.Count = &count
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// findObservationPage reads one page of observations and builds the link to the next.
+// The default (phenomenonTime, _id) ordering pages with continuation tokens, which stay
+// cheap however deep the client reads; an explicit $orderby or $skip falls back to offsets.
+func (s *Server) findObservationPage(r *http.Request, root string, filter bson.M,
+	opts *queryOptions) ([]models.Observation, string, error) {
+
+	if len(opts.OrderBy) == 0 && opts.Skip == 0 {
+		observations, token, err := s.observations.FindPage(r.Context(), filter,
+			repository.StreamOptions{After: opts.SkipToken}, opts.Top)
+		if err != nil {
+			return nil, "", err
+		}
+		return observations, skipTokenLink(r, root, opts, token), nil
+	}
+
+	observations, err := s.observations.Find(r.Context(), repository.Query{
+		Filter: filter,
+		Sort:   withIDTieBreak(opts.OrderBy),
+		Skip:   opts.Skip,
+		Limit:  opts.Top,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return observations, nextLink(r, root, opts, len(observations)), nil
+}
+
+// handleGetObservation serves a single Observation
+func (s *Server) handleGetObservation(w http.ResponseWriter, r *http.Request, id string) {
+	opts, err := s.parseQueryOptions(r.URL.Query(), entityTypes["Observation"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid observation id %q", id))
+		return
+	}
+
+	obs, err := s.observations.FindByID(r.Context(), oid)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	root := s.serviceRoot(r)
+	entity := applySelect(observationEntity(obs, root), opts.Select)
+	if err := s.expandObservations(r, root, []models.Observation{*obs}, []Entity{entity}, opts.Expand); err != nil {
+		s.writeExpandError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entity)
+}
+
+// expandObservations resolves $expand for a page of observations
+func (s *Server) expandObservations(r *http.Request, root string, observations []models.Observation,
+	entities []Entity, items []*expandItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	docs := make([]bson.M, len(observations))
+	for i := range observations {
+		doc, err := toDocument(&observations[i])
+		if err != nil {
+			return err
+		}
+		docs[i] = doc
+	}
+	return s.newExpansion(root).apply(r.Context(), docs, entities, items)
+}
+
+// observationEntity converts an observation document into its SensorThings representation
+func observationEntity(obs *models.Observation, root string) Entity {
+	id := obs.ID.Hex()
+	self := fmt.Sprintf("%s/Observations('%s')", root, id)
+
+	entity := Entity{
+		"@iot.id":                              id,
+		"@iot.selfLink":                        self,
+		"phenomenonTime":                       formatTime(obs.PhenomenonTime),
+		"resultTime":                           nil,
+		"result":                               jsonValue(obs.Result),
+		"Datastream@iot.navigationLink":        self + "/Datastream",
+		"FeatureOfInterest@iot.navigationLink": self + "/FeatureOfInterest",
+	}
+
+	if obs.ResultTime != nil {
+		entity["resultTime"] = formatTime(*obs.ResultTime)
+	}
+	if obs.ResultQuality != "" {
+		entity["resultQuality"] = obs.ResultQuality
+	}
+	if obs.ValidTime != nil {
+		entity["validTime"] = formatInterval(obs.ValidTime.Start, obs.ValidTime.End)
+	}
+	if len(obs.Parameters) > 0 {
+		entity["parameters"] = jsonValue(obs.Parameters)
+	}
+
+	return entity
+}
+
+// formatTime renders a time in the ISO 8601 form used by SensorThings
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatInterval renders an ISO 8601 time interval; open intervals end with ".."
+func formatInterval(start time.Time, end *time.Time) string {
+	if end == nil {
+		return formatTime(start) + "/.."
+	}
+	return formatTime(start) + "/" + formatTime(*end)
+}
+
+// jsonValue converts decoded BSON values into plain JSON-friendly values
+func jsonValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(val))
+		for _, e := range val {
+			m[e.Key] = jsonValue(e.Value)
+		}
+		return m
+	case primitive.M:
+		return jsonValue(map[string]interface{}(val))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case primitive.A:
+		return jsonValue([]interface{}(val))
+	case []interface{}:
+		a := make([]interface{}, len(val))
+		for i, e := range val {
+			a[i] = jsonValue(e)
+		}
+		return a
+	case primitive.DateTime:
+		return formatTime(val.Time())
+	case time.Time:
+		return formatTime(val)
+	case primitive.ObjectID:
+		return val.Hex()
+	case primitive.Decimal128:
+		return val.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// errInvalidRequest is returned when a request body is not a valid SensorThings entity
+var errInvalidRequest = errors.New("invalid request")
+
+// invalidRequest formats an errInvalidRequest
+func invalidRequest(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errInvalidRequest, fmt.Sprintf(format, args...))
+}
+
+// entityBody is a SensorThings entity as posted, keyed by property or navigation name
+type entityBody map[string]json.RawMessage
+
+// decodeBody parses a JSON object
+func decodeBody(data []byte) (entityBody, error) {
+	var body entityBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, invalidRequest("request body must be a JSON object: %v", err)
+	}
+	if body == nil {
+		return nil, invalidRequest("request body must be a JSON object")
+	}
+	return body, nil
+}
+
+// applyProperties passes every property of a body to set in a stable order,
+// skipping annotations such as @iot.id and the navigation properties of t
+func applyProperties(t *entityType, body entityBody, set func(name string, raw json.RawMessage) error) error {
+	names := make([]string, 0, len(body))
+	for name := range body {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "@iot.") {
+			continue
+		}
+		if _, ok := t.Navigation[name]; ok {
+			continue
+		}
+		if err := set(name, body[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unknownProperty reports a property the entity type does not have
+func unknownProperty(t *entityType, name string) error {
+	return invalidRequest("%s has no property %q", t.Name, name)
+}
+
+// setThingProperty sets a property of a thing
+func setThingProperty(thing *models.Thing, name string, raw json.RawMessage) error {
+	switch name {
+	case "name":
+		return decodeProperty(name, raw, &thing.Name)
+	case "description":
+		return decodeProperty(name, raw, &thing.Description)
+	case "properties":
+		return decodeProperty(name, raw, &thing.Properties)
+	}
+	return unknownProperty(entityTypes["Thing"], name)
+}
+
+// setLocationProperty sets a property of a location
+func setLocationProperty(loc *models.Location, name string, raw json.RawMessage) error {
+	switch name {
+	case "name":
+		return decodeProperty(name, raw, &loc.Name)
+	case "description":
+		return decodeProperty(name, raw, &loc.Description)
+	case "encodingType":
+		return decodeProperty(name, raw, &loc.EncodingType)
+	case "location":
+		geometry, err := geometryProperty(name, raw)
+		loc.Location = geometry
+		return err
+	case "properties":
+		return decodeProperty(name, raw, &loc.Properties)
+	}
+	return unknownProperty(entityTypes["Location"], name)
+}
+
+// setSensorProperty sets a property of a sensor
+func setSensorProperty(sensor *models.Sensor, name string, raw json.RawMessage) error {
+	switch name {
+	case "name":
+		return decodeProperty(name, raw, &sensor.Name)
+	case "description":
+		return decodeProperty(name, raw, &sensor.Description)
+	case "encodingType":
+		return decodeProperty(name, raw, &sensor.EncodingType)
+	case "metadata":
+		return decodeProperty(name, raw, &sensor.Metadata)
+	case "properties":
+		return decodeProperty(name, raw, &sensor.Properties)
+	}
+	return unknownProperty(entityTypes["Sensor"], name)
+}
+
+// setObservedPropertyProperty sets a property of an observed property
+func setObservedPropertyProperty(property *models.ObservedProperty, name string, raw json.RawMessage) error {
+	switch name {
+	case "name":
+		return decodeProperty(name, raw, &property.Name)
+	case "definition":
+		return decodeProperty(name, raw, &property.Definition)
+	case "description":
+		return decodeProperty(name, raw, &property.Description)
+	case "properties":
+		return decodeProperty(name, raw, &property.Properties)
+	}
+	return unknownProperty(entityTypes["ObservedProperty"], name)
+}
+
+// setDatastreamProperty sets a property of a datastream
+func setDatastreamProperty(ds *models.Datastream, name string, raw json.RawMessage) error {
+	switch name {
+	case "name":
+		return decodeProperty(name, raw, &ds.Name)
+	case "description":
+		return decodeProperty(name, raw, &ds.Description)
+	case "observationType":
+		return decodeProperty(name, raw, &ds.ObservationType)
+	case "unitOfMeasurement":
+		return decodeProperty(name, raw, &ds.UnitOfMeasurement)
+	case "observedArea":
+		geometry, err := geometryProperty(name, raw)
+		ds.ObservedArea = geometry
+		return err
+	case "phenomenonTime":
+		interval, err := intervalProperty(name, raw)
+		ds.PhenomenonTime = interval
+		return err
+	case "resultTime":
+		interval, err := intervalProperty(name, raw)
+		ds.ResultTime = interval
+		return err
+	case "properties":
+		return decodeProperty(name, raw, &ds.Properties)
+	}
+	return unknownProperty(entityTypes["Datastream"], name)
+}
+
+// setFeatureOfInterestProperty sets a property of a feature of interest.
+// The feature may be posted as a bare GeoJSON geometry or as a GeoJSON Feature.
+func setFeatureOfInterestProperty(foi *models.FeatureOfInterest, name string, raw json.RawMessage) error {
+	switch name {
+	case "name":
+		return decodeProperty(name, raw, &foi.Name)
+	case "description":
+		return decodeProperty(name, raw, &foi.Description)
+	case "encodingType":
+		if err := decodeProperty(name, raw, &foi.EncodingType); err != nil {
+			return err
+		}
+		// SensorThings clients commonly send the IANA media type
+		if foi.EncodingType == "application/geo+json" {
+			foi.EncodingType = "application/vnd.geo+json"
+		}
+		return nil
+	case "feature":
+		var feature models.GeoJSONFeature
+		if err := decodeProperty(name, raw, &feature); err != nil {
+			return err
+		}
+		if feature.Type != "Feature" {
+			geometry, err := geometryProperty(name, raw)
+			if err != nil {
+				return err
+			}
+			feature = models.GeoJSONFeature{Type: "Feature", Geometry: geometry}
+		}
+		foi.Feature = feature
+		return nil
+	}
+	return unknownProperty(entityTypes["FeatureOfInterest"], name)
+}
+
+// setObservationProperty sets a property of an observation
+func setObservationProperty(obs *models.Observation, name string, raw json.RawMessage) error {
+	switch name {
+	case "phenomenonTime":
+		t, err := timeProperty(name, raw)
+		obs.PhenomenonTime = t
+		return err
+	case "resultTime":
+		if isNull(raw) {
+			obs.ResultTime = nil
+			return nil
+		}
+		t, err := timeProperty(name, raw)
+		obs.ResultTime = &t
+		return err
+	case "result":
+		return decodeProperty(name, raw, &obs.Result)
+	case "resultQuality":
+		return decodeProperty(name, raw, &obs.ResultQuality)
+	case "validTime":
+		interval, err := intervalProperty(name, raw)
+		if err != nil || interval == nil {
+			obs.ValidTime = nil
+			return err
+		}
+		obs.ValidTime = &models.ValidTime{Start: interval.Start, End: interval.End}
+		return nil
+	case "parameters":
+		return decodeProperty(name, raw, &obs.Parameters)
+	}
+	return unknownProperty(entityTypes["Observation"], name)
+}
+
+// decodeProperty replaces the value at v with the property value; null clears it
+func decodeProperty(name string, raw json.RawMessage, v interface{}) error {
+	target := reflect.ValueOf(v).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	if err := json.Unmarshal(raw, v); err != nil {
+		return invalidRequest("invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+// timeProperty parses an ISO 8601 time instant
+func timeProperty(name string, raw json.RawMessage) (time.Time, error) {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return time.Time{}, invalidRequest("%s must be an ISO 8601 time", name)
+	}
+	t, err := parseTime(value)
+	if err != nil {
+		return time.Time{}, invalidRequest("%s must be an ISO 8601 time: %v", name, err)
+	}
+	return t, nil
+}
+
+// parseTime parses an ISO 8601 time, also accepting offsets without a colon such as -0700
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		if compact, compactErr := time.Parse("2006-01-02T15:04:05.999999999Z0700", value); compactErr == nil {
+			return compact.UTC(), nil
+		}
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// intervalProperty parses an ISO 8601 time interval "start/end", where an end
+// of ".." leaves it open, or a time instant as an interval of no length
+func intervalProperty(name string, raw json.RawMessage) (*models.TimeInterval, error) {
+	if isNull(raw) {
+		return nil, nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, invalidRequest("%s must be an ISO 8601 time interval", name)
+	}
+
+	startText, endText, isInterval := strings.Cut(value, "/")
+	start, err := parseTime(startText)
+	if err != nil {
+		return nil, invalidRequest("%s must be an ISO 8601 time interval: %v", name, err)
+	}
+	interval := &models.TimeInterval{Start: start}
+	switch {
+	case !isInterval:
+		end := interval.Start
+		interval.End = &end
+	case endText != "..":
+		end, err := parseTime(endText)
+		if err != nil {
+			return nil, invalidRequest("%s must be an ISO 8601 time interval: %v", name, err)
+		}
+		if end.Before(interval.Start) {
+			return nil, invalidRequest("%s ends before it starts", name)
+		}
+		interval.End = &end
+	}
+	return interval, nil
+}
+
+// geometryProperty parses a GeoJSON geometry, also accepting one wrapped in a Feature
+func geometryProperty(name string, raw json.RawMessage) (*models.GeoJSON, error) {
+	if isNull(raw) {
+		return nil, nil
+	}
+	var value struct {
+		models.GeoJSON
+		Geometry *models.GeoJSON `json:"geometry"`
+	}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, invalidRequest("%s must be a GeoJSON geometry: %v", name, err)
+	}
+	if value.Type == "Feature" {
+		if value.Geometry == nil {
+			return nil, invalidRequest("%s has no geometry", name)
+		}
+		return value.Geometry, nil
+	}
+	return &value.GeoJSON, nil
+}
+
+// isNull reports whether a property value is JSON null
+func isNull(raw json.RawMessage) bool {
+	return strings.TrimSpace(string(raw)) == "null"
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/odata"
+)
+
+// queryOptions holds the parsed OData system query options of a request
+type queryOptions struct {
+	Top       int64
+	Skip      int64
+	SkipToken string
+	Count     bool
+	OrderBy   bson.D
+	Select    []string
+	Filter    bson.M
+	Expand    []*expandItem
+}
+
+// supportedOptions lists the system query options understood by the server
+var supportedOptions = map[string]bool{
+	"$top":       true,
+	"$skip":      true,
+	"$skiptoken": true,
+	"$count":     true,
+	"$orderby":   true,
+	"$select":    true,
+	"$filter":    true,
+	"$expand":    true,
+}
+
+// parseQueryOptions parses the system query options of a request for an entity type
+func (s *Server) parseQueryOptions(values url.Values, t *entityType) (*queryOptions, error) {
+	for key := range values {
+		if strings.HasPrefix(key, "$") && !supportedOptions[key] {
+			return nil, fmt.Errorf("unsupported query option %s", key)
+		}
+	}
+
+	opts := &queryOptions{Top: int64(s.cfg.DefaultPageSize)}
+
+	if v := values.Get("$top"); v != "" {
+		top, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || top < 0 {
+			return nil, fmt.Errorf("invalid $top value %q", v)
+		}
+		opts.Top = top
+	}
+	if opts.Top > int64(s.cfg.MaxPageSize) {
+		opts.Top = int64(s.cfg.MaxPageSize)
+	}
+
+	if v := values.Get("$skip"); v != "" {
+		skip, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || skip < 0 {
+			return nil, fmt.Errorf("invalid $skip value %q", v)
+		}
+		opts.Skip = skip
+	}
+
+	opts.SkipToken = values.Get("$skiptoken")
+
+	if v := values.Get("$count"); v != "" {
+		count, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid $count value %q", v)
+		}
+		opts.Count = count
+	}
+
+	if v := values.Get("$orderby"); v != "" {
+		orderBy, err := parseOrderBy(v, t.Fields)
+		if err != nil {
+			return nil, err
+		}
+		opts.OrderBy = orderBy
+	}
+
+	if v := values.Get("$filter"); v != "" {
+		filter, err := odata.CompileFilter(v, t.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid $filter: %w", err)
+		}
+		opts.Filter = filter
+	}
+
+	// Continuation tokens encode a position in the default ordering only
+	if opts.SkipToken != "" && (opts.Skip > 0 || len(opts.OrderBy) > 0) {
+		return nil, fmt.Errorf("$skiptoken cannot be combined with $orderby or $skip")
+	}
+
+	if v := values.Get("$select"); v != "" {
+		opts.Select = splitList(v)
+	}
+
+	if v := values.Get("$expand"); v != "" {
+		expand, err := s.parseExpand(t, v, 0)
+		if err != nil {
+			return nil, err
+		}
+		opts.Expand = expand
+	}
+
+	return opts, nil
+}
+
+// parseOrderBy converts "prop [asc|desc], ..." into a sort document
+func parseOrderBy(expr string, fields odata.FieldMap) (bson.D, error) {
+	var sort bson.D
+	for _, clause := range strings.Split(expr, ",") {
+		parts := strings.Fields(clause)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("invalid $orderby clause %q", clause)
+		}
+
+		field, err := fields.Resolve(parts[0])
+		if err != nil || field.Type == odata.TypeGeometry {
+			return nil, fmt.Errorf("cannot order by %q", parts[0])
+		}
+
+		direction := 1
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				direction = -1
+			default:
+				return nil, fmt.Errorf("invalid $orderby direction %q", parts[1])
+			}
+		}
+		sort = append(sort, bson.E{Key: field.Path, Value: direction})
+	}
+	return sort, nil
+}
+
+// withIDTieBreak appends _id to a sort unless it already orders by it, so paging is stable
+func withIDTieBreak(sort bson.D) bson.D {
+	for _, e := range sort {
+		if e.Key == "_id" {
+			return sort
+		}
+	}
+	return append(append(bson.D{}, sort...), bson.E{Key: "_id", Value: 1})
+}
+
+// applySelect keeps only the selected properties of an entity
+func applySelect(entity Entity, selected []string) Entity {
+	if len(selected) == 0 {
+		return entity
+	}
+
+	result := Entity{"@iot.selfLink": entity["@iot.selfLink"]}
+	for _, name := range selected {
+		switch name {
+		case "id", "@iot.id":
+			result["@iot.id"] = entity["@iot.id"]
+		default:
+			if v, ok := entity[name]; ok {
+				result[name] = v
+			}
+			if v, ok := entity[name+"@iot.navigationLink"]; ok {
+				result[name+"@iot.navigationLink"] = v
+			}
+		}
+	}
+	return result
+}
+
+// nextLink builds the $skip based link to the following page, or "" on the last page
+func nextLink(r *http.Request, root string, opts *queryOptions, returned int) string {
+	if opts.Top == 0 || int64(returned) < opts.Top {
+		return ""
+	}
+
+	values := r.URL.Query()
+	values.Set("$skip", strconv.FormatInt(opts.Skip+opts.Top, 10))
+	return pageLink(r, root, opts, values)
+}
+
+// skipTokenLink builds the link resuming after a continuation token, or "" without one
+func skipTokenLink(r *http.Request, root string, opts *queryOptions, token string) string {
+	if token == "" {
+		return ""
+	}
+
+	values := r.URL.Query()
+	values.Set("$skiptoken", token)
+	return pageLink(r, root, opts, values)
+}
+
+// pageLink renders the request URL with the given query values and the effective $top
+func pageLink(r *http.Request, root string, opts *queryOptions, values url.Values) string {
+	values.Set("$top", strconv.FormatInt(opts.Top, 10))
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+APIVersion)
+	return root + path + "?" + values.Encode()
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// entitySetOrder lists the entity sets in the order of the SensorThings data model
+var entitySetOrder = []string{
+	"Things", "Locations", "HistoricalLocations", "Datastreams",
+	"Sensors", "ObservedProperties", "Observations", "FeaturesOfInterest",
+}
+
+// routeEntities serves a GET of an entity set, an entity, or a path of
+// navigation properties from an entity such as Datastreams('DS-001')/Thing/Locations
+func (s *Server) routeEntities(w http.ResponseWriter, r *http.Request, segments []pathSegment) {
+	notFound := fmt.Errorf("resource %s: %w", r.URL.Path, repository.ErrNotFound)
+
+	t := entitySet(segments[0].Name)
+	if t == nil {
+		s.writeRepositoryError(w, notFound)
+		return
+	}
+	if !segments[0].HasID {
+		if len(segments) > 1 {
+			s.writeRepositoryError(w, notFound)
+			return
+		}
+		s.handleListEntities(w, r, t, nil)
+		return
+	}
+
+	id, err := entityID(t, segments[0].ID)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	doc, err := s.findDocument(r.Context(), t, bson.M{"_id": id})
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	for i, seg := range segments[1:] {
+		nav, ok := t.Navigation[seg.Name]
+		if !ok || (!nav.Many && seg.HasID) {
+			s.writeRepositoryError(w, notFound)
+			return
+		}
+		target := entityTypes[nav.Target]
+		scope := relatedFilter(doc, nav)
+
+		if nav.Many && !seg.HasID {
+			if i < len(segments)-2 {
+				s.writeRepositoryError(w, notFound)
+				return
+			}
+			s.handleListEntities(w, r, target, scope)
+			return
+		}
+		if seg.HasID {
+			id, err := entityID(target, seg.ID)
+			if err != nil {
+				s.writeRepositoryError(w, err)
+				return
+			}
+			scope = bson.M{"$and": bson.A{scope, bson.M{"_id": id}}}
+		}
+		if doc, err = s.findDocument(r.Context(), target, scope); err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+		t = target
+	}
+
+	s.handleGetEntity(w, r, t, doc)
+}
+
+// handleListEntities serves the entities of a type, limited to scope when a
+// navigation property lists them
+func (s *Server) handleListEntities(w http.ResponseWriter, r *http.Request, t *entityType, scope bson.M) {
+	// Observations page with continuation tokens
+	if t.Name == "Observation" {
+		s.handleListObservations(w, r, scope)
+		return
+	}
+
+	opts, err := s.parseQueryOptions(r.URL.Query(), t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.SkipToken != "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("$skiptoken is not supported for %s", t.SetName))
+		return
+	}
+
+	filter := bson.M{}
+	if scope != nil {
+		filter = scope
+	}
+	if opts.Filter != nil {
+		filter = bson.M{"$and": bson.A{filter, opts.Filter}}
+	}
+
+	resp := CollectionResponse{Value: []Entity{}}
+	root := s.serviceRoot(r)
+
+	if opts.Top > 0 {
+		sort := opts.OrderBy
+		if len(sort) == 0 {
+			sort = t.DefaultSort
+		}
+		docs, err := s.lookups.Find(r.Context(), t.Collection, repository.Query{
+			Filter: filter,
+			Sort:   withIDTieBreak(sort),
+			Skip:   opts.Skip,
+			Limit:  opts.Top,
+		})
+		if err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+
+		for _, doc := range docs {
+			entity, err := t.encode(doc, root)
+			if err != nil {
+				s.writeRepositoryError(w, err)
+				return
+			}
+			resp.Value = append(resp.Value, applySelect(entity, opts.Select))
+		}
+		if len(opts.Expand) > 0 {
+			if err := s.newExpansion(root).apply(r.Context(), docs, resp.Value, opts.Expand); err != nil {
+				s.writeExpandError(w, err)
+				return
+			}
+		}
+		resp.NextLink = nextLink(r, root, opts, len(docs))
+	}
+
+	if opts.Count {
+		count, err := s.lookups.Count(r.Context(), t.Collection, filter)
+		if err != nil {
+			s.writeRepositoryError(w, err)
+			return
+		}
+		resp.Count = &count
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetEntity serves a single entity read from its stored document
+func (s *Server) handleGetEntity(w http.ResponseWriter, r *http.Request, t *entityType, doc bson.M) {
+	opts, err := s.parseQueryOptions(r.URL.Query(), t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	root := s.serviceRoot(r)
+	entity, err := t.encode(doc, root)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	entity = applySelect(entity, opts.Select)
+	if len(opts.Expand) > 0 {
+		if err := s.newExpansion(root).apply(r.Context(), []bson.M{doc}, []Entity{entity}, opts.Expand); err != nil {
+			s.writeExpandError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, entity)
+}
+
+// findDocument reads the first document of an entity type matching filter
+func (s *Server) findDocument(ctx context.Context, t *entityType, filter bson.M) (bson.M, error) {
+	docs, err := s.lookups.Find(ctx, t.Collection, repository.Query{
+		Filter: filter,
+		Limit:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%s: %w", t.Name, repository.ErrNotFound)
+	}
+	return docs[0], nil
+}
+
+// relatedFilter selects the documents a navigation property of doc leads to
+func relatedFilter(doc bson.M, nav navigation) bson.M {
+	keys := bson.A{}
+	for _, key := range valuesAt(doc, nav.Local) {
+		if isKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 1 {
+		return bson.M{nav.Foreign: keys[0]}
+	}
+	return bson.M{nav.Foreign: bson.M{"$in": keys}}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/services"
+)
+
+// Entity is a SensorThings entity serialized as a JSON object
+type Entity map[string]interface{}
+
+// CollectionResponse is the SensorThings envelope for entity sets
+type CollectionResponse struct {
+	Count    *int64   `json:"@iot.count,omitempty"`
+	NextLink string   `json:"@iot.nextLink,omitempty"`
+	Value    []Entity `json:"value"`
+}
+
+// ErrorResponse describes a failed request
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// writeJSON serializes a value as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{
+		Code:    status,
+		Type:    "error",
+		Message: message,
+	})
+}
+
+// writeRepositoryError maps repository errors to HTTP status codes
+func (s *Server) writeRepositoryError(w http.ResponseWriter, err error) {
+	status, message := s.errorStatus(err)
+	writeError(w, status, message)
+}
+
+// errorStatus returns the HTTP status and client message for a repository error,
+// logging unexpected errors and hiding their details
+func (s *Server) errorStatus(err error) (int, string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		return http.StatusNotFound, err.Error()
+	}
+	if errors.Is(err, repository.ErrInvalidToken) || errors.Is(err, repository.ErrInvalidEntity) ||
+		errors.Is(err, repository.ErrInvalidReference) || errors.Is(err, repository.ErrHierarchyCycle) ||
+		errors.Is(err, repository.ErrInvalidResumeToken) || errors.Is(err, errInvalidRequest) {
+		return http.StatusBadRequest, err.Error()
+	}
+	if errors.Is(err, errUnauthorized) {
+		return http.StatusUnauthorized, err.Error()
+	}
+	if errors.Is(err, errWritesDisabled) {
+		return http.StatusForbidden, err.Error()
+	}
+	if errors.Is(err, repository.ErrAlreadyExists) || errors.Is(err, repository.ErrReferenced) {
+		return http.StatusConflict, err.Error()
+	}
+	if errors.Is(err, repository.ErrResumeTokenExpired) {
+		return http.StatusGone, err.Error()
+	}
+	if errors.Is(err, services.ErrFeedStopped) {
+		return http.StatusServiceUnavailable, err.Error()
+	}
+	s.logger.Errorf("Request failed: %v", err)
+	return http.StatusInternalServerError, "internal server error"
+}
+
+// writeExpandError reports $expand failures, rejecting requests over the expansion budget
+func (s *Server) writeExpandError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errExpansionTooLarge) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeRepositoryError(w, err)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// pathSegment is one element of a SensorThings resource path, e.g. Datastreams('DS-001')
+type pathSegment struct {
+	Name  string
+	ID    string
+	HasID bool
+}
+
+// parsePath splits a resource path below the version prefix into segments
+func parsePath(path string) ([]pathSegment, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, nil
+	}
+
+	var segments []pathSegment
+	for _, raw := range splitPath(path) {
+		seg, err := parseSegment(raw)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// splitPath splits on '/' outside of quoted identifiers
+func splitPath(path string) []string {
+	var parts []string
+	var current strings.Builder
+	inQuote := false
+
+	for _, ch := range path {
+		switch {
+		case ch == '\'':
+			inQuote = !inQuote
+			current.WriteRune(ch)
+		case ch == '/' && !inQuote:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(ch)
+		}
+	}
+	return append(parts, current.String())
+}
+
+// parseSegment parses Name or Name(id) where id may be quoted
+func parseSegment(raw string) (pathSegment, error) {
+	open := strings.IndexByte(raw, '(')
+	if open < 0 {
+		if raw == "" {
+			return pathSegment{}, fmt.Errorf("empty path segment")
+		}
+		return pathSegment{Name: raw}, nil
+	}
+	if !strings.HasSuffix(raw, ")") || open == 0 {
+		return pathSegment{}, fmt.Errorf("malformed path segment %q", raw)
+	}
+
+	id := raw[open+1 : len(raw)-1]
+	if len(id) >= 2 && strings.HasPrefix(id, "'") && strings.HasSuffix(id, "'") {
+		id = strings.ReplaceAll(id[1:len(id)-1], "''", "'")
+	}
+	if id == "" {
+		return pathSegment{}, fmt.Errorf("missing identifier in %q", raw)
+	}
+	return pathSegment{Name: raw[:open], ID: id, HasID: true}, nil
+}
+
+// route dispatches a request to the handler for its resource path
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	segments, err := parsePath(strings.TrimPrefix(r.URL.Path, "/"+APIVersion))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A batch is authorized by the writes it holds
+	isBatch := r.Method == http.MethodPost && len(segments) == 1 && segments[0].Name == BatchPath && !segments[0].HasID
+	switch r.Method {
+	case http.MethodPost, http.MethodPatch, http.MethodDelete:
+		if !isBatch && !s.requireWrite(w, r) {
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.routeRead(w, r, segments)
+	case http.MethodPost:
+		if len(segments) == 1 && segments[0].Name == CreateObservationsPath && !segments[0].HasID {
+			s.handleCreateObservations(w, r)
+			return
+		}
+		if isBatch {
+			s.handleBatch(w, r)
+			return
+		}
+		s.handleCreate(w, r, segments)
+	case http.MethodPatch:
+		s.handleUpdate(w, r, segments)
+	case http.MethodDelete:
+		s.handleDelete(w, r, segments)
+	default:
+		w.Header().Set("Allow", "GET, POST, PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+	}
+}
+
+// routeRead dispatches a GET request to the handler for its resource path
+func (s *Server) routeRead(w http.ResponseWriter, r *http.Request, segments []pathSegment) {
+	switch {
+	case len(segments) == 0:
+		s.handleServiceRoot(w, r)
+	case len(segments) == 1 && segments[0].Name == "Observations" && segments[0].HasID:
+		s.handleGetObservation(w, r, segments[0].ID)
+	default:
+		s.routeEntities(w, r, segments)
+	}
+}
+
+// handleServiceRoot lists the entity sets and conformance classes of the service
+func (s *Server) handleServiceRoot(w http.ResponseWriter, r *http.Request) {
+	root := s.serviceRoot(r)
+	value := make([]map[string]string, len(entitySetOrder))
+	for i, name := range entitySetOrder {
+		value[i] = map[string]string{"name": name, "url": root + "/" + name}
+	}
+	conformance := []string{
+		"http://www.opengis.net/spec/iot_sensing/1.1/req/datamodel",
+		"http://www.opengis.net/spec/iot_sensing/1.1/req/resource-path/resource-path-to-entities",
+		"http://www.opengis.net/spec/iot_sensing/1.1/req/request-data",
+		"http://www.opengis.net/spec/iot_sensing/1.1/req/batch-request/batch-request",
+	}
+	if s.cfg.WritesEnabled {
+		conformance = append(conformance,
+			"http://www.opengis.net/spec/iot_sensing/1.1/req/create-update-delete",
+			"http://www.opengis.net/spec/iot_sensing/1.1/req/data-array/data-array",
+		)
+	}
+	if s.mqtt {
+		if s.cfg.WritesEnabled {
+			conformance = append(conformance,
+				"http://www.opengis.net/spec/iot_sensing/1.1/req/create-observations-via-mqtt/observations-creation")
+		}
+		conformance = append(conformance,
+			"http://www.opengis.net/spec/iot_sensing/1.1/req/receive-updates-via-mqtt/receive-updates")
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"value": value,
+		"serverSettings": map[string]interface{}{
+			"conformance": conformance,
+		},
+	})
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/config"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/services"
+)
+
+// APIVersion is the SensorThings API version prefix served by this server
+const APIVersion = "v1.1"
+
+// Server exposes the data lake as an OGC SensorThings API v1.1 service
+type Server struct {
+	cfg                *config.AppConfig
+	logger             *logrus.Logger
+	db                 *mongo.Database
+	observations       *repository.ObservationRepository
+	things             *repository.ThingRepository
+	locations          *repository.LocationRepository
+	sensors            *repository.SensorRepository
+	observedProperties *repository.ObservedPropertyRepository
+	datastreams        *repository.DatastreamRepository
+	features           *repository.FeatureOfInterestRepository
+	lookups            *repository.LookupRepository
+	linkedData         *services.LinkedDataService
+	feed               *services.ObservationFeedService
+	httpServer         *http.Server
+
+	// mqtt is set when stored observations are published over MQTT
+	mqtt bool
+
+	// noTransactions is set once the server turns out to be a standalone mongod
+	noTransactions atomic.Bool
+}
+
+// NewServer creates a new SensorThings API server
+func NewServer(cfg *config.AppConfig, db *mongo.Database, logger *logrus.Logger) *Server {
+	if logger == nil {
+		logger = logrus.New()
+	}
+
+	s := &Server{
+		cfg:                cfg,
+		logger:             logger,
+		db:                 db,
+		observations:       repository.NewObservationRepository(db),
+		things:             repository.NewThingRepository(db),
+		locations:          repository.NewLocationRepository(db),
+		sensors:            repository.NewSensorRepository(db),
+		observedProperties: repository.NewObservedPropertyRepository(db),
+		datastreams:        repository.NewDatastreamRepository(db),
+		features:           repository.NewFeatureOfInterestRepository(db),
+		lookups:            repository.NewLookupRepository(db),
+		linkedData:         services.NewLinkedDataService(db, logger),
+	}
+
+	s.httpServer = &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		WriteTimeout:      120 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	return s
+}
+
+// Handler returns the root HTTP handler including middleware
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+APIVersion, s.route)
+	mux.HandleFunc("/"+APIVersion+"/", s.route)
+	mux.HandleFunc(LinkedDataPath, s.handleLinkedData)
+	mux.HandleFunc(LinkedDataPath+"/", s.handleLinkedData)
+	mux.HandleFunc(FeedPath, s.handleFeed)
+	return s.logRequests(mux)
+}
+
+// Start listens for HTTP requests until the server is shut down
+func (s *Server) Start() error {
+	s.logger.Infof("SensorThings API listening on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("HTTP server failed: %w", err)
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server, waiting for active requests
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down HTTP server: %w", err)
+	}
+	s.logger.Info("SensorThings API stopped")
+	return nil
+}
+
+// logRequests logs every request with its status and duration
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		s.logger.WithFields(logrus.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   rec.status,
+			"duration": time.Since(start).String(),
+		}).Debug("Handled request")
+	})
+}
+
+// statusRecorder captures the response status for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before delegating
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack hands the connection over to a WebSocket
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// serviceRoot returns the absolute URL of the versioned service root
+func (s *Server) serviceRoot(r *http.Request) string {
+	if s.cfg.ServiceRootURL != "" {
+		return s.publishedRoot()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, r.Host, APIVersion)
+}
+
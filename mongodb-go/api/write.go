@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// maxWriteBody limits the size of a create or update request body
+const maxWriteBody = 16 << 20
+
+// writeTx collects the writes of one request. Entity writes run inside a
+// MongoDB transaction. Observations live in a time series collection, which
+// cannot be written in a transaction, so their writes are queued and run once
+// the transaction commits. Every write registers a step that undoes it, run in
+// reverse order if a later write fails after the entities were committed.
+type writeTx struct {
+	observations []models.Observation
+	steps        []writeStep
+	undo         []func(ctx context.Context) error
+	meta         map[string]*models.DatastreamMeta
+}
+
+// writeStep is a write that runs after commit and the step that undoes it
+type writeStep struct {
+	do   func(ctx context.Context) error
+	undo func(ctx context.Context) error
+}
+
+// newWriteTx creates an empty write transaction
+func newWriteTx() *writeTx {
+	return &writeTx{meta: map[string]*models.DatastreamMeta{}}
+}
+
+// onRollback registers the step that undoes a committed write
+func (tx *writeTx) onRollback(undo func(ctx context.Context) error) {
+	tx.undo = append(tx.undo, undo)
+}
+
+// afterCommit queues a write to run once the transaction has committed
+func (tx *writeTx) afterCommit(do, undo func(ctx context.Context) error) {
+	tx.steps = append(tx.steps, writeStep{do: do, undo: undo})
+}
+
+// insertObservation queues an observation to be inserted once the transaction has committed
+func (tx *writeTx) insertObservation(obs models.Observation) {
+	tx.observations = append(tx.observations, obs)
+}
+
+// transact runs fn in a transaction, then the writes it queued for after
+// commit. If any of those fail, the committed writes are undone. Standalone
+// servers without transaction support run fn directly and rely on the undo
+// steps alone.
+func (s *Server) transact(ctx context.Context, fn func(ctx context.Context, tx *writeTx) error) error {
+	var tx *writeTx
+	run := func(ctx context.Context) error {
+		// The driver retries transient transaction errors from the start
+		tx = newWriteTx()
+		return fn(ctx, tx)
+	}
+
+	err := repository.ErrTransactionsUnsupported
+	if !s.noTransactions.Load() {
+		err = repository.WithTransaction(ctx, s.db, run)
+	}
+	if errors.Is(err, repository.ErrTransactionsUnsupported) {
+		if s.noTransactions.CompareAndSwap(false, true) {
+			s.logger.Warn("MongoDB does not support transactions, failed writes will be undone instead")
+		}
+		if err = run(ctx); err != nil {
+			s.rollback(ctx, tx)
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if err := s.commit(ctx, tx); err != nil {
+		s.rollback(ctx, tx)
+		return err
+	}
+	return nil
+}
+
+// commit runs the writes queued for after the transaction. The new
+// observations are published only once every write has succeeded, as a
+// failed write rolls them back.
+func (s *Server) commit(ctx context.Context, tx *writeTx) error {
+	var stored []models.Observation
+	if len(tx.observations) > 0 {
+		ids := make([]primitive.ObjectID, len(tx.observations))
+		for i := range tx.observations {
+			ids[i] = tx.observations[i].ID
+		}
+		// Registered first as unordered inserts may store part of a failed batch
+		tx.onRollback(func(ctx context.Context) error {
+			return s.observations.DeleteMany(ctx, ids)
+		})
+		var err error
+		stored, err = s.observations.InsertManyUnpublished(ctx, tx.observations)
+		if errors.Is(err, repository.ErrRollupsNotMarked) {
+			// The observations were stored; rolling them back would lose a good write
+			s.logger.WithError(err).Warn("Stored observations without queueing their rollups")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for _, step := range tx.steps {
+		tx.onRollback(step.undo)
+		if err := step.do(ctx); err != nil {
+			return err
+		}
+	}
+	s.observations.Publish(ctx, stored)
+	return nil
+}
+
+// rollback undoes the writes of a failed request, newest first. It runs to
+// completion even if the client has gone away.
+func (s *Server) rollback(ctx context.Context, tx *writeTx) {
+	ctx = context.WithoutCancel(ctx)
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](ctx); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			s.logger.WithError(err).Error("Failed to undo write")
+		}
+	}
+}
+
+// handleCreate creates an entity from the request body, along with the related
+// entities nested in it (deep insert). Posting to a navigation path such as
+// Datastreams('DS-001')/Observations links the new entity to the parent.
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request, segments []pathSegment) {
+	body, err := readBody(w, r)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	t, err := createTarget(r.URL.Path, segments, body)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	var id interface{}
+	err = s.transact(r.Context(), func(ctx context.Context, tx *writeTx) error {
+		id, err = s.createEntity(ctx, tx, t, body)
+		return err
+	})
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	entity, err := s.loadEntity(r.Context(), s.serviceRoot(r), t, id)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	w.Header().Set("Location", entity["@iot.selfLink"].(string))
+	writeJSON(w, http.StatusCreated, entity)
+}
+
+// createTarget resolves the entity type created by a POST to path, adding
+// the link to the parent to the body when posting to a navigation path
+func createTarget(path string, segments []pathSegment, body entityBody) (*entityType, error) {
+	var t, parent *entityType
+	switch {
+	case len(segments) == 1 && !segments[0].HasID:
+		t = entitySet(segments[0].Name)
+	case len(segments) == 2 && segments[0].HasID && !segments[1].HasID:
+		parent = entitySet(segments[0].Name)
+		if parent == nil {
+			break
+		}
+		if nav, ok := parent.Navigation[segments[1].Name]; ok && nav.Many {
+			t = entityTypes[nav.Target]
+		}
+	}
+	if t == nil {
+		return nil, fmt.Errorf("resource %s: %w", path, repository.ErrNotFound)
+	}
+
+	if parent != nil {
+		if err := linkParent(t, body, parent, segments[0].ID); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// readBody reads the entity in a request body
+func readBody(w http.ResponseWriter, r *http.Request) (entityBody, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWriteBody))
+	if err != nil {
+		return nil, invalidRequest("failed to read request body: %v", err)
+	}
+	return decodeBody(data)
+}
+
+// linkParent adds a reference to the parent entity to a body, through the
+// navigation property of t that leads back to the parent
+func linkParent(t *entityType, body entityBody, parent *entityType, parentID string) error {
+	for name, nav := range t.Navigation {
+		if nav.Target != parent.Name {
+			continue
+		}
+		ref, _ := json.Marshal(map[string]string{"@iot.id": parentID})
+		if nav.Many {
+			ref, _ = json.Marshal([]json.RawMessage{ref})
+		}
+		body[name] = ref
+		return nil
+	}
+	return invalidRequest("%s cannot be created below %s", t.SetName, parent.SetName)
+}
+
+// handleUpdate applies the properties in the request body to an existing entity.
+// Single-valued navigation properties may be changed by reference.
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request, segments []pathSegment) {
+	t, id, err := writeTarget(r.URL.Path, segments)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	err = s.transact(r.Context(), func(ctx context.Context, tx *writeTx) error {
+		return s.updateEntity(ctx, tx, t, id, body)
+	})
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	entity, err := s.loadEntity(r.Context(), s.serviceRoot(r), t, id)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entity)
+}
+
+// handleDelete removes an entity. Entities that others still refer to are
+// not deleted and are reported as a conflict.
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, segments []pathSegment) {
+	t, id, err := writeTarget(r.URL.Path, segments)
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+
+	err = s.transact(r.Context(), func(ctx context.Context, tx *writeTx) error {
+		return s.deleteEntity(ctx, tx, t, id)
+	})
+	if err != nil {
+		s.writeRepositoryError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeTarget resolves the entity a PATCH or DELETE to path addresses. Observation
+// identifiers are returned as object ids, others as strings.
+func writeTarget(path string, segments []pathSegment) (*entityType, interface{}, error) {
+	if len(segments) != 1 || !segments[0].HasID {
+		return nil, nil, fmt.Errorf("resource %s: %w", path, repository.ErrNotFound)
+	}
+	t := entitySet(segments[0].Name)
+	if t == nil {
+		return nil, nil, fmt.Errorf("resource %s: %w", path, repository.ErrNotFound)
+	}
+	id, err := entityID(t, segments[0].ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, id, nil
+}
+
+// entityID converts an identifier from a path or body into the stored form
+func entityID(t *entityType, id string) (interface{}, error) {
+	if t.Name != "Observation" && t.Name != "HistoricalLocation" {
+		return id, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, invalidRequest("invalid %s id %q", t.Name, id)
+	}
+	return oid, nil
+}
+
+// loadEntity reads an entity back in its SensorThings representation
+func (s *Server) loadEntity(ctx context.Context, root string, t *entityType, id interface{}) (Entity, error) {
+	docs, err := s.lookups.Find(ctx, t.Collection, repository.Query{
+		Filter: bson.M{"_id": id},
+		Limit:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%s %v: %w", t.Collection, jsonValue(id), repository.ErrNotFound)
+	}
+	return t.encode(docs[0], root)
+}
+
+// entitySet returns the entity type of an entity set, or nil if there is none
+func entitySet(name string) *entityType {
+	for _, t := range entityTypes {
+		if t.SetName == name {
+			return t
+		}
+	}
+	return nil
+}
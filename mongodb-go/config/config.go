@@ -18,6 +18,9 @@ type Config struct {
 	App        AppConfig
 	Retention  RetentionConfig
 	Monitoring MonitoringConfig
+	Calendar   CalendarConfig
+	MQTT       MQTTConfig
+	Feed       FeedConfig
 }
 
 // MongoDBConfig contains MongoDB connection settings
@@ -34,9 +37,11 @@ type MongoDBConfig struct {
 
 // APIConfig contains external API configurations
 type APIConfig struct {
-	OGCAPIBaseURL string
-	FintoAPIURL   string
-	APIKey        string
+	OGCAPIBaseURL   string
+	FintoAPIURL     string
+	FintoVocabulary string
+	APIKey          string
+	RequestTimeout  time.Duration
 }
 
 // SyncConfig contains synchronization settings
@@ -48,6 +53,13 @@ type SyncConfig struct {
 	FeatureSyncRetries   int
 	UCUMSyncEnabled      bool
 	UCUMSyncSchedule     string
+	RollupEnabled        bool
+	RollupInterval       time.Duration
+	RollupBatchSize      int
+
+	// FeatureChangeTolerance is how far in meters an external geometry may move
+	// before the associations with it are invalidated
+	FeatureChangeTolerance float64
 }
 
 // AppConfig contains application settings
@@ -57,6 +69,30 @@ type AppConfig struct {
 	LogLevel    string
 	LogFormat   string
 	JWTSecret   string
+
+	// SensorThings API settings
+	ServiceRootURL    string
+	WritesEnabled     bool
+	DefaultPageSize   int
+	MaxPageSize       int
+	MaxExpandDepth    int
+	MaxExpandEntities int
+}
+
+// MQTTConfig contains the settings of the embedded MQTT broker
+type MQTTConfig struct {
+	Enabled bool
+	Port    int
+}
+
+// FeedConfig contains the settings of the real-time observation feed
+type FeedConfig struct {
+	Enabled bool
+	// Retention is how long observations stay in the feed collection
+	Retention time.Duration
+	// SubscriberBuffer is how many events may wait for a subscriber
+	// before it is considered lagging
+	SubscriberBuffer int
 }
 
 // RetentionConfig contains data retention policies
@@ -65,6 +101,15 @@ type RetentionConfig struct {
 	CacheDays       int
 }
 
+// CalendarConfig contains date dimension calendar settings
+type CalendarConfig struct {
+	HolidayCalendar  string
+	HolidayRulesFile string
+	StartDate        time.Time
+	HorizonDays      int
+	RefreshEnabled   bool
+}
+
 // MonitoringConfig contains monitoring settings
 type MonitoringConfig struct {
 	Enabled     bool
@@ -94,6 +139,8 @@ func Load() (*Config, error) {
 	// API configuration
 	cfg.APIs.OGCAPIBaseURL = getEnv("OGCAPI_BASE_URL", "")
 	cfg.APIs.FintoAPIURL = getEnv("FINTO_API_URL", "https://api.finto.fi")
+	cfg.APIs.FintoVocabulary = getEnv("FINTO_VOCABULARY", "ucum")
+	cfg.APIs.RequestTimeout = time.Duration(getEnvAsInt("API_REQUEST_TIMEOUT_SECONDS", 30)) * time.Second
 	cfg.APIs.APIKey = getEnv("API_KEY", "")
 
 	// Sync configuration
@@ -102,8 +149,12 @@ func Load() (*Config, error) {
 	cfg.Sync.FeatureSyncEnabled = getEnvAsBool("FEATURE_SYNC_ENABLED", true)
 	cfg.Sync.FeatureSyncBatchSize = getEnvAsInt("FEATURE_SYNC_BATCH_SIZE", 100)
 	cfg.Sync.FeatureSyncRetries = getEnvAsInt("FEATURE_SYNC_RETRY_ATTEMPTS", 3)
+	cfg.Sync.FeatureChangeTolerance = float64(getEnvAsInt("FEATURE_CHANGE_TOLERANCE_METERS", 1))
 	cfg.Sync.UCUMSyncEnabled = getEnvAsBool("UCUM_SYNC_ENABLED", true)
 	cfg.Sync.UCUMSyncSchedule = getEnv("UCUM_SYNC_SCHEDULE", "0 0 1 * *")
+	cfg.Sync.RollupEnabled = getEnvAsBool("ROLLUP_ENABLED", true)
+	cfg.Sync.RollupInterval = time.Duration(getEnvAsInt("ROLLUP_INTERVAL_SECONDS", 60)) * time.Second
+	cfg.Sync.RollupBatchSize = getEnvAsInt("ROLLUP_BATCH_SIZE", 500)
 
 	// App configuration
 	cfg.App.Environment = getEnv("APP_ENV", "development")
@@ -111,6 +162,21 @@ func Load() (*Config, error) {
 	cfg.App.LogLevel = getEnv("LOG_LEVEL", "info")
 	cfg.App.LogFormat = getEnv("LOG_FORMAT", "json")
 	cfg.App.JWTSecret = getEnv("JWT_SECRET", "")
+	cfg.App.ServiceRootURL = getEnv("SERVICE_ROOT_URL", "")
+	cfg.App.WritesEnabled = getEnvAsBool("API_WRITES_ENABLED", false)
+	cfg.App.DefaultPageSize = getEnvAsInt("API_DEFAULT_PAGE_SIZE", 100)
+	cfg.App.MaxPageSize = getEnvAsInt("API_MAX_PAGE_SIZE", 1000)
+	cfg.App.MaxExpandDepth = getEnvAsInt("API_MAX_EXPAND_DEPTH", 3)
+	cfg.App.MaxExpandEntities = getEnvAsInt("API_MAX_EXPAND_ENTITIES", 10000)
+
+	// MQTT configuration
+	cfg.MQTT.Enabled = getEnvAsBool("MQTT_ENABLED", false)
+	cfg.MQTT.Port = getEnvAsInt("MQTT_PORT", 1883)
+
+	// Observation feed configuration
+	cfg.Feed.Enabled = getEnvAsBool("FEED_ENABLED", false)
+	cfg.Feed.Retention = time.Duration(getEnvAsInt("FEED_RETENTION_HOURS", 24)) * time.Hour
+	cfg.Feed.SubscriberBuffer = getEnvAsInt("FEED_SUBSCRIBER_BUFFER", 256)
 
 	// Retention configuration
 	cfg.Retention.ObservationDays = getEnvAsInt("OBSERVATION_RETENTION_DAYS", 365)
@@ -121,6 +187,17 @@ func Load() (*Config, error) {
 	cfg.Monitoring.Endpoint = getEnv("MONITORING_ENDPOINT", "")
 	cfg.Monitoring.APIKey = getEnv("MONITORING_API_KEY", "")
 
+	// Calendar configuration
+	cfg.Calendar.HolidayCalendar = getEnv("HOLIDAY_CALENDAR", "FI")
+	cfg.Calendar.HolidayRulesFile = getEnv("HOLIDAY_RULES_FILE", "")
+	startDate, err := time.Parse("2006-01-02", getEnv("DATE_DIMENSION_START", "2025-01-01"))
+	if err != nil {
+		return nil, fmt.Errorf("DATE_DIMENSION_START must be a YYYY-MM-DD date: %w", err)
+	}
+	cfg.Calendar.StartDate = startDate
+	cfg.Calendar.HorizonDays = getEnvAsInt("DATE_DIMENSION_HORIZON_DAYS", 365)
+	cfg.Calendar.RefreshEnabled = getEnvAsBool("DATE_DIMENSION_REFRESH_ENABLED", true)
+
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
@@ -137,6 +214,27 @@ func (c *Config) Validate() error {
 	if c.MongoDB.DatabaseName == "" {
 		return fmt.Errorf("DATABASE_NAME is required")
 	}
+	if c.App.Port <= 0 || c.App.Port > 65535 {
+		return fmt.Errorf("APP_PORT must be between 1 and 65535")
+	}
+	if c.App.DefaultPageSize <= 0 || c.App.DefaultPageSize > c.App.MaxPageSize {
+		return fmt.Errorf("API_DEFAULT_PAGE_SIZE must be positive and not exceed API_MAX_PAGE_SIZE")
+	}
+	if c.MQTT.Enabled && (c.MQTT.Port <= 0 || c.MQTT.Port > 65535 || c.MQTT.Port == c.App.Port) {
+		return fmt.Errorf("MQTT_PORT must be between 1 and 65535 and differ from APP_PORT")
+	}
+	if c.Feed.Retention <= 0 {
+		return fmt.Errorf("FEED_RETENTION_HOURS must be positive")
+	}
+	if c.Sync.FeatureSyncEnabled && c.Sync.SyncInterval <= 0 {
+		return fmt.Errorf("SYNC_INTERVAL_MINUTES must be positive")
+	}
+	if c.Sync.RollupEnabled && c.Sync.RollupInterval <= 0 {
+		return fmt.Errorf("ROLLUP_INTERVAL_SECONDS must be positive")
+	}
+	if c.Calendar.HorizonDays < 0 {
+		return fmt.Errorf("DATE_DIMENSION_HORIZON_DAYS must not be negative")
+	}
 	if c.App.Environment == "production" && c.App.JWTSecret == "" {
 		return fmt.Errorf("JWT_SECRET is required in production")
 	}
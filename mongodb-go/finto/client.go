@@ -0,0 +1,283 @@
+package finto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when the API has no concept with the requested URI
+var ErrNotFound = errors.New("concept not found")
+
+// Client reads SKOS concepts of one vocabulary from a Skosmos REST API such as Finto
+type Client struct {
+	baseURL    string
+	vocabulary string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for a vocabulary; baseURL is the API root (e.g. https://api.finto.fi)
+func NewClient(baseURL, vocabulary string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		vocabulary: vocabulary,
+		httpClient: httpClient,
+	}
+}
+
+// Vocabulary describes the vocabulary being read
+type Vocabulary struct {
+	ID              string   `json:"id"`
+	Title           string   `json:"title"`
+	DefaultLanguage string   `json:"defaultLanguage"`
+	Languages       []string `json:"languages"`
+	Version         string   `json:"version,omitempty"`
+}
+
+// SearchResult is a concept returned by a search
+type SearchResult struct {
+	URI       string `json:"uri"`
+	PrefLabel string `json:"prefLabel"`
+	Lang      string `json:"lang"`
+	Notation  string `json:"notation"`
+}
+
+// Label is a language-tagged literal
+type Label struct {
+	Lang  string
+	Value string
+}
+
+// Reference points to a related concept
+type Reference struct {
+	URI      string
+	Notation string
+	Label    string
+}
+
+// Concept is a SKOS concept with the properties used by the unit cache
+type Concept struct {
+	URI         string
+	Notation    string
+	PrefLabels  map[string]string
+	AltLabels   []Label
+	Definitions map[string]string
+	Broader     []Reference
+	Narrower    []Reference
+}
+
+// Vocabulary retrieves the vocabulary metadata
+func (c *Client) Vocabulary(ctx context.Context) (*Vocabulary, error) {
+	var vocab Vocabulary
+	if err := c.get(ctx, c.vocabulary+"/", nil, &vocab); err != nil {
+		return nil, err
+	}
+	return &vocab, nil
+}
+
+// Search returns one page of concepts matching query; "*" matches every concept
+func (c *Client) Search(ctx context.Context, query, lang string, limit, offset int) ([]SearchResult, error) {
+	params := url.Values{"query": {query}}
+	if lang != "" {
+		params.Set("lang", lang)
+	}
+	if limit > 0 {
+		params.Set("maxhits", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+
+	var response struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := c.get(ctx, c.vocabulary+"/search", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+// Concept retrieves a concept with its labels, definitions and broader/narrower concepts
+func (c *Client) Concept(ctx context.Context, uri string) (*Concept, error) {
+	params := url.Values{"uri": {uri}, "format": {"application/ld+json"}}
+
+	var response struct {
+		Graph []node `json:"graph"`
+	}
+	if err := c.get(ctx, c.vocabulary+"/data", params, &response); err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]node, len(response.Graph))
+	for _, n := range response.Graph {
+		nodes[n.uri()] = n
+	}
+	n, ok := nodes[uri]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", uri, ErrNotFound)
+	}
+
+	concept := &Concept{
+		URI:         uri,
+		Notation:    n.literal("notation"),
+		PrefLabels:  map[string]string{},
+		Definitions: map[string]string{},
+	}
+	for _, l := range n.labels("prefLabel") {
+		concept.PrefLabels[l.Lang] = l.Value
+	}
+	concept.AltLabels = n.labels("altLabel")
+	for _, l := range n.labels("definition") {
+		concept.Definitions[l.Lang] = l.Value
+	}
+
+	// The graph carries labels and notations of the related concepts too
+	reference := func(related string) Reference {
+		ref := Reference{URI: related}
+		if rn, ok := nodes[related]; ok {
+			ref.Notation = rn.literal("notation")
+			ref.Label = preferredLabel(rn.labels("prefLabel"))
+		}
+		return ref
+	}
+	for _, related := range n.uris("broader") {
+		concept.Broader = append(concept.Broader, reference(related))
+	}
+	for _, related := range n.uris("narrower") {
+		concept.Narrower = append(concept.Narrower, reference(related))
+	}
+	return concept, nil
+}
+
+// get performs a GET request against the REST API and decodes the JSON response
+func (c *Client) get(ctx context.Context, path string, params url.Values, v interface{}) error {
+	endpoint := c.baseURL + "/rest/v1/" + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", endpoint, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// node is a JSON-LD graph node. Skosmos compacts SKOS properties inconsistently
+// ("prefLabel" but "skos:notation") and uses a bare value for single-valued
+// properties, so properties are read leniently.
+type node map[string]json.RawMessage
+
+// uri returns the identifier of the node
+func (n node) uri() string {
+	var s string
+	json.Unmarshal(n["uri"], &s)
+	return s
+}
+
+// property returns a property under its compact or skos-prefixed name
+func (n node) property(name string) json.RawMessage {
+	if raw, ok := n[name]; ok {
+		return raw
+	}
+	return n["skos:"+name]
+}
+
+// values splits a property into its values, whether single or an array
+func (n node) values(name string) []json.RawMessage {
+	raw := n.property(name)
+	if len(raw) == 0 {
+		return nil
+	}
+	var many []json.RawMessage
+	if json.Unmarshal(raw, &many) == nil {
+		return many
+	}
+	return []json.RawMessage{raw}
+}
+
+// labels reads a property of language-tagged literals
+func (n node) labels(name string) []Label {
+	var labels []Label
+	for _, raw := range n.values(name) {
+		var tagged struct {
+			Lang  string `json:"lang"`
+			Value string `json:"value"`
+		}
+		if json.Unmarshal(raw, &tagged) == nil && tagged.Value != "" {
+			labels = append(labels, Label{Lang: tagged.Lang, Value: tagged.Value})
+			continue
+		}
+		var plain string
+		if json.Unmarshal(raw, &plain) == nil && plain != "" {
+			labels = append(labels, Label{Value: plain})
+		}
+	}
+	return labels
+}
+
+// literal returns the first value of a literal property
+func (n node) literal(name string) string {
+	if labels := n.labels(name); len(labels) > 0 {
+		return labels[0].Value
+	}
+	return ""
+}
+
+// uris reads a property of references to other nodes
+func (n node) uris(name string) []string {
+	var uris []string
+	for _, raw := range n.values(name) {
+		var ref struct {
+			URI string `json:"uri"`
+		}
+		if json.Unmarshal(raw, &ref) == nil && ref.URI != "" {
+			uris = append(uris, ref.URI)
+			continue
+		}
+		var plain string
+		if json.Unmarshal(raw, &plain) == nil && plain != "" {
+			uris = append(uris, plain)
+		}
+	}
+	return uris
+}
+
+// preferredLabel picks the English label, falling back to the first one
+func preferredLabel(labels []Label) string {
+	for _, l := range labels {
+		if l.Lang == "en" {
+			return l.Value
+		}
+	}
+	if len(labels) > 0 {
+		return labels[0].Value
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package finto_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/finto"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/finto/fintotest"
+)
+
+const ucum = "http://urn.fi/URN:NBN:fi:au:ucum:"
+
+// newClient starts a fixture server and a client reading from it
+func newClient(t *testing.T) (*finto.Client, *fintotest.Server) {
+	server := fintotest.NewServer()
+	t.Cleanup(server.Close)
+	return finto.NewClient(server.URL+"/", fintotest.Vocabulary, server.Client()), server
+}
+
+func TestVocabulary(t *testing.T) {
+	client, _ := newClient(t)
+
+	vocab, err := client.Vocabulary(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ucum", vocab.ID)
+	assert.Equal(t, "2.1", vocab.Version)
+	assert.Equal(t, "en", vocab.DefaultLanguage)
+	assert.Equal(t, []string{"en", "fi"}, vocab.Languages)
+}
+
+func TestSearchPages(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	all, err := client.Search(ctx, "*", "", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, all, 8)
+	assert.Equal(t, finto.SearchResult{URI: ucum + "r010", PrefLabel: "metre", Lang: "en", Notation: "m"}, all[0])
+
+	// Pages of three cover every result once, in order
+	var paged []finto.SearchResult
+	for offset := 0; ; offset += 3 {
+		page, err := client.Search(ctx, "*", "en", 3, offset)
+		require.NoError(t, err)
+		paged = append(paged, page...)
+		if len(page) < 3 {
+			break
+		}
+	}
+	assert.Equal(t, all, paged)
+
+	page, err := client.Search(ctx, "*", "", 3, 100)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+func TestConceptParsesSKOS(t *testing.T) {
+	client, _ := newClient(t)
+
+	concept, err := client.Concept(context.Background(), ucum+"r133")
+	require.NoError(t, err)
+	assert.Equal(t, ucum+"r133", concept.URI)
+	assert.Equal(t, "atm", concept.Notation)
+	assert.Equal(t, map[string]string{"en": "standard atmosphere", "fi": "normaali-ilmakehä"}, concept.PrefLabels)
+	// Single-valued properties come as bare objects rather than arrays
+	assert.Equal(t, []finto.Label{{Lang: "en", Value: "atmosphere"}}, concept.AltLabels)
+	assert.Equal(t, map[string]string{"en": "non-SI unit of pressure equal to 101325 Pa"}, concept.Definitions)
+	// Related concepts take their notation and label from the same graph
+	assert.Equal(t, []finto.Reference{{URI: ucum + "r102", Notation: "Pa", Label: "pascal"}}, concept.Broader)
+	assert.Empty(t, concept.Narrower)
+}
+
+func TestConceptNarrower(t *testing.T) {
+	client, _ := newClient(t)
+
+	concept, err := client.Concept(context.Background(), ucum+"r102")
+	require.NoError(t, err)
+	assert.Equal(t, "Pa", concept.Notation)
+	assert.Empty(t, concept.Broader)
+	assert.Equal(t, []finto.Reference{
+		{URI: ucum + "r133", Notation: "atm", Label: "standard atmosphere"},
+		{URI: ucum + "r140", Notation: "bar", Label: "bar"},
+	}, concept.Narrower)
+}
+
+func TestConceptNotFound(t *testing.T) {
+	client, _ := newClient(t)
+
+	_, err := client.Concept(context.Background(), ucum+"r999")
+	assert.True(t, errors.Is(err, finto.ErrNotFound), "got %v", err)
+}
+
+func TestConceptOutage(t *testing.T) {
+	client, server := newClient(t)
+	server.FailConcepts.Store(true)
+
+	_, err := client.Concept(context.Background(), ucum+"r010")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, finto.ErrNotFound))
+	assert.Contains(t, err.Error(), "503")
+}
+
+// TestUCUMSyncWalk reads the vocabulary the way the UCUM sync does: page
+// through the "*" search and fetch every concept it lists
+func TestUCUMSyncWalk(t *testing.T) {
+	client, server := newClient(t)
+	ctx := context.Background()
+
+	notations := map[string]string{}
+	for offset := 0; ; offset += 5 {
+		results, err := client.Search(ctx, "*", "", 5, offset)
+		require.NoError(t, err)
+		for _, result := range results {
+			concept, err := client.Concept(ctx, result.URI)
+			require.NoError(t, err, result.URI)
+			assert.Equal(t, result.Notation, concept.Notation)
+			assert.Equal(t, result.PrefLabel, concept.PrefLabels["en"])
+			notations[concept.URI] = concept.Notation
+		}
+		if len(results) < 5 {
+			break
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		ucum + "r010": "m", ucum + "r011": "km",
+		ucum + "r020": "K", ucum + "r021": "Cel",
+		ucum + "r102": "Pa", ucum + "r133": "atm",
+		ucum + "r140": "bar", ucum + "r145": "mbar",
+	}, notations)
+	// Two search pages and one request per concept
+	assert.Equal(t, int64(2+8), server.Requests.Load())
+}
@@ -0,0 +1,110 @@
+// Package fintotest serves canned Finto (Skosmos REST v1) responses for a small
+// slice of the UCUM vocabulary, so the client and the UCUM sync can be exercised
+// without network access.
+package fintotest
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Vocabulary is the vocabulary identifier the fixtures are served under
+const Vocabulary = "ucum"
+
+//go:embed testdata
+var fixtures embed.FS
+
+// Server is a local Finto API backed by the embedded fixtures
+type Server struct {
+	*httptest.Server
+
+	// Requests counts the requests served
+	Requests atomic.Int64
+	// FailConcepts makes concept lookups answer 503, to simulate an outage
+	FailConcepts atomic.Bool
+}
+
+// NewServer starts a fixture server; callers must Close it
+func NewServer() *Server {
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/v1/"+Vocabulary+"/", s.vocabulary)
+	mux.HandleFunc("/rest/v1/"+Vocabulary+"/search", s.search)
+	mux.HandleFunc("/rest/v1/"+Vocabulary+"/data", s.data)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Requests.Add(1)
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// vocabulary serves the vocabulary metadata
+func (s *Server) vocabulary(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/rest/v1/"+Vocabulary+"/" {
+		http.NotFound(w, r)
+		return
+	}
+	serveFixture(w, "testdata/vocabulary.json")
+}
+
+// search serves the recorded "*" search, paged with maxhits and offset
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	data, err := fixtures.ReadFile("testdata/search.json")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var page struct {
+		Context json.RawMessage   `json:"@context"`
+		URI     string            `json:"uri"`
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("maxhits"))
+	if err != nil || limit <= 0 {
+		limit = len(page.Results)
+	}
+	if offset > len(page.Results) {
+		offset = len(page.Results)
+	}
+	page.Results = page.Results[offset:min(offset+limit, len(page.Results))]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// data serves the JSON-LD description of one concept
+func (s *Server) data(w http.ResponseWriter, r *http.Request) {
+	if s.FailConcepts.Load() {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	uri := r.URL.Query().Get("uri")
+	id := uri[strings.LastIndex(uri, ":")+1:]
+	if id == "" || strings.ContainsAny(id, "/.") {
+		http.NotFound(w, r)
+		return
+	}
+	serveFixture(w, "testdata/concepts/"+id+".json")
+}
+
+// serveFixture writes an embedded JSON file, or 404 if there is none
+func serveFixture(w http.ResponseWriter, name string) {
+	data, err := fixtures.ReadFile(name)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
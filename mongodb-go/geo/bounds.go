@@ -0,0 +1,42 @@
+package geo
+
+import (
+	"math"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// Bounds returns the [minx, miny, maxx, maxy] bounding box of a geometry
+func Bounds(g *models.GeoJSON) ([]float64, bool) {
+	if g == nil {
+		return nil, false
+	}
+	box := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	if !extend(box, g.Coordinates) || math.IsInf(box[0], 1) {
+		return nil, false
+	}
+	return box, true
+}
+
+// extend grows box by every position nested in coordinates
+func extend(box []float64, coordinates interface{}) bool {
+	if pos, ok := Position(coordinates); ok {
+		if len(pos) < 2 {
+			return false
+		}
+		box[0], box[1] = math.Min(box[0], pos[0]), math.Min(box[1], pos[1])
+		box[2], box[3] = math.Max(box[2], pos[0]), math.Max(box[3], pos[1])
+		return true
+	}
+
+	items, ok := sequence(coordinates)
+	if !ok {
+		return false
+	}
+	for _, item := range items {
+		if !extend(box, item) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package geo
+
+import (
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// Displacement returns how far apart two geometries are in meters, as the
+// Hausdorff distance between their vertices and outlines: the largest distance
+// from a vertex of either geometry to the nearest point of the other. Two
+// outlines of one shape score zero even when their vertices differ.
+func Displacement(a, b *models.GeoJSON) (float64, bool) {
+	pa, ok := parts(a)
+	if !ok {
+		return 0, false
+	}
+	pb, ok := parts(b)
+	if !ok {
+		return 0, false
+	}
+
+	// Project onto a local plane, which is accurate for the small changes measured
+	lat0 := radians(pa[0][0][1])
+	scale := EarthRadius * math.Pi / 180
+	project := func(ps [][][]float64) [][][2]float64 {
+		out := make([][][2]float64, len(ps))
+		for i, part := range ps {
+			out[i] = make([][2]float64, len(part))
+			for j, pos := range part {
+				out[i][j] = [2]float64{pos[0] * scale * math.Cos(lat0), pos[1] * scale}
+			}
+		}
+		return out
+	}
+	xa, xb := project(pa), project(pb)
+	return math.Max(directed(xa, xb), directed(xb, xa)), true
+}
+
+// directed returns the largest distance from a vertex of a to the outline of b
+func directed(a, b [][][2]float64) float64 {
+	worst := 0.0
+	for _, part := range a {
+		for _, p := range part {
+			nearest := math.Inf(1)
+			for _, other := range b {
+				if len(other) == 1 {
+					nearest = math.Min(nearest, math.Hypot(p[0]-other[0][0], p[1]-other[0][1]))
+					continue
+				}
+				for i := 1; i < len(other); i++ {
+					nearest = math.Min(nearest, segmentDistance(p, other[i-1], other[i]))
+				}
+			}
+			worst = math.Max(worst, nearest)
+		}
+	}
+	return worst
+}
+
+// segmentDistance returns the distance from p to the segment from a to b
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/l))
+	}
+	return math.Hypot(p[0]-a[0]-t*dx, p[1]-a[1]-t*dy)
+}
+
+// parts flattens a geometry into its points, lines and rings
+func parts(g *models.GeoJSON) ([][][]float64, bool) {
+	if g == nil {
+		return nil, false
+	}
+	var out [][][]float64
+	var walk func(v interface{}, depth int) bool
+	walk = func(v interface{}, depth int) bool {
+		if depth == 0 {
+			pos, ok := Position(v)
+			if !ok || len(pos) < 2 {
+				return false
+			}
+			out = append(out, [][]float64{pos})
+			return true
+		}
+		items, ok := sequence(v)
+		if !ok {
+			return false
+		}
+		if depth == 1 {
+			line := make([][]float64, 0, len(items))
+			for _, item := range items {
+				pos, ok := Position(item)
+				if !ok || len(pos) < 2 {
+					return false
+				}
+				line = append(line, pos)
+			}
+			if len(line) > 0 {
+				out = append(out, line)
+			}
+			return true
+		}
+		for _, item := range items {
+			if !walk(item, depth-1) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var ok bool
+	switch g.Type {
+	case "Point":
+		ok = walk(g.Coordinates, 0)
+	case "MultiPoint":
+		items, isSeq := sequence(g.Coordinates)
+		ok = isSeq
+		for _, item := range items {
+			ok = ok && walk(item, 0)
+		}
+	case "LineString":
+		ok = walk(g.Coordinates, 1)
+	case "MultiLineString", "Polygon":
+		ok = walk(g.Coordinates, 2)
+	case "MultiPolygon":
+		ok = walk(g.Coordinates, 3)
+	}
+	return out, ok && len(out) > 0
+}
+
+// sequence returns the items of a decoded GeoJSON coordinate array
+func sequence(v interface{}) ([]interface{}, bool) {
+	switch val := v.(type) {
+	case primitive.A:
+		return val, true
+	case []interface{}:
+		return val, true
+	case [][]float64:
+		items := make([]interface{}, len(val))
+		for i, pos := range val {
+			items[i] = pos
+		}
+		return items, true
+	case [][][]float64:
+		items := make([]interface{}, len(val))
+		for i, ring := range val {
+			items[i] = ring
+		}
+		return items, true
+	case [][][][]float64:
+		items := make([]interface{}, len(val))
+		for i, polygon := range val {
+			items[i] = polygon
+		}
+		return items, true
+	default:
+		return nil, false
+	}
+}
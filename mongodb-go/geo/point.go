@@ -0,0 +1,120 @@
+package geo
+
+import (
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// EarthRadius is the mean Earth radius in meters
+const EarthRadius = 6371008.8
+
+// PointCoordinates returns the [longitude, latitude(, elevation)] of a Point geometry
+func PointCoordinates(g *models.GeoJSON) ([]float64, bool) {
+	if g == nil || g.Type != "Point" {
+		return nil, false
+	}
+	coords, ok := Position(g.Coordinates)
+	if !ok || len(coords) < 2 {
+		return nil, false
+	}
+	return coords, true
+}
+
+// Position converts a decoded GeoJSON position into float64 coordinates
+func Position(v interface{}) ([]float64, bool) {
+	var items []interface{}
+	switch val := v.(type) {
+	case []float64:
+		return val, true
+	case primitive.A:
+		items = val
+	case []interface{}:
+		items = val
+	default:
+		return nil, false
+	}
+
+	coords := make([]float64, len(items))
+	for i, item := range items {
+		n, ok := number(item)
+		if !ok {
+			return nil, false
+		}
+		coords[i] = n
+	}
+	return coords, true
+}
+
+// NewPoint creates a Point geometry
+func NewPoint(coords []float64) *models.GeoJSON {
+	return &models.GeoJSON{Type: "Point", Coordinates: coords}
+}
+
+// NewLineString creates a LineString geometry from positions
+func NewLineString(positions [][]float64) *models.GeoJSON {
+	return &models.GeoJSON{Type: "LineString", Coordinates: positions}
+}
+
+// Distance returns the great-circle distance in meters between two positions
+func Distance(a, b []float64) float64 {
+	lat1, lat2 := radians(a[1]), radians(b[1])
+	dLat := lat2 - lat1
+	dLon := radians(b[0] - a[0])
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Interpolate returns the position at fraction f (0..1) of the way from a to b.
+// Interpolation is linear in longitude/latitude, which is accurate for the short
+// hops between consecutive fixes of a moving thing.
+func Interpolate(a, b []float64, f float64) []float64 {
+	n := min(len(a), len(b))
+	coords := make([]float64, n)
+	for i := 0; i < n; i++ {
+		coords[i] = a[i] + (b[i]-a[i])*f
+	}
+
+	// Take the short way across the antimeridian
+	if math.Abs(b[0]-a[0]) > 180 {
+		delta := b[0] - a[0]
+		if delta > 0 {
+			delta -= 360
+		} else {
+			delta += 360
+		}
+		coords[0] = normalizeLongitude(a[0] + delta*f)
+	}
+	return coords
+}
+
+// normalizeLongitude wraps a longitude into [-180, 180)
+func normalizeLongitude(lon float64) float64 {
+	return math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+}
+
+// radians converts degrees to radians
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// number converts a decoded BSON or JSON number to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,247 @@
+package geo
+
+import (
+	"math"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// Topological relations of one geometry to another, as used by associations
+const (
+	RelationWithin     = "within"
+	RelationContains   = "contains"
+	RelationOverlaps   = "overlaps"
+	RelationIntersects = "intersects"
+	RelationTouches    = "touches"
+)
+
+// WithinRatio is the share of a geometry that must lie inside another for it to count as within
+const WithinRatio = 0.95
+
+const (
+	// gridSize is the number of samples per axis taken over the bounds of an areal geometry
+	gridSize = 64
+	// lineSamples is the number of samples taken along a linear geometry
+	lineSamples = 256
+	// touchTolerance is how close in degrees two outlines must come to touch
+	touchTolerance = 1e-9
+)
+
+// Relation describes how a geometry relates to another
+type Relation struct {
+	// Type is one of the Relation constants, or empty when the geometries are disjoint
+	Type string
+	// Ratio is the overlap ratio: the share of the geometry inside the other,
+	// or for contains the share of the other geometry inside it
+	Ratio float64
+}
+
+// Relate computes the topological relation of geometry a to geometry b.
+// Overlap is measured by sampling: a regular grid over polygons, evenly spaced
+// points along lines, and the points themselves. Coordinates are treated as
+// planar, which is accurate for the local extents of features of interest.
+func Relate(a, b *models.GeoJSON) (Relation, bool) {
+	pa, ok := parts(a)
+	if !ok {
+		return Relation{}, false
+	}
+	pb, ok := parts(b)
+	if !ok {
+		return Relation{}, false
+	}
+	polysA, polysB := polygons(a), polygons(b)
+
+	var inA, inB float64
+	switch {
+	case polysB != nil:
+		inA = share(samples(a, pa, polysA), polysB)
+		if polysA != nil {
+			inB = share(samples(b, pb, polysB), polysA)
+		}
+	case polysA != nil:
+		inB = share(samples(b, pb, polysB), polysA)
+	default:
+		// Neither geometry has an area: they can only cross or touch
+		if outlinesMeet(pa, pb) {
+			return Relation{Type: RelationIntersects, Ratio: 1}, true
+		}
+		return Relation{}, true
+	}
+
+	switch {
+	case inA >= WithinRatio:
+		return Relation{Type: RelationWithin, Ratio: inA}, true
+	case inB >= WithinRatio:
+		return Relation{Type: RelationContains, Ratio: inB}, true
+	case inA > 0 || inB > 0:
+		if polysA != nil && polysB != nil {
+			return Relation{Type: RelationOverlaps, Ratio: inA}, true
+		}
+		return Relation{Type: RelationIntersects, Ratio: math.Max(inA, inB)}, true
+	case outlinesMeet(pa, pb):
+		return Relation{Type: RelationTouches}, true
+	default:
+		return Relation{}, true
+	}
+}
+
+// polygons returns the rings of each polygon of an areal geometry, nil otherwise
+func polygons(g *models.GeoJSON) [][][][]float64 {
+	var polys [][][][]float64
+	switch g.Type {
+	case "Polygon":
+		p, _ := parts(g)
+		polys = append(polys, p)
+	case "MultiPolygon":
+		items, _ := sequence(g.Coordinates)
+		for _, item := range items {
+			p, ok := parts(&models.GeoJSON{Type: "Polygon", Coordinates: item})
+			if ok {
+				polys = append(polys, p)
+			}
+		}
+	}
+	return polys
+}
+
+// samples returns evenly distributed positions covering a geometry
+func samples(g *models.GeoJSON, ps [][][]float64, polys [][][][]float64) [][]float64 {
+	if polys != nil {
+		box, ok := Bounds(g)
+		if !ok {
+			return nil
+		}
+		var out [][]float64
+		dx, dy := (box[2]-box[0])/gridSize, (box[3]-box[1])/gridSize
+		for i := 0; i < gridSize; i++ {
+			for j := 0; j < gridSize; j++ {
+				p := []float64{box[0] + (float64(i)+0.5)*dx, box[1] + (float64(j)+0.5)*dy}
+				if inside(p, polys) {
+					out = append(out, p)
+				}
+			}
+		}
+		return out
+	}
+
+	if g.Type == "Point" || g.Type == "MultiPoint" {
+		out := make([][]float64, 0, len(ps))
+		for _, part := range ps {
+			out = append(out, part[0])
+		}
+		return out
+	}
+
+	// Lines are sampled at the midpoints of equal stretches of their length
+	total := 0.0
+	for _, line := range ps {
+		for i := 1; i < len(line); i++ {
+			total += math.Hypot(line[i][0]-line[i-1][0], line[i][1]-line[i-1][1])
+		}
+	}
+	if total == 0 {
+		return [][]float64{ps[0][0]}
+	}
+	step := total / lineSamples
+	var out [][]float64
+	next := step / 2
+	walked := 0.0
+	for _, line := range ps {
+		for i := 1; i < len(line); i++ {
+			length := math.Hypot(line[i][0]-line[i-1][0], line[i][1]-line[i-1][1])
+			for length > 0 && next <= walked+length {
+				f := (next - walked) / length
+				out = append(out, []float64{
+					line[i-1][0] + (line[i][0]-line[i-1][0])*f,
+					line[i-1][1] + (line[i][1]-line[i-1][1])*f,
+				})
+				next += step
+			}
+			walked += length
+		}
+	}
+	return out
+}
+
+// share returns the fraction of positions inside the polygons
+func share(positions [][]float64, polys [][][][]float64) float64 {
+	if len(positions) == 0 {
+		return 0
+	}
+	n := 0
+	for _, p := range positions {
+		if inside(p, polys) {
+			n++
+		}
+	}
+	return float64(n) / float64(len(positions))
+}
+
+// inside reports whether a position lies in any of the polygons, outside their holes
+func inside(p []float64, polys [][][][]float64) bool {
+	for _, rings := range polys {
+		if len(rings) == 0 || !inRing(p, rings[0]) {
+			continue
+		}
+		hole := false
+		for _, ring := range rings[1:] {
+			if inRing(p, ring) {
+				hole = true
+				break
+			}
+		}
+		if !hole {
+			return true
+		}
+	}
+	return false
+}
+
+// inRing applies the even-odd rule to a closed ring
+func inRing(p []float64, ring [][]float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// outlinesMeet reports whether the points, lines or rings of two geometries touch or cross
+func outlinesMeet(a, b [][][]float64) bool {
+	for _, pa := range a {
+		for _, pb := range b {
+			for i := 0; i < max(len(pa)-1, 1); i++ {
+				for j := 0; j < max(len(pb)-1, 1); j++ {
+					if segmentsMeet(pa[i], pa[min(i+1, len(pa)-1)], pb[j], pb[min(j+1, len(pb)-1)]) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// segmentsMeet reports whether segment a1-a2 comes within the touch tolerance of segment b1-b2
+func segmentsMeet(a1, a2, b1, b2 []float64) bool {
+	d1, d2 := orientation(b1, b2, a1), orientation(b1, b2, a2)
+	d3, d4 := orientation(a1, a2, b1), orientation(a1, a2, b2)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	at := func(p []float64) [2]float64 { return [2]float64{p[0], p[1]} }
+	return segmentDistance(at(a1), at(b1), at(b2)) <= touchTolerance ||
+		segmentDistance(at(a2), at(b1), at(b2)) <= touchTolerance ||
+		segmentDistance(at(b1), at(a1), at(a2)) <= touchTolerance ||
+		segmentDistance(at(b2), at(a1), at(a2)) <= touchTolerance
+}
+
+// orientation returns the sign of the turn from a-b to a-c
+func orientation(a, b, c []float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
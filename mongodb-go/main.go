@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/api"
 	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/config"
-	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
-	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/finto"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/mqtt"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi"
 	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/schemas"
 	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/services"
 )
@@ -37,31 +43,32 @@ func main() {
 		}
 	}()
 	
-	// Create context with timeout
+	// Create context with timeout for startup tasks
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	
 	// Initialize schemas (create collections and indexes)
-	if err := initializeSchemas(ctx, db, logger); err != nil {
+	if err := initializeSchemas(ctx, cfg, db, logger); err != nil {
 		logger.Errorf("Failed to initialize schemas: %v", err)
 	}
 	
-	// Example: Generate and insert date dimension
-	if err := generateDateDimension(ctx, db, logger); err != nil {
+	// Generate and upsert the date dimension
+	dateDimension, err := generateDateDimension(ctx, cfg, db, logger)
+	if err != nil {
 		logger.Errorf("Failed to generate date dimension: %v", err)
 	}
 	
-	// Example: Insert sample observations
-	if err := insertSampleObservations(ctx, db, logger); err != nil {
-		logger.Errorf("Failed to insert sample observations: %v", err)
-	}
+	// Run background jobs for as long as the server runs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	startBackgroundJobs(jobsCtx, cfg, db, dateDimension, logger)
 	
-	// Example: Query observations
-	if err := queryObservations(ctx, db, logger); err != nil {
-		logger.Errorf("Failed to query observations: %v", err)
+	// Serve the SensorThings API until interrupted
+	if err := runServer(cfg, db, logger); err != nil {
+		logger.Errorf("Server error: %v", err)
 	}
+	stopJobs()
 	
-	logger.Info("Application completed successfully")
+	logger.Info("Application stopped")
 }
 
 // setupLogger configures the logger
@@ -98,7 +105,7 @@ func setupLogger() *logrus.Logger {
 }
 
 // initializeSchemas creates collections and indexes
-func initializeSchemas(ctx context.Context, db *config.Database, logger *logrus.Logger) error {
+func initializeSchemas(ctx context.Context, cfg *config.Config, db *config.Database, logger *logrus.Logger) error {
 	logger.Info("Initializing database schemas...")
 	
 	// Create observations time-series collection
@@ -106,116 +113,164 @@ func initializeSchemas(ctx context.Context, db *config.Database, logger *logrus.
 		return fmt.Errorf("failed to create observation collection: %w", err)
 	}
 	
-	// Create other collections would go here
-	// schemas.CreateFeatureOfInterestCollection(ctx, db.Database, logger)
-	// schemas.CreateUnitOfMeasurementCollection(ctx, db.Database, logger)
+	// Create indexes for the SensorThings dimension collections
+	if err := schemas.CreateDimensionIndexes(ctx, db.Database, logger); err != nil {
+		return fmt.Errorf("failed to create dimension indexes: %w", err)
+	}
+	
+	// Create features of interest collection
+	if err := schemas.CreateFeatureOfInterestCollection(ctx, db.Database, logger); err != nil {
+		return fmt.Errorf("failed to create features of interest collection: %w", err)
+	}
+	
+	// Create indexes for the rollup collections
+	if err := schemas.CreateRollupIndexes(ctx, db.Database, logger); err != nil {
+		return fmt.Errorf("failed to create rollup indexes: %w", err)
+	}
+	
+	// Create unit of measurement collection
+	if err := schemas.CreateUnitOfMeasurementCollection(ctx, db.Database, logger); err != nil {
+		return fmt.Errorf("failed to create unit of measurement collection: %w", err)
+	}
+	
+	// Create indexes for the materialized unit hierarchies
+	if err := schemas.CreateUnitHierarchyCacheIndexes(ctx, db.Database, logger); err != nil {
+		return fmt.Errorf("failed to create unit hierarchy cache indexes: %w", err)
+	}
+	
+	// Create external feature cache collection
+	if err := schemas.CreateExternalFeatureCacheCollection(ctx, db.Database, logger); err != nil {
+		return fmt.Errorf("failed to create external feature cache collection: %w", err)
+	}
+	
+	// Create the expiry index of the observation feed
+	if err := schemas.CreateObservationFeedIndexes(ctx, db.Database, cfg.Feed.Retention, logger); err != nil {
+		return fmt.Errorf("failed to create observation feed indexes: %w", err)
+	}
 	
 	logger.Info("Database schemas initialized successfully")
 	return nil
 }
 
-// generateDateDimension generates and inserts date dimension data
-func generateDateDimension(ctx context.Context, db *config.Database, logger *logrus.Logger) error {
+// generateDateDimension generates the date dimension from the configured start date
+// to the configured horizon, upserting so that restarts are harmless
+func generateDateDimension(ctx context.Context, cfg *config.Config, db *config.Database, 
+	logger *logrus.Logger) (*services.DateDimensionService, error) {
+	
 	logger.Info("Generating date dimension...")
 	
-	service := services.NewDateDimensionService(db.Database, logger)
+	calendar, err := services.LoadHolidayCalendar(cfg.Calendar.HolidayCalendar, cfg.Calendar.HolidayRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load holiday calendar: %w", err)
+	}
+	service := services.NewDateDimensionService(db.Database, logger, calendar)
 	
-	// Generate dates for 2025
-	startDate := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
-	endDate := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+	startDate := cfg.Calendar.StartDate
+	endDate := time.Now().UTC().Truncate(24 * time.Hour).AddDate(0, 0, cfg.Calendar.HorizonDays)
 	
 	dates, err := service.GenerateDateRange(ctx, startDate, endDate)
 	if err != nil {
-		return fmt.Errorf("failed to generate date range: %w", err)
+		return service, fmt.Errorf("failed to generate date range: %w", err)
 	}
 	
-	// Insert into database
-	if err := service.InsertDateDimension(ctx, dates); err != nil {
-		return fmt.Errorf("failed to insert date dimension: %w", err)
+	// Upsert into database
+	if err := service.UpsertDateDimension(ctx, dates); err != nil {
+		return service, fmt.Errorf("failed to upsert date dimension: %w", err)
 	}
 	
-	logger.Infof("Successfully generated and inserted %d date records", len(dates))
-	return nil
-}
-
-// insertSampleObservations inserts sample observation data
-func insertSampleObservations(ctx context.Context, db *config.Database, logger *logrus.Logger) error {
-	logger.Info("Inserting sample observations...")
-	
-	repo := repository.NewObservationRepository(db.Database)
-	
-	// Create sample observations
-	observations := []models.Observation{
-		{
-			PhenomenonTime: time.Now().UTC(),
-			Datastream: models.DatastreamMeta{
-				DatastreamID:       "DS-001",
-				ThingID:           "THING-001",
-				SensorID:          "SENSOR-001",
-				ObservedPropertyID: "PROP-001",
-				LocationID:        "LOC-001",
-				UnitOfMeasurement: &models.UnitOfMeasure{
-					Name:       "degree Celsius",
-					Symbol:     "°C",
-					Definition: "http://unitsofmeasure.org/ucum.html#para-30",
-				},
-			},
-			Result:        23.5,
-			ResultTime:    func() *time.Time { t := time.Now().UTC(); return &t }(),
-			ResultQuality: "good",
-			FeatureOfInterestID: "FOI-001",
-			Parameters: map[string]interface{}{
-				"calibrationDate": time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
-				"accuracy":        0.1,
-			},
-			Location: &models.GeoJSON{
-				Type:        "Point",
-				Coordinates: []float64{-114.133, 51.08},
-			},
-		},
-	}
-	
-	// Insert observations
-	if err := repo.InsertMany(ctx, observations); err != nil {
-		return fmt.Errorf("failed to insert observations: %w", err)
-	}
-	
-	logger.Infof("Successfully inserted %d sample observations", len(observations))
-	return nil
+	logger.Infof("Successfully generated and upserted %d date records", len(dates))
+	return service, nil
 }
 
-// queryObservations demonstrates various query patterns
-func queryObservations(ctx context.Context, db *config.Database, logger *logrus.Logger) error {
-	logger.Info("Querying observations...")
+// startBackgroundJobs launches the periodic maintenance jobs enabled in the configuration
+func startBackgroundJobs(ctx context.Context, cfg *config.Config, db *config.Database,
+	dateDimension *services.DateDimensionService, logger *logrus.Logger) {
 	
-	repo := repository.NewObservationRepository(db.Database)
+	if cfg.Calendar.RefreshEnabled && dateDimension != nil {
+		go dateDimension.StartDailyRefresh(ctx, cfg.Calendar.HorizonDays)
+		logger.Info("Date dimension refresh scheduled daily")
+	}
+	if cfg.Sync.UCUMSyncEnabled {
+		schedule, err := services.ParseCronSchedule(cfg.Sync.UCUMSyncSchedule)
+		if err != nil {
+			logger.Errorf("UCUM sync disabled: %v", err)
+		} else {
+			client := finto.NewClient(cfg.APIs.FintoAPIURL, cfg.APIs.FintoVocabulary,
+				&http.Client{Timeout: cfg.APIs.RequestTimeout})
+			ucum := services.NewUCUMSyncService(db.Database, client, logger, cfg.Sync.CacheTTL)
+			go ucum.Start(ctx, schedule)
+			logger.Infof("UCUM sync scheduled (%s)", cfg.Sync.UCUMSyncSchedule)
+		}
+	}
 	
-	// Query by datastream
-	startTime := time.Now().Add(-24 * time.Hour)
-	endTime := time.Now()
+	if cfg.Sync.FeatureSyncEnabled && cfg.APIs.OGCAPIBaseURL != "" {
+		client := ogcapi.NewClient(cfg.APIs.OGCAPIBaseURL,
+			&http.Client{Timeout: cfg.APIs.RequestTimeout}, cfg.Sync.FeatureSyncRetries)
+		features := services.NewFeatureCacheService(db.Database, client, logger, cfg.Sync.CacheTTL)
+		featureSync := services.NewFeatureSyncService(db.Database, features, logger,
+			cfg.Sync.FeatureSyncBatchSize, cfg.Sync.FeatureChangeTolerance)
+		go featureSync.Start(ctx, cfg.Sync.SyncInterval)
+		logger.Infof("Feature sync started (every %s)", cfg.Sync.SyncInterval)
+	}
 	
-	observations, err := repo.FindByDatastream(ctx, "DS-001", startTime, endTime, 100)
-	if err != nil {
-		return fmt.Errorf("failed to query observations: %w", err)
+	if cfg.Sync.RollupEnabled {
+		rollups := services.NewRollupService(db.Database, logger, cfg.Sync.RollupBatchSize)
+		go rollups.Start(ctx, cfg.Sync.RollupInterval)
+		logger.Infof("Rollup service started (every %s)", cfg.Sync.RollupInterval)
+	}
+}
+
+// runServer starts the SensorThings API server, and the MQTT broker and
+// observation feed when enabled, and blocks until a shutdown signal
+func runServer(cfg *config.Config, db *config.Database, logger *logrus.Logger) error {
+	server := api.NewServer(&cfg.App, db.Database, logger)
+	
+	if cfg.App.WritesEnabled && cfg.App.JWTSecret == "" {
+		logger.Warn("Writes are enabled without JWT_SECRET, anyone can change data")
 	}
 	
-	logger.Infof("Found %d observations for datastream DS-001", len(observations))
+	// Publishers are wired before the server starts taking requests
+	errCh := make(chan error, 2)
+	if cfg.MQTT.Enabled {
+		broker := mqtt.NewBroker(server.HandleMQTTPublish, server.AuthenticateMQTT, logger)
+		server.PublishTo(broker)
+		defer broker.Close()
+		go func() {
+			if err := broker.ListenAndServe(fmt.Sprintf(":%d", cfg.MQTT.Port)); !errors.Is(err, mqtt.ErrBrokerClosed) {
+				errCh <- err
+			}
+		}()
+	}
 	
-	// Get hourly statistics
-	stats, err := repo.GetHourlyStatistics(ctx, "DS-001", startTime, endTime)
-	if err != nil {
-		return fmt.Errorf("failed to get statistics: %w", err)
+	stopFeed := func() {}
+	if cfg.Feed.Enabled {
+		feed := services.NewObservationFeedService(db.Database, logger, cfg.Feed.SubscriberBuffer)
+		server.ServeFeed(feed)
+		var feedCtx context.Context
+		feedCtx, stopFeed = context.WithCancel(context.Background())
+		defer stopFeed()
+		go feed.Start(feedCtx)
 	}
 	
-	logger.Infof("Calculated statistics for %d hourly periods", len(stats))
+	go func() {
+		errCh <- server.Start()
+	}()
 	
-	// Query near location
-	nearObs, err := repo.FindNearLocation(ctx, -114.133, 51.08, 1000, 10)
-	if err != nil {
-		logger.Warnf("Failed to find observations near location: %v", err)
-	} else {
-		logger.Infof("Found %d observations near location", len(nearObs))
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-stop:
+		logger.Infof("Received %s, shutting down", sig)
 	}
 	
-	return nil
+	// Feed connections stay open until the feed ends them
+	stopFeed()
+	
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
 }
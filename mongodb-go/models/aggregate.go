@@ -0,0 +1,156 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// HourKey identifies one hour of a datastream in the rollup collections
+type HourKey struct {
+	DatastreamID string `bson:"datastreamId" json:"datastreamId"`
+	DateKey      int    `bson:"date_key" json:"dateKey"`
+	Hour         int    `bson:"hour" json:"hour"`
+}
+
+// DayKey identifies one day of a datastream in the rollup collections
+type DayKey struct {
+	DatastreamID string `bson:"datastreamId" json:"datastreamId"`
+	DateKey      int    `bson:"date_key" json:"dateKey"`
+}
+
+// NewHourKey returns the rollup bucket containing t; buckets are UTC hours
+func NewHourKey(datastreamID string, t time.Time) HourKey {
+	t = t.UTC()
+	return HourKey{DatastreamID: datastreamID, DateKey: GetDateKey(t), Hour: GetHourBucket(t)}
+}
+
+// Start returns the first instant of the hour
+func (k HourKey) Start() time.Time {
+	return DateKeyTime(k.DateKey).Add(time.Duration(k.Hour) * time.Hour)
+}
+
+// Day returns the key of the day containing the hour
+func (k HourKey) Day() DayKey {
+	return DayKey{DatastreamID: k.DatastreamID, DateKey: k.DateKey}
+}
+
+// DateKeyTime converts a YYYYMMDD date key into midnight UTC
+func DateKeyTime(dateKey int) time.Time {
+	return time.Date(dateKey/10000, time.Month(dateKey/100%100), dateKey%100, 0, 0, 0, 0, time.UTC)
+}
+
+// MetricStats holds summary statistics of numeric results. Sums are kept so that
+// statistics of adjacent buckets can be merged without revisiting raw data.
+type MetricStats struct {
+	Count      int64   `bson:"count" json:"count"`
+	ValueCount int64   `bson:"value_count" json:"valueCount"`
+	Sum        float64 `bson:"sum" json:"sum"`
+	SumSquares float64 `bson:"sum_sq" json:"sumSquares"`
+	Min        float64 `bson:"min" json:"min"`
+	Max        float64 `bson:"max" json:"max"`
+	Avg        float64 `bson:"avg" json:"avg"`
+	StdDev     float64 `bson:"stddev" json:"stddev"`
+}
+
+// Merge adds the statistics of another bucket
+func (m *MetricStats) Merge(o MetricStats) {
+	if o.ValueCount > 0 {
+		if m.ValueCount == 0 || o.Min < m.Min {
+			m.Min = o.Min
+		}
+		if m.ValueCount == 0 || o.Max > m.Max {
+			m.Max = o.Max
+		}
+	}
+	m.Count += o.Count
+	m.ValueCount += o.ValueCount
+	m.Sum += o.Sum
+	m.SumSquares += o.SumSquares
+	m.Finalize()
+}
+
+// Finalize derives the mean and population standard deviation from the sums
+func (m *MetricStats) Finalize() {
+	if m.ValueCount == 0 {
+		m.Avg, m.StdDev = 0, 0
+		return
+	}
+	n := float64(m.ValueCount)
+	m.Avg = m.Sum / n
+	m.StdDev = math.Sqrt(math.Max(0, m.SumSquares/n-m.Avg*m.Avg))
+}
+
+// MetricSet holds statistics keyed by observed property
+type MetricSet map[string]MetricStats
+
+// Merge adds the statistics of another bucket property by property
+func (s MetricSet) Merge(o MetricSet) {
+	for name, stats := range o {
+		merged := s[name]
+		merged.Merge(stats)
+		s[name] = merged
+	}
+}
+
+// Total combines the statistics of all properties
+func (s MetricSet) Total() MetricStats {
+	var total MetricStats
+	for _, stats := range s {
+		total.Merge(stats)
+	}
+	return total
+}
+
+// QualityCounts tallies observations by resultQuality
+type QualityCounts struct {
+	Good         int64   `bson:"good_readings" json:"goodReadings"`
+	Bad          int64   `bson:"bad_readings" json:"badReadings"`
+	Uncertain    int64   `bson:"uncertain_readings" json:"uncertainReadings"`
+	Missing      int64   `bson:"missing_readings" json:"missingReadings"`
+	QualityScore float64 `bson:"quality_score" json:"qualityScore"`
+}
+
+// Merge adds the counts of another bucket
+func (q *QualityCounts) Merge(o QualityCounts) {
+	q.Good += o.Good
+	q.Bad += o.Bad
+	q.Uncertain += o.Uncertain
+	q.Missing += o.Missing
+	q.Finalize()
+}
+
+// Finalize computes the share of good readings among rated readings, in percent
+func (q *QualityCounts) Finalize() {
+	rated := q.Good + q.Bad + q.Uncertain + q.Missing
+	if rated == 0 {
+		q.QualityScore = 0
+		return
+	}
+	q.QualityScore = 100 * float64(q.Good) / float64(rated)
+}
+
+// HourlyAggregate is the rollup of one hour of a datastream
+type HourlyAggregate struct {
+	ID               HourKey       `bson:"_id" json:"id"`
+	StartTime        time.Time     `bson:"start_time" json:"startTime"`
+	EndTime          time.Time     `bson:"end_time" json:"endTime"`
+	Metrics          MetricSet     `bson:"metrics" json:"metrics"`
+	Quality          QualityCounts `bson:"quality" json:"quality"`
+	FirstObservation time.Time     `bson:"first_observation" json:"firstObservation"`
+	LastObservation  time.Time     `bson:"last_observation" json:"lastObservation"`
+	ComputedAt       time.Time     `bson:"computed_at" json:"computedAt"`
+	Version          int           `bson:"version" json:"version"`
+}
+
+// DailySummary is the rollup of one day of a datastream, built from its hourly aggregates
+type DailySummary struct {
+	ID               DayKey        `bson:"_id" json:"id"`
+	Date             time.Time     `bson:"date" json:"date"`
+	Statistics       MetricSet     `bson:"statistics" json:"statistics"`
+	Quality          QualityCounts `bson:"quality" json:"quality"`
+	Hours            int           `bson:"hours" json:"hours"`
+	FirstObservation time.Time     `bson:"first_observation" json:"firstObservation"`
+	LastObservation  time.Time     `bson:"last_observation" json:"lastObservation"`
+	ComputedAt       time.Time     `bson:"computed_at" json:"computedAt"`
+	Version          int           `bson:"version" json:"version"`
+}
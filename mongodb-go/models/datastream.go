@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Datastream groups observations of one observed property made by one sensor on one thing
+type Datastream struct {
+	ID                 string                 `bson:"_id" json:"id" validate:"required"`
+	Name               string                 `bson:"name" json:"name" validate:"required"`
+	Description        string                 `bson:"description,omitempty" json:"description,omitempty"`
+	ObservationType    string                 `bson:"observationType" json:"observationType" validate:"required"`
+	ThingID            string                 `bson:"thingId" json:"thingId" validate:"required"`
+	SensorID           string                 `bson:"sensorId" json:"sensorId" validate:"required"`
+	ObservedPropertyID string                 `bson:"observedPropertyId" json:"observedPropertyId" validate:"required"`
+	UnitOfMeasurement  *UnitOfMeasure         `bson:"unitOfMeasurement,omitempty" json:"unitOfMeasurement,omitempty"`
+	ObservedArea       *GeoJSON               `bson:"observedArea,omitempty" json:"observedArea,omitempty"`
+	PhenomenonTime     *TimeInterval          `bson:"phenomenonTime,omitempty" json:"phenomenonTime,omitempty"`
+	ResultTime         *TimeInterval          `bson:"resultTime,omitempty" json:"resultTime,omitempty"`
+	ValidFrom          time.Time              `bson:"valid_from" json:"validFrom"`
+	ValidTo            time.Time              `bson:"valid_to" json:"validTo"`
+	IsCurrent          bool                   `bson:"is_current" json:"isCurrent"`
+	Version            int                    `bson:"version" json:"version"`
+	Properties         map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+	CreatedAt          time.Time              `bson:"created_at" json:"createdAt"`
+	UpdatedAt          time.Time              `bson:"updated_at" json:"updatedAt"`
+}
+
+// TimeInterval is a time period; a nil End means the period is still open
+type TimeInterval struct {
+	Start time.Time  `bson:"start" json:"start"`
+	End   *time.Time `bson:"end" json:"end"`
+}
+
+// EndOfTime marks the open end of an SCD Type 2 validity period
+var EndOfTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
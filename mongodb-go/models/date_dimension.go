@@ -58,12 +58,6 @@ type DateDimension struct {
 	QuartersFromToday int `bson:"quarters_from_today" json:"quartersFromToday"`
 }
 
-// HolidayDefinition defines a holiday
-type HolidayDefinition struct {
-	MonthDay string `json:"monthDay"` // Format: "MM-DD"
-	Name     string `json:"name"`
-}
-
 // GetDateKey returns the date key in YYYYMMDD format
 func GetDateKey(t time.Time) int {
 	year := t.Year()
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExternalFeatureCache is a cached copy of a feature served by an OGC API - Features service
+type ExternalFeatureCache struct {
+	ID      string                `bson:"_id" json:"id"`
+	Source  ExternalFeatureSource `bson:"source" json:"source"`
+	Feature CachedFeature         `bson:"feature" json:"feature"`
+	Cache   FeatureCacheInfo      `bson:"cache" json:"cache"`
+	Usage   *FeatureUsage         `bson:"usage,omitempty" json:"usage,omitempty"`
+
+	// Stale is set when the entry is served past its expiry because the API could not be reached
+	Stale bool `bson:"-" json:"stale,omitempty"`
+}
+
+// ExternalFeatureSource identifies where a cached feature was fetched from
+type ExternalFeatureSource struct {
+	API        string `bson:"api" json:"api"`
+	Collection string `bson:"collection" json:"collection"`
+	FeatureID  string `bson:"featureId" json:"featureId"`
+	FullURI    string `bson:"fullUri" json:"fullUri"`
+}
+
+// CachedFeature is the GeoJSON content of a cached feature
+type CachedFeature struct {
+	Type       string                 `bson:"type" json:"type"`
+	ID         string                 `bson:"id" json:"id"`
+	Geometry   *GeoJSON               `bson:"geometry,omitempty" json:"geometry,omitempty"`
+	Properties map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+	BBox       []float64              `bson:"bbox,omitempty" json:"bbox,omitempty"`
+	Links      []FeatureLink          `bson:"links,omitempty" json:"links,omitempty"`
+}
+
+// FeatureLink is a hypermedia link of a cached feature
+type FeatureLink struct {
+	Href string `bson:"href" json:"href"`
+	Rel  string `bson:"rel,omitempty" json:"rel,omitempty"`
+	Type string `bson:"type,omitempty" json:"type,omitempty"`
+}
+
+// FeatureCacheInfo records when a cached feature was fetched and when it expires
+type FeatureCacheInfo struct {
+	FetchedAt   time.Time  `bson:"fetchedAt" json:"fetchedAt"`
+	Expires     time.Time  `bson:"expires" json:"expires"`
+	ContentCRS  string     `bson:"contentCrs,omitempty" json:"contentCrs,omitempty"`
+	ContentType string     `bson:"contentType,omitempty" json:"contentType,omitempty"`
+	Size        int        `bson:"size,omitempty" json:"size,omitempty"`
+	Hash        string     `bson:"hash,omitempty" json:"hash,omitempty"`
+	LastError   string     `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	LastErrorAt *time.Time `bson:"lastErrorAt,omitempty" json:"lastErrorAt,omitempty"`
+}
+
+// FeatureUsage tracks how often a cached feature is read and by which features of interest
+type FeatureUsage struct {
+	AccessCount      int64     `bson:"accessCount" json:"accessCount"`
+	LastAccessed     time.Time `bson:"lastAccessed" json:"lastAccessed"`
+	ReferencedByFOIs []string  `bson:"referencedByFOIs,omitempty" json:"referencedByFOIs,omitempty"`
+}
+
+// Fresh reports whether the entry has not yet expired at now
+func (c *ExternalFeatureCache) Fresh(now time.Time) bool {
+	return now.Before(c.Cache.Expires)
+}
+
+// FeatureSyncReport summarizes one run of the external feature synchronization
+type FeatureSyncReport struct {
+	ID                      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StartedAt               time.Time          `bson:"startedAt" json:"startedAt"`
+	FinishedAt              time.Time          `bson:"finishedAt" json:"finishedAt"`
+	FeaturesOfInterest      int                `bson:"featuresOfInterest" json:"featuresOfInterest"`
+	Features                int                `bson:"features" json:"features"`
+	Updated                 int                `bson:"updated" json:"updated"`
+	Unchanged               int                `bson:"unchanged" json:"unchanged"`
+	Failed                  int                `bson:"failed" json:"failed"`
+	Removed                 int                `bson:"removed" json:"removed"`
+	GeometryChanged         int                `bson:"geometryChanged" json:"geometryChanged"`
+	AssociationsInvalidated int64              `bson:"associationsInvalidated" json:"associationsInvalidated"`
+	Errors                  []FeatureSyncError `bson:"errors,omitempty" json:"errors,omitempty"`
+}
+
+// FeatureSyncError records an external feature that could not be refreshed
+type FeatureSyncError struct {
+	Collection string `bson:"collection" json:"collection"`
+	FeatureID  string `bson:"featureId" json:"featureId"`
+	Error      string `bson:"error" json:"error"`
+}
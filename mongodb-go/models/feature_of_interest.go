@@ -54,6 +54,7 @@ type Association struct {
 	EstablishedBy string    `bson:"establishedBy" json:"establishedBy"`
 	ValidFrom    time.Time  `bson:"validFrom" json:"validFrom"`
 	ValidTo      *time.Time `bson:"validTo,omitempty" json:"validTo,omitempty"`
+	ClosedReason string     `bson:"closedReason,omitempty" json:"closedReason,omitempty"`
 }
 
 // CachedMetadata contains cached external feature data
@@ -62,10 +63,14 @@ type CachedMetadata struct {
 	Properties      map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
 	BBox            []float64              `bson:"bbox,omitempty" json:"bbox,omitempty"`
 	UpdateFrequency string                 `bson:"updateFrequency,omitempty" json:"updateFrequency,omitempty"`
+
+	// Stale is set when the metadata is past its expiry and could not be refreshed
+	Stale bool `bson:"-" json:"stale,omitempty"`
 }
 
 // FeatureHierarchy represents hierarchical relationships
 type FeatureHierarchy struct {
+	Level             string             `bson:"level,omitempty" json:"level,omitempty"`
 	Parents           []HierarchyNode    `bson:"parents,omitempty" json:"parents,omitempty"`
 	Children          []HierarchyNode    `bson:"children,omitempty" json:"children,omitempty"`
 	SemanticRelations []SemanticRelation `bson:"semanticRelations,omitempty" json:"semanticRelations,omitempty"`
@@ -78,6 +83,26 @@ type HierarchyNode struct {
 	Name  string `bson:"name" json:"name"`
 }
 
+// FeatureHierarchyEntry is a feature of interest reached by walking a hierarchy
+type FeatureHierarchyEntry struct {
+	FoiID string `bson:"foiId" json:"foiId"`
+	Name  string `bson:"name" json:"name"`
+	Level string `bson:"level,omitempty" json:"level,omitempty"`
+	Depth int    `bson:"depth" json:"depth"`
+}
+
+// HierarchyStats aggregates the observations of a feature of interest and all features below it
+type HierarchyStats struct {
+	FoiID            string    `bson:"foiId" json:"foiId"`
+	Name             string    `bson:"name" json:"name"`
+	Level            string    `bson:"level,omitempty" json:"level,omitempty"`
+	Features         int       `bson:"features" json:"features"`
+	ObservedFeatures int       `bson:"observedFeatures" json:"observedFeatures"`
+	Metrics          MetricSet `bson:"metrics" json:"metrics"`
+	FirstObservation time.Time `bson:"firstObservation,omitempty" json:"firstObservation,omitempty"`
+	LastObservation  time.Time `bson:"lastObservation,omitempty" json:"lastObservation,omitempty"`
+}
+
 // SemanticRelation represents a semantic relationship
 type SemanticRelation struct {
 	Predicate string `bson:"predicate" json:"predicate"`
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HistoricalLocation records where a thing was from a point in time onwards
+type HistoricalLocation struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ThingID    string             `bson:"thingId" json:"thingId" validate:"required"`
+	LocationID string             `bson:"locationId,omitempty" json:"locationId,omitempty"`
+	Time       time.Time          `bson:"time" json:"time" validate:"required"`
+	Location   *GeoJSON           `bson:"location,omitempty" json:"location,omitempty"`
+	Movement   *Movement          `bson:"movement,omitempty" json:"movement,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// Movement describes how a thing came to be at a historical location
+type Movement struct {
+	Reason             string  `bson:"reason,omitempty" json:"reason,omitempty"`
+	MovedBy            string  `bson:"movedBy,omitempty" json:"movedBy,omitempty"`
+	PreviousLocationID string  `bson:"previousLocationId,omitempty" json:"previousLocationId,omitempty"`
+	DistanceMoved      float64 `bson:"distanceMoved" json:"distanceMoved"`
+}
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HolidayDefinition defines a holiday as a rule that yields its date in a given year.
+// The anchor is one of:
+//   - a fixed date: MonthDay
+//   - Easter Sunday: Easter
+//   - the Nth Weekday of Month (negative Nth counts from the end of the month)
+//   - the first Weekday on or after MonthDay
+//
+// Offset then shifts the anchor by a number of days (Good Friday is Easter -2).
+type HolidayDefinition struct {
+	Name     string `json:"name" yaml:"name"`
+	MonthDay string `json:"monthDay,omitempty" yaml:"monthDay,omitempty"` // Format: "MM-DD"
+	Easter   bool   `json:"easter,omitempty" yaml:"easter,omitempty"`
+	Month    int    `json:"month,omitempty" yaml:"month,omitempty"`
+	Weekday  string `json:"weekday,omitempty" yaml:"weekday,omitempty"`
+	Nth      int    `json:"nth,omitempty" yaml:"nth,omitempty"`
+	Offset   int    `json:"offset,omitempty" yaml:"offset,omitempty"`
+
+	// Observed moves a holiday falling on a weekend to the next free weekday
+	Observed bool `json:"observed,omitempty" yaml:"observed,omitempty"`
+
+	// FromYear and ToYear limit the years the holiday exists in (0 = unbounded)
+	FromYear int `json:"fromYear,omitempty" yaml:"fromYear,omitempty"`
+	ToYear   int `json:"toYear,omitempty" yaml:"toYear,omitempty"`
+}
+
+// Validate checks that the definition has exactly one well-formed anchor
+func (h HolidayDefinition) Validate() error {
+	if h.Name == "" {
+		return fmt.Errorf("holiday requires a name")
+	}
+
+	anchors := 0
+	if h.Easter {
+		anchors++
+	}
+	if h.Month != 0 || h.Nth != 0 {
+		anchors++
+		if h.Month < 1 || h.Month > 12 || h.Nth == 0 || h.Nth < -5 || h.Nth > 5 || h.Weekday == "" {
+			return fmt.Errorf("holiday %q: nth-weekday rules need month 1-12, nth ±1-5 and a weekday", h.Name)
+		}
+	}
+	if h.MonthDay != "" {
+		anchors++
+		if _, _, err := parseMonthDay(h.MonthDay); err != nil {
+			return fmt.Errorf("holiday %q: %w", h.Name, err)
+		}
+	}
+	if anchors != 1 {
+		return fmt.Errorf("holiday %q: exactly one of monthDay, easter or month/nth is required", h.Name)
+	}
+
+	if h.Weekday != "" {
+		if h.Easter {
+			return fmt.Errorf("holiday %q: easter rules take no weekday", h.Name)
+		}
+		if _, err := parseWeekday(h.Weekday); err != nil {
+			return fmt.Errorf("holiday %q: %w", h.Name, err)
+		}
+	}
+	if h.FromYear != 0 && h.ToYear != 0 && h.ToYear < h.FromYear {
+		return fmt.Errorf("holiday %q: toYear is before fromYear", h.Name)
+	}
+	return nil
+}
+
+// Date returns the date of the holiday in a year, or false if it does not occur that year
+func (h HolidayDefinition) Date(year int) (time.Time, bool) {
+	if (h.FromYear != 0 && year < h.FromYear) || (h.ToYear != 0 && year > h.ToYear) {
+		return time.Time{}, false
+	}
+
+	var date time.Time
+	switch {
+	case h.Easter:
+		date = EasterSunday(year)
+	case h.Nth != 0:
+		weekday, err := parseWeekday(h.Weekday)
+		if err != nil {
+			return time.Time{}, false
+		}
+		date = nthWeekday(year, time.Month(h.Month), weekday, h.Nth)
+	default:
+		month, day, err := parseMonthDay(h.MonthDay)
+		if err != nil {
+			return time.Time{}, false
+		}
+		date = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		if h.Weekday != "" {
+			weekday, err := parseWeekday(h.Weekday)
+			if err != nil {
+				return time.Time{}, false
+			}
+			date = date.AddDate(0, 0, (int(weekday)-int(date.Weekday())+7)%7)
+		}
+	}
+
+	return date.AddDate(0, 0, h.Offset), true
+}
+
+// HolidayCalendar is a named set of holiday rules. A calendar loaded from a
+// rules file may extend a preset, replacing preset holidays of the same name.
+type HolidayCalendar struct {
+	Name     string              `json:"name" yaml:"name"`
+	Extends  string              `json:"extends,omitempty" yaml:"extends,omitempty"`
+	Holidays []HolidayDefinition `json:"holidays" yaml:"holidays"`
+}
+
+// Validate checks every holiday definition of the calendar
+func (c *HolidayCalendar) Validate() error {
+	for _, h := range c.Holidays {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("calendar %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// HolidaysInYear returns the holidays of a year keyed by "MM-DD"
+func (c *HolidayCalendar) HolidaysInYear(year int) map[string]string {
+	type occurrence struct {
+		date time.Time
+		def  HolidayDefinition
+	}
+
+	var occurrences []occurrence
+	for _, h := range c.Holidays {
+		if date, ok := h.Date(year); ok && date.Year() == year {
+			occurrences = append(occurrences, occurrence{date, h})
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].date.Before(occurrences[j].date)
+	})
+
+	holidays := map[string]string{}
+	add := func(date time.Time, name string) {
+		key := date.Format("01-02")
+		if existing, ok := holidays[key]; ok {
+			name = existing + ", " + name
+		}
+		holidays[key] = name
+	}
+
+	for _, o := range occurrences {
+		add(o.date, o.def.Name)
+	}
+
+	// Observed days go to the first weekday that is not already a holiday
+	for _, o := range occurrences {
+		if !o.def.Observed || !isWeekend(o.date) {
+			continue
+		}
+		observed := o.date
+		for isWeekend(observed) || holidays[observed.Format("01-02")] != "" {
+			observed = observed.AddDate(0, 0, 1)
+		}
+		if observed.Year() == year {
+			add(observed, o.def.Name+" (observed)")
+		}
+	}
+
+	return holidays
+}
+
+// Holiday returns the name of the holiday on a date, if any
+func (c *HolidayCalendar) Holiday(date time.Time) (string, bool) {
+	name, ok := c.HolidaysInYear(date.Year())[date.Format("01-02")]
+	return name, ok
+}
+
+// IsBusinessDay reports whether a date is a weekday and not a holiday
+func (c *HolidayCalendar) IsBusinessDay(date time.Time) bool {
+	if isWeekend(date) {
+		return false
+	}
+	_, holiday := c.Holiday(date)
+	return !holiday
+}
+
+// HolidayCalendarPresets holds the built-in country calendars keyed by ISO country code
+var HolidayCalendarPresets = map[string]HolidayCalendar{
+	"FI": {
+		Name: "FI",
+		Holidays: []HolidayDefinition{
+			{Name: "New Year's Day", MonthDay: "01-01"},
+			{Name: "Epiphany", MonthDay: "01-06"},
+			{Name: "Good Friday", Easter: true, Offset: -2},
+			{Name: "Easter Sunday", Easter: true},
+			{Name: "Easter Monday", Easter: true, Offset: 1},
+			{Name: "May Day", MonthDay: "05-01"},
+			{Name: "Ascension Day", Easter: true, Offset: 39},
+			{Name: "Whitsunday", Easter: true, Offset: 49},
+			{Name: "Midsummer Eve", MonthDay: "06-19", Weekday: "Friday"},
+			{Name: "Midsummer Day", MonthDay: "06-20", Weekday: "Saturday"},
+			{Name: "All Saints' Day", MonthDay: "10-31", Weekday: "Saturday"},
+			{Name: "Independence Day", MonthDay: "12-06"},
+			{Name: "Christmas Eve", MonthDay: "12-24"},
+			{Name: "Christmas Day", MonthDay: "12-25"},
+			{Name: "St. Stephen's Day", MonthDay: "12-26"},
+		},
+	},
+	"CA": {
+		Name: "CA",
+		Holidays: []HolidayDefinition{
+			{Name: "New Year's Day", MonthDay: "01-01", Observed: true},
+			{Name: "Good Friday", Easter: true, Offset: -2},
+			{Name: "Victoria Day", MonthDay: "05-18", Weekday: "Monday"},
+			{Name: "Canada Day", MonthDay: "07-01", Observed: true},
+			{Name: "Labour Day", Month: 9, Weekday: "Monday", Nth: 1},
+			{Name: "National Day for Truth and Reconciliation", MonthDay: "09-30", Observed: true, FromYear: 2021},
+			{Name: "Thanksgiving", Month: 10, Weekday: "Monday", Nth: 2},
+			{Name: "Remembrance Day", MonthDay: "11-11", Observed: true},
+			{Name: "Christmas Day", MonthDay: "12-25", Observed: true},
+			{Name: "Boxing Day", MonthDay: "12-26", Observed: true},
+		},
+	},
+}
+
+// HolidayCalendarPreset returns a copy of a built-in calendar by country code
+func HolidayCalendarPreset(code string) (*HolidayCalendar, error) {
+	preset, ok := HolidayCalendarPresets[strings.ToUpper(code)]
+	if !ok {
+		return nil, fmt.Errorf("unknown holiday calendar %q", code)
+	}
+	preset.Holidays = append([]HolidayDefinition(nil), preset.Holidays...)
+	return &preset, nil
+}
+
+// EasterSunday returns the date of Western Easter using the anonymous Gregorian algorithm
+func EasterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// nthWeekday returns the nth weekday of a month; negative n counts from the end
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n > 0 {
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		return first.AddDate(0, 0, (int(weekday)-int(first.Weekday())+7)%7+(n-1)*7)
+	}
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+	return last.AddDate(0, 0, -((int(last.Weekday())-int(weekday)+7)%7)+(n+1)*7)
+}
+
+// parseMonthDay parses an "MM-DD" string
+func parseMonthDay(s string) (time.Month, int, error) {
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid monthDay %q, expected MM-DD", s)
+	}
+	return t.Month(), t.Day(), nil
+}
+
+// parseWeekday parses an English weekday name
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// isWeekend reports whether a date falls on Saturday or Sunday
+func isWeekend(date time.Time) bool {
+	return date.Weekday() == time.Saturday || date.Weekday() == time.Sunday
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// Location is a geographical position of one or more things
+type Location struct {
+	ID           string                 `bson:"_id" json:"id" validate:"required"`
+	Name         string                 `bson:"name" json:"name" validate:"required"`
+	Description  string                 `bson:"description,omitempty" json:"description,omitempty"`
+	EncodingType string                 `bson:"encodingType" json:"encodingType" validate:"required"`
+	Location     *GeoJSON               `bson:"location" json:"location" validate:"required"`
+	Hierarchy    *LocationHierarchy     `bson:"hierarchy,omitempty" json:"hierarchy,omitempty"`
+	Address      *Address               `bson:"address,omitempty" json:"address,omitempty"`
+	Things       []string               `bson:"things,omitempty" json:"things,omitempty"`
+	Properties   map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+	CreatedAt    time.Time              `bson:"created_at" json:"createdAt"`
+	UpdatedAt    time.Time              `bson:"updated_at" json:"updatedAt"`
+}
+
+// LocationHierarchy places a location within a site
+type LocationHierarchy struct {
+	Site     string `bson:"site,omitempty" json:"site,omitempty"`
+	Building string `bson:"building,omitempty" json:"building,omitempty"`
+	Floor    string `bson:"floor,omitempty" json:"floor,omitempty"`
+	Zone     string `bson:"zone,omitempty" json:"zone,omitempty"`
+	Room     string `bson:"room,omitempty" json:"room,omitempty"`
+}
+
+// Address is the postal address of a location
+type Address struct {
+	Street     string `bson:"street,omitempty" json:"street,omitempty"`
+	City       string `bson:"city,omitempty" json:"city,omitempty"`
+	State      string `bson:"state,omitempty" json:"state,omitempty"`
+	Country    string `bson:"country,omitempty" json:"country,omitempty"`
+	PostalCode string `bson:"postalCode,omitempty" json:"postalCode,omitempty"`
+}
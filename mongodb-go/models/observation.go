@@ -53,6 +53,8 @@ type GeoJSON struct {
 // ObservationStats contains aggregated statistics
 type ObservationStats struct {
 	DatastreamID   string    `bson:"_id" json:"datastreamId"`
+	Date           string    `bson:"date,omitempty" json:"date,omitempty"`
+	Hour           int       `bson:"hour" json:"hour"`
 	Count          int64     `bson:"count" json:"count"`
 	Average        float64   `bson:"average" json:"average"`
 	Min            float64   `bson:"min" json:"min"`
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ObservationFeedEntry records a stored observation for the real-time feed.
+// Time series collections have no change streams, so the feed follows these
+// entries instead.
+type ObservationFeedEntry struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Observation Observation        `bson:"observation" json:"observation"`
+	// BBox bounds the location of the observation, or of its feature of interest
+	BBox     []float64 `bson:"bbox,omitempty" json:"bbox,omitempty"`
+	StoredAt time.Time `bson:"storedAt" json:"storedAt"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// ObservedProperty describes the phenomenon a datastream measures
+type ObservedProperty struct {
+	ID              string                 `bson:"_id" json:"id" validate:"required"`
+	Name            string                 `bson:"name" json:"name" validate:"required"`
+	Definition      string                 `bson:"definition" json:"definition" validate:"required"`
+	Description     string                 `bson:"description,omitempty" json:"description,omitempty"`
+	Category        string                 `bson:"category,omitempty" json:"category,omitempty"`
+	Subcategory     string                 `bson:"subcategory,omitempty" json:"subcategory,omitempty"`
+	ValidUnits      []UnitOfMeasure        `bson:"validUnits,omitempty" json:"validUnits,omitempty"`
+	TypicalRange    *ValueRange            `bson:"typicalRange,omitempty" json:"typicalRange,omitempty"`
+	AlertThresholds map[string]float64     `bson:"alertThresholds,omitempty" json:"alertThresholds,omitempty"`
+	Properties      map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+	CreatedAt       time.Time              `bson:"created_at" json:"createdAt"`
+	UpdatedAt       time.Time              `bson:"updated_at" json:"updatedAt"`
+}
+
+// ValueRange is the expected range of values in a unit
+type ValueRange struct {
+	Min  float64 `bson:"min" json:"min"`
+	Max  float64 `bson:"max" json:"max"`
+	Unit string  `bson:"unit,omitempty" json:"unit,omitempty"`
+}
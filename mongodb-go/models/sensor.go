@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Sensor describes the instrument that produces a datastream
+type Sensor struct {
+	ID              string                 `bson:"_id" json:"id" validate:"required"`
+	Name            string                 `bson:"name" json:"name" validate:"required"`
+	Description     string                 `bson:"description,omitempty" json:"description,omitempty"`
+	EncodingType    string                 `bson:"encodingType" json:"encodingType" validate:"required"`
+	Metadata        interface{}            `bson:"metadata" json:"metadata" validate:"required"`
+	Properties      map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+	DatastreamCount int                    `bson:"datastreamCount" json:"datastreamCount"`
+	LastCalibration *time.Time             `bson:"lastCalibration,omitempty" json:"lastCalibration,omitempty"`
+	NextCalibration *time.Time             `bson:"nextCalibration,omitempty" json:"nextCalibration,omitempty"`
+	CreatedAt       time.Time              `bson:"created_at" json:"createdAt"`
+	UpdatedAt       time.Time              `bson:"updated_at" json:"updatedAt"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// Thing represents a physical or virtual IoT device
+type Thing struct {
+	ID                string                 `bson:"_id" json:"id" validate:"required"`
+	Name              string                 `bson:"name" json:"name" validate:"required"`
+	Description       string                 `bson:"description,omitempty" json:"description,omitempty"`
+	CurrentLocation   *ThingLocation         `bson:"currentLocation,omitempty" json:"currentLocation,omitempty"`
+	Properties        map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+	DatastreamCount   int                    `bson:"datastreamCount" json:"datastreamCount"`
+	ActiveDatastreams []string               `bson:"activeDatastreams,omitempty" json:"activeDatastreams,omitempty"`
+	CreatedAt         time.Time              `bson:"created_at" json:"createdAt"`
+	UpdatedAt         time.Time              `bson:"updated_at" json:"updatedAt"`
+}
+
+// ThingLocation is the current location denormalized into a thing.
+// LocationID is empty for ad-hoc positions reported by mobile things.
+type ThingLocation struct {
+	LocationID   string   `bson:"locationId,omitempty" json:"locationId,omitempty"`
+	Name         string   `bson:"name,omitempty" json:"name,omitempty"`
+	EncodingType string   `bson:"encodingType,omitempty" json:"encodingType,omitempty"`
+	Location     *GeoJSON `bson:"location,omitempty" json:"location,omitempty"`
+}
@@ -2,10 +2,12 @@ package models
 
 import (
 	"time"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // UnitOfMeasurement represents a UCUM unit with ontology support
 type UnitOfMeasurement struct {
+	ID                     primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
 	URI                    string              `bson:"uri" json:"uri" validate:"required"`
 	UCUMCode               string              `bson:"ucumCode" json:"ucumCode" validate:"required"`
 	UCUMCodeCaseSensitive  string              `bson:"ucumCodeCaseSensitive,omitempty" json:"ucumCodeCaseSensitive,omitempty"`
@@ -54,9 +56,12 @@ type UnitConversion struct {
 	IsMetric   bool              `bson:"isMetric" json:"isMetric"`
 }
 
-// BaseUnitConversion defines conversion to base unit
+// BaseUnitConversion defines conversion to base unit: multiply and divide scale
+// the value by Factor, add and subtract shift it by Factor. Offset is added
+// afterwards, so affine units such as degree Fahrenheit need a single entry.
 type BaseUnitConversion struct {
 	Factor       float64 `bson:"factor" json:"factor"`
+	Offset       float64 `bson:"offset,omitempty" json:"offset,omitempty"`
 	BaseUnitURI  string  `bson:"baseUnitUri" json:"baseUnitUri"`
 	BaseUnitCode string  `bson:"baseUnitCode" json:"baseUnitCode"`
 	Operation    string  `bson:"operation" json:"operation" validate:"oneof=multiply divide add subtract"`
@@ -97,3 +102,32 @@ type UsageStatistics struct {
 	LastUsed         time.Time `bson:"lastUsed,omitempty" json:"lastUsed,omitempty"`
 	FrequencyScore   float64   `bson:"frequencyScore,omitempty" json:"frequencyScore,omitempty" validate:"min=0,max=1"`
 }
+
+// UnitHierarchyCache materializes the tree of units below one root unit
+type UnitHierarchyCache struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	RootURI     string              `bson:"rootUri" json:"rootUri"`
+	Dimension   string              `bson:"dimension,omitempty" json:"dimension,omitempty"`
+	Tree        UnitTreeNode        `bson:"tree" json:"tree"`
+	AllNodes    []UnitHierarchyNode `bson:"allNodes" json:"allNodes"`
+	LastRebuilt time.Time           `bson:"lastRebuilt" json:"lastRebuilt"`
+}
+
+// UnitTreeNode is a unit and its narrower units
+type UnitTreeNode struct {
+	URI      string         `bson:"uri" json:"uri"`
+	Code     string         `bson:"code" json:"code"`
+	Label    string         `bson:"label,omitempty" json:"label,omitempty"`
+	Path     string         `bson:"path" json:"path"`
+	Depth    int            `bson:"depth" json:"depth"`
+	Children []UnitTreeNode `bson:"children,omitempty" json:"children,omitempty"`
+}
+
+// UnitHierarchyNode is a flattened tree node with the URIs of its ancestors, root first
+type UnitHierarchyNode struct {
+	URI       string   `bson:"uri" json:"uri"`
+	Code      string   `bson:"code" json:"code"`
+	Path      string   `bson:"path" json:"path"`
+	Depth     int      `bson:"depth" json:"depth"`
+	Ancestors []string `bson:"ancestors" json:"ancestors"`
+}
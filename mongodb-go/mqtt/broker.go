@@ -0,0 +1,490 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrBrokerClosed is returned by Serve once the broker has been closed
+var ErrBrokerClosed = errors.New("mqtt: broker closed")
+
+const (
+	// maxPacketSize limits the size of a packet a client may send
+	maxPacketSize = 16 << 20
+	// connectTimeout is how long a new connection may take to send CONNECT
+	connectTimeout = 10 * time.Second
+	// writeTimeout is how long a write to a client may block
+	writeTimeout = 30 * time.Second
+	// outboundQueue is how many messages may wait for a slow subscriber
+	// before further messages to it are dropped
+	outboundQueue = 256
+)
+
+// Handler processes a message published by a client. MQTT 3.1.1 cannot
+// report errors to the publisher, so they are only logged.
+type Handler func(ctx context.Context, topic string, payload []byte) error
+
+// Authenticator decides from the user name and password of a CONNECT packet
+// whether a client may connect. Either may be empty when the client sent none.
+type Authenticator func(username string, password []byte) bool
+
+// Broker accepts MQTT 3.1.1 clients. Messages clients publish are passed to
+// the handler and not relayed; subscribers receive only what is sent with
+// Publish. Delivery is at most once and sessions are not persisted.
+type Broker struct {
+	handler      Handler
+	authenticate Authenticator
+	logger       *logrus.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	conns  sync.WaitGroup
+	nextID atomic.Int64
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	clients   map[string]*client
+	closed    bool
+}
+
+// NewBroker creates a broker that passes published messages to handler and
+// admits the clients authenticate accepts, or every client if it is nil
+func NewBroker(handler Handler, authenticate Authenticator, logger *logrus.Logger) *Broker {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Broker{
+		handler:      handler,
+		authenticate: authenticate,
+		logger:       logger,
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: map[net.Listener]bool{},
+		clients:   map[string]*client{},
+	}
+}
+
+// ListenAndServe accepts clients on a TCP address until the broker is closed
+func (b *Broker) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	b.logger.Infof("MQTT broker listening on %s", listener.Addr())
+	return b.Serve(listener)
+}
+
+// Serve accepts clients on a listener until the broker is closed
+func (b *Broker) Serve(listener net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		listener.Close()
+		return ErrBrokerClosed
+	}
+	b.listeners[listener] = true
+	b.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return ErrBrokerClosed
+			}
+			return fmt.Errorf("failed to accept MQTT connection: %w", err)
+		}
+		b.conns.Add(1)
+		go func() {
+			defer b.conns.Done()
+			b.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting clients, disconnects the connected ones and waits
+// for messages being handled
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.cancel()
+	for listener := range b.listeners {
+		listener.Close()
+	}
+	for _, c := range b.clients {
+		c.close()
+	}
+	b.mu.Unlock()
+
+	b.conns.Wait()
+	return nil
+}
+
+// Publish sends a message to every client subscribed to a matching topic
+// filter. Messages to clients that fall behind are dropped.
+func (b *Broker) Publish(topic string, payload []byte) {
+	var packet []byte
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.clients {
+		if !c.subscribed(topic) {
+			continue
+		}
+		if packet == nil {
+			packet = encodePublish(topic, payload)
+		}
+		if !c.offer(packet) {
+			b.logger.WithFields(logrus.Fields{
+				"client": c.id,
+				"topic":  topic,
+			}).Warn("Dropped MQTT message to a slow subscriber")
+		}
+	}
+}
+
+// client is a connected MQTT client
+type client struct {
+	id        string
+	conn      net.Conn
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	subscriptions map[string]bool
+	// received holds the QoS 2 packet identifiers awaiting PUBREL
+	received map[uint16]bool
+}
+
+// subscribed reports whether a topic matches any filter of the client
+func (c *client) subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for filter := range c.subscriptions {
+		if matchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// offer queues a packet without blocking, reporting whether there was room
+func (c *client) offer(packet []byte) bool {
+	select {
+	case c.out <- packet:
+		return true
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queues a packet, waiting for room unless the client disconnects
+func (c *client) send(packet []byte) {
+	select {
+	case c.out <- packet:
+	case <-c.done:
+	}
+}
+
+// close disconnects the client
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writeLoop writes queued packets to the connection
+func (c *client) writeLoop() {
+	for {
+		select {
+		case packet := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := c.conn.Write(packet); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// serveConn runs the session of one connection
+func (b *Broker) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := readPacket(reader, maxPacketSize)
+	if err != nil || p.kind != packetConnect {
+		return
+	}
+	c, keepAlive, code, err := b.connect(conn, p)
+	if err != nil {
+		b.logger.WithError(err).Debug("Refused MQTT connection")
+		return
+	}
+	if code != connectAccepted {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		conn.Write(encodePacket(packetConnack, 0, []byte{0, code}))
+		return
+	}
+	if !b.register(c) {
+		return
+	}
+	defer b.unregister(c)
+	go c.writeLoop()
+	c.send(encodePacket(packetConnack, 0, []byte{0, connectAccepted}))
+
+	logger := b.logger.WithField("client", c.id)
+	logger.Debug("MQTT client connected")
+	for {
+		// Clients must send something within one and a half keep alive periods
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(reader, maxPacketSize)
+		if err != nil {
+			if errors.Is(err, errMalformed) {
+				logger.WithError(err).Warn("Disconnecting MQTT client")
+			}
+			return
+		}
+		if p.kind == packetDisconnect {
+			logger.Debug("MQTT client disconnected")
+			return
+		}
+		if err := b.handlePacket(c, p); err != nil {
+			logger.WithError(err).Warn("Disconnecting MQTT client")
+			return
+		}
+	}
+}
+
+// connect reads a CONNECT packet into a client, returning the keep alive
+// period and the CONNACK return code. The user name and password are passed
+// to the broker's Authenticator; a rejected client is refused with 5 (not
+// authorized) if it sent no credentials and 4 (bad user name or password)
+// otherwise. Wills are accepted but not used.
+func (b *Broker) connect(conn net.Conn, p *packet) (*client, time.Duration, byte, error) {
+	d := &decoder{data: p.body}
+	protocol := d.string()
+	level := d.byte()
+	flags := d.byte()
+	keepAlive := time.Duration(d.uint16()) * time.Second
+	id := d.string()
+	if flags&0x04 != 0 {
+		d.string()
+		d.bytes()
+	}
+	var username string
+	var password []byte
+	if flags&0x80 != 0 {
+		username = d.string()
+	}
+	if flags&0x40 != 0 {
+		password = d.bytes()
+	}
+	// A password may only be sent along with a user name
+	if d.err != nil || flags&0x01 != 0 || flags&0xc0 == 0x40 {
+		return nil, 0, 0, fmt.Errorf("%w: CONNECT", errMalformed)
+	}
+	if !(protocol == "MQTT" && level == protocolLevel311) && !(protocol == "MQIsdp" && level == protocolLevel31) {
+		return nil, 0, connectBadProtocolVersion, nil
+	}
+	if b.authenticate != nil && !b.authenticate(username, password) {
+		if flags&0xc0 == 0 {
+			return nil, 0, connectNotAuthorized, nil
+		}
+		return nil, 0, connectBadCredentials, nil
+	}
+
+	if id == "" {
+		// Only clients that keep no session may let the broker pick an identifier
+		if flags&0x02 == 0 {
+			return nil, 0, connectIdentifierRejected, nil
+		}
+		id = fmt.Sprintf("auto-%d", b.nextID.Add(1))
+	}
+	return &client{
+		id:            id,
+		conn:          conn,
+		out:           make(chan []byte, outboundQueue),
+		done:          make(chan struct{}),
+		subscriptions: map[string]bool{},
+		received:      map[uint16]bool{},
+	}, keepAlive, connectAccepted, nil
+}
+
+// register adds a connected client, disconnecting an earlier client with the same identifier
+func (b *Broker) register(c *client) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	if previous, ok := b.clients[c.id]; ok {
+		previous.close()
+	}
+	b.clients[c.id] = c
+	return true
+}
+
+// unregister removes a disconnected client
+func (b *Broker) unregister(c *client) {
+	b.mu.Lock()
+	if b.clients[c.id] == c {
+		delete(b.clients, c.id)
+	}
+	b.mu.Unlock()
+	c.close()
+}
+
+// handlePacket processes a packet of an established session. An error is a
+// protocol violation that ends the session.
+func (b *Broker) handlePacket(c *client, p *packet) error {
+	switch p.kind {
+	case packetPublish:
+		return b.receivePublish(c, p)
+	case packetPubrel:
+		d := &decoder{data: p.body}
+		id := d.uint16()
+		if d.err != nil || p.flags != 0x02 {
+			return fmt.Errorf("%w: PUBREL", errMalformed)
+		}
+		c.mu.Lock()
+		delete(c.received, id)
+		c.mu.Unlock()
+		c.send(encodeAck(packetPubcomp, 0, id))
+	case packetSubscribe:
+		return b.subscribe(c, p)
+	case packetUnsubscribe:
+		return b.unsubscribe(c, p)
+	case packetPingreq:
+		c.send(encodePacket(packetPingresp, 0, nil))
+	case packetPuback, packetPubrec, packetPubcomp:
+		// The broker delivers at most once, so there is nothing to acknowledge
+	default:
+		return fmt.Errorf("%w: unexpected packet type %d", errMalformed, p.kind)
+	}
+	return nil
+}
+
+// receivePublish passes a published message to the handler and acknowledges
+// it as its QoS level requires. A QoS 2 message is handled once even if the
+// client sends it again before releasing it.
+func (b *Broker) receivePublish(c *client, p *packet) error {
+	qos := (p.flags >> 1) & 0x03
+	d := &decoder{data: p.body}
+	topic := d.string()
+	var id uint16
+	if qos > 0 {
+		id = d.uint16()
+	}
+	if d.err != nil || qos > 2 {
+		return fmt.Errorf("%w: PUBLISH", errMalformed)
+	}
+	if !validTopicName(topic) {
+		return fmt.Errorf("%w: invalid topic name %q", errMalformed, topic)
+	}
+
+	if qos == 2 {
+		c.mu.Lock()
+		duplicate := c.received[id]
+		c.received[id] = true
+		c.mu.Unlock()
+		if duplicate {
+			c.send(encodeAck(packetPubrec, 0, id))
+			return nil
+		}
+	}
+
+	if b.handler != nil {
+		if err := b.handler(b.ctx, topic, d.data); err != nil {
+			b.logger.WithFields(logrus.Fields{
+				"client": c.id,
+				"topic":  topic,
+			}).WithError(err).Warn("Rejected MQTT message")
+		}
+	}
+
+	switch qos {
+	case 1:
+		c.send(encodeAck(packetPuback, 0, id))
+	case 2:
+		c.send(encodeAck(packetPubrec, 0, id))
+	}
+	return nil
+}
+
+// subscribe adds the topic filters of a SUBSCRIBE packet. Every subscription
+// is granted QoS 0; malformed filters are refused.
+func (b *Broker) subscribe(c *client, p *packet) error {
+	d := &decoder{data: p.body}
+	id := d.uint16()
+	var codes []byte
+	c.mu.Lock()
+	for d.err == nil && len(d.data) > 0 {
+		filter := d.string()
+		qos := d.byte()
+		if qos > 2 {
+			d.err = errMalformed
+		}
+		if d.err != nil {
+			break
+		}
+		if !validTopicFilter(filter) {
+			codes = append(codes, subscribeFailure)
+			continue
+		}
+		c.subscriptions[filter] = true
+		codes = append(codes, 0)
+	}
+	c.mu.Unlock()
+	if d.err != nil || p.flags != 0x02 || len(codes) == 0 {
+		return fmt.Errorf("%w: SUBSCRIBE", errMalformed)
+	}
+
+	body := append(binary.BigEndian.AppendUint16(nil, id), codes...)
+	c.send(encodePacket(packetSuback, 0, body))
+	return nil
+}
+
+// unsubscribe removes the topic filters of an UNSUBSCRIBE packet
+func (b *Broker) unsubscribe(c *client, p *packet) error {
+	d := &decoder{data: p.body}
+	id := d.uint16()
+	var filters []string
+	for d.err == nil && len(d.data) > 0 {
+		filters = append(filters, d.string())
+	}
+	if d.err != nil || p.flags != 0x02 || len(filters) == 0 {
+		return fmt.Errorf("%w: UNSUBSCRIBE", errMalformed)
+	}
+
+	c.mu.Lock()
+	for _, filter := range filters {
+		delete(c.subscriptions, filter)
+	}
+	c.mu.Unlock()
+	c.send(encodeAck(packetUnsuback, 0, id))
+	return nil
+}
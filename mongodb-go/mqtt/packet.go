@@ -0,0 +1,222 @@
+// Package mqtt is a small embedded MQTT 3.1.1 broker. It relays the messages
+// the application publishes to subscribed clients and hands the messages
+// clients publish to the application, which decides what to relay.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Control packet types
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	connectAccepted           = 0
+	connectBadProtocolVersion = 1
+	connectIdentifierRejected = 2
+	connectBadCredentials     = 4
+	connectNotAuthorized      = 5
+)
+
+// Protocol levels of MQTT 3.1 and 3.1.1
+const (
+	protocolLevel31  = 3
+	protocolLevel311 = 4
+)
+
+// subscribeFailure is the SUBACK return code of a rejected subscription
+const subscribeFailure = 0x80
+
+// maxRemainingLengthBytes is the longest encoding of a packet length
+const maxRemainingLengthBytes = 4
+
+// errMalformed is returned for packets that violate the protocol
+var errMalformed = errors.New("malformed packet")
+
+// packet is a control packet as read from the wire
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one control packet, rejecting packets larger than maxSize
+func readPacket(r *bufio.Reader, maxSize int) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length := 0
+	for i, multiplier := 0, 1; ; i, multiplier = i+1, multiplier*128 {
+		if i == maxRemainingLengthBytes {
+			return nil, fmt.Errorf("%w: remaining length too long", errMalformed)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxSize {
+		return nil, fmt.Errorf("%w: packet of %d bytes exceeds the limit of %d", errMalformed, length, maxSize)
+	}
+
+	p := &packet{kind: header >> 4, flags: header & 0x0f, body: make([]byte, length)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// encodePacket frames a packet body with its fixed header
+func encodePacket(kind, flags byte, body []byte) []byte {
+	out := make([]byte, 0, len(body)+5)
+	out = append(out, kind<<4|flags)
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// decoder reads the fields of a packet body in order
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// byte reads a single byte
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+// uint16 reads a big-endian two byte integer
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.data) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.data)
+	d.data = d.data[2:]
+	return v
+}
+
+// bytes reads a length-prefixed byte string
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.data) < n {
+		d.err = errMalformed
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+// string reads a length-prefixed UTF-8 string
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(out []byte, s string) []byte {
+	out = binary.BigEndian.AppendUint16(out, uint16(len(s)))
+	return append(out, s...)
+}
+
+// encodePublish builds a QoS 0 PUBLISH packet
+func encodePublish(topic string, payload []byte) []byte {
+	body := make([]byte, 0, 2+len(topic)+len(payload))
+	body = appendString(body, topic)
+	return encodePacket(packetPublish, 0, append(body, payload...))
+}
+
+// encodeAck builds an acknowledgement carrying only a packet identifier
+func encodeAck(kind, flags byte, id uint16) []byte {
+	return encodePacket(kind, flags, binary.BigEndian.AppendUint16(nil, id))
+}
+
+// validTopicName reports whether a topic may be published to: it is not
+// empty and contains no wildcards
+func validTopicName(topic string) bool {
+	return topic != "" && len(topic) <= 0xffff && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// validTopicFilter reports whether a subscription filter is well formed:
+// + stands for a whole level and # only for the last one
+func validTopicFilter(filter string) bool {
+	if filter == "" || len(filter) > 0xffff || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTopic reports whether a topic matches a subscription filter. Topics
+// starting with $ are not matched by a leading wildcard.
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
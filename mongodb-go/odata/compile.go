@@ -0,0 +1,528 @@
+package odata
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// earthRadiusMeters converts geo.distance limits to $centerSphere radians
+const earthRadiusMeters = 6378100.0
+
+// comparisonOperators maps OData comparison keywords to MongoDB operators
+var comparisonOperators = map[string]string{
+	"eq": "$eq", "ne": "$ne", "gt": "$gt", "ge": "$gte", "lt": "$lt", "le": "$lte",
+}
+
+// flippedComparisons gives the operator to use when operands are swapped
+var flippedComparisons = map[string]string{
+	"eq": "eq", "ne": "ne", "gt": "lt", "ge": "le", "lt": "gt", "le": "ge",
+}
+
+// arithmeticOperators maps OData arithmetic keywords to aggregation operators
+var arithmeticOperators = map[string]string{
+	"add": "$add", "sub": "$subtract", "mul": "$multiply", "div": "$divide", "mod": "$mod",
+}
+
+// functionArity lists supported functions with their minimum and maximum argument counts
+var functionArity = map[string][2]int{
+	"substringof": {2, 2}, "contains": {2, 2}, "startswith": {2, 2}, "endswith": {2, 2},
+	"length": {1, 1}, "indexof": {2, 2}, "substring": {2, 3}, "tolower": {1, 1},
+	"toupper": {1, 1}, "trim": {1, 1}, "concat": {2, 2},
+	"year": {1, 1}, "month": {1, 1}, "day": {1, 1}, "hour": {1, 1}, "minute": {1, 1},
+	"second": {1, 1}, "fractionalseconds": {1, 1}, "date": {1, 1}, "time": {1, 1},
+	"totaloffsetminutes": {1, 1}, "now": {0, 0}, "mindatetime": {0, 0}, "maxdatetime": {0, 0},
+	"round": {1, 1}, "floor": {1, 1}, "ceiling": {1, 1},
+	"geo.intersects": {2, 2}, "st_intersects": {2, 2}, "st_within": {2, 2},
+	"st_disjoint": {2, 2}, "geo.distance": {2, 2},
+}
+
+// CompileFilter parses a $filter expression and compiles it into a MongoDB query
+func CompileFilter(input string, fields FieldMap) (bson.M, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(expr, fields)
+}
+
+// Compile translates a parsed filter into a MongoDB query document.
+// Simple property/literal comparisons become plain query operators so that
+// indexes apply; everything else falls back to an $expr aggregation expression.
+func Compile(expr Expr, fields FieldMap) (bson.M, error) {
+	c := &compiler{fields: fields}
+	return c.filter(expr)
+}
+
+// compiler holds the field mapping used while compiling
+type compiler struct {
+	fields FieldMap
+}
+
+// filter compiles a boolean expression into a query document
+func (c *compiler) filter(expr Expr) (bson.M, error) {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		switch e.Op {
+		case "and", "or":
+			return c.logical(e)
+		}
+		if _, ok := comparisonOperators[e.Op]; ok {
+			return c.comparison(e)
+		}
+		return nil, fmt.Errorf("%s is not a boolean expression", e)
+	case *UnaryExpr:
+		if e.Op != "not" {
+			return nil, fmt.Errorf("%s is not a boolean expression", e)
+		}
+		inner, err := c.filter(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$nor": bson.A{inner}}, nil
+	case *Call:
+		return c.booleanCall(e)
+	case *Property:
+		field, err := c.fields.Resolve(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{field.Path: true}, nil
+	case *Literal:
+		if b, ok := e.Value.(bool); ok {
+			if b {
+				return bson.M{}, nil
+			}
+			return bson.M{"$expr": false}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a boolean expression", expr)
+}
+
+// logical compiles and/or, flattening chains of the same operator
+func (c *compiler) logical(e *BinaryExpr) (bson.M, error) {
+	op := "$" + e.Op
+	var clauses bson.A
+
+	for _, side := range []Expr{e.Left, e.Right} {
+		compiled, err := c.filter(side)
+		if err != nil {
+			return nil, err
+		}
+		if nested, ok := compiled[op].(bson.A); ok && len(compiled) == 1 {
+			clauses = append(clauses, nested...)
+		} else {
+			clauses = append(clauses, compiled)
+		}
+	}
+	return bson.M{op: clauses}, nil
+}
+
+// comparison compiles eq, ne, gt, ge, lt and le
+func (c *compiler) comparison(e *BinaryExpr) (bson.M, error) {
+	op, left, right := e.Op, e.Left, e.Right
+
+	// Normalize literal op property into property op literal
+	if _, ok := left.(*Literal); ok {
+		if _, ok := right.(*Property); ok {
+			op, left, right = flippedComparisons[op], right, left
+		}
+	}
+
+	if prop, ok := left.(*Property); ok {
+		if lit, ok := right.(*Literal); ok {
+			return c.propertyComparison(op, prop, lit)
+		}
+	}
+
+	// boolfunc(...) eq true / ne false
+	if call, ok := left.(*Call); ok && (op == "eq" || op == "ne") {
+		if lit, ok := right.(*Literal); ok {
+			if b, ok := lit.Value.(bool); ok && isBooleanFunction(call.Name) {
+				inner, err := c.booleanCall(call)
+				if err != nil {
+					return nil, err
+				}
+				if b == (op == "eq") {
+					return inner, nil
+				}
+				return bson.M{"$nor": bson.A{inner}}, nil
+			}
+		}
+	}
+
+	// geo.distance(property, geometry) lt|le|gt|ge meters
+	if call, ok := left.(*Call); ok && call.Name == "geo.distance" {
+		return c.distanceComparison(op, call, right)
+	}
+
+	l, err := c.expression(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.expression(right)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$expr": bson.M{comparisonOperators[op]: bson.A{l, r}}}, nil
+}
+
+// propertyComparison compiles a direct field comparison
+func (c *compiler) propertyComparison(op string, prop *Property, lit *Literal) (bson.M, error) {
+	field, err := c.fields.Resolve(prop.Path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := convertLiteral(field, lit.Value)
+	if err != nil {
+		return nil, err
+	}
+	if op == "eq" {
+		return bson.M{field.Path: value}, nil
+	}
+	return bson.M{field.Path: bson.M{comparisonOperators[op]: value}}, nil
+}
+
+// distanceComparison compiles geo.distance into a $centerSphere query
+func (c *compiler) distanceComparison(op string, call *Call, limit Expr) (bson.M, error) {
+	if err := checkArity(call); err != nil {
+		return nil, err
+	}
+	field, geom, err := c.geoOperands(call)
+	if err != nil {
+		return nil, err
+	}
+	if geom.Type != "Point" {
+		return nil, fmt.Errorf("geo.distance requires a POINT geometry")
+	}
+
+	lit, ok := limit.(*Literal)
+	if !ok {
+		return nil, fmt.Errorf("geo.distance must be compared with a numeric literal")
+	}
+	meters, ok := toFloat(lit.Value)
+	if !ok {
+		return nil, fmt.Errorf("geo.distance must be compared with a numeric literal")
+	}
+
+	within := bson.M{field.Path: bson.M{"$geoWithin": bson.M{
+		"$centerSphere": bson.A{geom.Coordinates, meters / earthRadiusMeters},
+	}}}
+
+	switch op {
+	case "lt", "le":
+		return within, nil
+	case "gt", "ge":
+		return bson.M{"$nor": bson.A{within}}, nil
+	}
+	return nil, fmt.Errorf("geo.distance supports only lt, le, gt and ge")
+}
+
+// booleanCall compiles a function returning a boolean into a query document
+func (c *compiler) booleanCall(call *Call) (bson.M, error) {
+	if err := checkArity(call); err != nil {
+		return nil, err
+	}
+
+	switch call.Name {
+	case "geo.intersects", "st_intersects", "st_within", "st_disjoint":
+		field, geom, err := c.geoOperands(call)
+		if err != nil {
+			return nil, err
+		}
+		geometry := bson.M{"$geometry": bson.M{"type": geom.Type, "coordinates": geom.Coordinates}}
+		switch call.Name {
+		case "st_within":
+			if geom.Type != "Polygon" && geom.Type != "MultiPolygon" {
+				return nil, fmt.Errorf("st_within requires a POLYGON or MULTIPOLYGON geometry")
+			}
+			return bson.M{field.Path: bson.M{"$geoWithin": geometry}}, nil
+		case "st_disjoint":
+			return bson.M{"$nor": bson.A{bson.M{field.Path: bson.M{"$geoIntersects": geometry}}}}, nil
+		default:
+			return bson.M{field.Path: bson.M{"$geoIntersects": geometry}}, nil
+		}
+
+	case "startswith", "endswith", "contains", "substringof":
+		haystack, needle := call.Args[0], call.Args[1]
+		if call.Name == "substringof" {
+			haystack, needle = needle, haystack
+		}
+		if prop, ok := haystack.(*Property); ok {
+			if lit, ok := needle.(*Literal); ok {
+				if s, ok := lit.Value.(string); ok {
+					field, err := c.fields.Resolve(prop.Path)
+					if err != nil {
+						return nil, err
+					}
+					pattern := regexp.QuoteMeta(s)
+					switch call.Name {
+					case "startswith":
+						pattern = "^" + pattern
+					case "endswith":
+						pattern = pattern + "$"
+					}
+					return bson.M{field.Path: bson.M{"$regex": pattern}}, nil
+				}
+			}
+		}
+	}
+
+	if !isBooleanFunction(call.Name) {
+		return nil, fmt.Errorf("%s does not return a boolean", call.Name)
+	}
+	expr, err := c.expression(call)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$expr": expr}, nil
+}
+
+// geoOperands extracts the geometry property and literal of a spatial function
+func (c *compiler) geoOperands(call *Call) (Field, *models.GeoJSON, error) {
+	a, b := call.Args[0], call.Args[1]
+	if _, ok := a.(*Literal); ok {
+		a, b = b, a
+	}
+
+	prop, ok := a.(*Property)
+	if !ok {
+		return Field{}, nil, fmt.Errorf("%s requires a geometry property", call.Name)
+	}
+	field, err := c.fields.Resolve(prop.Path)
+	if err != nil {
+		return Field{}, nil, err
+	}
+	if field.Type != TypeGeometry {
+		return Field{}, nil, fmt.Errorf("%s is not a geometry property", prop.Path)
+	}
+
+	lit, ok := b.(*Literal)
+	if !ok {
+		return Field{}, nil, fmt.Errorf("%s requires a geography literal", call.Name)
+	}
+	geom, ok := lit.Value.(*models.GeoJSON)
+	if !ok {
+		return Field{}, nil, fmt.Errorf("%s requires a geography literal", call.Name)
+	}
+	return field, geom, nil
+}
+
+// expression compiles a value expression into an aggregation expression
+func (c *compiler) expression(expr Expr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *Literal:
+		switch v := e.Value.(type) {
+		case string:
+			return bson.M{"$literal": v}, nil
+		case *models.GeoJSON:
+			return nil, fmt.Errorf("geography literals are only allowed in spatial functions")
+		default:
+			return v, nil
+		}
+
+	case *Property:
+		field, err := c.fields.Resolve(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		return "$" + field.Path, nil
+
+	case *UnaryExpr:
+		operand, err := c.expression(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "not" {
+			return bson.M{"$not": bson.A{operand}}, nil
+		}
+		switch v := operand.(type) {
+		case int64:
+			return -v, nil
+		case float64:
+			return -v, nil
+		}
+		return bson.M{"$multiply": bson.A{-1, operand}}, nil
+
+	case *BinaryExpr:
+		l, err := c.expression(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		r, err := c.expression(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		if op, ok := arithmeticOperators[e.Op]; ok {
+			return bson.M{op: bson.A{l, r}}, nil
+		}
+		if op, ok := comparisonOperators[e.Op]; ok {
+			return bson.M{op: bson.A{l, r}}, nil
+		}
+		return bson.M{"$" + e.Op: bson.A{l, r}}, nil
+
+	case *Call:
+		return c.callExpression(e)
+	}
+	return nil, fmt.Errorf("unsupported expression %s", expr)
+}
+
+// callExpression compiles a function call into an aggregation expression
+func (c *compiler) callExpression(call *Call) (interface{}, error) {
+	if err := checkArity(call); err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, len(call.Args))
+	for i, a := range call.Args {
+		compiled, err := c.expression(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = compiled
+	}
+
+	switch call.Name {
+	// String functions
+	case "substringof":
+		return bson.M{"$gte": bson.A{bson.M{"$indexOfCP": bson.A{args[1], args[0]}}, 0}}, nil
+	case "contains":
+		return bson.M{"$gte": bson.A{bson.M{"$indexOfCP": bson.A{args[0], args[1]}}, 0}}, nil
+	case "startswith":
+		return bson.M{"$eq": bson.A{bson.M{"$indexOfCP": bson.A{args[0], args[1]}}, 0}}, nil
+	case "endswith":
+		return bson.M{"$let": bson.M{
+			"vars": bson.M{"s": args[0], "suffix": args[1]},
+			"in": bson.M{"$eq": bson.A{
+				bson.M{"$substrCP": bson.A{
+					"$$s",
+					bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{
+						bson.M{"$strLenCP": "$$s"}, bson.M{"$strLenCP": "$$suffix"},
+					}}}},
+					bson.M{"$strLenCP": "$$suffix"},
+				}},
+				"$$suffix",
+			}},
+		}}, nil
+	case "length":
+		return bson.M{"$strLenCP": args[0]}, nil
+	case "indexof":
+		return bson.M{"$indexOfCP": bson.A{args[0], args[1]}}, nil
+	case "substring":
+		if len(args) == 3 {
+			return bson.M{"$substrCP": bson.A{args[0], args[1], args[2]}}, nil
+		}
+		return bson.M{"$substrCP": bson.A{
+			args[0], args[1], bson.M{"$subtract": bson.A{bson.M{"$strLenCP": args[0]}, args[1]}},
+		}}, nil
+	case "tolower":
+		return bson.M{"$toLower": args[0]}, nil
+	case "toupper":
+		return bson.M{"$toUpper": args[0]}, nil
+	case "trim":
+		return bson.M{"$trim": bson.M{"input": args[0]}}, nil
+	case "concat":
+		return bson.M{"$concat": bson.A{args[0], args[1]}}, nil
+
+	// Date functions (all times are stored in UTC)
+	case "year":
+		return bson.M{"$year": args[0]}, nil
+	case "month":
+		return bson.M{"$month": args[0]}, nil
+	case "day":
+		return bson.M{"$dayOfMonth": args[0]}, nil
+	case "hour":
+		return bson.M{"$hour": args[0]}, nil
+	case "minute":
+		return bson.M{"$minute": args[0]}, nil
+	case "second":
+		return bson.M{"$second": args[0]}, nil
+	case "fractionalseconds":
+		return bson.M{"$divide": bson.A{bson.M{"$millisecond": args[0]}, 1000}}, nil
+	case "date":
+		return bson.M{"$dateTrunc": bson.M{"date": args[0], "unit": "day"}}, nil
+	case "time":
+		return bson.M{"$dateToString": bson.M{"date": args[0], "format": "%H:%M:%S.%L"}}, nil
+	case "totaloffsetminutes":
+		return 0, nil
+	case "now":
+		return "$$NOW", nil
+	case "mindatetime":
+		return time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), nil
+	case "maxdatetime":
+		return time.Date(9999, 12, 31, 23, 59, 59, 999000000, time.UTC), nil
+
+	// Math functions
+	case "round":
+		return bson.M{"$round": bson.A{args[0], 0}}, nil
+	case "floor":
+		return bson.M{"$floor": args[0]}, nil
+	case "ceiling":
+		return bson.M{"$ceil": args[0]}, nil
+	}
+
+	return nil, fmt.Errorf("%s must be used as a top-level condition", call.Name)
+}
+
+// convertLiteral converts a literal to the representation stored in a field
+func convertLiteral(field Field, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *models.GeoJSON:
+		return nil, fmt.Errorf("geography literals are only allowed in spatial functions")
+	case string:
+		switch field.Type {
+		case TypeDateTime:
+			t, err := ParseTime(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date-time %q for %s", v, field.Path)
+			}
+			return t, nil
+		case TypeObjectID:
+			if oid, err := primitive.ObjectIDFromHex(v); err == nil {
+				return oid, nil
+			}
+		}
+	case int64, float64:
+		if field.Type == TypeObjectID || field.Type == TypeDateTime {
+			return nil, fmt.Errorf("cannot compare %s with a number", field.Path)
+		}
+	}
+	return value, nil
+}
+
+// checkArity validates the number of arguments of a function call
+func checkArity(call *Call) error {
+	arity, ok := functionArity[call.Name]
+	if !ok {
+		return fmt.Errorf("unsupported function %s", call.Name)
+	}
+	if len(call.Args) < arity[0] || len(call.Args) > arity[1] {
+		return fmt.Errorf("%s expects %d to %d arguments, got %d", call.Name, arity[0], arity[1], len(call.Args))
+	}
+	return nil
+}
+
+// isBooleanFunction reports whether a function returns a boolean
+func isBooleanFunction(name string) bool {
+	switch name {
+	case "substringof", "contains", "startswith", "endswith",
+		"geo.intersects", "st_intersects", "st_within", "st_disjoint":
+		return true
+	}
+	return false
+}
+
+// toFloat converts numeric literals to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,189 @@
+package odata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompileComparisons(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("665f1c2e8b3e4a0012345678")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input string
+		want  bson.M
+	}{
+		{"result gt 20", bson.M{"result": bson.M{"$gt": int64(20)}}},
+		{"result eq 1.5", bson.M{"result": 1.5}},
+		{"20 lt result", bson.M{"result": bson.M{"$gt": int64(20)}}},
+		{"20 ge result", bson.M{"result": bson.M{"$lte": int64(20)}}},
+		{"resultQuality ne 'bad'", bson.M{"resultQuality": bson.M{"$ne": "bad"}}},
+		{"resultQuality eq 'it''s'", bson.M{"resultQuality": "it's"}},
+		{"resultTime eq null", bson.M{"resultTime": nil}},
+		{"id eq '665f1c2e8b3e4a0012345678'", bson.M{"_id": oid}},
+		{"phenomenonTime ge 2025-01-01T00:00:00Z", bson.M{"phenomenonTime": bson.M{"$gte": start}}},
+		{"phenomenonTime lt '2025-01-01T00:00:00Z'", bson.M{"phenomenonTime": bson.M{"$lt": start}}},
+		{"parameters/sensor/accuracy le 0.5", bson.M{"parameters.sensor.accuracy": bson.M{"$lte": 0.5}}},
+		{"Datastream/Thing/id eq 'T-1'", bson.M{"datastream.thingId": "T-1"}},
+		{"FeatureOfInterest/id eq 'F-1'", bson.M{"featureOfInterestId": "F-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := CompileFilter(tt.input, ObservationFields)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileLogical(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bson.M
+	}{
+		{"result gt 1 and result lt 5 and resultQuality eq 'good'", bson.M{"$and": bson.A{
+			bson.M{"result": bson.M{"$gt": int64(1)}},
+			bson.M{"result": bson.M{"$lt": int64(5)}},
+			bson.M{"resultQuality": "good"},
+		}}},
+		{"result eq 1 or result eq 2 and resultQuality eq 'good'", bson.M{"$or": bson.A{
+			bson.M{"result": int64(1)},
+			bson.M{"$and": bson.A{bson.M{"result": int64(2)}, bson.M{"resultQuality": "good"}}},
+		}}},
+		{"not result eq 1", bson.M{"$nor": bson.A{bson.M{"result": int64(1)}}}},
+		{"true", bson.M{}},
+		{"false", bson.M{"$expr": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := CompileFilter(tt.input, ObservationFields)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileFunctions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bson.M
+	}{
+		// String matches on a property become anchored, escaped regexes
+		{"startswith(name, 'a.b')", bson.M{"name": bson.M{"$regex": `^a\.b`}}},
+		{"endswith(name, 'x')", bson.M{"name": bson.M{"$regex": "x$"}}},
+		{"contains(name, '(x)')", bson.M{"name": bson.M{"$regex": `\(x\)`}}},
+		{"substringof('x', name)", bson.M{"name": bson.M{"$regex": "x"}}},
+		{"startswith(name, 'a') eq false", bson.M{"$nor": bson.A{bson.M{"name": bson.M{"$regex": "^a"}}}}},
+		{"contains(tolower(name), 'x')", bson.M{"$expr": bson.M{"$gte": bson.A{
+			bson.M{"$indexOfCP": bson.A{bson.M{"$toLower": "$name"}, bson.M{"$literal": "x"}}}, 0,
+		}}}},
+		{"length(name) gt 3", bson.M{"$expr": bson.M{"$gt": bson.A{bson.M{"$strLenCP": "$name"}, int64(3)}}}},
+		{"toupper(name) eq 'AB'", bson.M{"$expr": bson.M{"$eq": bson.A{bson.M{"$toUpper": "$name"}, bson.M{"$literal": "AB"}}}}},
+		{"concat(name, 'x') eq 'ax'", bson.M{"$expr": bson.M{"$eq": bson.A{
+			bson.M{"$concat": bson.A{"$name", bson.M{"$literal": "x"}}}, bson.M{"$literal": "ax"},
+		}}}},
+		{"substring(name, 1) eq 'b'", bson.M{"$expr": bson.M{"$eq": bson.A{
+			bson.M{"$substrCP": bson.A{"$name", int64(1), bson.M{"$subtract": bson.A{bson.M{"$strLenCP": "$name"}, int64(1)}}}},
+			bson.M{"$literal": "b"},
+		}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := CompileFilter(tt.input, ThingFields)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileArithmeticAndDates(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bson.M
+	}{
+		{"year(phenomenonTime) eq 2025", bson.M{"$expr": bson.M{"$eq": bson.A{bson.M{"$year": "$phenomenonTime"}, int64(2025)}}}},
+		{"day(phenomenonTime) le 15", bson.M{"$expr": bson.M{"$lte": bson.A{bson.M{"$dayOfMonth": "$phenomenonTime"}, int64(15)}}}},
+		{"phenomenonTime lt now()", bson.M{"$expr": bson.M{"$lt": bson.A{"$phenomenonTime", "$$NOW"}}}},
+		{"result add 1 gt 5", bson.M{"$expr": bson.M{"$gt": bson.A{bson.M{"$add": bson.A{"$result", int64(1)}}, int64(5)}}}},
+		{"result mul 2 sub 1 eq 3", bson.M{"$expr": bson.M{"$eq": bson.A{
+			bson.M{"$subtract": bson.A{bson.M{"$multiply": bson.A{"$result", int64(2)}}, int64(1)}}, int64(3),
+		}}}},
+		{"-result gt 1", bson.M{"$expr": bson.M{"$gt": bson.A{bson.M{"$multiply": bson.A{-1, "$result"}}, int64(1)}}}},
+		{"round(result) eq 2", bson.M{"$expr": bson.M{"$eq": bson.A{bson.M{"$round": bson.A{"$result", 0}}, int64(2)}}}},
+		{"ceiling(result) ne floor(result)", bson.M{"$expr": bson.M{"$ne": bson.A{bson.M{"$ceil": "$result"}, bson.M{"$floor": "$result"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := CompileFilter(tt.input, ObservationFields)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileSpatial(t *testing.T) {
+	polygon := bson.M{"$geometry": bson.M{"type": "Polygon", "coordinates": []interface{}{[][]float64{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}}}
+	point := []float64{24.9, 60.2}
+
+	tests := []struct {
+		input string
+		want  bson.M
+	}{
+		{"st_within(location, geography'POLYGON((0 0, 1 0, 1 1, 0 0))')", bson.M{"location": bson.M{"$geoWithin": polygon}}},
+		{"st_intersects(geography'POLYGON((0 0, 1 0, 1 1, 0 0))', location)", bson.M{"location": bson.M{"$geoIntersects": polygon}}},
+		{"geo.intersects(location, geography'POLYGON((0 0, 1 0, 1 1, 0 0))')", bson.M{"location": bson.M{"$geoIntersects": polygon}}},
+		{"st_disjoint(location, geography'POLYGON((0 0, 1 0, 1 1, 0 0))')", bson.M{"$nor": bson.A{bson.M{"location": bson.M{"$geoIntersects": polygon}}}}},
+		{"geo.distance(location, geography'POINT(24.9 60.2)') lt 500", bson.M{"location": bson.M{"$geoWithin": bson.M{
+			"$centerSphere": bson.A{point, 500 / earthRadiusMeters},
+		}}}},
+		{"geo.distance(location, geography'POINT(24.9 60.2)') ge 500", bson.M{"$nor": bson.A{bson.M{"location": bson.M{"$geoWithin": bson.M{
+			"$centerSphere": bson.A{point, 500 / earthRadiusMeters},
+		}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := CompileFilter(tt.input, ObservationFields)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		msg   string
+	}{
+		{"unknown eq 1", `unknown property "unknown"`},
+		// The geometry of the feature of interest is not stored with observations
+		{"st_within(FeatureOfInterest/feature, geography'POLYGON((0 0, 1 0, 1 1, 0 0))')", `unknown property "FeatureOfInterest/feature"`},
+		{"st_within(result, geography'POLYGON((0 0, 1 0, 1 1, 0 0))')", "result is not a geometry property"},
+		{"st_within(location, geography'POINT(1 2)')", "st_within requires a POLYGON or MULTIPOLYGON geometry"},
+		{"st_within(location, 'x')", "st_within requires a geography literal"},
+		{"geo.distance(location, geography'LINESTRING(0 0, 1 1)') lt 1", "geo.distance requires a POINT geometry"},
+		{"geo.distance(location, geography'POINT(1 2)') eq 1", "geo.distance supports only lt, le, gt and ge"},
+		{"geo.distance(location, geography'POINT(1 2)') lt result", "geo.distance must be compared with a numeric literal"},
+		{"result eq geography'POINT(1 2)'", "geography literals are only allowed in spatial functions"},
+		{"id eq 5", "cannot compare _id with a number"},
+		{"phenomenonTime gt 'yesterday'", `invalid date-time "yesterday" for phenomenonTime`},
+		{"length(resultQuality)", "length does not return a boolean"},
+		{"foo(result) eq 1", "unsupported function foo"},
+		{"substring(resultQuality)", "substring expects 2 to 3 arguments, got 1"},
+		{"now(result) eq 1", "now expects 0 to 0 arguments, got 1"},
+		{"result add 1", "(result add 1) is not a boolean expression"},
+		{"-result", "(- result) is not a boolean expression"},
+		{"2", "2 is not a boolean expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := CompileFilter(tt.input, ObservationFields)
+			require.Error(t, err)
+			assert.Equal(t, tt.msg, err.Error())
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package odata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldType tells the compiler how to convert literals compared with a field
+type FieldType int
+
+const (
+	// TypeAny passes literals through unchanged
+	TypeAny FieldType = iota
+	// TypeString holds text values
+	TypeString
+	// TypeNumber holds numeric values
+	TypeNumber
+	// TypeDateTime holds BSON dates; string literals are parsed as ISO 8601
+	TypeDateTime
+	// TypeObjectID holds ObjectIDs; hex string literals are converted
+	TypeObjectID
+	// TypeGeometry holds GeoJSON geometries usable with geospatial functions
+	TypeGeometry
+)
+
+// Field maps a SensorThings property to a document field
+type Field struct {
+	Path string
+	Type FieldType
+}
+
+// FieldMap resolves SensorThings property paths to document fields.
+// Keys ending in "/" match any sub-path, e.g. "parameters/" resolves
+// "parameters/accuracy" to "parameters.accuracy".
+type FieldMap map[string]Field
+
+// Resolve looks up the document field for a property path
+func (m FieldMap) Resolve(name string) (Field, error) {
+	if f, ok := m[name]; ok {
+		return f, nil
+	}
+
+	// Prefer the longest matching prefix
+	best := ""
+	for key := range m {
+		if strings.HasSuffix(key, "/") && strings.HasPrefix(name, key) &&
+			len(name) > len(key) && len(key) > len(best) {
+			best = key
+		}
+	}
+	if best == "" {
+		return Field{}, fmt.Errorf("unknown property %q", name)
+	}
+
+	f := m[best]
+	sub := strings.ReplaceAll(strings.TrimPrefix(name, best), "/", ".")
+	return Field{Path: f.Path + "." + sub, Type: f.Type}, nil
+}
+
+// ObservationFields maps Observation properties and navigation paths to the
+// denormalized layout of the observations time-series collection
+var ObservationFields = FieldMap{
+	"id":                             {Path: "_id", Type: TypeObjectID},
+	"@iot.id":                        {Path: "_id", Type: TypeObjectID},
+	"phenomenonTime":                 {Path: "phenomenonTime", Type: TypeDateTime},
+	"resultTime":                     {Path: "resultTime", Type: TypeDateTime},
+	"result":                         {Path: "result", Type: TypeAny},
+	"resultQuality":                  {Path: "resultQuality", Type: TypeString},
+	"validTime/start":                {Path: "validTime.start", Type: TypeDateTime},
+	"validTime/end":                  {Path: "validTime.end", Type: TypeDateTime},
+	"parameters/":                    {Path: "parameters", Type: TypeAny},
+	"location":                       {Path: "location", Type: TypeGeometry},
+	"Datastream/id":                  {Path: "datastream.datastreamId", Type: TypeString},
+	"Datastream/unitOfMeasurement/":  {Path: "datastream.unitOfMeasurement", Type: TypeString},
+	"Datastream/Thing/id":            {Path: "datastream.thingId", Type: TypeString},
+	"Datastream/Sensor/id":           {Path: "datastream.sensorId", Type: TypeString},
+	"Datastream/ObservedProperty/id": {Path: "datastream.observedPropertyId", Type: TypeString},
+	"Datastream/Thing/Locations/id":  {Path: "datastream.locationId", Type: TypeString},
+	// Observations only hold the id of their feature of interest, so its
+	// geometry cannot be filtered on here
+	"FeatureOfInterest/id":           {Path: "featureOfInterestId", Type: TypeString},
+	"date_key":                       {Path: "date_key", Type: TypeNumber},
+	"hour_bucket":                    {Path: "hour_bucket", Type: TypeNumber},
+}
+
+// DatastreamFields maps Datastream properties to the datastreams collection
+var DatastreamFields = FieldMap{
+	"id":                   {Path: "_id", Type: TypeString},
+	"@iot.id":              {Path: "_id", Type: TypeString},
+	"name":                 {Path: "name", Type: TypeString},
+	"description":          {Path: "description", Type: TypeString},
+	"observationType":      {Path: "observationType", Type: TypeString},
+	"unitOfMeasurement/":   {Path: "unitOfMeasurement", Type: TypeString},
+	"observedArea":         {Path: "observedArea", Type: TypeGeometry},
+	"phenomenonTime/start": {Path: "phenomenonTime.start", Type: TypeDateTime},
+	"phenomenonTime/end":   {Path: "phenomenonTime.end", Type: TypeDateTime},
+	"resultTime/start":     {Path: "resultTime.start", Type: TypeDateTime},
+	"resultTime/end":       {Path: "resultTime.end", Type: TypeDateTime},
+	"properties/":          {Path: "properties", Type: TypeAny},
+	"Thing/id":             {Path: "thingId", Type: TypeString},
+	"Sensor/id":            {Path: "sensorId", Type: TypeString},
+	"ObservedProperty/id":  {Path: "observedPropertyId", Type: TypeString},
+}
+
+// ThingFields maps Thing properties to the things collection
+var ThingFields = FieldMap{
+	"id":          {Path: "_id", Type: TypeString},
+	"@iot.id":     {Path: "_id", Type: TypeString},
+	"name":        {Path: "name", Type: TypeString},
+	"description": {Path: "description", Type: TypeString},
+	"properties/": {Path: "properties", Type: TypeAny},
+}
+
+// SensorFields maps Sensor properties to the sensors collection
+var SensorFields = FieldMap{
+	"id":           {Path: "_id", Type: TypeString},
+	"@iot.id":      {Path: "_id", Type: TypeString},
+	"name":         {Path: "name", Type: TypeString},
+	"description":  {Path: "description", Type: TypeString},
+	"encodingType": {Path: "encodingType", Type: TypeString},
+	"metadata/":    {Path: "metadata", Type: TypeAny},
+	"properties/":  {Path: "properties", Type: TypeAny},
+}
+
+// ObservedPropertyFields maps ObservedProperty properties to the observed_properties collection
+var ObservedPropertyFields = FieldMap{
+	"id":          {Path: "_id", Type: TypeString},
+	"@iot.id":     {Path: "_id", Type: TypeString},
+	"name":        {Path: "name", Type: TypeString},
+	"definition":  {Path: "definition", Type: TypeString},
+	"description": {Path: "description", Type: TypeString},
+	"properties/": {Path: "properties", Type: TypeAny},
+}
+
+// LocationFields maps Location properties to the locations collection
+var LocationFields = FieldMap{
+	"id":           {Path: "_id", Type: TypeString},
+	"@iot.id":      {Path: "_id", Type: TypeString},
+	"name":         {Path: "name", Type: TypeString},
+	"description":  {Path: "description", Type: TypeString},
+	"encodingType": {Path: "encodingType", Type: TypeString},
+	"location":     {Path: "location", Type: TypeGeometry},
+	"properties/":  {Path: "properties", Type: TypeAny},
+}
+
+// HistoricalLocationFields maps HistoricalLocation properties to the historical_locations collection
+var HistoricalLocationFields = FieldMap{
+	"id":          {Path: "_id", Type: TypeObjectID},
+	"@iot.id":     {Path: "_id", Type: TypeObjectID},
+	"time":        {Path: "time", Type: TypeDateTime},
+	"Thing/id":    {Path: "thingId", Type: TypeString},
+	"Location/id": {Path: "locationId", Type: TypeString},
+}
+
+// FeatureOfInterestFields maps FeatureOfInterest properties to the features_of_interest collection
+var FeatureOfInterestFields = FieldMap{
+	"id":           {Path: "_id", Type: TypeString},
+	"@iot.id":      {Path: "_id", Type: TypeString},
+	"name":         {Path: "name", Type: TypeString},
+	"description":  {Path: "description", Type: TypeString},
+	"encodingType": {Path: "encodingType", Type: TypeString},
+	"feature":      {Path: "feature.geometry", Type: TypeGeometry},
+	"properties/":  {Path: "feature.properties", Type: TypeAny},
+}
@@ -0,0 +1,248 @@
+package odata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// tokenKind classifies lexical tokens of a $filter expression
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenDateTime
+	tokenGeography
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenMinus
+)
+
+// token is a single lexical element with its position in the input
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// SyntaxError reports a malformed $filter expression
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+// Error implements the error interface
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// lexer turns a $filter string into tokens
+type lexer struct {
+	input []rune
+	pos   int
+}
+
+// tokenize splits the whole input into tokens terminated by tokenEOF
+func tokenize(input string) ([]token, error) {
+	l := &lexer{input: []rune(input)}
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// next scans the following token
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	ch := l.input[l.pos]
+
+	switch {
+	case ch == '(':
+		l.pos++
+		return token{kind: tokenLParen, text: "(", pos: start}, nil
+	case ch == ')':
+		l.pos++
+		return token{kind: tokenRParen, text: ")", pos: start}, nil
+	case ch == ',':
+		l.pos++
+		return token{kind: tokenComma, text: ",", pos: start}, nil
+	case ch == '\'':
+		s, err := l.quoted()
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokenString, text: s, value: s, pos: start}, nil
+	case ch == '-' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1]):
+		return l.number()
+	case ch == '-':
+		l.pos++
+		return token{kind: tokenMinus, text: "-", pos: start}, nil
+	case isDigit(ch):
+		if l.looksLikeDateTime() {
+			return l.dateTime()
+		}
+		return l.number()
+	case isIdentStart(ch):
+		return l.identifier()
+	}
+
+	return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", ch)}
+}
+
+// quoted reads a single-quoted string in which a doubled quote escapes a quote
+func (l *lexer) quoted() (string, error) {
+	start := l.pos
+	l.pos++ // opening quote
+
+	var sb strings.Builder
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if ch == '\'' {
+			if l.pos+1 < len(l.input) && l.input[l.pos+1] == '\'' {
+				sb.WriteRune('\'')
+				l.pos += 2
+				continue
+			}
+			l.pos++
+			return sb.String(), nil
+		}
+		sb.WriteRune(ch)
+		l.pos++
+	}
+	return "", &SyntaxError{Pos: start, Msg: "unterminated string literal"}
+}
+
+// number reads an integer or decimal literal
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	if l.input[l.pos] == '-' {
+		l.pos++
+	}
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if isDigit(ch) || ch == '.' || ch == 'e' || ch == 'E' ||
+			((ch == '+' || ch == '-') && (l.input[l.pos-1] == 'e' || l.input[l.pos-1] == 'E')) {
+			l.pos++
+			continue
+		}
+		break
+	}
+
+	text := string(l.input[start:l.pos])
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return token{kind: tokenNumber, text: text, value: i, pos: start}, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("invalid number %q", text)}
+	}
+	return token{kind: tokenNumber, text: text, value: f, pos: start}, nil
+}
+
+// looksLikeDateTime reports whether the input continues with YYYY-MM-DD
+func (l *lexer) looksLikeDateTime() bool {
+	if l.pos+10 > len(l.input) {
+		return false
+	}
+	s := l.input[l.pos : l.pos+10]
+	for i, ch := range s {
+		if i == 4 || i == 7 {
+			if ch != '-' {
+				return false
+			}
+		} else if !isDigit(ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// dateTime reads an unquoted ISO 8601 date or date-time literal
+func (l *lexer) dateTime() (token, error) {
+	start := l.pos
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if unicode.IsSpace(ch) || ch == ')' || ch == ',' {
+			break
+		}
+		l.pos++
+	}
+
+	text := string(l.input[start:l.pos])
+	t, err := ParseTime(text)
+	if err != nil {
+		return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("invalid date-time %q", text)}
+	}
+	return token{kind: tokenDateTime, text: text, value: t, pos: start}, nil
+}
+
+// identifier reads a name, property path or typed literal such as geography'POINT(1 2)'
+func (l *lexer) identifier() (token, error) {
+	start := l.pos
+	for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+		l.pos++
+	}
+	text := string(l.input[start:l.pos])
+
+	if l.pos < len(l.input) && l.input[l.pos] == '\'' {
+		prefix := strings.ToLower(text)
+		if prefix != "geography" && prefix != "geometry" {
+			return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unsupported typed literal %s", text)}
+		}
+		wkt, err := l.quoted()
+		if err != nil {
+			return token{}, err
+		}
+		geom, err := ParseWKT(wkt)
+		if err != nil {
+			return token{}, &SyntaxError{Pos: start, Msg: err.Error()}
+		}
+		return token{kind: tokenGeography, text: wkt, value: geom, pos: start}, nil
+	}
+
+	if strings.HasSuffix(text, "/") {
+		return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("incomplete property path %q", text)}
+	}
+	return token{kind: tokenIdent, text: text, pos: start}, nil
+}
+
+// ParseTime parses an ISO 8601 date-time or date as used in SensorThings requests
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func isDigit(ch rune) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdentStart(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_' || ch == '@' || ch == '$'
+}
+
+func isIdentPart(ch rune) bool {
+	return isIdentStart(ch) || isDigit(ch) || ch == '.' || ch == '/'
+}
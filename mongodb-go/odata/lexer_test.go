@@ -0,0 +1,110 @@
+package odata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizeLiterals(t *testing.T) {
+	tests := []struct {
+		input string
+		kind  tokenKind
+		value interface{}
+	}{
+		{"'plain'", tokenString, "plain"},
+		{"''", tokenString, ""},
+		{"'O''Brien'", tokenString, "O'Brien"},
+		{"''''", tokenString, "'"},
+		{"'a, b (c) eq d'", tokenString, "a, b (c) eq d"},
+		{"'sää'", tokenString, "sää"},
+		{"42", tokenNumber, int64(42)},
+		{"-7", tokenNumber, int64(-7)},
+		{"1.5", tokenNumber, 1.5},
+		{"1e3", tokenNumber, 1000.0},
+		{"2.5E-1", tokenNumber, 0.25},
+		{"2025-01-02", tokenDateTime, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2025-01-02T03:04:05Z", tokenDateTime, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2025-01-02T05:04:05.5+02:00", tokenDateTime, time.Date(2025, 1, 2, 3, 4, 5, 500000000, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := tokenize(tt.input)
+			require.NoError(t, err)
+			require.Len(t, tokens, 2)
+			assert.Equal(t, tt.kind, tokens[0].kind)
+			assert.Equal(t, tt.value, tokens[0].value)
+			assert.Equal(t, tokenEOF, tokens[1].kind)
+		})
+	}
+}
+
+func TestTokenizeExpression(t *testing.T) {
+	tokens, err := tokenize("Datastream/id eq 'DS-1' and result sub -2 gt (3)")
+	require.NoError(t, err)
+
+	var kinds []tokenKind
+	var texts []string
+	var positions []int
+	for _, tok := range tokens {
+		kinds = append(kinds, tok.kind)
+		texts = append(texts, tok.text)
+		positions = append(positions, tok.pos)
+	}
+	assert.Equal(t, []tokenKind{
+		tokenIdent, tokenIdent, tokenString, tokenIdent, tokenIdent, tokenIdent,
+		tokenNumber, tokenIdent, tokenLParen, tokenNumber, tokenRParen, tokenEOF,
+	}, kinds)
+	assert.Equal(t, []string{
+		"Datastream/id", "eq", "DS-1", "and", "result", "sub", "-2", "gt", "(", "3", ")", "",
+	}, texts)
+	assert.Equal(t, []int{0, 14, 17, 24, 28, 35, 39, 42, 45, 46, 47, 48}, positions)
+}
+
+func TestTokenizeMinus(t *testing.T) {
+	// A minus not followed by a digit negates what follows
+	tokens, err := tokenize("-result")
+	require.NoError(t, err)
+	assert.Equal(t, tokenMinus, tokens[0].kind)
+	assert.Equal(t, tokenIdent, tokens[1].kind)
+}
+
+func TestTokenizeGeography(t *testing.T) {
+	tokens, err := tokenize("geography'POINT(24.9 60.2)'")
+	require.NoError(t, err)
+	require.Equal(t, tokenGeography, tokens[0].kind)
+	assert.Equal(t, "POINT(24.9 60.2)", tokens[0].text)
+
+	tokens, err = tokenize("GEOMETRY'SRID=4326;LINESTRING(0 0, 1 1)'")
+	require.NoError(t, err)
+	assert.Equal(t, tokenGeography, tokens[0].kind)
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{"name eq 'open", 8, "unterminated string literal"},
+		{"name eq 'it''s", 8, "unterminated string literal"},
+		{"name eq #", 8, `unexpected character '#'`},
+		{"'ä' eq #", 7, `unexpected character '#'`},
+		{"result eq 1.2.3", 10, `invalid number "1.2.3"`},
+		{"phenomenonTime gt 2025-13-45", 18, `invalid date-time "2025-13-45"`},
+		{"name eq binary'AA=='", 8, "unsupported typed literal binary"},
+		{"location eq geography'CIRCLE(1 2)'", 12, `unsupported WKT geometry type "CIRCLE"`},
+		{"Datastream/ eq 'x'", 0, `incomplete property path "Datastream/"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := tokenize(tt.input)
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.pos, syntaxErr.Pos)
+			assert.Equal(t, tt.msg, syntaxErr.Msg)
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package odata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr is a node of a parsed $filter expression
+type Expr interface {
+	String() string
+}
+
+// BinaryExpr is a logical, comparison or arithmetic operation
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// UnaryExpr is a logical negation or arithmetic minus
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+}
+
+// Literal is a constant value: string, int64, float64, bool, nil, time.Time or Geometry
+type Literal struct {
+	Value interface{}
+}
+
+// Property references an entity property or navigation path such as Datastream/id
+type Property struct {
+	Path string
+}
+
+// Call is a built-in function invocation
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+// String renders the expression for diagnostics
+func (e *BinaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
+}
+
+// String renders the expression for diagnostics
+func (e *UnaryExpr) String() string {
+	return fmt.Sprintf("(%s %s)", e.Op, e.Operand)
+}
+
+// String renders the expression for diagnostics
+func (e *Literal) String() string {
+	if s, ok := e.Value.(string); ok {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return fmt.Sprintf("%v", e.Value)
+}
+
+// String renders the expression for diagnostics
+func (e *Property) String() string {
+	return e.Path
+}
+
+// String renders the expression for diagnostics
+func (e *Call) String() string {
+	args := make([]string, len(e.Args))
+	for i, a := range e.Args {
+		args[i] = a.String()
+	}
+	return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ","))
+}
+
+// Operator groups by precedence, lowest first
+var (
+	comparisonOps     = map[string]bool{"eq": true, "ne": true, "gt": true, "ge": true, "lt": true, "le": true}
+	additiveOps       = map[string]bool{"add": true, "sub": true}
+	multiplicativeOps = map[string]bool{"mul": true, "div": true, "mod": true}
+)
+
+// parser is a recursive descent parser over the token stream
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a SensorThings $filter expression
+func Parse(input string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, &SyntaxError{Pos: 0, Msg: "empty expression"}
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// peekKeyword returns the operator keyword at the current position, if any
+func (p *parser) peekKeyword(ops map[string]bool) (string, bool) {
+	tok := p.peek()
+	if tok.kind == tokenIdent && ops[tok.text] {
+		return tok.text, true
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekKeyword(map[string]bool{"or": true}); !ok {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "or", Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekKeyword(map[string]bool{"and": true}); !ok {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "and", Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if _, ok := p.peekKeyword(map[string]bool{"not": true}); ok {
+		p.advance()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "not", Operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.peekKeyword(comparisonOps); ok {
+		p.advance()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: op, Left: left, Right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.peekKeyword(additiveOps)
+		if !ok {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.peekKeyword(multiplicativeOps)
+		if !ok {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().kind == tokenMinus {
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "-", Operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.advance()
+
+	switch tok.kind {
+	case tokenString, tokenNumber, tokenDateTime, tokenGeography:
+		return &Literal{Value: tok.value}, nil
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.advance(); closing.kind != tokenRParen {
+			return nil, &SyntaxError{Pos: closing.pos, Msg: "expected ')'"}
+		}
+		return expr, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &Literal{Value: true}, nil
+		case "false":
+			return &Literal{Value: false}, nil
+		case "null":
+			return &Literal{Value: nil}, nil
+		}
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(tok)
+		}
+		if isReserved(tok.text) {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected operator %q", tok.text)}
+		}
+		return &Property{Path: tok.text}, nil
+	case tokenEOF:
+		return nil, &SyntaxError{Pos: tok.pos, Msg: "unexpected end of expression"}
+	}
+
+	return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+}
+
+// parseCall parses the argument list of a function call
+func (p *parser) parseCall(name token) (Expr, error) {
+	p.advance() // opening parenthesis
+	call := &Call{Name: strings.ToLower(name.text)}
+
+	if p.peek().kind == tokenRParen {
+		p.advance()
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		tok := p.advance()
+		switch tok.kind {
+		case tokenComma:
+			continue
+		case tokenRParen:
+			return call, nil
+		default:
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected ',' or ')' in call to %s", name.text)}
+		}
+	}
+}
+
+// isReserved reports whether a name is an operator keyword
+func isReserved(name string) bool {
+	return comparisonOps[name] || additiveOps[name] || multiplicativeOps[name] ||
+		name == "and" || name == "or" || name == "not"
+}
@@ -0,0 +1,107 @@
+package odata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"a eq 1 or b eq 2 and c eq 3", "((a eq 1) or ((b eq 2) and (c eq 3)))"},
+		{"a eq 1 and b eq 2 or c eq 3", "(((a eq 1) and (b eq 2)) or (c eq 3))"},
+		{"(a eq 1 or b eq 2) and c eq 3", "(((a eq 1) or (b eq 2)) and (c eq 3))"},
+		{"not a eq 1 and b eq 2", "((not (a eq 1)) and (b eq 2))"},
+		{"not not a", "(not (not a))"},
+		{"a add b mul c eq 7", "((a add (b mul c)) eq 7)"},
+		{"a sub b sub c eq 0", "(((a sub b) sub c) eq 0)"},
+		{"a div 2 mod 3 ne 1", "(((a div 2) mod 3) ne 1)"},
+		{"(a add b) mul c le 7", "(((a add b) mul c) le 7)"},
+		{"-a gt -1", "((- a) gt -1)"},
+		{"- -a lt 0", "((- (- a)) lt 0)"},
+		{"a or b or c", "((a or b) or c)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"name eq 'O''Brien'", "(name eq 'O''Brien')"},
+		{"name eq ''", "(name eq '')"},
+		{"flag eq true", "(flag eq true)"},
+		{"flag ne false", "(flag ne false)"},
+		{"resultTime eq null", "(resultTime eq <nil>)"},
+		{"result eq 1.5", "(result eq 1.5)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParseCalls(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"now()", "now()"},
+		{"StartsWith(name, 'a')", "startswith(name,'a')"},
+		{"substring(name, 1, 2) eq 'b'", "(substring(name,1,2) eq 'b')"},
+		{"year(phenomenonTime) add 1 eq 2026", "((year(phenomenonTime) add 1) eq 2026)"},
+		{"contains(concat(name, 'x'), 'ax')", "contains(concat(name,'x'),'ax')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{"", 0, "empty expression"},
+		{"   ", 0, "empty expression"},
+		{"a eq 1 and", 10, "unexpected end of expression"},
+		{"(a eq 1", 7, "expected ')'"},
+		{"a eq 1)", 6, `unexpected ")"`},
+		{"a eq 1 b", 7, `unexpected "b"`},
+		{"a eq b eq c", 7, `unexpected "eq"`},
+		{"a eq and", 5, `unexpected operator "and"`},
+		{"eq 1", 0, `unexpected operator "eq"`},
+		{"contains(a 'b')", 11, "expected ',' or ')' in call to contains"},
+		{"contains(a,", 11, "unexpected end of expression"},
+		{"a eq ,", 5, `unexpected ","`},
+		{"'ä' eq 1 1", 9, `unexpected "1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.pos, syntaxErr.Pos)
+			assert.Equal(t, tt.msg, syntaxErr.Msg)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package odata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// wktTypes maps WKT geometry keywords to GeoJSON types and their coordinate nesting depth
+var wktTypes = map[string]struct {
+	geoJSONType string
+	depth       int
+}{
+	"POINT":           {"Point", 0},
+	"LINESTRING":      {"LineString", 1},
+	"POLYGON":         {"Polygon", 2},
+	"MULTIPOINT":      {"MultiPoint", 1},
+	"MULTILINESTRING": {"MultiLineString", 2},
+	"MULTIPOLYGON":    {"MultiPolygon", 3},
+}
+
+// wktNode is either a position or a parenthesized list of nodes
+type wktNode struct {
+	position []float64
+	children []wktNode
+}
+
+// ParseWKT converts a Well-Known Text geometry into GeoJSON
+func ParseWKT(wkt string) (*models.GeoJSON, error) {
+	text := strings.TrimSpace(wkt)
+	if strings.HasPrefix(strings.ToUpper(text), "SRID=") {
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = strings.TrimSpace(text[i+1:])
+		}
+	}
+
+	open := strings.IndexByte(text, '(')
+	if open < 0 {
+		return nil, fmt.Errorf("invalid WKT geometry %q", wkt)
+	}
+
+	keyword := strings.ToUpper(strings.TrimSpace(text[:open]))
+	info, ok := wktTypes[keyword]
+	if !ok {
+		return nil, fmt.Errorf("unsupported WKT geometry type %q", keyword)
+	}
+
+	p := &wktParser{input: text, pos: open}
+	node, err := p.parseList()
+	if err != nil {
+		return nil, fmt.Errorf("invalid WKT geometry %q: %w", wkt, err)
+	}
+	if strings.TrimSpace(text[p.pos:]) != "" {
+		return nil, fmt.Errorf("invalid WKT geometry %q: trailing characters", wkt)
+	}
+
+	var coords interface{}
+	if info.depth == 0 {
+		if len(node.children) != 1 || node.children[0].position == nil {
+			return nil, fmt.Errorf("invalid WKT point %q", wkt)
+		}
+		coords = node.children[0].position
+	} else {
+		coords, err = node.coordinates(info.depth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WKT geometry %q: %w", wkt, err)
+		}
+	}
+
+	return &models.GeoJSON{Type: info.geoJSONType, Coordinates: coords}, nil
+}
+
+// coordinates converts a list node into nested coordinate arrays of the given depth
+func (n wktNode) coordinates(depth int) (interface{}, error) {
+	if depth == 1 {
+		positions := make([][]float64, 0, len(n.children))
+		for _, child := range n.children {
+			pos := child.position
+			// MULTIPOINT((1 2), (3 4)) wraps each position in parentheses
+			if pos == nil && len(child.children) == 1 {
+				pos = child.children[0].position
+			}
+			if pos == nil {
+				return nil, fmt.Errorf("expected a position")
+			}
+			positions = append(positions, pos)
+		}
+		return positions, nil
+	}
+
+	result := make([]interface{}, 0, len(n.children))
+	for _, child := range n.children {
+		if child.position != nil {
+			return nil, fmt.Errorf("unexpected position at nesting depth %d", depth)
+		}
+		coords, err := child.coordinates(depth - 1)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, coords)
+	}
+	return result, nil
+}
+
+// wktParser parses the parenthesized coordinate part of a WKT string
+type wktParser struct {
+	input string
+	pos   int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseList parses "(" item {"," item} ")"
+func (p *wktParser) parseList() (wktNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != '(' {
+		return wktNode{}, fmt.Errorf("expected '(' at %d", p.pos)
+	}
+	p.pos++
+
+	var node wktNode
+	for {
+		p.skipSpace()
+		var child wktNode
+		var err error
+		if p.pos < len(p.input) && p.input[p.pos] == '(' {
+			child, err = p.parseList()
+		} else {
+			child, err = p.parsePosition()
+		}
+		if err != nil {
+			return wktNode{}, err
+		}
+		node.children = append(node.children, child)
+
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return wktNode{}, fmt.Errorf("unterminated coordinate list")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return node, nil
+		default:
+			return wktNode{}, fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos)
+		}
+	}
+}
+
+// parsePosition parses two or three space separated numbers
+func (p *wktParser) parsePosition() (wktNode, error) {
+	end := p.pos
+	for end < len(p.input) && p.input[end] != ',' && p.input[end] != ')' {
+		end++
+	}
+
+	fields := strings.Fields(p.input[p.pos:end])
+	if len(fields) < 2 || len(fields) > 3 {
+		return wktNode{}, fmt.Errorf("invalid position %q", p.input[p.pos:end])
+	}
+
+	position := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return wktNode{}, fmt.Errorf("invalid coordinate %q", f)
+		}
+		position[i] = v
+	}
+
+	p.pos = end
+	return wktNode{position: position}, nil
+}
@@ -0,0 +1,498 @@
+package ogcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// Well-known coordinate reference systems
+const (
+	// CRS84 is WGS 84 longitude/latitude, the default of OGC API - Features
+	CRS84 = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	// EPSG4326 is WGS 84 with latitude/longitude axis order
+	EPSG4326 = "http://www.opengis.net/def/crs/EPSG/0/4326"
+)
+
+// Conformance classes checked by the client
+const (
+	ConformanceCore    = "http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core"
+	ConformanceGeoJSON = "http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson"
+	ConformanceCRS     = "http://www.opengis.net/spec/ogcapi-features-2/1.0/conf/crs"
+)
+
+var (
+	// ErrNotFound is returned when the API has no such collection or feature
+	ErrNotFound = errors.New("feature not found")
+	// ErrUnsupportedCRS is returned when a requested CRS is not offered
+	ErrUnsupportedCRS = errors.New("unsupported crs")
+	// ErrNotConformant is returned when the API lacks a required conformance class
+	ErrNotConformant = errors.New("api does not conform")
+)
+
+// Client reads features from an OGC API - Features service
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+
+	mu          sync.Mutex
+	conformance *Conformance
+}
+
+// NewClient creates a client for the API rooted at baseURL. Requests failing
+// with a network error, 429 or a 5xx status are retried up to retries times.
+func NewClient(baseURL string, httpClient *http.Client, retries int) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+		retries:    max(retries, 0),
+		backoff:    200 * time.Millisecond,
+	}
+}
+
+// SetBackoff sets the delay before the first retry; it doubles on each further retry
+func (c *Client) SetBackoff(d time.Duration) {
+	c.backoff = d
+}
+
+// BaseURL returns the root URL of the API
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// ItemURL returns the URL of a feature
+func (c *Client) ItemURL(collection, id string) string {
+	return c.baseURL + "/collections/" + url.PathEscape(collection) + "/items/" + url.PathEscape(id)
+}
+
+// Link is a hypermedia link
+type Link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// Conformance lists the conformance classes an API implements
+type Conformance struct {
+	ConformsTo []string `json:"conformsTo"`
+}
+
+// Supports reports whether the API implements a conformance class
+func (c *Conformance) Supports(class string) bool {
+	for _, conformsTo := range c.ConformsTo {
+		if conformsTo == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Extent is the spatial and temporal extent of a collection
+type Extent struct {
+	Spatial *struct {
+		BBox [][]float64 `json:"bbox"`
+		CRS  string      `json:"crs,omitempty"`
+	} `json:"spatial,omitempty"`
+	Temporal *struct {
+		Interval [][]*string `json:"interval"`
+	} `json:"temporal,omitempty"`
+}
+
+// Collection describes a feature collection
+type Collection struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Links       []Link   `json:"links,omitempty"`
+	Extent      *Extent  `json:"extent,omitempty"`
+	ItemType    string   `json:"itemType,omitempty"`
+	CRS         []string `json:"crs,omitempty"`
+	StorageCRS  string   `json:"storageCrs,omitempty"`
+}
+
+// SupportsCRS reports whether features of the collection can be requested in crs
+func (c *Collection) SupportsCRS(crs string) bool {
+	if len(c.CRS) == 0 {
+		return crs == CRS84
+	}
+	for _, offered := range c.CRS {
+		if offered == crs || offered == "#/crs" {
+			return true
+		}
+	}
+	return false
+}
+
+// Feature is a GeoJSON feature
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"-"`
+	Geometry   *models.GeoJSON        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+	BBox       []float64              `json:"bbox,omitempty"`
+	Links      []Link                 `json:"links,omitempty"`
+
+	// CRS is the coordinate reference system of the geometry
+	CRS string `json:"-"`
+}
+
+// UnmarshalJSON accepts both string and numeric feature identifiers
+func (f *Feature) UnmarshalJSON(data []byte) error {
+	type feature Feature
+	var raw struct {
+		feature
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*f = Feature(raw.feature)
+
+	var id string
+	if err := json.Unmarshal(raw.ID, &id); err == nil {
+		f.ID = id
+	} else {
+		f.ID = string(raw.ID)
+	}
+	return nil
+}
+
+// MarshalJSON writes the feature with its identifier
+func (f Feature) MarshalJSON() ([]byte, error) {
+	type feature Feature
+	return json.Marshal(struct {
+		ID string `json:"id,omitempty"`
+		feature
+	}{ID: f.ID, feature: feature(f)})
+}
+
+// FeatureCollection is one page of features
+type FeatureCollection struct {
+	Type           string    `json:"type"`
+	Features       []Feature `json:"features"`
+	Links          []Link    `json:"links,omitempty"`
+	NumberMatched  *int      `json:"numberMatched,omitempty"`
+	NumberReturned *int      `json:"numberReturned,omitempty"`
+	TimeStamp      string    `json:"timeStamp,omitempty"`
+
+	// CRS is the coordinate reference system of the geometries
+	CRS string `json:"-"`
+}
+
+// Next returns the link to the following page, if any
+func (fc *FeatureCollection) Next() (Link, bool) {
+	for _, link := range fc.Links {
+		if link.Rel == "next" {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// ItemsQuery selects the features returned by Items
+type ItemsQuery struct {
+	// BBox is minx, miny, maxx, maxy (optionally with heights), in BBoxCRS
+	BBox    []float64
+	BBoxCRS string
+	// Datetime is an RFC 3339 instant or an interval such as "2025-01-01T00:00:00Z/.."
+	Datetime string
+	Limit    int
+	// CRS requests geometries in a coordinate reference system other than CRS84
+	CRS string
+	// Properties filters on feature property values
+	Properties map[string]string
+}
+
+// Interval formats a datetime interval for ItemsQuery; a zero time is open-ended
+func Interval(start, end time.Time) string {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ".."
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return format(start) + "/" + format(end)
+}
+
+// Conformance retrieves the conformance classes of the API; the result is cached
+func (c *Client) Conformance(ctx context.Context) (*Conformance, error) {
+	c.mu.Lock()
+	cached := c.conformance
+	c.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var conformance Conformance
+	if _, err := c.get(ctx, c.baseURL+"/conformance", "application/json", "", &conformance); err != nil {
+		return nil, err
+	}
+	if !conformance.Supports(ConformanceCore) {
+		return nil, fmt.Errorf("%s: missing %s: %w", c.baseURL, ConformanceCore, ErrNotConformant)
+	}
+
+	c.mu.Lock()
+	c.conformance = &conformance
+	c.mu.Unlock()
+	return &conformance, nil
+}
+
+// Collections lists the feature collections of the API
+func (c *Client) Collections(ctx context.Context) ([]Collection, error) {
+	var response struct {
+		Collections []Collection `json:"collections"`
+	}
+	if _, err := c.get(ctx, c.baseURL+"/collections", "application/json", "", &response); err != nil {
+		return nil, err
+	}
+	return response.Collections, nil
+}
+
+// Collection describes one feature collection
+func (c *Client) Collection(ctx context.Context, id string) (*Collection, error) {
+	var collection Collection
+	endpoint := c.baseURL + "/collections/" + url.PathEscape(id)
+	if _, err := c.get(ctx, endpoint, "application/json", "", &collection); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// Items retrieves the first page of features of a collection matching query
+func (c *Client) Items(ctx context.Context, collection string, query ItemsQuery) (*FeatureCollection, error) {
+	if err := c.checkCRS(ctx, collection, query.CRS, query.BBoxCRS); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	if len(query.BBox) > 0 {
+		if len(query.BBox) != 4 && len(query.BBox) != 6 {
+			return nil, fmt.Errorf("bbox needs 4 or 6 numbers, got %d", len(query.BBox))
+		}
+		values := make([]string, len(query.BBox))
+		for i, v := range query.BBox {
+			values[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		params.Set("bbox", strings.Join(values, ","))
+		if query.BBoxCRS != "" {
+			params.Set("bbox-crs", query.BBoxCRS)
+		}
+	}
+	if query.Datetime != "" {
+		params.Set("datetime", query.Datetime)
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.CRS != "" {
+		params.Set("crs", query.CRS)
+	}
+	for name, value := range query.Properties {
+		params.Set(name, value)
+	}
+
+	endpoint := c.baseURL + "/collections/" + url.PathEscape(collection) + "/items"
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	return c.page(ctx, endpoint, query.CRS)
+}
+
+// NextPage follows the next link of a page; it returns nil when there is none
+func (c *Client) NextPage(ctx context.Context, page *FeatureCollection) (*FeatureCollection, error) {
+	next, ok := page.Next()
+	if !ok {
+		return nil, nil
+	}
+	href, err := c.resolve(next.Href)
+	if err != nil {
+		return nil, err
+	}
+	return c.page(ctx, href, page.CRS)
+}
+
+// AllItems calls fn for every feature matching query, following next links
+func (c *Client) AllItems(ctx context.Context, collection string, query ItemsQuery, fn func(*Feature) error) error {
+	page, err := c.Items(ctx, collection, query)
+	for err == nil && page != nil {
+		for i := range page.Features {
+			if err := fn(&page.Features[i]); err != nil {
+				return err
+			}
+		}
+		if len(page.Features) == 0 {
+			return nil
+		}
+		page, err = c.NextPage(ctx, page)
+	}
+	return err
+}
+
+// Item retrieves one feature, with its geometry in crs (CRS84 if empty)
+func (c *Client) Item(ctx context.Context, collection, id, crs string) (*Feature, error) {
+	if err := c.checkCRS(ctx, collection, crs, ""); err != nil {
+		return nil, err
+	}
+	endpoint := c.ItemURL(collection, id)
+	if crs != "" {
+		endpoint += "?" + url.Values{"crs": {crs}}.Encode()
+	}
+
+	var feature Feature
+	contentCRS, err := c.get(ctx, endpoint, "application/geo+json", crs, &feature)
+	if err != nil {
+		return nil, err
+	}
+	feature.CRS = contentCRS
+	return &feature, nil
+}
+
+// ItemByHref retrieves a feature from its absolute or API-relative URL
+func (c *Client) ItemByHref(ctx context.Context, href string) (*Feature, error) {
+	endpoint, err := c.resolve(href)
+	if err != nil {
+		return nil, err
+	}
+	var feature Feature
+	contentCRS, err := c.get(ctx, endpoint, "application/geo+json", "", &feature)
+	if err != nil {
+		return nil, err
+	}
+	feature.CRS = contentCRS
+	return &feature, nil
+}
+
+// page retrieves one page of features
+func (c *Client) page(ctx context.Context, endpoint, crs string) (*FeatureCollection, error) {
+	var page FeatureCollection
+	contentCRS, err := c.get(ctx, endpoint, "application/geo+json", crs, &page)
+	if err != nil {
+		return nil, err
+	}
+	page.CRS = contentCRS
+	for i := range page.Features {
+		page.Features[i].CRS = contentCRS
+	}
+	return &page, nil
+}
+
+// checkCRS verifies that the API and the collection offer the requested CRSs
+func (c *Client) checkCRS(ctx context.Context, collection string, crss ...string) error {
+	var requested []string
+	for _, crs := range crss {
+		if crs != "" && crs != CRS84 {
+			requested = append(requested, crs)
+		}
+	}
+	if len(requested) == 0 {
+		return nil
+	}
+
+	conformance, err := c.Conformance(ctx)
+	if err != nil {
+		return err
+	}
+	if !conformance.Supports(ConformanceCRS) {
+		return fmt.Errorf("%s: missing %s: %w", c.baseURL, ConformanceCRS, ErrNotConformant)
+	}
+	info, err := c.Collection(ctx, collection)
+	if err != nil {
+		return err
+	}
+	for _, crs := range requested {
+		if !info.SupportsCRS(crs) {
+			return fmt.Errorf("collection %s does not offer %s: %w", collection, crs, ErrUnsupportedCRS)
+		}
+	}
+	return nil
+}
+
+// resolve makes a link absolute against the API root
+func (c *Client) resolve(href string) (string, error) {
+	base, err := url.Parse(c.baseURL + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid base url %s: %w", c.baseURL, err)
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid link %s: %w", href, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// get performs a GET request with retries and decodes the JSON response. It
+// returns the Content-Crs of the response, CRS84 if the server sent none, and
+// fails if a requested CRS was not honoured.
+func (c *Client) get(ctx context.Context, endpoint, accept, crs string, v interface{}) (string, error) {
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		contentCRS, retry, err := c.do(ctx, endpoint, accept, v)
+		if err == nil {
+			if crs != "" && contentCRS != crs {
+				return "", fmt.Errorf("%s answered in %s instead of %s: %w", endpoint, contentCRS, crs, ErrUnsupportedCRS)
+			}
+			return contentCRS, nil
+		}
+		if !retry || attempt >= c.retries {
+			return "", err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// do performs one request, reporting whether a failure is worth retrying
+func (c *Client) do(ctx context.Context, endpoint, accept string, v interface{}) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", accept+", application/json;q=0.9")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", ctx.Err() == nil, fmt.Errorf("failed to call %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", false, fmt.Errorf("%s: %w", endpoint, ErrNotFound)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		io.Copy(io.Discard, resp.Body)
+		return "", true, fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return "", false, fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", false, fmt.Errorf("failed to decode %s: %w", endpoint, err)
+	}
+
+	contentCRS := strings.Trim(resp.Header.Get("Content-Crs"), "<> ")
+	if contentCRS == "" {
+		contentCRS = CRS84
+	}
+	return contentCRS, false, nil
+}
@@ -0,0 +1,180 @@
+package ogcapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi/ogcapitest"
+)
+
+// newClient starts a fixture server and a client reading from it
+func newClient(t *testing.T, retries int) (*ogcapi.Client, *ogcapitest.Server) {
+	server := ogcapitest.NewServer()
+	t.Cleanup(server.Close)
+	client := ogcapi.NewClient(server.URL, server.Client(), retries)
+	client.SetBackoff(time.Millisecond)
+	return client, server
+}
+
+// featureIDs lists the identifiers of features
+func featureIDs(features []ogcapi.Feature) []string {
+	ids := make([]string, len(features))
+	for i := range features {
+		ids[i] = features[i].ID
+	}
+	return ids
+}
+
+func TestItemsPages(t *testing.T) {
+	client, _ := newClient(t, 0)
+	ctx := context.Background()
+
+	page, err := client.Items(ctx, ogcapitest.Collection, ogcapi.ItemsQuery{Limit: 2})
+	require.NoError(t, err)
+	require.NotNil(t, page.NumberMatched)
+	assert.Equal(t, 5, *page.NumberMatched)
+	assert.Equal(t, ogcapi.CRS84, page.CRS)
+
+	var ids []string
+	for page != nil {
+		assert.LessOrEqual(t, len(page.Features), 2)
+		ids = append(ids, featureIDs(page.Features)...)
+		page, err = client.NextPage(ctx, page)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"049", "091", "092", "235", "837"}, ids)
+}
+
+func TestAllItems(t *testing.T) {
+	client, server := newClient(t, 0)
+
+	var names []string
+	err := client.AllItems(context.Background(), ogcapitest.Collection, ogcapi.ItemsQuery{Limit: 2}, func(f *ogcapi.Feature) error {
+		names = append(names, f.Properties["name"].(string))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Espoo", "Helsinki", "Vantaa", "Kauniainen", "Tampere"}, names)
+	assert.Equal(t, int64(3), server.Requests.Load())
+
+	// An error from fn stops the walk
+	stop := errors.New("stop")
+	calls := 0
+	err = client.AllItems(context.Background(), ogcapitest.Collection, ogcapi.ItemsQuery{Limit: 2}, func(f *ogcapi.Feature) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestItemsFilters(t *testing.T) {
+	client, _ := newClient(t, 0)
+	ctx := context.Background()
+
+	page, err := client.Items(ctx, ogcapitest.Collection, ogcapi.ItemsQuery{
+		Properties: map[string]string{"name": "Tampere"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"837"}, featureIDs(page.Features))
+
+	// The same box in latitude/longitude order selects the same features
+	page, err = client.Items(ctx, ogcapitest.Collection, ogcapi.ItemsQuery{BBox: []float64{24.9, 60.15, 25.0, 60.2}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"091"}, featureIDs(page.Features))
+	page, err = client.Items(ctx, ogcapitest.Collection, ogcapi.ItemsQuery{
+		BBox:    []float64{60.15, 24.9, 60.2, 25.0},
+		BBoxCRS: ogcapi.EPSG4326,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"091"}, featureIDs(page.Features))
+}
+
+func TestRetries(t *testing.T) {
+	client, server := newClient(t, 2)
+	server.FailRequests.Store(2)
+
+	feature, err := client.Item(context.Background(), ogcapitest.Collection, "091", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Helsinki", feature.Properties["name"])
+	assert.Equal(t, int64(3), server.Requests.Load())
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	client, server := newClient(t, 1)
+	server.FailRequests.Store(2)
+
+	_, err := client.Item(context.Background(), ogcapitest.Collection, "091", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, int64(2), server.Requests.Load())
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	client, server := newClient(t, 3)
+
+	_, err := client.Item(context.Background(), ogcapitest.Collection, "999", "")
+	assert.ErrorIs(t, err, ogcapi.ErrNotFound)
+	assert.Equal(t, int64(1), server.Requests.Load())
+
+	_, err = client.Collection(context.Background(), "rivers")
+	assert.ErrorIs(t, err, ogcapi.ErrNotFound)
+}
+
+func TestItemCRS(t *testing.T) {
+	client, _ := newClient(t, 0)
+	ctx := context.Background()
+
+	feature, err := client.Item(ctx, ogcapitest.Collection, "091", "")
+	require.NoError(t, err)
+	assert.Equal(t, ogcapi.CRS84, feature.CRS)
+	ring := feature.Geometry.Coordinates.([]interface{})[0].([]interface{})
+	assert.Equal(t, []interface{}{24.78, 60.13}, ring[0])
+
+	// EPSG:4326 is answered in latitude/longitude order
+	feature, err = client.Item(ctx, ogcapitest.Collection, "091", ogcapi.EPSG4326)
+	require.NoError(t, err)
+	assert.Equal(t, ogcapi.EPSG4326, feature.CRS)
+	ring = feature.Geometry.Coordinates.([]interface{})[0].([]interface{})
+	assert.Equal(t, []interface{}{60.13, 24.78}, ring[0])
+
+	page, err := client.Items(ctx, ogcapitest.Collection, ogcapi.ItemsQuery{Limit: 2, CRS: ogcapi.EPSG4326})
+	require.NoError(t, err)
+	assert.Equal(t, ogcapi.EPSG4326, page.CRS)
+	next, err := client.NextPage(ctx, page)
+	require.NoError(t, err)
+	assert.Equal(t, ogcapi.EPSG4326, next.CRS)
+	for _, f := range next.Features {
+		assert.Equal(t, ogcapi.EPSG4326, f.CRS)
+	}
+}
+
+func TestUnsupportedCRS(t *testing.T) {
+	client, server := newClient(t, 0)
+	const etrsTM35FIN = "http://www.opengis.net/def/crs/EPSG/0/3067"
+
+	_, err := client.Item(context.Background(), ogcapitest.Collection, "091", etrsTM35FIN)
+	assert.ErrorIs(t, err, ogcapi.ErrUnsupportedCRS)
+	_, err = client.Items(context.Background(), ogcapitest.Collection, ogcapi.ItemsQuery{CRS: etrsTM35FIN})
+	assert.ErrorIs(t, err, ogcapi.ErrUnsupportedCRS)
+
+	// Conformance is fetched once; the collection is checked on every request
+	assert.Equal(t, int64(3), server.Requests.Load())
+}
+
+func TestItemByHref(t *testing.T) {
+	client, _ := newClient(t, 0)
+
+	feature, err := client.ItemByHref(context.Background(), "collections/"+ogcapitest.Collection+"/items/235")
+	require.NoError(t, err)
+	assert.Equal(t, "Kauniainen", feature.Properties["name"])
+
+	feature, err = client.ItemByHref(context.Background(), client.ItemURL(ogcapitest.Collection, "837"))
+	require.NoError(t, err)
+	assert.Equal(t, "Tampere", feature.Properties["name"])
+}
@@ -0,0 +1,430 @@
+// Package ogcapitest serves an in-memory OGC API - Features service seeded with
+// a few Finnish municipalities, so the client and the feature services can be
+// exercised without network access.
+package ogcapitest
+
+import (
+	"embed"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi"
+)
+
+// Collection is the identifier of the seeded collection
+const Collection = "municipalities"
+
+// Page size limits of the items endpoint
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+//go:embed testdata
+var fixtures embed.FS
+
+// Server is a local OGC API - Features service
+type Server struct {
+	*httptest.Server
+
+	// Requests counts the requests served
+	Requests atomic.Int64
+	// FailRequests makes that many upcoming requests answer 503, to exercise retries
+	FailRequests atomic.Int32
+
+	mu       sync.RWMutex
+	features map[string]map[string]ogcapi.Feature
+}
+
+// NewServer starts a server seeded with the fixture features; callers must Close it
+func NewServer() *Server {
+	s := &Server{features: map[string]map[string]ogcapi.Feature{Collection: {}}}
+
+	data, err := fixtures.ReadFile("testdata/" + Collection + ".json")
+	if err != nil {
+		panic(err)
+	}
+	var seed ogcapi.FeatureCollection
+	if err := json.Unmarshal(data, &seed); err != nil {
+		panic(err)
+	}
+	for _, f := range seed.Features {
+		s.features[Collection][f.ID] = f
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// SetFeature adds or replaces a feature, creating its collection if needed
+func (s *Server) SetFeature(collection string, f ogcapi.Feature) {
+	// Round-trip through JSON so coordinates look as if they were decoded
+	data, err := json.Marshal(f)
+	if err != nil {
+		panic(err)
+	}
+	f = ogcapi.Feature{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.features[collection] == nil {
+		s.features[collection] = map[string]ogcapi.Feature{}
+	}
+	s.features[collection][f.ID] = f
+}
+
+// RemoveFeature deletes a feature, as if it was removed upstream
+func (s *Server) RemoveFeature(collection, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.features[collection], id)
+}
+
+// Feature returns a copy of a stored feature
+func (s *Server) Feature(collection, id string) (ogcapi.Feature, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.features[collection][id]
+	return f, ok
+}
+
+// serve routes a request
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.Requests.Add(1)
+	if n := s.FailRequests.Load(); n > 0 && s.FailRequests.CompareAndSwap(n, n-1) {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/":
+		s.writeJSON(w, "", map[string]interface{}{
+			"title": "Fixture features",
+			"links": []ogcapi.Link{
+				{Href: s.URL + "/conformance", Rel: "conformance"},
+				{Href: s.URL + "/collections", Rel: "data"},
+			},
+		})
+	case len(parts) == 1 && parts[0] == "conformance":
+		s.writeJSON(w, "", ogcapi.Conformance{ConformsTo: []string{
+			ogcapi.ConformanceCore, ogcapi.ConformanceGeoJSON, ogcapi.ConformanceCRS,
+		}})
+	case len(parts) == 1 && parts[0] == "collections":
+		s.mu.RLock()
+		collections := []ogcapi.Collection{}
+		for id := range s.features {
+			collections = append(collections, s.collection(id))
+		}
+		s.mu.RUnlock()
+		sort.Slice(collections, func(i, j int) bool { return collections[i].ID < collections[j].ID })
+		s.writeJSON(w, "", map[string]interface{}{"collections": collections})
+	case len(parts) >= 2 && parts[0] == "collections":
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		features, ok := s.features[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case len(parts) == 2:
+			s.writeJSON(w, "", s.collection(parts[1]))
+		case len(parts) == 3 && parts[2] == "items":
+			s.items(w, r, parts[1], features)
+		case len(parts) == 4 && parts[2] == "items":
+			f, ok := features[parts[3]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			crs, ok := requestedCRS(r.URL.Query().Get("crs"))
+			if !ok {
+				http.Error(w, "unsupported crs", http.StatusBadRequest)
+				return
+			}
+			s.writeJSON(w, crs, inCRS(f, crs))
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// collection describes a stored collection
+func (s *Server) collection(id string) ogcapi.Collection {
+	return ogcapi.Collection{
+		ID:         id,
+		Title:      id,
+		ItemType:   "feature",
+		CRS:        []string{ogcapi.CRS84, ogcapi.EPSG4326},
+		StorageCRS: ogcapi.CRS84,
+		Links: []ogcapi.Link{
+			{Href: s.URL + "/collections/" + id + "/items", Rel: "items", Type: "application/geo+json"},
+		},
+	}
+}
+
+// items serves a filtered page of features
+func (s *Server) items(w http.ResponseWriter, r *http.Request, collection string, features map[string]ogcapi.Feature) {
+	query := r.URL.Query()
+
+	crs, ok := requestedCRS(query.Get("crs"))
+	if !ok {
+		http.Error(w, "unsupported crs", http.StatusBadRequest)
+		return
+	}
+	limit, offset := defaultLimit, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxLimit)
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	var bbox []float64
+	if v := query.Get("bbox"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				http.Error(w, "invalid bbox", http.StatusBadRequest)
+				return
+			}
+			bbox = append(bbox, n)
+		}
+		if len(bbox) == 6 {
+			bbox = []float64{bbox[0], bbox[1], bbox[3], bbox[4]}
+		}
+		if len(bbox) != 4 {
+			http.Error(w, "invalid bbox", http.StatusBadRequest)
+			return
+		}
+		bboxCRS, ok := requestedCRS(query.Get("bbox-crs"))
+		if !ok {
+			http.Error(w, "unsupported bbox-crs", http.StatusBadRequest)
+			return
+		}
+		if bboxCRS == ogcapi.EPSG4326 {
+			bbox = []float64{bbox[1], bbox[0], bbox[3], bbox[2]}
+		}
+	}
+	start, end, ok := parseDatetime(query.Get("datetime"))
+	if !ok {
+		http.Error(w, "invalid datetime", http.StatusBadRequest)
+		return
+	}
+
+	ids := make([]string, 0, len(features))
+	for id := range features {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	matched := []ogcapi.Feature{}
+	for _, id := range ids {
+		f := features[id]
+		if bbox != nil && !intersects(bbox, featureBBox(f)) {
+			continue
+		}
+		if !inInterval(f, start, end) || !matchesProperties(f, query) {
+			continue
+		}
+		matched = append(matched, f)
+	}
+
+	page := matched[min(offset, len(matched)):min(offset+limit, len(matched))]
+	out := make([]ogcapi.Feature, len(page))
+	for i, f := range page {
+		out[i] = inCRS(f, crs)
+	}
+
+	numberMatched, numberReturned := len(matched), len(out)
+	fc := ogcapi.FeatureCollection{
+		Type:           "FeatureCollection",
+		Features:       out,
+		NumberMatched:  &numberMatched,
+		NumberReturned: &numberReturned,
+		TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if offset+limit < len(matched) {
+		next := url.Values{}
+		for k, v := range query {
+			next[k] = v
+		}
+		next.Set("offset", strconv.Itoa(offset+limit))
+		next.Set("limit", strconv.Itoa(limit))
+		fc.Links = append(fc.Links, ogcapi.Link{
+			Href: s.URL + "/collections/" + collection + "/items?" + next.Encode(),
+			Rel:  "next",
+			Type: "application/geo+json",
+		})
+	}
+	s.writeJSON(w, crs, fc)
+}
+
+// writeJSON writes a response, announcing the CRS of any geometries
+func (s *Server) writeJSON(w http.ResponseWriter, crs string, v interface{}) {
+	if crs != "" {
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Header().Set("Content-Crs", "<"+crs+">")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// requestedCRS validates a crs parameter, defaulting to CRS84
+func requestedCRS(crs string) (string, bool) {
+	switch crs {
+	case "", ogcapi.CRS84:
+		return ogcapi.CRS84, true
+	case ogcapi.EPSG4326:
+		return crs, true
+	default:
+		return "", false
+	}
+}
+
+// inCRS returns the feature with its geometry in crs; EPSG:4326 swaps the axes
+func inCRS(f ogcapi.Feature, crs string) ogcapi.Feature {
+	if crs != ogcapi.EPSG4326 || f.Geometry == nil {
+		return f
+	}
+	geometry := *f.Geometry
+	geometry.Coordinates = mapPositions(geometry.Coordinates, func(p []interface{}) []interface{} {
+		swapped := append([]interface{}{}, p...)
+		swapped[0], swapped[1] = p[1], p[0]
+		return swapped
+	})
+	f.Geometry = &geometry
+	return f
+}
+
+// mapPositions applies fn to every position of decoded GeoJSON coordinates
+func mapPositions(coords interface{}, fn func([]interface{}) []interface{}) interface{} {
+	items, ok := coords.([]interface{})
+	if !ok || len(items) == 0 {
+		return coords
+	}
+	if _, isNumber := items[0].(float64); isNumber {
+		return fn(items)
+	}
+	mapped := make([]interface{}, len(items))
+	for i, item := range items {
+		mapped[i] = mapPositions(item, fn)
+	}
+	return mapped
+}
+
+// featureBBox computes the bounding box of a feature's geometry
+func featureBBox(f ogcapi.Feature) []float64 {
+	if f.Geometry == nil {
+		return nil
+	}
+	bbox := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	mapPositions(f.Geometry.Coordinates, func(p []interface{}) []interface{} {
+		x, _ := p[0].(float64)
+		y, _ := p[1].(float64)
+		bbox[0], bbox[1] = math.Min(bbox[0], x), math.Min(bbox[1], y)
+		bbox[2], bbox[3] = math.Max(bbox[2], x), math.Max(bbox[3], y)
+		return p
+	})
+	return bbox
+}
+
+// intersects reports whether two boxes overlap
+func intersects(a, b []float64) bool {
+	return b != nil && a[0] <= b[2] && b[0] <= a[2] && a[1] <= b[3] && b[1] <= a[3]
+}
+
+// parseDatetime parses an instant or an interval with open ".." ends
+func parseDatetime(v string) (time.Time, time.Time, bool) {
+	if v == "" {
+		return time.Time{}, time.Time{}, true
+	}
+	bounds := strings.SplitN(v, "/", 2)
+	parse := func(s string) (time.Time, bool) {
+		if s == ".." || s == "" {
+			return time.Time{}, true
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		return t, err == nil
+	}
+	start, ok := parse(bounds[0])
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	if len(bounds) == 1 {
+		return start, start, true
+	}
+	end, ok := parse(bounds[1])
+	return start, end, ok
+}
+
+// inInterval reports whether a feature's datetime property lies within [start, end]
+func inInterval(f ogcapi.Feature, start, end time.Time) bool {
+	if start.IsZero() && end.IsZero() {
+		return true
+	}
+	v, _ := f.Properties["datetime"].(string)
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return false
+	}
+	return (start.IsZero() || !t.Before(start)) && (end.IsZero() || !t.After(end))
+}
+
+// reserved are the items parameters that are not property filters
+var reserved = map[string]bool{
+	"bbox": true, "bbox-crs": true, "datetime": true, "limit": true, "offset": true, "crs": true, "f": true,
+}
+
+// matchesProperties applies equality filters on feature properties
+func matchesProperties(f ogcapi.Feature, query url.Values) bool {
+	for name, values := range query {
+		if reserved[name] {
+			continue
+		}
+		v, ok := f.Properties[name]
+		if !ok {
+			return false
+		}
+		var s string
+		switch val := v.(type) {
+		case string:
+			s = val
+		case float64:
+			s = strconv.FormatFloat(val, 'f', -1, 64)
+		default:
+			b, _ := json.Marshal(val)
+			s = string(b)
+		}
+		if s != values[0] {
+			return false
+		}
+	}
+	return true
+}
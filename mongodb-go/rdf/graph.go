@@ -0,0 +1,235 @@
+package rdf
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Namespaces of the vocabularies used by the exports
+const (
+	RDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	RDFS      = "http://www.w3.org/2000/01/rdf-schema#"
+	XSD       = "http://www.w3.org/2001/XMLSchema#"
+	OWL       = "http://www.w3.org/2002/07/owl#"
+	SKOS      = "http://www.w3.org/2004/02/skos/core#"
+	SOSA      = "http://www.w3.org/ns/sosa/"
+	SSN       = "http://www.w3.org/ns/ssn/"
+	GeoSPARQL = "http://www.opengis.net/ont/geosparql#"
+	DCTerms   = "http://purl.org/dc/terms/"
+)
+
+// Type is the rdf:type predicate
+const Type = RDF + "type"
+
+// DefaultPrefixes maps the conventional prefix of each vocabulary to its namespace
+var DefaultPrefixes = map[string]string{
+	"rdf":     RDF,
+	"rdfs":    RDFS,
+	"xsd":     XSD,
+	"owl":     OWL,
+	"skos":    SKOS,
+	"sosa":    SOSA,
+	"ssn":     SSN,
+	"geo":     GeoSPARQL,
+	"dcterms": DCTerms,
+}
+
+// localName matches the local parts that may follow a prefix in Turtle and JSON-LD
+var localName = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_.-]*[A-Za-z0-9_-])?$`)
+
+// TermKind distinguishes IRIs, blank nodes and literals
+type TermKind int
+
+// Kinds of term
+const (
+	KindIRI TermKind = iota
+	KindBlank
+	KindLiteral
+)
+
+// Term is a node or value of a graph. Literals carry either a datatype IRI or a
+// language tag; a literal with neither is a plain string.
+type Term struct {
+	Kind     TermKind
+	Value    string
+	Datatype string
+	Lang     string
+}
+
+// IRI returns an IRI term
+func IRI(iri string) Term {
+	return Term{Kind: KindIRI, Value: iri}
+}
+
+// Literal returns a plain string literal
+func Literal(value string) Term {
+	return Term{Kind: KindLiteral, Value: value}
+}
+
+// TypedLiteral returns a literal of a datatype
+func TypedLiteral(value, datatype string) Term {
+	return Term{Kind: KindLiteral, Value: value, Datatype: datatype}
+}
+
+// LangLiteral returns a literal in a language
+func LangLiteral(value, lang string) Term {
+	return Term{Kind: KindLiteral, Value: value, Lang: lang}
+}
+
+// Triple is one statement of a graph
+type Triple struct {
+	Subject   Term
+	Predicate Term
+	Object    Term
+}
+
+// Graph is an ordered set of triples with the prefixes used to abbreviate them.
+// Subjects are serialized in the order they were first added.
+type Graph struct {
+	prefixes map[string]string
+	triples  []Triple
+	seen     map[Triple]bool
+	blanks   int
+}
+
+// NewGraph creates an empty graph bound to the default prefixes
+func NewGraph() *Graph {
+	g := &Graph{prefixes: map[string]string{}, seen: map[Triple]bool{}}
+	for prefix, namespace := range DefaultPrefixes {
+		g.prefixes[prefix] = namespace
+	}
+	return g
+}
+
+// Bind declares a prefix for a namespace
+func (g *Graph) Bind(prefix, namespace string) {
+	g.prefixes[prefix] = namespace
+}
+
+// Add adds a triple unless the graph already holds it
+func (g *Graph) Add(subject, predicate, object Term) {
+	t := Triple{Subject: subject, Predicate: predicate, Object: object}
+	if g.seen[t] {
+		return
+	}
+	g.seen[t] = true
+	g.triples = append(g.triples, t)
+}
+
+// Merge adds the triples and prefixes of another graph. Blank nodes of the
+// other graph are renamed so they stay distinct from those of this one.
+func (g *Graph) Merge(o *Graph) {
+	for prefix, namespace := range o.prefixes {
+		g.prefixes[prefix] = namespace
+	}
+	renamed := map[string]Term{}
+	rename := func(t Term) Term {
+		if t.Kind != KindBlank {
+			return t
+		}
+		if n, ok := renamed[t.Value]; ok {
+			return n
+		}
+		n := g.BlankNode()
+		renamed[t.Value] = n
+		return n
+	}
+	for _, t := range o.triples {
+		g.Add(rename(t.Subject), t.Predicate, rename(t.Object))
+	}
+}
+
+// BlankNode returns a blank node not used elsewhere in the graph
+func (g *Graph) BlankNode() Term {
+	g.blanks++
+	return Term{Kind: KindBlank, Value: fmt.Sprintf("b%d", g.blanks)}
+}
+
+// Len returns the number of triples
+func (g *Graph) Len() int {
+	return len(g.triples)
+}
+
+// Triples returns the triples in the order they were added
+func (g *Graph) Triples() []Triple {
+	return g.triples
+}
+
+// Expand resolves a compact IRI such as skos:exactMatch against the bound prefixes
+func (g *Graph) Expand(curie string) (string, bool) {
+	prefix, local, ok := strings.Cut(curie, ":")
+	if !ok || strings.HasPrefix(local, "//") {
+		return "", false
+	}
+	namespace, ok := g.prefixes[prefix]
+	if !ok {
+		return "", false
+	}
+	return namespace + local, true
+}
+
+// compact abbreviates an IRI with the longest matching prefix
+func (g *Graph) compact(iri string) (string, bool) {
+	best, bestNamespace := "", ""
+	for prefix, namespace := range g.prefixes {
+		if len(namespace) > len(bestNamespace) && strings.HasPrefix(iri, namespace) &&
+			localName.MatchString(iri[len(namespace):]) {
+			best, bestNamespace = prefix, namespace
+		}
+	}
+	if bestNamespace == "" {
+		return "", false
+	}
+	return best + ":" + iri[len(bestNamespace):], true
+}
+
+// sortedPrefixes returns the bound prefixes in alphabetical order
+func (g *Graph) sortedPrefixes() []string {
+	prefixes := make([]string, 0, len(g.prefixes))
+	for prefix := range g.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// statement is the triples of one subject and predicate
+type statement struct {
+	predicate Term
+	objects   []Term
+}
+
+// description is the statements made about one subject
+type description struct {
+	subject    Term
+	statements []*statement
+}
+
+// descriptions groups the triples by subject and predicate, keeping their order
+func (g *Graph) descriptions() []*description {
+	var out []*description
+	bySubject := map[Term]*description{}
+	for _, t := range g.triples {
+		d, ok := bySubject[t.Subject]
+		if !ok {
+			d = &description{subject: t.Subject}
+			bySubject[t.Subject] = d
+			out = append(out, d)
+		}
+		var st *statement
+		for _, existing := range d.statements {
+			if existing.predicate == t.Predicate {
+				st = existing
+				break
+			}
+		}
+		if st == nil {
+			st = &statement{predicate: t.Predicate}
+			d.statements = append(d.statements, st)
+		}
+		st.objects = append(st.objects, t.Object)
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package rdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONLDContentType is the media type of JSON-LD documents
+const JSONLDContentType = "application/ld+json"
+
+// JSONLD returns the graph as a JSON-LD document: a context of the bound
+// prefixes and a flattened @graph with one node object per subject
+func (g *Graph) JSONLD() map[string]interface{} {
+	context := map[string]interface{}{}
+	for prefix, namespace := range g.prefixes {
+		context[prefix] = namespace
+	}
+
+	nodes := []map[string]interface{}{}
+	for _, d := range g.descriptions() {
+		node := map[string]interface{}{"@id": g.nodeID(d.subject)}
+		for _, st := range d.statements {
+			if st.predicate.Value == Type {
+				types := make([]interface{}, 0, len(st.objects))
+				for _, object := range st.objects {
+					types = append(types, g.vocabIRI(object.Value))
+				}
+				node["@type"] = single(types)
+				continue
+			}
+
+			values := make([]interface{}, 0, len(st.objects))
+			for _, object := range st.objects {
+				values = append(values, g.jsonLDValue(object))
+			}
+			node[g.vocabIRI(st.predicate.Value)] = single(values)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return map[string]interface{}{
+		"@context": context,
+		"@graph":   nodes,
+	}
+}
+
+// WriteJSONLD serializes the graph as JSON-LD
+func (g *Graph) WriteJSONLD(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(g.JSONLD()); err != nil {
+		return fmt.Errorf("failed to write JSON-LD: %w", err)
+	}
+	return nil
+}
+
+// jsonLDValue renders an object as a node reference or value object
+func (g *Graph) jsonLDValue(t Term) interface{} {
+	switch {
+	case t.Kind != KindLiteral:
+		return map[string]interface{}{"@id": g.nodeID(t)}
+	case t.Lang != "":
+		return map[string]interface{}{"@value": t.Value, "@language": t.Lang}
+	case t.Datatype != "":
+		return map[string]interface{}{"@value": t.Value, "@type": g.vocabIRI(t.Datatype)}
+	default:
+		return t.Value
+	}
+}
+
+// nodeID renders the identifier of a node, writing IRIs in full
+func (g *Graph) nodeID(t Term) string {
+	if t.Kind == KindBlank {
+		return "_:" + t.Value
+	}
+	return t.Value
+}
+
+// vocabIRI abbreviates a property, class or datatype IRI where a prefix matches
+func (g *Graph) vocabIRI(iri string) string {
+	if curie, ok := g.compact(iri); ok {
+		return curie
+	}
+	return iri
+}
+
+// single unwraps a list of one value
+func single(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
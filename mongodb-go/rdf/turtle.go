@@ -0,0 +1,79 @@
+package rdf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TurtleContentType is the media type of Turtle documents
+const TurtleContentType = "text/turtle"
+
+// iriEscaper percent-encodes the characters Turtle does not allow in an IRI
+var iriEscaper = strings.NewReplacer(
+	" ", "%20", "<", "%3C", ">", "%3E", `"`, "%22", "{", "%7B", "}", "%7D",
+	"|", "%7C", "^", "%5E", "`", "%60", `\`, "%5C",
+)
+
+// stringEscaper escapes the characters of a Turtle string literal
+var stringEscaper = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`,
+)
+
+// WriteTurtle serializes the graph as Turtle, one block of statements per subject
+func (g *Graph) WriteTurtle(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, prefix := range g.sortedPrefixes() {
+		fmt.Fprintf(bw, "@prefix %s: <%s> .\n", prefix, iriEscaper.Replace(g.prefixes[prefix]))
+	}
+
+	for _, d := range g.descriptions() {
+		fmt.Fprintf(bw, "\n%s", g.turtleTerm(d.subject))
+		for i, st := range d.statements {
+			if i > 0 {
+				bw.WriteString(" ;\n   ")
+			}
+			predicate := "a"
+			if st.predicate.Value != Type {
+				predicate = g.turtleTerm(st.predicate)
+			}
+			fmt.Fprintf(bw, " %s ", predicate)
+			for j, object := range st.objects {
+				if j > 0 {
+					bw.WriteString(" , ")
+				}
+				bw.WriteString(g.turtleTerm(object))
+			}
+		}
+		bw.WriteString(" .\n")
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write turtle: %w", err)
+	}
+	return nil
+}
+
+// turtleTerm renders a term, abbreviating IRIs where a prefix matches
+func (g *Graph) turtleTerm(t Term) string {
+	switch t.Kind {
+	case KindBlank:
+		return "_:" + t.Value
+	case KindLiteral:
+		s := `"` + stringEscaper.Replace(t.Value) + `"`
+		switch {
+		case t.Lang != "":
+			return s + "@" + t.Lang
+		case t.Datatype != "":
+			return s + "^^" + g.turtleTerm(IRI(t.Datatype))
+		}
+		return s
+	default:
+		if curie, ok := g.compact(t.Value); ok {
+			return curie
+		}
+		return "<" + iriEscaper.Replace(t.Value) + ">"
+	}
+}
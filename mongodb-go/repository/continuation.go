@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// tokenVersion is bumped whenever the token layout changes
+const tokenVersion = 1
+
+// tokenLength is version + direction + milliseconds + ObjectID
+const tokenLength = 1 + 1 + 8 + 12
+
+// ErrInvalidToken is returned for malformed continuation tokens. Tokens are
+// not signed; an edited token only moves where reading resumes.
+var ErrInvalidToken = errors.New("invalid continuation token")
+
+// ErrStopStream may be returned by a stream callback to end iteration early without error
+var ErrStopStream = errors.New("stop stream")
+
+// ContinuationToken marks the position just after the last observation read,
+// keyed on (phenomenonTime, _id) in the direction of iteration
+type ContinuationToken struct {
+	PhenomenonTime time.Time
+	ID             primitive.ObjectID
+	Ascending      bool
+}
+
+// NewContinuationToken creates a token positioned after an observation
+func NewContinuationToken(obs *models.Observation, ascending bool) ContinuationToken {
+	return ContinuationToken{
+		PhenomenonTime: obs.PhenomenonTime,
+		ID:             obs.ID,
+		Ascending:      ascending,
+	}
+}
+
+// Encode serializes the token into an opaque URL-safe string
+func (t ContinuationToken) Encode() string {
+	buf := make([]byte, tokenLength)
+	buf[0] = tokenVersion
+	if t.Ascending {
+		buf[1] = 1
+	}
+	binary.BigEndian.PutUint64(buf[2:10], uint64(t.PhenomenonTime.UnixMilli()))
+	copy(buf[10:], t.ID[:])
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// DecodeContinuationToken parses a token produced by Encode
+func DecodeContinuationToken(s string) (ContinuationToken, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(buf) != tokenLength || buf[0] != tokenVersion || buf[1] > 1 {
+		return ContinuationToken{}, ErrInvalidToken
+	}
+
+	var token ContinuationToken
+	token.Ascending = buf[1] == 1
+	token.PhenomenonTime = time.UnixMilli(int64(binary.BigEndian.Uint64(buf[2:10]))).UTC()
+	copy(token.ID[:], buf[10:])
+	return token, nil
+}
+
+// Filter selects the observations that follow the token position
+func (t ContinuationToken) Filter() bson.M {
+	op := "$lt"
+	if t.Ascending {
+		op = "$gt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"phenomenonTime": bson.M{op: t.PhenomenonTime}},
+		bson.M{"phenomenonTime": t.PhenomenonTime, "_id": bson.M{op: t.ID}},
+	}}
+}
+
+// KeysetSort returns the (phenomenonTime, _id) ordering continuation tokens rely on
+func KeysetSort(ascending bool) bson.D {
+	direction := -1
+	if ascending {
+		direction = 1
+	}
+	return bson.D{{Key: "phenomenonTime", Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// StreamOptions controls keyset iteration over observations
+type StreamOptions struct {
+	// After resumes iteration after the position of a previous continuation token
+	After string
+	// Ascending iterates oldest first; the default is newest first
+	Ascending bool
+	// BatchSize is the number of documents fetched per round trip (driver default when zero)
+	BatchSize int32
+}
+
+// keyset resolves the filter and sort for the options, validating any token
+func (o StreamOptions) keyset(filter bson.M) (bson.M, bson.D, error) {
+	if o.After == "" {
+		return filter, KeysetSort(o.Ascending), nil
+	}
+
+	token, err := DecodeContinuationToken(o.After)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.Ascending != o.Ascending {
+		return nil, nil, fmt.Errorf("%w: token direction does not match request", ErrInvalidToken)
+	}
+	return bson.M{"$and": bson.A{filter, token.Filter()}}, KeysetSort(o.Ascending), nil
+}
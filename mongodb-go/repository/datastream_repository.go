@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/units"
+)
+
+// DatastreamRepository handles datastream data operations
+type DatastreamRepository struct {
+	collection *mongo.Collection
+	database   *mongo.Database
+}
+
+// NewDatastreamRepository creates a new datastream repository
+func NewDatastreamRepository(db *mongo.Database) *DatastreamRepository {
+	return &DatastreamRepository{
+		collection: db.Collection("datastreams"),
+		database:   db,
+	}
+}
+
+// Create adds a new datastream after verifying its thing, sensor and observed property
+func (r *DatastreamRepository) Create(ctx context.Context, ds *models.Datastream) error {
+	if err := r.checkReferences(ctx, ds); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	ds.CreatedAt = now
+	ds.UpdatedAt = now
+
+	// New datastreams start as the current version of an open-ended SCD Type 2 record
+	if ds.ValidFrom.IsZero() {
+		ds.ValidFrom = now
+	}
+	if ds.ValidTo.IsZero() {
+		ds.ValidTo = models.EndOfTime
+		ds.IsCurrent = true
+	}
+	if ds.Version == 0 {
+		ds.Version = 1
+	}
+
+	return insertDocument(ctx, r.collection, ds.ID, ds)
+}
+
+// FindByID retrieves a datastream by its identifier
+func (r *DatastreamRepository) FindByID(ctx context.Context, id string) (*models.Datastream, error) {
+	var ds models.Datastream
+	if err := findDocument(ctx, r.collection, id, &ds); err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
+
+// Meta returns the metadata of a datastream as denormalized into its observations,
+// placing them at the current location of its thing
+func (r *DatastreamRepository) Meta(ctx context.Context, id string) (*models.DatastreamMeta, error) {
+	ds, err := r.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var thing models.Thing
+	if err := findDocument(ctx, r.database.Collection("things"), ds.ThingID, &thing); err != nil {
+		return nil, err
+	}
+
+	meta := &models.DatastreamMeta{
+		DatastreamID:       ds.ID,
+		ThingID:            ds.ThingID,
+		SensorID:           ds.SensorID,
+		ObservedPropertyID: ds.ObservedPropertyID,
+		UnitOfMeasurement:  ds.UnitOfMeasurement,
+	}
+	if thing.CurrentLocation != nil {
+		meta.LocationID = thing.CurrentLocation.LocationID
+	}
+	return meta, nil
+}
+
+// Find retrieves datastreams matching a query
+func (r *DatastreamRepository) Find(ctx context.Context, query Query) ([]models.Datastream, error) {
+	datastreams := []models.Datastream{}
+	if err := findDocuments(ctx, r.collection, query, &datastreams); err != nil {
+		return nil, err
+	}
+	return datastreams, nil
+}
+
+// FindByQuantity retrieves the datastreams whose unit of measurement is any unit
+// of a quantity (see UnitRepository.FindByQuantity). A datastream unit matches a
+// cached unit by UCUM code or alternative label in its symbol, or by URI in its
+// definition. Symbols are compared as written and as mapped to UCUM by
+// units.SymbolToUCUM, so "°C" matches Cel and "µg/m³" matches ug/m3.
+func (r *DatastreamRepository) FindByQuantity(ctx context.Context, quantity string) ([]models.Datastream, error) {
+	quantityUnits, err := NewUnitRepository(r.database).FindByQuantity(ctx, quantity)
+	if err != nil {
+		return nil, err
+	}
+	datastreams := []models.Datastream{}
+	if len(quantityUnits) == 0 {
+		return datastreams, nil
+	}
+	uris := make([]string, len(quantityUnits))
+	codes := map[string]bool{}
+	for i, unit := range quantityUnits {
+		uris[i] = unit.URI
+		codes[unit.UCUMCode] = true
+		for _, label := range unit.Labels.Alternative {
+			codes[label.Value] = true
+		}
+	}
+
+	// Free-text symbols are normalized here, as the server cannot map them to UCUM
+	values, err := r.collection.Distinct(ctx, "unitOfMeasurement.symbol", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find datastream unit symbols: %w", err)
+	}
+	symbols := bson.A{}
+	for _, value := range values {
+		symbol, ok := value.(string)
+		if ok && (codes[symbol] || codes[units.SymbolToUCUM(symbol)]) {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	err = findDocuments(ctx, r.collection, Query{
+		Filter: bson.M{"$or": bson.A{
+			bson.M{"unitOfMeasurement.symbol": bson.M{"$in": symbols}},
+			bson.M{"unitOfMeasurement.definition": bson.M{"$in": uris}},
+		}},
+		Sort: bson.D{{Key: "_id", Value: 1}},
+	}, &datastreams)
+	if err != nil {
+		return nil, err
+	}
+	return datastreams, nil
+}
+
+// Update replaces an existing datastream after verifying its references
+func (r *DatastreamRepository) Update(ctx context.Context, ds *models.Datastream) error {
+	if err := r.checkReferences(ctx, ds); err != nil {
+		return err
+	}
+
+	ds.UpdatedAt = time.Now().UTC()
+	return replaceDocument(ctx, r.collection, ds.ID, ds)
+}
+
+// Delete removes a datastream that has no observations
+func (r *DatastreamRepository) Delete(ctx context.Context, id string) error {
+	if err := checkUnreferenced(ctx, r.database.Collection("observations"), "datastream.datastreamId", id); err != nil {
+		return err
+	}
+	return deleteDocument(ctx, r.collection, id)
+}
+
+// checkReferences verifies that the thing, sensor and observed property of a datastream exist
+func (r *DatastreamRepository) checkReferences(ctx context.Context, ds *models.Datastream) error {
+	if ds.ThingID == "" || ds.SensorID == "" || ds.ObservedPropertyID == "" {
+		return errors.New("datastream requires a thing, a sensor and an observed property")
+	}
+
+	if err := checkExists(ctx, r.database.Collection("things"), ds.ThingID); err != nil {
+		return err
+	}
+	if err := checkExists(ctx, r.database.Collection("sensors"), ds.SensorID); err != nil {
+		return err
+	}
+	return checkExists(ctx, r.database.Collection("observed_properties"), ds.ObservedPropertyID)
+}
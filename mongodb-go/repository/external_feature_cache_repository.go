@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// ExternalFeatureCacheRepository handles the external_feature_cache of OGC API features,
+// and the reports of its synchronization runs in feature_sync_reports
+type ExternalFeatureCacheRepository struct {
+	collection *mongo.Collection
+	reports    *mongo.Collection
+}
+
+// NewExternalFeatureCacheRepository creates a new external feature cache repository
+func NewExternalFeatureCacheRepository(db *mongo.Database) *ExternalFeatureCacheRepository {
+	return &ExternalFeatureCacheRepository{
+		collection: db.Collection("external_feature_cache"),
+		reports:    db.Collection("feature_sync_reports"),
+	}
+}
+
+// ExternalFeatureCacheID returns the cache key of a feature, e.g. "parcels/12345"
+func ExternalFeatureCacheID(collection, featureID string) string {
+	return collection + "/" + featureID
+}
+
+// FindByID retrieves a cached feature by its cache key
+func (r *ExternalFeatureCacheRepository) FindByID(ctx context.Context, id string) (*models.ExternalFeatureCache, error) {
+	var entry models.ExternalFeatureCache
+	if err := findDocument(ctx, r.collection, id, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Find retrieves cached features matching a query
+func (r *ExternalFeatureCacheRepository) Find(ctx context.Context, query Query) ([]models.ExternalFeatureCache, error) {
+	entries := []models.ExternalFeatureCache{}
+	if err := findDocuments(ctx, r.collection, query, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Save upserts the source, content and cache metadata of a feature; usage tracking is kept
+func (r *ExternalFeatureCacheRepository) Save(ctx context.Context, entry *models.ExternalFeatureCache) error {
+	update := bson.M{"$set": bson.M{
+		"source":  entry.Source,
+		"feature": entry.Feature,
+		"cache":   entry.Cache,
+	}}
+	if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": entry.ID}, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("failed to save cached feature %s: %w", entry.ID, err)
+	}
+	return nil
+}
+
+// MarkFetchError records a failed refresh; the cached content is kept
+func (r *ExternalFeatureCacheRepository) MarkFetchError(ctx context.Context, id string, fetchErr error, at time.Time) error {
+	update := bson.M{"$set": bson.M{
+		"cache.lastError":   fetchErr.Error(),
+		"cache.lastErrorAt": at,
+	}}
+	if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return fmt.Errorf("failed to mark cached feature %s: %w", id, err)
+	}
+	return nil
+}
+
+// RecordAccess counts a read of a cached feature, and the feature of interest it was read for
+func (r *ExternalFeatureCacheRepository) RecordAccess(ctx context.Context, id, foiID string, at time.Time) error {
+	update := bson.M{
+		"$inc": bson.M{"usage.accessCount": 1},
+		"$set": bson.M{"usage.lastAccessed": at},
+	}
+	if foiID != "" {
+		update["$addToSet"] = bson.M{"usage.referencedByFOIs": foiID}
+	}
+	if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return fmt.Errorf("failed to record access to cached feature %s: %w", id, err)
+	}
+	return nil
+}
+
+// Delete removes a cached feature
+func (r *ExternalFeatureCacheRepository) Delete(ctx context.Context, id string) error {
+	return deleteDocument(ctx, r.collection, id)
+}
+
+// SaveSyncReport stores the report of a synchronization run
+func (r *ExternalFeatureCacheRepository) SaveSyncReport(ctx context.Context, report *models.FeatureSyncReport) error {
+	result, err := r.reports.InsertOne(ctx, report)
+	if err != nil {
+		return fmt.Errorf("failed to save feature sync report: %w", err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		report.ID = id
+	}
+	return nil
+}
+
+// RecentSyncReports retrieves the reports of the latest synchronization runs, newest first
+func (r *ExternalFeatureCacheRepository) RecentSyncReports(ctx context.Context, limit int64) ([]models.FeatureSyncReport, error) {
+	reports := []models.FeatureSyncReport{}
+	query := Query{Sort: bson.D{{Key: "startedAt", Value: -1}}, Limit: limit}
+	if err := findDocuments(ctx, r.reports, query, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
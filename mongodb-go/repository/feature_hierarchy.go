@@ -0,0 +1,552 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// ErrHierarchyCycle is returned when a link would make a feature of interest its own ancestor
+var ErrHierarchyCycle = errors.New("hierarchy cycle")
+
+// Ancestors returns the features of interest above foiID, nearest first
+func (r *FeatureOfInterestRepository) Ancestors(ctx context.Context, foiID string) ([]models.FeatureHierarchyEntry, error) {
+	return r.walk(ctx, foiID, true)
+}
+
+// Descendants returns the features of interest below foiID, nearest first
+func (r *FeatureOfInterestRepository) Descendants(ctx context.Context, foiID string) ([]models.FeatureHierarchyEntry, error) {
+	return r.walk(ctx, foiID, false)
+}
+
+// SetParent links a feature of interest below a parent, refusing links that would form a cycle.
+// The parent lists the feature among its children.
+func (r *FeatureOfInterestRepository) SetParent(ctx context.Context, foiID, parentID string) error {
+	foi, err := r.FindByID(ctx, foiID)
+	if err != nil {
+		return err
+	}
+	if foi.Hierarchy == nil {
+		foi.Hierarchy = &models.FeatureHierarchy{}
+	}
+	for _, parent := range foi.Hierarchy.Parents {
+		if parent.FoiID == parentID {
+			return nil
+		}
+	}
+	foi.Hierarchy.Parents = append(foi.Hierarchy.Parents, models.HierarchyNode{FoiID: parentID})
+	return r.Update(ctx, foi)
+}
+
+// RemoveParent unlinks a feature of interest from a parent on both sides
+func (r *FeatureOfInterestRepository) RemoveParent(ctx context.Context, foiID, parentID string) error {
+	foi, err := r.FindByID(ctx, foiID)
+	if err != nil {
+		return err
+	}
+	if foi.Hierarchy == nil {
+		return fmt.Errorf("parent %s of %s: %w", parentID, foiID, ErrNotFound)
+	}
+
+	parents := foi.Hierarchy.Parents[:0]
+	for _, parent := range foi.Hierarchy.Parents {
+		if parent.FoiID != parentID {
+			parents = append(parents, parent)
+		}
+	}
+	if len(parents) == len(foi.Hierarchy.Parents) {
+		return fmt.Errorf("parent %s of %s: %w", parentID, foiID, ErrNotFound)
+	}
+	foi.Hierarchy.Parents = parents
+	return r.Update(ctx, foi)
+}
+
+// AggregateHierarchy aggregates the observations of a feature of interest and
+// every feature below it between start and end, by observed property
+func (r *FeatureOfInterestRepository) AggregateHierarchy(ctx context.Context, foiID string,
+	start, end time.Time) (*models.HierarchyStats, error) {
+
+	stats, err := r.aggregateNodes(ctx, []string{foiID}, "", start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", r.collection.Name(), foiID, ErrNotFound)
+	}
+	return &stats[0], nil
+}
+
+// AggregateByLevel aggregates observations between start and end up to every
+// feature of interest at a hierarchy level, such as "building": each gets the
+// statistics of itself and all features below it
+func (r *FeatureOfInterestRepository) AggregateByLevel(ctx context.Context, level string,
+	start, end time.Time) ([]models.HierarchyStats, error) {
+
+	nodes, err := r.levelNodes(ctx, level)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return []models.HierarchyStats{}, nil
+	}
+	return r.aggregateNodes(ctx, nodes, level, start, end)
+}
+
+// hierarchyLookup returns a $graphLookup stage following parent links up or down
+// from the input documents, not passing through the feature of interest exclude
+func hierarchyLookup(up bool, as, exclude string) bson.D {
+	lookup := bson.M{
+		"from":       "features_of_interest",
+		"as":         as,
+		"depthField": "depth",
+	}
+	if exclude != "" {
+		lookup["restrictSearchWithMatch"] = bson.M{"_id": bson.M{"$ne": exclude}}
+	}
+	if up {
+		lookup["startWith"] = "$hierarchy.parents.foiId"
+		lookup["connectFromField"] = "hierarchy.parents.foiId"
+		lookup["connectToField"] = "_id"
+	} else {
+		lookup["startWith"] = "$_id"
+		lookup["connectFromField"] = "_id"
+		lookup["connectToField"] = "hierarchy.parents.foiId"
+	}
+	return bson.D{{Key: "$graphLookup", Value: lookup}}
+}
+
+// walk returns the features of interest reachable from foiID along parent links
+func (r *FeatureOfInterestRepository) walk(ctx context.Context, foiID string, up bool) ([]models.FeatureHierarchyEntry, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": foiID}}},
+		hierarchyLookup(up, "found", ""),
+		{{Key: "$project", Value: bson.M{
+			"hierarchy":       1,
+			"found._id":       1,
+			"found.name":      1,
+			"found.hierarchy": 1,
+			"found.depth":     1,
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk hierarchy of %s: %w", foiID, err)
+	}
+	defer cursor.Close(ctx)
+
+	type node struct {
+		ID        string                   `bson:"_id"`
+		Name      string                   `bson:"name"`
+		Hierarchy *models.FeatureHierarchy `bson:"hierarchy"`
+		Depth     int                      `bson:"depth"`
+	}
+	var rows []struct {
+		ID        string                   `bson:"_id"`
+		Hierarchy *models.FeatureHierarchy `bson:"hierarchy"`
+		Found     []node                   `bson:"found"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode hierarchy of %s: %w", foiID, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", r.collection.Name(), foiID, ErrNotFound)
+	}
+
+	// Levels not stored on a feature itself are taken from the links pointing at it
+	levels := map[string]string{}
+	hierarchies := []*models.FeatureHierarchy{rows[0].Hierarchy}
+	for _, n := range rows[0].Found {
+		hierarchies = append(hierarchies, n.Hierarchy)
+	}
+	for _, h := range hierarchies {
+		if h == nil {
+			continue
+		}
+		links := h.Children
+		if up {
+			links = h.Parents
+		}
+		for _, link := range links {
+			if link.Level != "" {
+				levels[link.FoiID] = link.Level
+			}
+		}
+	}
+
+	entries := []models.FeatureHierarchyEntry{}
+	for _, n := range rows[0].Found {
+		if n.ID == foiID {
+			continue
+		}
+		entry := models.FeatureHierarchyEntry{FoiID: n.ID, Name: n.Name, Level: levels[n.ID], Depth: n.Depth + 1}
+		if n.Hierarchy != nil && n.Hierarchy.Level != "" {
+			entry.Level = n.Hierarchy.Level
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Depth != entries[j].Depth {
+			return entries[i].Depth < entries[j].Depth
+		}
+		return entries[i].FoiID < entries[j].FoiID
+	})
+	return entries, nil
+}
+
+// reachable returns ids and every feature of interest above (or below) them,
+// without passing through the feature of interest exclude
+func (r *FeatureOfInterestRepository) reachable(ctx context.Context, ids []string, up bool,
+	exclude string) (map[string]bool, error) {
+
+	found := map[string]bool{}
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": ids}}}},
+		hierarchyLookup(up, "found", exclude),
+		{{Key: "$project", Value: bson.M{"found": "$found._id"}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk hierarchy: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID    string   `bson:"_id"`
+		Found []string `bson:"found"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode hierarchy: %w", err)
+	}
+	for _, row := range rows {
+		found[row.ID] = true
+		for _, id := range row.Found {
+			found[id] = true
+		}
+	}
+	return found, nil
+}
+
+// resolveLinks checks that the parents and children of a feature of interest
+// exist and would not form a cycle, filling in their names and levels
+func (r *FeatureOfInterestRepository) resolveLinks(ctx context.Context, foi *models.FeatureOfInterest) error {
+	if foi.Hierarchy == nil {
+		return nil
+	}
+	parents, children := nodeIDs(foi.Hierarchy.Parents), nodeIDs(foi.Hierarchy.Children)
+	ids := append(append([]string{}, parents...), children...)
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, id := range ids {
+		if id == foi.ID {
+			return fmt.Errorf("%s cannot be linked to itself: %w", foi.ID, ErrHierarchyCycle)
+		}
+	}
+
+	linked, err := r.Find(ctx, Query{Filter: bson.M{"_id": bson.M{"$in": ids}}})
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*models.FeatureOfInterest, len(linked))
+	for i := range linked {
+		byID[linked[i].ID] = &linked[i]
+	}
+	for _, nodes := range [][]models.HierarchyNode{foi.Hierarchy.Parents, foi.Hierarchy.Children} {
+		for i := range nodes {
+			other, ok := byID[nodes[i].FoiID]
+			if !ok {
+				return fmt.Errorf("%s %s: %w", r.collection.Name(), nodes[i].FoiID, ErrInvalidReference)
+			}
+			if nodes[i].Name == "" {
+				nodes[i].Name = other.Name
+			}
+			if nodes[i].Level == "" && other.Hierarchy != nil {
+				nodes[i].Level = other.Hierarchy.Level
+			}
+		}
+	}
+
+	// A cycle through the feature would lead from one of its new parents up to
+	// one of its new children. Its stored links are about to be replaced, so
+	// neither walk passes through the feature itself.
+	above, err := r.reachable(ctx, parents, true, foi.ID)
+	if err != nil {
+		return err
+	}
+	below, err := r.reachable(ctx, children, false, foi.ID)
+	if err != nil {
+		return err
+	}
+	for id := range above {
+		if below[id] {
+			return fmt.Errorf("%s would be both above and below %s: %w", id, foi.ID, ErrHierarchyCycle)
+		}
+	}
+	return nil
+}
+
+// mirrorLinks makes the parents and children of a feature of interest list it
+// as their child and parent, and drops it from features it is no longer linked to.
+// A nil foi removes a deleted feature from every hierarchy it appeared in.
+func (r *FeatureOfInterestRepository) mirrorLinks(ctx context.Context, previous, foi *models.FeatureOfInterest) error {
+	if previous != nil && foi != nil && hierarchyKey(previous) == hierarchyKey(foi) {
+		return nil
+	}
+
+	id := ""
+	var parents, children []string
+	if foi != nil {
+		id = foi.ID
+		if foi.Hierarchy != nil {
+			parents, children = nodeIDs(foi.Hierarchy.Parents), nodeIDs(foi.Hierarchy.Children)
+		}
+	} else if previous != nil {
+		id = previous.ID
+	}
+
+	now := time.Now().UTC()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"hierarchy.parents.foiId": id},
+		bson.M{"hierarchy.children.foiId": id},
+	}}
+	if _, err := r.collection.UpdateMany(ctx, filter, bson.M{
+		"$pull": bson.M{"hierarchy.parents": bson.M{"foiId": id}, "hierarchy.children": bson.M{"foiId": id}},
+		"$set":  bson.M{"updated_at": now},
+	}); err != nil {
+		return fmt.Errorf("failed to unlink %s from its hierarchy: %w", id, err)
+	}
+	if foi == nil {
+		return nil
+	}
+
+	node := models.HierarchyNode{FoiID: foi.ID, Name: foi.Name}
+	if foi.Hierarchy != nil {
+		node.Level = foi.Hierarchy.Level
+	}
+	for field, ids := range map[string][]string{"hierarchy.children": parents, "hierarchy.parents": children} {
+		if len(ids) == 0 {
+			continue
+		}
+		if _, err := r.collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+			"$push": bson.M{field: node},
+			"$set":  bson.M{"updated_at": now},
+		}); err != nil {
+			return fmt.Errorf("failed to link %s into its hierarchy: %w", foi.ID, err)
+		}
+	}
+	return nil
+}
+
+// levelNodes returns the features of interest at a hierarchy level, whether
+// the level is stored on the feature or on the links pointing at it
+func (r *FeatureOfInterestRepository) levelNodes(ctx context.Context, level string) ([]string, error) {
+	atLevel := func(field string) bson.M {
+		return bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$" + field, bson.A{}}},
+				"cond":  bson.M{"$eq": bson.A{"$$this.level", level}},
+			}},
+			"in": "$$this.foiId",
+		}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"hierarchy.level": level},
+			bson.M{"hierarchy.parents.level": level},
+			bson.M{"hierarchy.children.level": level},
+		}}}},
+		{{Key: "$project", Value: bson.M{"ids": bson.M{"$concatArrays": bson.A{
+			bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$hierarchy.level", level}}, bson.A{"$_id"}, bson.A{}}},
+			atLevel("hierarchy.parents"),
+			atLevel("hierarchy.children"),
+		}}}}},
+		{{Key: "$unwind", Value: "$ids"}},
+		{{Key: "$group", Value: bson.M{"_id": "$ids"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s features: %w", level, err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode %s features: %w", level, err)
+	}
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// aggregateNodes aggregates observations up to each of the given features of interest.
+// Observations are grouped once per feature and observed property, then merged
+// into every node above the feature, so features shared by several nodes count in each.
+func (r *FeatureOfInterestRepository) aggregateNodes(ctx context.Context, nodes []string, level string,
+	start, end time.Time) ([]models.HierarchyStats, error) {
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": nodes}}}},
+		hierarchyLookup(false, "found", ""),
+		{{Key: "$project", Value: bson.M{"name": 1, "hierarchy.level": 1, "found": "$found._id"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk hierarchy: %w", err)
+	}
+	var rows []struct {
+		ID        string `bson:"_id"`
+		Name      string `bson:"name"`
+		Hierarchy *struct {
+			Level string `bson:"level"`
+		} `bson:"hierarchy"`
+		Found []string `bson:"found"`
+	}
+	err = cursor.All(ctx, &rows)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hierarchy: %w", err)
+	}
+
+	stats := make([]models.HierarchyStats, len(rows))
+	above := map[string][]int{}
+	for i, row := range rows {
+		stats[i] = models.HierarchyStats{FoiID: row.ID, Name: row.Name, Level: level, Metrics: models.MetricSet{}}
+		if row.Hierarchy != nil && row.Hierarchy.Level != "" {
+			stats[i].Level = row.Hierarchy.Level
+		}
+		members := map[string]bool{row.ID: true}
+		for _, id := range row.Found {
+			members[id] = true
+		}
+		stats[i].Features = len(members)
+		for id := range members {
+			above[id] = append(above[id], i)
+		}
+	}
+	if len(above) == 0 {
+		return stats, nil
+	}
+
+	features := make([]string, 0, len(above))
+	for id := range above {
+		features = append(features, id)
+	}
+	isNumber := bson.M{"$isNumber": "$result"}
+	value := bson.M{"$cond": bson.A{isNumber, bson.M{"$toDouble": "$result"}, nil}}
+	observed := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"featureOfInterestId": bson.M{"$in": features},
+			"phenomenonTime":      bson.M{"$gte": start, "$lt": end},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"foi":      "$featureOfInterestId",
+				"property": bson.M{"$ifNull": bson.A{"$datastream.observedPropertyId", "result"}},
+			},
+			"count":             bson.M{"$sum": 1},
+			"value_count":       bson.M{"$sum": bson.M{"$cond": bson.A{isNumber, 1, 0}}},
+			"sum":               bson.M{"$sum": value},
+			"sum_sq":            bson.M{"$sum": bson.M{"$multiply": bson.A{value, value}}},
+			"min":               bson.M{"$min": value},
+			"max":               bson.M{"$max": value},
+			"first_observation": bson.M{"$min": "$phenomenonTime"},
+			"last_observation":  bson.M{"$max": "$phenomenonTime"},
+		}}},
+	}
+	cursor, err = r.database.Collection("observations").Aggregate(ctx, observed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate observations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID struct {
+			FoiID    string `bson:"foi"`
+			Property string `bson:"property"`
+		} `bson:"_id"`
+		Count            int64     `bson:"count"`
+		ValueCount       int64     `bson:"value_count"`
+		Sum              float64   `bson:"sum"`
+		SumSquares       float64   `bson:"sum_sq"`
+		Min              *float64  `bson:"min"`
+		Max              *float64  `bson:"max"`
+		FirstObservation time.Time `bson:"first_observation"`
+		LastObservation  time.Time `bson:"last_observation"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode observation aggregates: %w", err)
+	}
+
+	observedBy := make([]map[string]bool, len(stats))
+	for _, g := range groups {
+		metrics := models.MetricStats{Count: g.Count, ValueCount: g.ValueCount, Sum: g.Sum, SumSquares: g.SumSquares}
+		if g.Min != nil && g.Max != nil {
+			metrics.Min, metrics.Max = *g.Min, *g.Max
+		}
+		metrics.Finalize()
+
+		for _, i := range above[g.ID.FoiID] {
+			s := &stats[i]
+			s.Metrics.Merge(models.MetricSet{g.ID.Property: metrics})
+			if s.FirstObservation.IsZero() || g.FirstObservation.Before(s.FirstObservation) {
+				s.FirstObservation = g.FirstObservation
+			}
+			if g.LastObservation.After(s.LastObservation) {
+				s.LastObservation = g.LastObservation
+			}
+			if observedBy[i] == nil {
+				observedBy[i] = map[string]bool{}
+			}
+			observedBy[i][g.ID.FoiID] = true
+		}
+	}
+	for i := range stats {
+		stats[i].ObservedFeatures = len(observedBy[i])
+	}
+	return stats, nil
+}
+
+// nodeIDs returns the feature of interest identifiers of hierarchy nodes
+func nodeIDs(nodes []models.HierarchyNode) []string {
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.FoiID
+	}
+	return ids
+}
+
+// hierarchyKey summarizes what other features of interest mirror of one: its name, level and links
+func hierarchyKey(foi *models.FeatureOfInterest) string {
+	if foi.Hierarchy == nil {
+		return foi.Name
+	}
+	parents, children := nodeIDs(foi.Hierarchy.Parents), nodeIDs(foi.Hierarchy.Children)
+	sort.Strings(parents)
+	sort.Strings(children)
+	return strings.Join([]string{
+		foi.Name,
+		foi.Hierarchy.Level,
+		strings.Join(parents, ","),
+		strings.Join(children, ","),
+	}, "|")
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/go-playground/validator/v10"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// validate checks model constraints declared in validate tags
+var validate = validator.New()
+
+// Reasons recorded when the feature synchronization closes an association
+const (
+	AssociationClosedGeometryChanged = "geometry_changed"
+	AssociationClosedRemoved         = "removed_upstream"
+)
+
+// FeatureOfInterestRepository handles feature of interest data operations
+type FeatureOfInterestRepository struct {
+	collection *mongo.Collection
+	database   *mongo.Database
+}
+
+// NewFeatureOfInterestRepository creates a new feature of interest repository
+func NewFeatureOfInterestRepository(db *mongo.Database) *FeatureOfInterestRepository {
+	return &FeatureOfInterestRepository{
+		collection: db.Collection("features_of_interest"),
+		database:   db,
+	}
+}
+
+// Create adds a new feature of interest and links it into the hierarchies of its parents and children
+func (r *FeatureOfInterestRepository) Create(ctx context.Context, foi *models.FeatureOfInterest) error {
+	if err := r.resolveLinks(ctx, foi); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	foi.CreatedAt = now
+	foi.UpdatedAt = now
+
+	if err := insertDocument(ctx, r.collection, foi.ID, foi); err != nil {
+		return err
+	}
+	return r.mirrorLinks(ctx, nil, foi)
+}
+
+// FindByID retrieves a feature of interest by its identifier
+func (r *FeatureOfInterestRepository) FindByID(ctx context.Context, id string) (*models.FeatureOfInterest, error) {
+	var foi models.FeatureOfInterest
+	if err := findDocument(ctx, r.collection, id, &foi); err != nil {
+		return nil, err
+	}
+	return &foi, nil
+}
+
+// Find retrieves features of interest matching a query
+func (r *FeatureOfInterestRepository) Find(ctx context.Context, query Query) ([]models.FeatureOfInterest, error) {
+	features := []models.FeatureOfInterest{}
+	if err := findDocuments(ctx, r.collection, query, &features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// Update replaces an existing feature of interest, keeping the features it
+// is linked to or unlinked from in the hierarchy consistent
+func (r *FeatureOfInterestRepository) Update(ctx context.Context, foi *models.FeatureOfInterest) error {
+	if err := r.resolveLinks(ctx, foi); err != nil {
+		return err
+	}
+
+	foi.UpdatedAt = time.Now().UTC()
+	var previous models.FeatureOfInterest
+	if err := swapDocument(ctx, r.collection, foi.ID, foi, &previous); err != nil {
+		return err
+	}
+	return r.mirrorLinks(ctx, &previous, foi)
+}
+
+// Delete removes a feature of interest that no observation refers to, along
+// with the hierarchy links other features hold to it
+func (r *FeatureOfInterestRepository) Delete(ctx context.Context, id string) error {
+	if err := checkUnreferenced(ctx, r.database.Collection("observations"), "featureOfInterestId", id); err != nil {
+		return err
+	}
+	foi, err := r.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := deleteDocument(ctx, r.collection, id); err != nil {
+		return err
+	}
+	return r.mirrorLinks(ctx, foi, nil)
+}
+
+// AddAssociation links a feature of interest to an external feature.
+// Only one open association per external feature is allowed at a time.
+func (r *FeatureOfInterestRepository) AddAssociation(ctx context.Context, foiID string, ext models.ExternalFeature) error {
+	now := time.Now().UTC()
+	if ext.Association.EstablishedAt.IsZero() {
+		ext.Association.EstablishedAt = now
+	}
+	if ext.Association.ValidFrom.IsZero() {
+		ext.Association.ValidFrom = ext.Association.EstablishedAt
+	}
+	ext.Association.ValidTo = nil
+
+	if ext.FeatureID == "" {
+		return fmt.Errorf("association requires an external feature id")
+	}
+	if err := validate.Struct(ext.Association); err != nil {
+		return fmt.Errorf("invalid association: %w", err)
+	}
+
+	filter := bson.M{
+		"_id":              foiID,
+		"externalFeatures": bson.M{"$not": bson.M{"$elemMatch": openAssociation(ext.FeatureID)}},
+	}
+	update := bson.M{
+		"$push": bson.M{"externalFeatures": ext},
+		"$set":  bson.M{"updated_at": now},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to add association: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		if _, err := r.FindByID(ctx, foiID); err != nil {
+			return err
+		}
+		return fmt.Errorf("open association of %s with %s: %w", foiID, ext.FeatureID, ErrAlreadyExists)
+	}
+	return nil
+}
+
+// CloseAssociation ends the open association with an external feature at validTo
+func (r *FeatureOfInterestRepository) CloseAssociation(ctx context.Context, foiID, featureID string, validTo time.Time) error {
+	closed, err := r.closeAssociation(ctx, foiID, featureID, validTo, "")
+	if err != nil {
+		return err
+	}
+	if !closed {
+		return fmt.Errorf("open association of %s with %s starting before %s: %w",
+			foiID, featureID, validTo.Format(time.RFC3339), ErrNotFound)
+	}
+	return nil
+}
+
+// InvalidateAssociation ends the open association with an external feature at
+// validTo because the feature changed, reporting whether there was one to end
+func (r *FeatureOfInterestRepository) InvalidateAssociation(ctx context.Context, foiID, featureID, reason string,
+	validTo time.Time) (bool, error) {
+
+	return r.closeAssociation(ctx, foiID, featureID, validTo, reason)
+}
+
+// closeAssociation sets validTo, and the reason if any, on the open association with an external feature
+func (r *FeatureOfInterestRepository) closeAssociation(ctx context.Context, foiID, featureID string, validTo time.Time,
+	reason string) (bool, error) {
+
+	filter := bson.M{
+		"_id":              foiID,
+		"externalFeatures": bson.M{"$elemMatch": openAssociation(featureID)},
+	}
+	set := bson.M{
+		"externalFeatures.$[ext].association.validTo": validTo,
+		"updated_at": time.Now().UTC(),
+	}
+	if reason != "" {
+		set["externalFeatures.$[ext].association.closedReason"] = reason
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{
+			"ext.featureId":             featureID,
+			"ext.association.validTo":   nil,
+			"ext.association.validFrom": bson.M{"$lte": validTo},
+		}},
+	})
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": set}, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to close association: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// FindByAssociationType retrieves features of interest with an association of the given type.
+// A non-zero validAt restricts the match to associations valid at that instant.
+func (r *FeatureOfInterestRepository) FindByAssociationType(ctx context.Context, associationType string,
+	validAt time.Time) ([]models.FeatureOfInterest, error) {
+
+	match := bson.M{"association.type": associationType}
+	if !validAt.IsZero() {
+		match["association.validFrom"] = bson.M{"$lte": validAt}
+		match["$or"] = bson.A{
+			bson.M{"association.validTo": nil},
+			bson.M{"association.validTo": bson.M{"$gt": validAt}},
+		}
+	}
+
+	return r.Find(ctx, Query{
+		Filter: bson.M{"externalFeatures": bson.M{"$elemMatch": match}},
+		Sort:   bson.D{{Key: "_id", Value: 1}},
+	})
+}
+
+// openAssociation matches an external feature entry whose association has not been closed
+func openAssociation(featureID string) bson.M {
+	return bson.M{"featureId": featureID, "association.validTo": nil}
+}
+
+// UpdateCachedMetadata stores the cached metadata of an external feature on every
+// association of a feature of interest with it
+func (r *FeatureOfInterestRepository) UpdateCachedMetadata(ctx context.Context, foiID, featureID string,
+	metadata *models.CachedMetadata) error {
+
+	update := bson.M{"$set": bson.M{"externalFeatures.$[ext].cachedMetadata": metadata}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"ext.featureId": featureID}},
+	})
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": foiID, "externalFeatures.featureId": featureID}, update, opts)
+	if err != nil {
+		return fmt.Errorf("failed to update cached metadata: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("association of %s with %s: %w", foiID, featureID, ErrNotFound)
+	}
+	return nil
+}
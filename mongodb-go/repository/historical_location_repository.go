@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/geo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// HistoricalLocationRepository handles the location history of things
+type HistoricalLocationRepository struct {
+	collection *mongo.Collection
+}
+
+// NewHistoricalLocationRepository creates a new historical location repository
+func NewHistoricalLocationRepository(db *mongo.Database) *HistoricalLocationRepository {
+	return &HistoricalLocationRepository{
+		collection: db.Collection("historical_locations"),
+	}
+}
+
+// Record adds a historical location
+func (r *HistoricalLocationRepository) Record(ctx context.Context, hl *models.HistoricalLocation) error {
+	hl.CreatedAt = time.Now().UTC()
+
+	result, err := r.collection.InsertOne(ctx, hl)
+	if err != nil {
+		return fmt.Errorf("failed to record historical location: %w", err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		hl.ID = id
+	}
+	return nil
+}
+
+// FindByThing retrieves the location history of a thing within [startTime, endTime), oldest first
+func (r *HistoricalLocationRepository) FindByThing(ctx context.Context, thingID string,
+	startTime, endTime time.Time) ([]models.HistoricalLocation, error) {
+
+	history := []models.HistoricalLocation{}
+	err := findDocuments(ctx, r.collection, Query{
+		Filter: bson.M{
+			"thingId": thingID,
+			"time": bson.M{
+				"$gte": startTime,
+				"$lt":  endTime,
+			},
+		},
+		Sort: bson.D{{Key: "time", Value: 1}},
+	}, &history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Trajectory returns the path of a thing within [startTime, endTime) as a GeoJSON LineString.
+// Only point locations contribute; a single fix is returned as a Point.
+func (r *HistoricalLocationRepository) Trajectory(ctx context.Context, thingID string,
+	startTime, endTime time.Time) (*models.GeoJSON, error) {
+
+	history, err := r.FindByThing(ctx, thingID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions [][]float64
+	for i := range history {
+		coords, ok := geo.PointCoordinates(history[i].Location)
+		if !ok {
+			continue
+		}
+		// Repeated fixes at the same spot add nothing to the path
+		if n := len(positions); n > 0 && equalPositions(positions[n-1], coords) {
+			continue
+		}
+		positions = append(positions, coords)
+	}
+
+	switch len(positions) {
+	case 0:
+		return nil, fmt.Errorf("trajectory of thing %s: %w", thingID, ErrNotFound)
+	case 1:
+		return geo.NewPoint(positions[0]), nil
+	default:
+		return geo.NewLineString(positions), nil
+	}
+}
+
+// PositionAt estimates where a thing was at time t by interpolating linearly
+// between the surrounding fixes. After the last fix the thing is assumed to
+// have stayed put; non-point locations are returned as recorded.
+func (r *HistoricalLocationRepository) PositionAt(ctx context.Context, thingID string, t time.Time) (*models.GeoJSON, error) {
+	before, err := r.findNearest(ctx, thingID, bson.M{"$lte": t}, -1)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, fmt.Errorf("location of thing %s at %s: %w", thingID, t.Format(time.RFC3339), ErrNotFound)
+	}
+
+	from, ok := geo.PointCoordinates(before.Location)
+	if !ok || before.Time.Equal(t) {
+		return before.Location, nil
+	}
+
+	after, err := r.findNearest(ctx, thingID, bson.M{"$gt": t}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if after == nil {
+		return before.Location, nil
+	}
+	to, ok := geo.PointCoordinates(after.Location)
+	if !ok {
+		return before.Location, nil
+	}
+
+	f := float64(t.Sub(before.Time)) / float64(after.Time.Sub(before.Time))
+	return geo.NewPoint(geo.Interpolate(from, to, f)), nil
+}
+
+// DeleteByThing removes the location history of a thing
+func (r *HistoricalLocationRepository) DeleteByThing(ctx context.Context, thingID string) error {
+	if _, err := r.collection.DeleteMany(ctx, bson.M{"thingId": thingID}); err != nil {
+		return fmt.Errorf("failed to delete historical locations: %w", err)
+	}
+	return nil
+}
+
+// findNearest returns the first fix of a thing matching a time condition in the given direction
+func (r *HistoricalLocationRepository) findNearest(ctx context.Context, thingID string,
+	timeFilter bson.M, direction int) (*models.HistoricalLocation, error) {
+
+	filter := bson.M{"thingId": thingID, "time": timeFilter}
+
+	var hl models.HistoricalLocation
+	err := r.collection.FindOne(ctx, filter,
+		options.FindOne().SetSort(bson.D{{Key: "time", Value: direction}})).Decode(&hl)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find historical location: %w", err)
+	}
+	return &hl, nil
+}
+
+// equalPositions reports whether two positions are identical
+func equalPositions(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
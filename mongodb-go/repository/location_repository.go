@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// LocationRepository handles location data operations
+type LocationRepository struct {
+	collection *mongo.Collection
+	database   *mongo.Database
+}
+
+// NewLocationRepository creates a new location repository
+func NewLocationRepository(db *mongo.Database) *LocationRepository {
+	return &LocationRepository{
+		collection: db.Collection("locations"),
+		database:   db,
+	}
+}
+
+// Create adds a new location after verifying the things placed at it
+func (r *LocationRepository) Create(ctx context.Context, loc *models.Location) error {
+	if err := checkExists(ctx, r.database.Collection("things"), loc.Things...); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	loc.CreatedAt = now
+	loc.UpdatedAt = now
+
+	return insertDocument(ctx, r.collection, loc.ID, loc)
+}
+
+// FindByID retrieves a location by its identifier
+func (r *LocationRepository) FindByID(ctx context.Context, id string) (*models.Location, error) {
+	var loc models.Location
+	if err := findDocument(ctx, r.collection, id, &loc); err != nil {
+		return nil, err
+	}
+	return &loc, nil
+}
+
+// Find retrieves locations matching a query
+func (r *LocationRepository) Find(ctx context.Context, query Query) ([]models.Location, error) {
+	locations := []models.Location{}
+	if err := findDocuments(ctx, r.collection, query, &locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// Update replaces an existing location after verifying the things placed at it
+func (r *LocationRepository) Update(ctx context.Context, loc *models.Location) error {
+	if err := checkExists(ctx, r.database.Collection("things"), loc.Things...); err != nil {
+		return err
+	}
+
+	loc.UpdatedAt = time.Now().UTC()
+	return replaceDocument(ctx, r.collection, loc.ID, loc)
+}
+
+// Delete removes a location that is not the current location of any thing
+func (r *LocationRepository) Delete(ctx context.Context, id string) error {
+	if err := checkUnreferenced(ctx, r.database.Collection("things"), "currentLocation.locationId", id); err != nil {
+		return err
+	}
+	return deleteDocument(ctx, r.collection, id)
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LookupRepository reads raw documents from any collection to resolve
+// references between SensorThings entities, and puts them back when a
+// write is undone
+type LookupRepository struct {
+	database *mongo.Database
+}
+
+// NewLookupRepository creates a new lookup repository
+func NewLookupRepository(db *mongo.Database) *LookupRepository {
+	return &LookupRepository{
+		database: db,
+	}
+}
+
+// Find retrieves raw documents from a collection
+func (r *LookupRepository) Find(ctx context.Context, collection string, query Query) ([]bson.M, error) {
+	cursor, err := r.database.Collection(collection).Find(ctx, query.filter(), query.findOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", collection, err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := []bson.M{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", collection, err)
+	}
+	return docs, nil
+}
+
+// Aggregate runs an aggregation pipeline on a collection and returns the raw results
+func (r *LookupRepository) Aggregate(ctx context.Context, collection string, pipeline mongo.Pipeline) ([]bson.M, error) {
+	cursor, err := r.database.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate %s: %w", collection, err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := []bson.M{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", collection, err)
+	}
+	return docs, nil
+}
+
+// Count returns the number of documents in a collection matching a filter
+func (r *LookupRepository) Count(ctx context.Context, collection string, filter bson.M) (int64, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	count, err := r.database.Collection(collection).CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s: %w", collection, err)
+	}
+	return count, nil
+}
+
+// Snapshot holds the documents of a collection matching a filter as they were read
+type Snapshot struct {
+	collection string
+	filter     bson.M
+	docs       []bson.Raw
+}
+
+// Snapshot reads the documents of a collection matching a filter so that Restore can put them back
+func (r *LookupRepository) Snapshot(ctx context.Context, collection string, filter bson.M) (*Snapshot, error) {
+	cursor, err := r.database.Collection(collection).Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", collection, err)
+	}
+	defer cursor.Close(ctx)
+
+	snapshot := &Snapshot{collection: collection, filter: filter}
+	for cursor.Next(ctx) {
+		snapshot.docs = append(snapshot.docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", collection, err)
+	}
+	return snapshot, nil
+}
+
+// Restore writes the documents of a snapshot back as they were read and
+// removes the documents matching its filter that were added since. The
+// documents are stored as is, without the timestamps and side effects of
+// the entity repositories.
+func (r *LookupRepository) Restore(ctx context.Context, snapshot *Snapshot) error {
+	collection := r.database.Collection(snapshot.collection)
+	ids := make(bson.A, len(snapshot.docs))
+	for i, doc := range snapshot.docs {
+		ids[i] = doc.Lookup("_id")
+	}
+
+	added := bson.M{"$and": bson.A{snapshot.filter, bson.M{"_id": bson.M{"$nin": ids}}}}
+	if _, err := collection.DeleteMany(ctx, added); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", snapshot.collection, err)
+	}
+	for i, doc := range snapshot.docs {
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": ids[i]}, doc, options.Replace().SetUpsert(true)); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", snapshot.collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// ErrInvalidResumeToken is returned for malformed change stream resume tokens
+var ErrInvalidResumeToken = errors.New("invalid resume token")
+
+// ErrResumeTokenExpired is returned when a change stream can no longer be
+// resumed because the oplog no longer reaches back to the token
+var ErrResumeTokenExpired = errors.New("resume token expired")
+
+// ObservationFeedRepository records stored observations in the
+// observation_feed collection and follows its change stream
+type ObservationFeedRepository struct {
+	collection *mongo.Collection
+}
+
+// NewObservationFeedRepository creates a new observation feed repository
+func NewObservationFeedRepository(db *mongo.Database) *ObservationFeedRepository {
+	return &ObservationFeedRepository{
+		collection: db.Collection("observation_feed"),
+	}
+}
+
+// Append records feed entries
+func (r *ObservationFeedRepository) Append(ctx context.Context, entries []models.ObservationFeedEntry) error {
+	docs := make([]interface{}, len(entries))
+	for i := range entries {
+		docs[i] = entries[i]
+	}
+	if _, err := r.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to append to observation feed: %w", err)
+	}
+	return nil
+}
+
+// Watch opens a change stream of the entries appended from now on or, when
+// resumeAfter is set, after the entry with that resume token
+func (r *ObservationFeedRepository) Watch(ctx context.Context, resumeAfter string) (*ObservationFeedStream, error) {
+	opts := options.ChangeStream()
+	if resumeAfter != "" {
+		// Resume tokens are hex encoded key strings
+		if _, err := hex.DecodeString(resumeAfter); err != nil {
+			return nil, ErrInvalidResumeToken
+		}
+		opts.SetResumeAfter(bson.M{"_data": resumeAfter})
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := r.collection.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, changeStreamError(err)
+	}
+	return &ObservationFeedStream{stream: stream}, nil
+}
+
+// ObservationFeedStream is an open change stream of feed entries
+type ObservationFeedStream struct {
+	stream *mongo.ChangeStream
+}
+
+// Next waits for the next entry and returns it with its resume token
+func (s *ObservationFeedStream) Next(ctx context.Context) (*models.ObservationFeedEntry, string, error) {
+	if !s.stream.Next(ctx) {
+		if err := s.stream.Err(); err != nil {
+			return nil, "", changeStreamError(err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("observation feed change stream closed")
+	}
+
+	var event struct {
+		FullDocument models.ObservationFeedEntry `bson:"fullDocument"`
+	}
+	if err := s.stream.Decode(&event); err != nil {
+		return nil, "", fmt.Errorf("failed to decode observation feed entry: %w", err)
+	}
+	token, ok := s.stream.ResumeToken().Lookup("_data").StringValueOK()
+	if !ok {
+		return nil, "", fmt.Errorf("observation feed change stream returned no resume token")
+	}
+	return &event.FullDocument, token, nil
+}
+
+// Close closes the change stream
+func (s *ObservationFeedStream) Close(ctx context.Context) error {
+	return s.stream.Close(ctx)
+}
+
+// changeStreamError reports resume token failures as ErrInvalidResumeToken or ErrResumeTokenExpired
+func changeStreamError(err error) error {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		switch cmdErr.Code {
+		case 260: // InvalidResumeToken
+			return fmt.Errorf("%w: %v", ErrInvalidResumeToken, err)
+		case 280, 286: // ChangeStreamFatalError, ChangeStreamHistoryLost
+			return fmt.Errorf("%w: %v", ErrResumeTokenExpired, err)
+		}
+	}
+	return fmt.Errorf("observation feed change stream failed: %w", err)
+}
@@ -2,19 +2,30 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/units"
 )
 
 // ObservationRepository handles observation data operations
 type ObservationRepository struct {
 	collection *mongo.Collection
 	database   *mongo.Database
+	rollups    *RollupRepository
+	units      *units.Converter
+	publishers []ObservationPublisher
+}
+
+// ObservationPublisher is told about the observations the repository has stored
+type ObservationPublisher interface {
+	PublishObservations(ctx context.Context, observations []models.Observation)
 }
 
 // NewObservationRepository creates a new observation repository
@@ -22,11 +33,31 @@ func NewObservationRepository(db *mongo.Database) *ObservationRepository {
 	return &ObservationRepository{
 		collection: db.Collection("observations"),
 		database:   db,
+		rollups:    NewRollupRepository(db),
+		units:      units.NewConverter(NewUnitRepository(db)),
 	}
 }
 
-// Insert adds a new observation
+// AddPublisher makes the repository pass every observation stored by Insert
+// and InsertMany to publisher. Callers of InsertManyUnpublished pass the
+// observations on with Publish themselves.
+func (r *ObservationRepository) AddPublisher(publisher ObservationPublisher) {
+	r.publishers = append(r.publishers, publisher)
+}
+
+// Insert adds a new observation after validating it and verifying its datastream and feature of interest
 func (r *ObservationRepository) Insert(ctx context.Context, obs *models.Observation) error {
+	if err := Validate(obs); err != nil {
+		return err
+	}
+	if err := r.checkReferences(ctx, []models.Observation{*obs}); err != nil {
+		return err
+	}
+
+	// The driver does not write generated ids back, so assign them here
+	if obs.ID.IsZero() {
+		obs.ID = primitive.NewObjectID()
+	}
 	// Add date key and hour bucket
 	obs.DateKey = models.GetDateKey(obs.PhenomenonTime)
 	obs.HourBucket = models.GetHourBucket(obs.PhenomenonTime)
@@ -35,14 +66,39 @@ func (r *ObservationRepository) Insert(ctx context.Context, obs *models.Observat
 	if err != nil {
 		return fmt.Errorf("failed to insert observation: %w", err)
 	}
-	return nil
+	r.Publish(ctx, []models.Observation{*obs})
+	return r.rollups.MarkPending(ctx, hourKeys([]models.Observation{*obs}))
 }
 
-// InsertMany adds multiple observations
+// InsertMany adds multiple observations, rejecting the batch if any is invalid or any reference is missing.
+// ErrRollupsNotMarked means the whole batch was stored.
 func (r *ObservationRepository) InsertMany(ctx context.Context, observations []models.Observation) error {
-	// Prepare documents for insertion
+	stored, err := r.InsertManyUnpublished(ctx, observations)
+	r.Publish(ctx, stored)
+	return err
+}
+
+// InsertManyUnpublished adds multiple observations like InsertMany but leaves
+// publishing them to the caller, returning the observations that were stored.
+// ErrRollupsNotMarked means the whole batch was stored.
+func (r *ObservationRepository) InsertManyUnpublished(ctx context.Context, observations []models.Observation) ([]models.Observation, error) {
+	for i := range observations {
+		if err := Validate(&observations[i]); err != nil {
+			return nil, fmt.Errorf("observation %d: %w", i, err)
+		}
+	}
+	if err := r.checkReferences(ctx, observations); err != nil {
+		return nil, err
+	}
+
+	// Prepare documents for insertion, completing the caller's observations
+	// so that what is published matches what was stored
 	docs := make([]interface{}, len(observations))
-	for i, obs := range observations {
+	for i := range observations {
+		obs := &observations[i]
+		if obs.ID.IsZero() {
+			obs.ID = primitive.NewObjectID()
+		}
 		obs.DateKey = models.GetDateKey(obs.PhenomenonTime)
 		obs.HourBucket = models.GetHourBucket(obs.PhenomenonTime)
 		docs[i] = obs
@@ -51,22 +107,195 @@ func (r *ObservationRepository) InsertMany(ctx context.Context, observations []m
 	opts := options.InsertMany().SetOrdered(false)
 	_, err := r.collection.InsertMany(ctx, docs, opts)
 	if err != nil {
+		err = insertManyError(err)
+	}
+
+	// Unordered inserts may have stored part of the batch even on error
+	var stored []models.Observation
+	var insertErr *InsertManyError
+	switch {
+	case err == nil:
+		stored = observations
+	case errors.As(err, &insertErr):
+		stored = make([]models.Observation, 0, len(observations)-len(insertErr.Failed))
+		for i := range observations {
+			if _, failed := insertErr.Failed[i]; !failed {
+				stored = append(stored, observations[i])
+			}
+		}
+	}
+	// A failed mark does not undo the insert, so it is reported apart from it
+	if markErr := r.rollups.MarkPending(ctx, hourKeys(observations)); markErr != nil && err == nil {
+		err = fmt.Errorf("%w: %v", ErrRollupsNotMarked, markErr)
+	}
+	return stored, err
+}
+
+// Publish passes stored observations to the publishers
+func (r *ObservationRepository) Publish(ctx context.Context, observations []models.Observation) {
+	if len(observations) == 0 {
+		return
+	}
+	for _, publisher := range r.publishers {
+		publisher.PublishObservations(ctx, observations)
+	}
+}
+
+// InsertManyError reports the observations an unordered InsertMany did not store;
+// the rest of the batch was stored
+type InsertManyError struct {
+	// Failed maps the index of each rejected observation in the batch to its error
+	Failed map[int]error
+	err    error
+}
+
+// Error summarizes the failed inserts
+func (e *InsertManyError) Error() string {
+	return fmt.Sprintf("failed to insert %d observations: %v", len(e.Failed), e.err)
+}
+
+// Unwrap returns the driver error
+func (e *InsertManyError) Unwrap() error {
+	return e.err
+}
+
+// insertManyError reports per observation write errors as an InsertManyError.
+// Other failures leave it unknown which observations were stored.
+func insertManyError(err error) error {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 || bulkErr.WriteConcernError != nil {
 		return fmt.Errorf("failed to insert observations: %w", err)
 	}
+
+	failed := make(map[int]error, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed[writeErr.Index] = writeErr
+	}
+	return &InsertManyError{Failed: failed, err: err}
+}
+
+// observationOptionalFields are the fields an update removes when the new observation leaves them out
+var observationOptionalFields = []string{"resultTime", "resultQuality", "validTime", "featureOfInterestId", "parameters", "location"}
+
+// Update replaces an existing observation after validating it and verifying its references.
+// Updating measurements of a time series collection requires MongoDB 7.0 or later.
+func (r *ObservationRepository) Update(ctx context.Context, obs *models.Observation) error {
+	if err := Validate(obs); err != nil {
+		return err
+	}
+	if err := r.checkReferences(ctx, []models.Observation{*obs}); err != nil {
+		return err
+	}
+	previous, err := r.FindByID(ctx, obs.ID)
+	if err != nil {
+		return err
+	}
+
+	obs.DateKey = models.GetDateKey(obs.PhenomenonTime)
+	obs.HourBucket = models.GetHourBucket(obs.PhenomenonTime)
+
+	data, err := bson.Marshal(obs)
+	if err != nil {
+		return fmt.Errorf("failed to encode observation %s: %w", obs.ID.Hex(), err)
+	}
+	var set bson.M
+	if err := bson.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to encode observation %s: %w", obs.ID.Hex(), err)
+	}
+	delete(set, "_id")
+	update := bson.M{"$set": set}
+	unset := bson.M{}
+	for _, field := range observationOptionalFields {
+		if _, ok := set[field]; !ok {
+			unset[field] = ""
+		}
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": obs.ID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update observation %s: %w", obs.ID.Hex(), err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("observation %s: %w", obs.ID.Hex(), ErrNotFound)
+	}
+	// Both the hour it left and the hour it moved to need recomputing
+	return r.rollups.MarkPending(ctx, hourKeys([]models.Observation{*previous, *obs}))
+}
+
+// Delete removes an observation.
+// Deleting measurements of a time series collection requires MongoDB 7.0 or later.
+func (r *ObservationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.DeleteMany(ctx, []primitive.ObjectID{id})
+}
+
+// DeleteMany removes observations by identifier, failing with ErrNotFound if none of them exist
+func (r *ObservationRepository) DeleteMany(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	// Read them first so the rollups of the hours they leave can be recomputed
+	observations, err := r.Find(ctx, Query{Filter: bson.M{"_id": bson.M{"$in": ids}}})
+	if err != nil {
+		return err
+	}
+	if len(observations) == 0 {
+		return fmt.Errorf("observation %s: %w", ids[0].Hex(), ErrNotFound)
+	}
+
+	if _, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return fmt.Errorf("failed to delete observations: %w", err)
+	}
+	return r.rollups.MarkPending(ctx, hourKeys(observations))
+}
+
+// SyncDatastream copies the thing, sensor, observed property and unit of a
+// datastream into the metadata of its observations. The location each
+// observation was made at is kept. Only the metaField changes, which time
+// series collections allow on every supported MongoDB version.
+func (r *ObservationRepository) SyncDatastream(ctx context.Context, ds *models.Datastream) error {
+	update := bson.M{"$set": bson.M{
+		"datastream.thingId":            ds.ThingID,
+		"datastream.sensorId":           ds.SensorID,
+		"datastream.observedPropertyId": ds.ObservedPropertyID,
+	}}
+	if ds.UnitOfMeasurement != nil {
+		update["$set"].(bson.M)["datastream.unitOfMeasurement"] = ds.UnitOfMeasurement
+	} else {
+		update["$unset"] = bson.M{"datastream.unitOfMeasurement": ""}
+	}
+
+	if _, err := r.collection.UpdateMany(ctx, bson.M{"datastream.datastreamId": ds.ID}, update); err != nil {
+		return fmt.Errorf("failed to update observations of datastream %s: %w", ds.ID, err)
+	}
 	return nil
 }
 
+// checkReferences verifies that the datastreams and features of interest of observations exist
+func (r *ObservationRepository) checkReferences(ctx context.Context, observations []models.Observation) error {
+	datastreamIDs := make([]string, 0, len(observations))
+	var featureIDs []string
+	for i := range observations {
+		if observations[i].Datastream.DatastreamID == "" {
+			return errors.New("observation requires a datastream")
+		}
+		datastreamIDs = append(datastreamIDs, observations[i].Datastream.DatastreamID)
+		featureIDs = append(featureIDs, observations[i].FeatureOfInterestID)
+	}
+
+	if err := checkExists(ctx, r.database.Collection("datastreams"), datastreamIDs...); err != nil {
+		return err
+	}
+	return checkExists(ctx, r.database.Collection("features_of_interest"), featureIDs...)
+}
+
 // FindByDatastream retrieves observations for a datastream
 func (r *ObservationRepository) FindByDatastream(ctx context.Context, datastreamID string, 
 	startTime, endTime time.Time, limit int64) ([]models.Observation, error) {
 	
-	filter := bson.M{
-		"datastream.datastreamId": datastreamID,
-		"phenomenonTime": bson.M{
-			"$gte": startTime,
-			"$lt":  endTime,
-		},
-	}
+	filter := datastreamWindow(datastreamID, startTime, endTime)
 
 	opts := options.Find().
 		SetSort(bson.D{{Key: "phenomenonTime", Value: -1}}).
@@ -86,10 +315,189 @@ func (r *ObservationRepository) FindByDatastream(ctx context.Context, datastream
 	return observations, nil
 }
 
-// GetHourlyStatistics calculates hourly statistics
+// FindByQuantity retrieves observations of every datastream measured in any
+// unit of a quantity, such as "temperature", newest first
+func (r *ObservationRepository) FindByQuantity(ctx context.Context, quantity string,
+	startTime, endTime time.Time, limit int64) ([]models.Observation, error) {
+
+	datastreams, err := NewDatastreamRepository(r.database).FindByQuantity(ctx, quantity)
+	if err != nil {
+		return nil, err
+	}
+	if len(datastreams) == 0 {
+		return []models.Observation{}, nil
+	}
+	ids := make([]string, len(datastreams))
+	for i := range datastreams {
+		ids[i] = datastreams[i].ID
+	}
+
+	return r.Find(ctx, Query{
+		Filter: bson.M{
+			"datastream.datastreamId": bson.M{"$in": ids},
+			"phenomenonTime": bson.M{
+				"$gte": startTime,
+				"$lt":  endTime,
+			},
+		},
+		Sort:  bson.D{{Key: "phenomenonTime", Value: -1}},
+		Limit: limit,
+	})
+}
+
+// StreamByDatastream walks the observations of a datastream one at a time.
+// See Stream for the iteration contract.
+func (r *ObservationRepository) StreamByDatastream(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time, opts StreamOptions, fn func(*models.Observation) error) (string, error) {
+	return r.Stream(ctx, datastreamWindow(datastreamID, startTime, endTime), opts, fn)
+}
+
+// FindPageByDatastream retrieves one page of a datastream's observations
+// and the continuation token for the next page ("" on the last page)
+func (r *ObservationRepository) FindPageByDatastream(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time, opts StreamOptions, limit int64) ([]models.Observation, string, error) {
+	return r.FindPage(ctx, datastreamWindow(datastreamID, startTime, endTime), opts, limit)
+}
+
+// Stream calls fn for every observation matching filter in (phenomenonTime, _id) order,
+// decoding one document at a time so memory use does not grow with the result set.
+// fn may return ErrStopStream to end early. The returned token resumes after the
+// last observation passed to fn, or is opts.After if none was.
+func (r *ObservationRepository) Stream(ctx context.Context, filter bson.M, opts StreamOptions,
+	fn func(*models.Observation) error) (string, error) {
+
+	filter, sort, err := opts.keyset(filter)
+	if err != nil {
+		return "", err
+	}
+
+	findOpts := options.Find().SetSort(sort)
+	if opts.BatchSize > 0 {
+		findOpts.SetBatchSize(opts.BatchSize)
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return "", fmt.Errorf("failed to stream observations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	token := opts.After
+	for cursor.Next(ctx) {
+		var obs models.Observation
+		if err := cursor.Decode(&obs); err != nil {
+			return token, fmt.Errorf("failed to decode observation: %w", err)
+		}
+
+		if err := fn(&obs); err != nil {
+			if errors.Is(err, ErrStopStream) {
+				return token, nil
+			}
+			return token, err
+		}
+		token = NewContinuationToken(&obs, opts.Ascending).Encode()
+	}
+
+	if err := cursor.Err(); err != nil {
+		return token, fmt.Errorf("failed to stream observations: %w", err)
+	}
+	return token, nil
+}
+
+// FindPage retrieves up to limit observations matching filter after the position in opts,
+// returning the continuation token for the next page ("" on the last page)
+func (r *ObservationRepository) FindPage(ctx context.Context, filter bson.M, opts StreamOptions,
+	limit int64) ([]models.Observation, string, error) {
+
+	filter, sort, err := opts.keyset(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	observations, err := r.Find(ctx, Query{Filter: filter, Sort: sort, Limit: limit})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if limit == 0 || int64(len(observations)) < limit {
+		return observations, "", nil
+	}
+	last := &observations[len(observations)-1]
+	return observations, NewContinuationToken(last, opts.Ascending).Encode(), nil
+}
+
+// FindByFeatureOfInterest retrieves observations of a feature of interest within [startTime, endTime)
+func (r *ObservationRepository) FindByFeatureOfInterest(ctx context.Context, foiID string,
+	startTime, endTime time.Time, limit int64) ([]models.Observation, error) {
+
+	return r.Find(ctx, Query{
+		Filter: bson.M{
+			"featureOfInterestId": foiID,
+			"phenomenonTime": bson.M{
+				"$gte": startTime,
+				"$lt":  endTime,
+			},
+		},
+		Sort:  bson.D{{Key: "phenomenonTime", Value: -1}},
+		Limit: limit,
+	})
+}
+
+// FindByID retrieves a single observation by its identifier
+func (r *ObservationRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Observation, error) {
+	var obs models.Observation
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&obs)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("observation %s: %w", id.Hex(), ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to find observation: %w", err)
+	}
+	return &obs, nil
+}
+
+// Find retrieves observations matching an arbitrary query
+func (r *ObservationRepository) Find(ctx context.Context, query Query) ([]models.Observation, error) {
+	cursor, err := r.collection.Find(ctx, query.filter(), query.findOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find observations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	observations := []models.Observation{}
+	if err := cursor.All(ctx, &observations); err != nil {
+		return nil, fmt.Errorf("failed to decode observations: %w", err)
+	}
+
+	return observations, nil
+}
+
+// Count returns the number of observations matching a filter
+func (r *ObservationRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count observations: %w", err)
+	}
+	return count, nil
+}
+
+// GetHourlyStatistics calculates hourly statistics, reading hourly_aggregates
+// instead of raw observations when the window is aligned to whole UTC hours
+// and the rollups are up to date
 func (r *ObservationRepository) GetHourlyStatistics(ctx context.Context, 
 	datastreamID string, startTime, endTime time.Time) ([]models.ObservationStats, error) {
 	
+	covered, err := r.rollups.Covers(ctx, datastreamID, startTime, endTime, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if covered {
+		return r.rollups.HourlyStatistics(ctx, datastreamID, startTime, endTime)
+	}
+
 	pipeline := mongo.Pipeline{
 		{{Key: "$match", Value: bson.M{
 			"datastream.datastreamId": datastreamID,
@@ -118,6 +526,62 @@ func (r *ObservationRepository) GetHourlyStatistics(ctx context.Context,
 			{Key: "_id.date", Value: 1},
 			{Key: "_id.hour", Value: 1},
 		}}},
+		// Flatten the group key so each row decodes into ObservationStats
+		{{Key: "$set", Value: bson.M{
+			"date": "$_id.date",
+			"hour": "$_id.hour",
+			"_id":  bson.M{"$literal": datastreamID},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate statistics: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var stats []models.ObservationStats
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, fmt.Errorf("failed to decode statistics: %w", err)
+	}
+
+	return stats, nil
+}
+
+// GetDailyStatistics calculates daily statistics, reading daily_summaries
+// instead of raw observations when the window is aligned to whole UTC days
+// and the rollups are up to date
+func (r *ObservationRepository) GetDailyStatistics(ctx context.Context,
+	datastreamID string, startTime, endTime time.Time) ([]models.ObservationStats, error) {
+
+	covered, err := r.rollups.Covers(ctx, datastreamID, startTime, endTime, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if covered {
+		return r.rollups.DailyStatistics(ctx, datastreamID, startTime, endTime)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: datastreamWindow(datastreamID, startTime, endTime)}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format": "%Y-%m-%d",
+				"date":   "$phenomenonTime",
+			}},
+			"average":          bson.M{"$avg": "$result"},
+			"min":              bson.M{"$min": "$result"},
+			"max":              bson.M{"$max": "$result"},
+			"stdDev":           bson.M{"$stdDevPop": "$result"},
+			"count":            bson.M{"$sum": 1},
+			"firstObservation": bson.M{"$min": "$phenomenonTime"},
+			"lastObservation":  bson.M{"$max": "$phenomenonTime"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$set", Value: bson.M{
+			"date": "$_id",
+			"_id":  bson.M{"$literal": datastreamID},
+		}}},
 	}
 
 	cursor, err := r.collection.Aggregate(ctx, pipeline)
@@ -134,6 +598,57 @@ func (r *ObservationRepository) GetHourlyStatistics(ctx context.Context,
 	return stats, nil
 }
 
+// GetHourlyStatisticsInUnit calculates hourly statistics converted from the
+// datastream's unit of measurement into unit, a UCUM code
+func (r *ObservationRepository) GetHourlyStatisticsInUnit(ctx context.Context,
+	datastreamID string, startTime, endTime time.Time, unit string) ([]models.ObservationStats, error) {
+
+	conversion, err := r.unitConversion(ctx, datastreamID, unit)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := r.GetHourlyStatistics(ctx, datastreamID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	units.ConvertStats(stats, conversion)
+	return stats, nil
+}
+
+// GetDailyStatisticsInUnit calculates daily statistics converted from the
+// datastream's unit of measurement into unit, a UCUM code
+func (r *ObservationRepository) GetDailyStatisticsInUnit(ctx context.Context,
+	datastreamID string, startTime, endTime time.Time, unit string) ([]models.ObservationStats, error) {
+
+	conversion, err := r.unitConversion(ctx, datastreamID, unit)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := r.GetDailyStatistics(ctx, datastreamID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	units.ConvertStats(stats, conversion)
+	return stats, nil
+}
+
+// unitConversion maps results of a datastream from its declared unit into unit
+func (r *ObservationRepository) unitConversion(ctx context.Context, datastreamID, unit string) (units.Linear, error) {
+	var ds models.Datastream
+	if err := findDocument(ctx, r.database.Collection("datastreams"), datastreamID, &ds); err != nil {
+		return units.Linear{}, err
+	}
+	source, err := r.units.ResolveUnitOfMeasure(ctx, ds.UnitOfMeasurement)
+	if err != nil {
+		return units.Linear{}, fmt.Errorf("datastream %s: %w", datastreamID, err)
+	}
+	target, err := r.units.Resolve(ctx, unit)
+	if err != nil {
+		return units.Linear{}, err
+	}
+	return units.Mapping(source, target)
+}
+
 // FindNearLocation finds observations near a geographic location
 func (r *ObservationRepository) FindNearLocation(ctx context.Context, 
 	longitude, latitude, maxDistance float64, limit int64) ([]models.Observation, error) {
@@ -178,3 +693,23 @@ func (r *ObservationRepository) DeleteOldObservations(ctx context.Context, befor
 
 	return result.DeletedCount, nil
 }
+
+// hourKeys returns the rollup buckets touched by observations
+func hourKeys(observations []models.Observation) []models.HourKey {
+	keys := make([]models.HourKey, len(observations))
+	for i := range observations {
+		keys[i] = models.NewHourKey(observations[i].Datastream.DatastreamID, observations[i].PhenomenonTime)
+	}
+	return keys
+}
+
+// datastreamWindow filters the observations of a datastream within [startTime, endTime)
+func datastreamWindow(datastreamID string, startTime, endTime time.Time) bson.M {
+	return bson.M{
+		"datastream.datastreamId": datastreamID,
+		"phenomenonTime": bson.M{
+			"$gte": startTime,
+			"$lt":  endTime,
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// ObservedPropertyRepository handles observed property data operations
+type ObservedPropertyRepository struct {
+	collection *mongo.Collection
+	database   *mongo.Database
+}
+
+// NewObservedPropertyRepository creates a new observed property repository
+func NewObservedPropertyRepository(db *mongo.Database) *ObservedPropertyRepository {
+	return &ObservedPropertyRepository{
+		collection: db.Collection("observed_properties"),
+		database:   db,
+	}
+}
+
+// Create adds a new observed property
+func (r *ObservedPropertyRepository) Create(ctx context.Context, property *models.ObservedProperty) error {
+	now := time.Now().UTC()
+	property.CreatedAt = now
+	property.UpdatedAt = now
+
+	return insertDocument(ctx, r.collection, property.ID, property)
+}
+
+// FindByID retrieves an observed property by its identifier
+func (r *ObservedPropertyRepository) FindByID(ctx context.Context, id string) (*models.ObservedProperty, error) {
+	var property models.ObservedProperty
+	if err := findDocument(ctx, r.collection, id, &property); err != nil {
+		return nil, err
+	}
+	return &property, nil
+}
+
+// Find retrieves observed properties matching a query
+func (r *ObservedPropertyRepository) Find(ctx context.Context, query Query) ([]models.ObservedProperty, error) {
+	properties := []models.ObservedProperty{}
+	if err := findDocuments(ctx, r.collection, query, &properties); err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
+
+// Update replaces an existing observed property
+func (r *ObservedPropertyRepository) Update(ctx context.Context, property *models.ObservedProperty) error {
+	property.UpdatedAt = time.Now().UTC()
+	return replaceDocument(ctx, r.collection, property.ID, property)
+}
+
+// Delete removes an observed property that no datastream measures
+func (r *ObservedPropertyRepository) Delete(ctx context.Context, id string) error {
+	if err := checkUnreferenced(ctx, r.database.Collection("datastreams"), "observedPropertyId", id); err != nil {
+		return err
+	}
+	return deleteDocument(ctx, r.collection, id)
+}
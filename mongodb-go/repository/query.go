@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotFound is returned when a requested entity does not exist
+var ErrNotFound = errors.New("entity not found")
+
+// Query describes a filtered, sorted and paged read
+type Query struct {
+	Filter bson.M
+	Sort   bson.D
+	Skip   int64
+	Limit  int64
+}
+
+// filter returns the query filter, never nil
+func (q Query) filter() bson.M {
+	if q.Filter == nil {
+		return bson.M{}
+	}
+	return q.Filter
+}
+
+// findOptions converts the query into driver find options
+func (q Query) findOptions() *options.FindOptions {
+	opts := options.Find()
+	if len(q.Sort) > 0 {
+		opts.SetSort(q.Sort)
+	}
+	if q.Skip > 0 {
+		opts.SetSkip(q.Skip)
+	}
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	return opts
+}
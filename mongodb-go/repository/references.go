@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAlreadyExists is returned when creating an entity whose identifier is taken
+var ErrAlreadyExists = errors.New("entity already exists")
+
+// ErrInvalidReference is returned when an entity refers to an entity that does not exist
+var ErrInvalidReference = errors.New("referenced entity does not exist")
+
+// ErrReferenced is returned when deleting an entity that other entities still refer to
+var ErrReferenced = errors.New("entity is still referenced")
+
+// checkExists verifies that every id exists in the collection
+func checkExists(ctx context.Context, collection *mongo.Collection, ids ...string) error {
+	unique := map[string]bool{}
+	for _, id := range ids {
+		if id != "" {
+			unique[id] = true
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	wanted := make(bson.A, 0, len(unique))
+	for id := range unique {
+		wanted = append(wanted, id)
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": wanted}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to check %s references: %w", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to check %s references: %w", collection.Name(), err)
+		}
+		delete(unique, doc.ID)
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to check %s references: %w", collection.Name(), err)
+	}
+
+	if len(unique) > 0 {
+		missing := make([]string, 0, len(unique))
+		for id := range unique {
+			missing = append(missing, id)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("%s %v: %w", collection.Name(), missing, ErrInvalidReference)
+	}
+	return nil
+}
+
+// checkUnreferenced verifies that no document in the collection refers to the id through field
+func checkUnreferenced(ctx context.Context, collection *mongo.Collection, field, id string) error {
+	err := collection.FindOne(ctx, bson.M{field: id}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check references to %s: %w", id, err)
+	}
+	return fmt.Errorf("%s is used by %s: %w", id, collection.Name(), ErrReferenced)
+}
+
+// insertDocument inserts a new entity, reporting taken identifiers as ErrAlreadyExists
+func insertDocument(ctx context.Context, collection *mongo.Collection, id string, doc interface{}) error {
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%s %s: %w", collection.Name(), id, ErrAlreadyExists)
+		}
+		return fmt.Errorf("failed to insert into %s: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+// findDocument decodes the entity with the id into v
+func findDocument(ctx context.Context, collection *mongo.Collection, id string, v interface{}) error {
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(v)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("%s %s: %w", collection.Name(), id, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find %s %s: %w", collection.Name(), id, err)
+	}
+	return nil
+}
+
+// findDocuments decodes the entities matching a query into v, which must be a slice pointer
+func findDocuments(ctx context.Context, collection *mongo.Collection, query Query, v interface{}) error {
+	cursor, err := collection.Find(ctx, query.filter(), query.findOptions())
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+// replaceDocument replaces the entity with the id, keeping its original created_at
+func replaceDocument(ctx context.Context, collection *mongo.Collection, id string, doc interface{}) error {
+	pipeline, err := replacementPipeline(collection, id, doc)
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to update %s %s: %w", collection.Name(), id, err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%s %s: %w", collection.Name(), id, ErrNotFound)
+	}
+	return nil
+}
+
+// swapDocument replaces the entity like replaceDocument and decodes its previous state into previous
+func swapDocument(ctx context.Context, collection *mongo.Collection, id string, doc, previous interface{}) error {
+	pipeline, err := replacementPipeline(collection, id, doc)
+	if err != nil {
+		return err
+	}
+
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, pipeline,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(previous)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("%s %s: %w", collection.Name(), id, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s %s: %w", collection.Name(), id, err)
+	}
+	return nil
+}
+
+// replacementPipeline builds an update pipeline that replaces a document but keeps created_at
+func replacementPipeline(collection *mongo.Collection, id string, doc interface{}) (mongo.Pipeline, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s %s: %w", collection.Name(), id, err)
+	}
+	var replacement bson.M
+	if err := bson.Unmarshal(data, &replacement); err != nil {
+		return nil, fmt.Errorf("failed to encode %s %s: %w", collection.Name(), id, err)
+	}
+	delete(replacement, "created_at")
+
+	// $literal keeps user values starting with '$' from being read as field paths
+	return mongo.Pipeline{{{Key: "$replaceWith", Value: bson.M{"$mergeObjects": bson.A{
+		bson.M{"$literal": replacement},
+		bson.M{"created_at": "$created_at"},
+	}}}}}, nil
+}
+
+// deleteDocument removes the entity with the id
+func deleteDocument(ctx context.Context, collection *mongo.Collection, id string) error {
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s %s: %w", collection.Name(), id, err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%s %s: %w", collection.Name(), id, ErrNotFound)
+	}
+	return nil
+}
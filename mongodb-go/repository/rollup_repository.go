@@ -0,0 +1,438 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// ErrRollupsNotMarked is returned alongside stored observations whose hours
+// could not be queued for re-rolling. The observations were stored; a
+// backfill of their datastreams brings the rollups up to date.
+var ErrRollupsNotMarked = errors.New("rollups not marked pending")
+
+// PendingBucket is an hour whose rollups are out of date
+type PendingBucket struct {
+	Key      models.HourKey `bson:"_id"`
+	Start    time.Time      `bson:"start"`
+	MarkedAt time.Time      `bson:"marked_at"`
+}
+
+// RollupRepository handles the hourly_aggregates and daily_summaries rollups
+// and the queue of hour buckets that need re-rolling
+type RollupRepository struct {
+	observations *mongo.Collection
+	datastreams  *mongo.Collection
+	hourly       *mongo.Collection
+	daily        *mongo.Collection
+	pending      *mongo.Collection
+	state        *mongo.Collection
+}
+
+// NewRollupRepository creates a new rollup repository
+func NewRollupRepository(db *mongo.Database) *RollupRepository {
+	return &RollupRepository{
+		observations: db.Collection("observations"),
+		datastreams:  db.Collection("datastreams"),
+		hourly:       db.Collection("hourly_aggregates"),
+		daily:        db.Collection("daily_summaries"),
+		pending:      db.Collection("rollup_pending"),
+		state:        db.Collection("rollup_state"),
+	}
+}
+
+// MarkPending queues hour buckets for re-rolling
+func (r *RollupRepository) MarkPending(ctx context.Context, keys []models.HourKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	seen := map[models.HourKey]bool{}
+	var writes []mongo.WriteModel
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": key}).
+			SetUpdate(bson.M{"$set": bson.M{"start": key.Start(), "marked_at": now}}).
+			SetUpsert(true))
+	}
+
+	if _, err := r.pending.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to mark rollups pending: %w", err)
+	}
+	return nil
+}
+
+// PendingBatch returns up to limit pending buckets, oldest mark first
+func (r *RollupRepository) PendingBatch(ctx context.Context, limit int64) ([]PendingBucket, error) {
+	buckets := []PendingBucket{}
+	err := findDocuments(ctx, r.pending, Query{
+		Sort:  bson.D{{Key: "marked_at", Value: 1}},
+		Limit: limit,
+	}, &buckets)
+	if err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// ClearPending removes a bucket from the queue unless it was marked again after it was read
+func (r *RollupRepository) ClearPending(ctx context.Context, bucket PendingBucket) error {
+	_, err := r.pending.DeleteOne(ctx, bson.M{"_id": bucket.Key, "marked_at": bson.M{"$lte": bucket.MarkedAt}})
+	if err != nil {
+		return fmt.Errorf("failed to clear pending rollup: %w", err)
+	}
+	return nil
+}
+
+// IsInitialized reports whether all historical hours of a datastream have been queued
+func (r *RollupRepository) IsInitialized(ctx context.Context, datastreamID string) (bool, error) {
+	err := r.state.FindOne(ctx, bson.M{"_id": datastreamID}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read rollup state: %w", err)
+	}
+	return true, nil
+}
+
+// Initialize queues every hour of a datastream that has raw observations, so that
+// data stored before rollups were maintained is rolled up too
+func (r *RollupRepository) Initialize(ctx context.Context, datastreamID string) error {
+	now := time.Now().UTC()
+	hour := bson.M{"$dateTrunc": bson.M{"date": "$phenomenonTime", "unit": "hour"}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"datastream.datastreamId": datastreamID}}},
+		{{Key: "$group", Value: bson.M{"_id": hour}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "datastreamId", Value: datastreamID},
+				{Key: "date_key", Value: bson.M{"$toInt": bson.M{"$dateToString": bson.M{"format": "%Y%m%d", "date": "$_id"}}}},
+				{Key: "hour", Value: bson.M{"$hour": "$_id"}},
+			}},
+			{Key: "start", Value: "$_id"},
+			{Key: "marked_at", Value: now},
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           r.pending.Name(),
+			"on":             "_id",
+			"whenMatched":    "keepExisting",
+			"whenNotMatched": "insert",
+		}}},
+	}
+
+	cursor, err := r.observations.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to queue historical rollups: %w", err)
+	}
+	cursor.Close(ctx)
+
+	_, err = r.state.UpdateOne(ctx,
+		bson.M{"_id": datastreamID},
+		bson.M{"$set": bson.M{"initialized_at": now}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save rollup state: %w", err)
+	}
+	return nil
+}
+
+// Covers reports whether rollups can answer a query over [startTime, endTime) at the
+// given granularity: the window is aligned, the datastream is initialized and no hour
+// inside the window is waiting to be re-rolled
+func (r *RollupRepository) Covers(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time, unit time.Duration) (bool, error) {
+
+	if !endTime.After(startTime) || !startTime.Equal(startTime.Truncate(unit)) || !endTime.Equal(endTime.Truncate(unit)) {
+		return false, nil
+	}
+
+	initialized, err := r.IsInitialized(ctx, datastreamID)
+	if err != nil || !initialized {
+		return false, err
+	}
+
+	err = r.pending.FindOne(ctx, bson.M{
+		"_id.datastreamId": datastreamID,
+		"start":            bson.M{"$gte": startTime, "$lt": endTime},
+	}).Err()
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check pending rollups: %w", err)
+	}
+	return false, nil
+}
+
+// ComputeHourly aggregates the raw observations of one hour, returning nil for an empty hour
+func (r *RollupRepository) ComputeHourly(ctx context.Context, key models.HourKey) (*models.HourlyAggregate, error) {
+	start := key.Start()
+	end := start.Add(time.Hour)
+
+	isNumber := bson.M{"$isNumber": "$result"}
+	value := bson.M{"$cond": bson.A{isNumber, bson.M{"$toDouble": "$result"}, nil}}
+	countIf := func(cond interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	quality := func(q string) bson.M {
+		return countIf(bson.M{"$eq": bson.A{"$resultQuality", q}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"datastream.datastreamId": key.DatastreamID,
+			"phenomenonTime":          bson.M{"$gte": start, "$lt": end},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               nil,
+			"count":             bson.M{"$sum": 1},
+			"value_count":       countIf(isNumber),
+			"sum":               bson.M{"$sum": value},
+			"sum_sq":            bson.M{"$sum": bson.M{"$multiply": bson.A{value, value}}},
+			"min":               bson.M{"$min": value},
+			"max":               bson.M{"$max": value},
+			"good":              quality("good"),
+			"bad":               quality("bad"),
+			"uncertain":         quality("uncertain"),
+			"missing":           quality("missing"),
+			"first_observation": bson.M{"$min": "$phenomenonTime"},
+			"last_observation":  bson.M{"$max": "$phenomenonTime"},
+		}}},
+	}
+
+	cursor, err := r.observations.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate hour: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Count            int64     `bson:"count"`
+		ValueCount       int64     `bson:"value_count"`
+		Sum              float64   `bson:"sum"`
+		SumSquares       float64   `bson:"sum_sq"`
+		Min              *float64  `bson:"min"`
+		Max              *float64  `bson:"max"`
+		Good             int64     `bson:"good"`
+		Bad              int64     `bson:"bad"`
+		Uncertain        int64     `bson:"uncertain"`
+		Missing          int64     `bson:"missing"`
+		FirstObservation time.Time `bson:"first_observation"`
+		LastObservation  time.Time `bson:"last_observation"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode hour aggregate: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	row := rows[0]
+	name, err := r.metricName(ctx, key.DatastreamID)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := models.MetricStats{Count: row.Count, ValueCount: row.ValueCount, Sum: row.Sum, SumSquares: row.SumSquares}
+	if row.Min != nil && row.Max != nil {
+		metrics.Min, metrics.Max = *row.Min, *row.Max
+	}
+	metrics.Finalize()
+
+	agg := &models.HourlyAggregate{
+		ID:               key,
+		StartTime:        start,
+		EndTime:          end.Add(-time.Second),
+		Metrics:          models.MetricSet{name: metrics},
+		Quality:          models.QualityCounts{Good: row.Good, Bad: row.Bad, Uncertain: row.Uncertain, Missing: row.Missing},
+		FirstObservation: row.FirstObservation,
+		LastObservation:  row.LastObservation,
+	}
+	agg.Quality.Finalize()
+	return agg, nil
+}
+
+// metricName returns the observed property a datastream's rollups are keyed by
+func (r *RollupRepository) metricName(ctx context.Context, datastreamID string) (string, error) {
+	var ds struct {
+		ObservedPropertyID string `bson:"observedPropertyId"`
+	}
+	err := r.datastreams.FindOne(ctx, bson.M{"_id": datastreamID},
+		options.FindOne().SetProjection(bson.M{"observedPropertyId": 1})).Decode(&ds)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", fmt.Errorf("failed to find datastream %s: %w", datastreamID, err)
+	}
+	if ds.ObservedPropertyID == "" {
+		return "result", nil
+	}
+	return ds.ObservedPropertyID, nil
+}
+
+// SaveHourly stores an hourly aggregate, bumping its version
+func (r *RollupRepository) SaveHourly(ctx context.Context, agg *models.HourlyAggregate) error {
+	agg.ComputedAt = time.Now().UTC()
+	return upsertVersioned(ctx, r.hourly, agg.ID, agg)
+}
+
+// DeleteHourly removes the aggregate of an hour that no longer has observations
+func (r *RollupRepository) DeleteHourly(ctx context.Context, key models.HourKey) error {
+	if _, err := r.hourly.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return fmt.Errorf("failed to delete hourly aggregate: %w", err)
+	}
+	return nil
+}
+
+// FindHourly retrieves the hourly aggregates of a datastream within [startTime, endTime)
+func (r *RollupRepository) FindHourly(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time) ([]models.HourlyAggregate, error) {
+
+	aggregates := []models.HourlyAggregate{}
+	err := findDocuments(ctx, r.hourly, Query{
+		Filter: bson.M{
+			"_id.datastreamId": datastreamID,
+			"start_time":       bson.M{"$gte": startTime, "$lt": endTime},
+		},
+		Sort: bson.D{{Key: "start_time", Value: 1}},
+	}, &aggregates)
+	if err != nil {
+		return nil, err
+	}
+	return aggregates, nil
+}
+
+// HourlyStatistics converts the hourly aggregates of a window into observation statistics
+func (r *RollupRepository) HourlyStatistics(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time) ([]models.ObservationStats, error) {
+
+	hours, err := r.FindHourly(ctx, datastreamID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]models.ObservationStats, len(hours))
+	for i, hour := range hours {
+		stats[i] = rollupStats(datastreamID, hour.StartTime, hour.Metrics, hour.FirstObservation, hour.LastObservation)
+		stats[i].Hour = hour.ID.Hour
+	}
+	return stats, nil
+}
+
+// DailyStatistics converts the daily summaries of a window into observation statistics
+func (r *RollupRepository) DailyStatistics(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time) ([]models.ObservationStats, error) {
+
+	days, err := r.FindDaily(ctx, datastreamID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]models.ObservationStats, len(days))
+	for i, day := range days {
+		stats[i] = rollupStats(datastreamID, day.Date, day.Statistics, day.FirstObservation, day.LastObservation)
+	}
+	return stats, nil
+}
+
+// RerollDay rebuilds the daily summary of a day from its hourly aggregates
+func (r *RollupRepository) RerollDay(ctx context.Context, key models.DayKey) error {
+	date := models.DateKeyTime(key.DateKey)
+	hours, err := r.FindHourly(ctx, key.DatastreamID, date, date.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	if len(hours) == 0 {
+		if _, err := r.daily.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+			return fmt.Errorf("failed to delete daily summary: %w", err)
+		}
+		return nil
+	}
+
+	summary := &models.DailySummary{
+		ID:               key,
+		Date:             date,
+		Hours:            len(hours),
+		FirstObservation: hours[0].FirstObservation,
+		LastObservation:  hours[len(hours)-1].LastObservation,
+		Statistics:       models.MetricSet{},
+		ComputedAt:       time.Now().UTC(),
+	}
+	for _, hour := range hours {
+		summary.Statistics.Merge(hour.Metrics)
+		summary.Quality.Merge(hour.Quality)
+	}
+
+	return upsertVersioned(ctx, r.daily, key, summary)
+}
+
+// FindDaily retrieves the daily summaries of a datastream within [startTime, endTime)
+func (r *RollupRepository) FindDaily(ctx context.Context, datastreamID string,
+	startTime, endTime time.Time) ([]models.DailySummary, error) {
+
+	summaries := []models.DailySummary{}
+	err := findDocuments(ctx, r.daily, Query{
+		Filter: bson.M{
+			"_id.datastreamId": datastreamID,
+			"date":             bson.M{"$gte": startTime, "$lt": endTime},
+		},
+		Sort: bson.D{{Key: "date", Value: 1}},
+	}, &summaries)
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// rollupStats converts rollup metrics into the shape returned by the raw statistics queries
+func rollupStats(datastreamID string, date time.Time, metrics models.MetricSet,
+	first, last time.Time) models.ObservationStats {
+
+	total := metrics.Total()
+	return models.ObservationStats{
+		DatastreamID:     datastreamID,
+		Date:             date.Format("2006-01-02"),
+		Count:            total.Count,
+		Average:          total.Avg,
+		Min:              total.Min,
+		Max:              total.Max,
+		StdDev:           total.StdDev,
+		FirstObservation: first,
+		LastObservation:  last,
+	}
+}
+
+// upsertVersioned writes a rollup document, incrementing its version on every write
+func upsertVersioned(ctx context.Context, collection *mongo.Collection, id, doc interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", collection.Name(), err)
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", collection.Name(), err)
+	}
+	delete(fields, "_id")
+	delete(fields, "version")
+
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", collection.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// SensorRepository handles sensor data operations
+type SensorRepository struct {
+	collection *mongo.Collection
+	database   *mongo.Database
+}
+
+// NewSensorRepository creates a new sensor repository
+func NewSensorRepository(db *mongo.Database) *SensorRepository {
+	return &SensorRepository{
+		collection: db.Collection("sensors"),
+		database:   db,
+	}
+}
+
+// Create adds a new sensor
+func (r *SensorRepository) Create(ctx context.Context, sensor *models.Sensor) error {
+	now := time.Now().UTC()
+	sensor.CreatedAt = now
+	sensor.UpdatedAt = now
+
+	return insertDocument(ctx, r.collection, sensor.ID, sensor)
+}
+
+// FindByID retrieves a sensor by its identifier
+func (r *SensorRepository) FindByID(ctx context.Context, id string) (*models.Sensor, error) {
+	var sensor models.Sensor
+	if err := findDocument(ctx, r.collection, id, &sensor); err != nil {
+		return nil, err
+	}
+	return &sensor, nil
+}
+
+// Find retrieves sensors matching a query
+func (r *SensorRepository) Find(ctx context.Context, query Query) ([]models.Sensor, error) {
+	sensors := []models.Sensor{}
+	if err := findDocuments(ctx, r.collection, query, &sensors); err != nil {
+		return nil, err
+	}
+	return sensors, nil
+}
+
+// Update replaces an existing sensor
+func (r *SensorRepository) Update(ctx context.Context, sensor *models.Sensor) error {
+	sensor.UpdatedAt = time.Now().UTC()
+	return replaceDocument(ctx, r.collection, sensor.ID, sensor)
+}
+
+// Delete removes a sensor that no datastream uses
+func (r *SensorRepository) Delete(ctx context.Context, id string) error {
+	if err := checkUnreferenced(ctx, r.database.Collection("datastreams"), "sensorId", id); err != nil {
+		return err
+	}
+	return deleteDocument(ctx, r.collection, id)
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/geo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// ThingRepository handles thing data operations
+type ThingRepository struct {
+	collection *mongo.Collection
+	database   *mongo.Database
+	history    *HistoricalLocationRepository
+}
+
+// NewThingRepository creates a new thing repository
+func NewThingRepository(db *mongo.Database) *ThingRepository {
+	return &ThingRepository{
+		collection: db.Collection("things"),
+		database:   db,
+		history:    NewHistoricalLocationRepository(db),
+	}
+}
+
+// Create adds a new thing and records its initial location
+func (r *ThingRepository) Create(ctx context.Context, thing *models.Thing) error {
+	if err := r.checkReferences(ctx, thing); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	thing.CreatedAt = now
+	thing.UpdatedAt = now
+
+	if err := insertDocument(ctx, r.collection, thing.ID, thing); err != nil {
+		return err
+	}
+	return r.locationChanged(ctx, thing.ID, nil, thing.CurrentLocation, now)
+}
+
+// FindByID retrieves a thing by its identifier
+func (r *ThingRepository) FindByID(ctx context.Context, id string) (*models.Thing, error) {
+	var thing models.Thing
+	if err := findDocument(ctx, r.collection, id, &thing); err != nil {
+		return nil, err
+	}
+	return &thing, nil
+}
+
+// Find retrieves things matching a query
+func (r *ThingRepository) Find(ctx context.Context, query Query) ([]models.Thing, error) {
+	things := []models.Thing{}
+	if err := findDocuments(ctx, r.collection, query, &things); err != nil {
+		return nil, err
+	}
+	return things, nil
+}
+
+// Update replaces an existing thing, recording a historical location if it moved
+func (r *ThingRepository) Update(ctx context.Context, thing *models.Thing) error {
+	if err := r.checkReferences(ctx, thing); err != nil {
+		return err
+	}
+
+	thing.UpdatedAt = time.Now().UTC()
+
+	var previous models.Thing
+	if err := swapDocument(ctx, r.collection, thing.ID, thing, &previous); err != nil {
+		return err
+	}
+	return r.locationChanged(ctx, thing.ID, previous.CurrentLocation, thing.CurrentLocation, thing.UpdatedAt)
+}
+
+// MoveTo sets the current location of a thing as of time at, recording a historical location.
+// Mobile things report ad-hoc positions with an empty LocationID.
+func (r *ThingRepository) MoveTo(ctx context.Context, thingID string, loc models.ThingLocation, at time.Time) error {
+	if err := checkExists(ctx, r.database.Collection("locations"), loc.LocationID); err != nil {
+		return err
+	}
+
+	var previous models.Thing
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": thingID},
+		bson.M{"$set": bson.M{"currentLocation": loc, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("things %s: %w", thingID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move thing %s: %w", thingID, err)
+	}
+
+	return r.locationChanged(ctx, thingID, previous.CurrentLocation, &loc, at)
+}
+
+// Delete removes a thing that no datastream belongs to, along with its location history
+func (r *ThingRepository) Delete(ctx context.Context, id string) error {
+	if err := checkUnreferenced(ctx, r.database.Collection("datastreams"), "thingId", id); err != nil {
+		return err
+	}
+
+	if err := deleteDocument(ctx, r.collection, id); err != nil {
+		return err
+	}
+
+	_, err := r.database.Collection("locations").UpdateMany(ctx,
+		bson.M{"things": id},
+		bson.M{"$pull": bson.M{"things": id}})
+	if err != nil {
+		return fmt.Errorf("failed to detach thing from locations: %w", err)
+	}
+	return r.history.DeleteByThing(ctx, id)
+}
+
+// checkReferences verifies that the current location of a thing exists
+func (r *ThingRepository) checkReferences(ctx context.Context, thing *models.Thing) error {
+	if thing.CurrentLocation == nil {
+		return nil
+	}
+	return checkExists(ctx, r.database.Collection("locations"), thing.CurrentLocation.LocationID)
+}
+
+// locationChanged records a historical location and keeps Location.things in step
+// when a thing's current location differs from its previous one
+func (r *ThingRepository) locationChanged(ctx context.Context, thingID string,
+	previous, current *models.ThingLocation, at time.Time) error {
+
+	if current == nil || sameLocation(previous, current) {
+		return nil
+	}
+
+	locations := r.database.Collection("locations")
+
+	// Snapshot the geometry so the history survives later edits of the location
+	geometry := current.Location
+	if geometry == nil && current.LocationID != "" {
+		var loc models.Location
+		if err := findDocument(ctx, locations, current.LocationID, &loc); err != nil {
+			return err
+		}
+		geometry = loc.Location
+	}
+
+	hl := &models.HistoricalLocation{
+		ThingID:    thingID,
+		LocationID: current.LocationID,
+		Time:       at,
+		Location:   geometry,
+		Movement:   &models.Movement{},
+	}
+	if previous != nil {
+		hl.Movement.PreviousLocationID = previous.LocationID
+		from, ok1 := geo.PointCoordinates(previous.Location)
+		to, ok2 := geo.PointCoordinates(geometry)
+		if ok1 && ok2 {
+			hl.Movement.DistanceMoved = geo.Distance(from, to)
+		}
+	}
+	if err := r.history.Record(ctx, hl); err != nil {
+		return err
+	}
+
+	if previous != nil && previous.LocationID != "" && previous.LocationID != current.LocationID {
+		if _, err := locations.UpdateOne(ctx, bson.M{"_id": previous.LocationID},
+			bson.M{"$pull": bson.M{"things": thingID}}); err != nil {
+			return fmt.Errorf("failed to detach thing from location: %w", err)
+		}
+	}
+	if current.LocationID != "" {
+		if _, err := locations.UpdateOne(ctx, bson.M{"_id": current.LocationID},
+			bson.M{"$addToSet": bson.M{"things": thingID}}); err != nil {
+			return fmt.Errorf("failed to attach thing to location: %w", err)
+		}
+	}
+	return nil
+}
+
+// sameLocation reports whether two thing locations refer to the same place
+func sameLocation(a, b *models.ThingLocation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.LocationID != b.LocationID {
+		return false
+	}
+	return sameGeometry(a.Location, b.Location)
+}
+
+// sameGeometry compares two geometries by their BSON encoding
+func sameGeometry(a, b *models.GeoJSON) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	// Decoded BSON arrays and float slices encode identically
+	ea, errA := bson.Marshal(a)
+	eb, errB := bson.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ea, eb)
+}
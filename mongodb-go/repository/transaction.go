@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrTransactionsUnsupported is returned when the server is a standalone mongod,
+// which does not support transactions
+var ErrTransactionsUnsupported = errors.New("transactions are not supported by this deployment")
+
+// ErrInvalidEntity is returned when an entity fails the constraints of its validate tags
+var ErrInvalidEntity = errors.New("invalid entity")
+
+// Validate checks an entity against its validate struct tags
+func Validate(v interface{}) error {
+	if err := validate.Struct(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntity, err)
+	}
+	return nil
+}
+
+// WithTransaction runs fn in a transaction, committing if it returns nil and
+// aborting otherwise. Repository calls made with the context fn receives take
+// part in the transaction. fn may run more than once, as the driver retries
+// transient errors. Time series collections such as observations cannot be
+// written in a transaction.
+func WithTransaction(ctx context.Context, db *mongo.Database, fn func(ctx context.Context) error) error {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if transactionsUnsupported(err) {
+		return ErrTransactionsUnsupported
+	}
+	return err
+}
+
+// transactionsUnsupported reports whether err is a standalone server rejecting a transaction
+func transactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	// IllegalOperation: "Transaction numbers are only allowed on a replica set member or mongos"
+	return cmdErr.Code == 20 && strings.Contains(cmdErr.Message, "Transaction numbers")
+}
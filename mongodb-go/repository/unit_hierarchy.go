@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// unitGraph holds the broader/narrower links between cached units. A link is
+// taken from either side, since the ontology does not always list both.
+type unitGraph struct {
+	units    map[string]*models.UnitOfMeasurement
+	order    []string
+	parents  map[string][]string
+	children map[string][]string
+}
+
+// loadUnitGraph reads the hierarchy links of every cached unit
+func (r *UnitRepository) loadUnitGraph(ctx context.Context) (*unitGraph, error) {
+	units, err := r.Find(ctx, Query{Sort: bson.D{{Key: "uri", Value: 1}}})
+	if err != nil {
+		return nil, err
+	}
+
+	g := &unitGraph{
+		units:    make(map[string]*models.UnitOfMeasurement, len(units)),
+		parents:  map[string][]string{},
+		children: map[string][]string{},
+	}
+	seen := map[[2]string]bool{}
+	link := func(parent, child string) {
+		if parent == "" || child == "" || parent == child || seen[[2]string{parent, child}] {
+			return
+		}
+		seen[[2]string{parent, child}] = true
+		g.parents[child] = append(g.parents[child], parent)
+		g.children[parent] = append(g.children[parent], child)
+	}
+
+	for i := range units {
+		u := &units[i]
+		g.units[u.URI] = u
+		g.order = append(g.order, u.URI)
+		if u.Hierarchy == nil {
+			continue
+		}
+		for _, b := range u.Hierarchy.Broader {
+			link(b.URI, u.URI)
+		}
+		for _, n := range u.Hierarchy.Narrower {
+			link(u.URI, n.URI)
+		}
+	}
+	return g, nil
+}
+
+// closure returns every URI reachable from uri along edges, sorted; cycles are followed once
+func closure(uri string, edges map[string][]string) []string {
+	visited := map[string]bool{uri: true}
+	stack := append([]string(nil), edges[uri]...)
+	reached := []string{}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[next] {
+			continue
+		}
+		visited[next] = true
+		reached = append(reached, next)
+		stack = append(stack, edges[next]...)
+	}
+	sort.Strings(reached)
+	return reached
+}
+
+// ComputeClosures stores the transitive broader and narrower units of every
+// cached unit, returning the number of units whose closures changed
+func (r *UnitRepository) ComputeClosures(ctx context.Context) (int64, error) {
+	g, err := r.loadUnitGraph(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(g.order) == 0 {
+		return 0, nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(g.order))
+	for _, uri := range g.order {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"uri": uri}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"hierarchy.broaderTransitive":  closure(uri, g.parents),
+				"hierarchy.narrowerTransitive": closure(uri, g.children),
+			}}))
+	}
+
+	result, err := r.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, fmt.Errorf("failed to store unit closures: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// RebuildHierarchyCache materializes one tree per root unit (a unit without
+// cached broader units) into unit_hierarchy_cache and drops trees whose root
+// is gone. Cycles are cut where a path would revisit a unit, so a cycle without
+// a root appears in no tree.
+func (r *UnitRepository) RebuildHierarchyCache(ctx context.Context, now time.Time) (int, error) {
+	g, err := r.loadUnitGraph(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	trees := 0
+	for _, uri := range g.order {
+		if g.hasCachedParent(uri) {
+			continue
+		}
+
+		cache := models.UnitHierarchyCache{RootURI: uri, LastRebuilt: now}
+		cache.Tree = g.tree(uri, "", 0, nil, &cache)
+		if root := g.units[uri]; root.Classification != nil {
+			cache.Dimension = root.Classification.Dimension
+		}
+
+		_, err := r.hierarchy.ReplaceOne(ctx, bson.M{"rootUri": uri}, cache, options.Replace().SetUpsert(true))
+		if err != nil {
+			return trees, fmt.Errorf("failed to save unit hierarchy %s: %w", uri, err)
+		}
+		trees++
+	}
+
+	if _, err := r.hierarchy.DeleteMany(ctx, bson.M{"lastRebuilt": bson.M{"$lt": now}}); err != nil {
+		return trees, fmt.Errorf("failed to remove old unit hierarchies: %w", err)
+	}
+	return trees, nil
+}
+
+// hasCachedParent reports whether a unit has a broader unit in the cache
+func (g *unitGraph) hasCachedParent(uri string) bool {
+	for _, parent := range g.parents[uri] {
+		if _, ok := g.units[parent]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tree builds the subtree of uri, appending its nodes to the cache's flat list;
+// ancestors holds the URIs on the path from the root
+func (g *unitGraph) tree(uri, parentPath string, depth int, ancestors []string, cache *models.UnitHierarchyCache) models.UnitTreeNode {
+	unit := g.units[uri]
+	node := models.UnitTreeNode{
+		URI:   uri,
+		Code:  unit.UCUMCode,
+		Label: unit.Labels.Preferred["en"],
+		Path:  parentPath + "/" + unit.UCUMCode,
+		Depth: depth,
+	}
+	if cache.Dimension == "" && unit.Classification != nil {
+		cache.Dimension = unit.Classification.Dimension
+	}
+	cache.AllNodes = append(cache.AllNodes, models.UnitHierarchyNode{
+		URI:       uri,
+		Code:      unit.UCUMCode,
+		Path:      node.Path,
+		Depth:     depth,
+		Ancestors: append([]string{}, ancestors...),
+	})
+
+	path := append(ancestors[:len(ancestors):len(ancestors)], uri)
+	for _, child := range g.children[uri] {
+		if _, ok := g.units[child]; !ok || contains(path, child) {
+			continue
+		}
+		node.Children = append(node.Children, g.tree(child, node.Path, depth+1, path, cache))
+	}
+	return node
+}
+
+// contains reports whether s holds v
+func contains(s []string, v string) bool {
+	for _, item := range s {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// FindHierarchy retrieves the materialized tree rooted at a unit
+func (r *UnitRepository) FindHierarchy(ctx context.Context, rootURI string) (*models.UnitHierarchyCache, error) {
+	var cache models.UnitHierarchyCache
+	err := r.hierarchy.FindOne(ctx, bson.M{"rootUri": rootURI}).Decode(&cache)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("unit hierarchy %s: %w", rootURI, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unit hierarchy %s: %w", rootURI, err)
+	}
+	return &cache, nil
+}
+
+// Ancestors retrieves every broader unit of a unit, using the stored closure
+func (r *UnitRepository) Ancestors(ctx context.Context, uri string) ([]models.UnitOfMeasurement, error) {
+	return r.Find(ctx, Query{Filter: bson.M{"hierarchy.narrowerTransitive": uri}})
+}
+
+// Descendants retrieves every narrower unit of a unit, using the stored closure
+func (r *UnitRepository) Descendants(ctx context.Context, uri string) ([]models.UnitOfMeasurement, error) {
+	return r.Find(ctx, Query{Filter: bson.M{"hierarchy.broaderTransitive": uri}})
+}
+
+// FindByQuantity retrieves every unit of a quantity: the units classified with
+// the dimension (e.g. "temperature") or quantity kind URI, the units narrower
+// than them, and the units in hierarchy trees of that dimension
+func (r *UnitRepository) FindByQuantity(ctx context.Context, quantity string) ([]models.UnitOfMeasurement, error) {
+	uris, err := r.collection.Distinct(ctx, "uri", bson.M{"$or": bson.A{
+		bson.M{"classification.dimension": quantity},
+		bson.M{"classification.quantityKind": quantity},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find units of %s: %w", quantity, err)
+	}
+	nodeURIs, err := r.hierarchy.Distinct(ctx, "allNodes.uri", bson.M{"dimension": quantity})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unit hierarchies of %s: %w", quantity, err)
+	}
+	uris = append(uris, nodeURIs...)
+	if len(uris) == 0 {
+		return []models.UnitOfMeasurement{}, nil
+	}
+
+	return r.Find(ctx, Query{
+		Filter: bson.M{"$or": bson.A{
+			bson.M{"uri": bson.M{"$in": uris}},
+			bson.M{"hierarchy.broaderTransitive": bson.M{"$in": uris}},
+		}},
+		Sort: bson.D{{Key: "ucumCode", Value: 1}},
+	})
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// Sync statuses of cached units
+const (
+	SyncStatusCurrent = "current"
+	SyncStatusStale   = "stale"
+	SyncStatusError   = "error"
+)
+
+// UnitRepository handles the unit_of_measurement cache of the UCUM ontology
+// and the unit_hierarchy_cache trees materialized from it
+type UnitRepository struct {
+	collection *mongo.Collection
+	hierarchy  *mongo.Collection
+}
+
+// NewUnitRepository creates a new unit repository
+func NewUnitRepository(db *mongo.Database) *UnitRepository {
+	return &UnitRepository{
+		collection: db.Collection("unit_of_measurement"),
+		hierarchy:  db.Collection("unit_hierarchy_cache"),
+	}
+}
+
+// FindByURI retrieves a unit by its ontology URI
+func (r *UnitRepository) FindByURI(ctx context.Context, uri string) (*models.UnitOfMeasurement, error) {
+	return r.findOne(ctx, bson.M{"uri": uri}, uri)
+}
+
+// FindByCode retrieves a unit by its UCUM code
+func (r *UnitRepository) FindByCode(ctx context.Context, code string) (*models.UnitOfMeasurement, error) {
+	return r.findOne(ctx, bson.M{"ucumCode": code}, code)
+}
+
+// LookupCode retrieves a unit by its UCUM code, returning nil if it is not cached
+func (r *UnitRepository) LookupCode(ctx context.Context, code string) (*models.UnitOfMeasurement, error) {
+	unit, err := r.FindByCode(ctx, code)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return unit, err
+}
+
+// LookupURI retrieves a unit by its ontology URI, returning nil if it is not cached
+func (r *UnitRepository) LookupURI(ctx context.Context, uri string) (*models.UnitOfMeasurement, error) {
+	unit, err := r.FindByURI(ctx, uri)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return unit, err
+}
+
+// Find retrieves units matching a query
+func (r *UnitRepository) Find(ctx context.Context, query Query) ([]models.UnitOfMeasurement, error) {
+	units := []models.UnitOfMeasurement{}
+	if err := findDocuments(ctx, r.collection, query, &units); err != nil {
+		return nil, err
+	}
+	return units, nil
+}
+
+// SaveFromOntology upserts the ontology-sourced fields of a unit by URI. Locally
+// maintained fields (conversion, classification, usage statistics) are kept.
+func (r *UnitRepository) SaveFromOntology(ctx context.Context, unit *models.UnitOfMeasurement) error {
+	set := bson.M{
+		"ucumCode": unit.UCUMCode,
+		"labels":   unit.Labels,
+		"metadata": unit.Metadata,
+	}
+	if unit.Definition != nil {
+		set["definition"] = unit.Definition
+	}
+	if unit.Hierarchy != nil {
+		set["hierarchy.broader"] = unit.Hierarchy.Broader
+		set["hierarchy.narrower"] = unit.Hierarchy.Narrower
+	}
+
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"uri": unit.URI},
+		bson.M{"$set": set},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save unit %s: %w", unit.URI, err)
+	}
+	return nil
+}
+
+// SaveConversion sets the locally maintained conversion and classification of a unit
+func (r *UnitRepository) SaveConversion(ctx context.Context, code string,
+	conversion *models.UnitConversion, classification *models.UnitClassification) error {
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"ucumCode": code},
+		bson.M{"$set": bson.M{
+			"conversion":     conversion,
+			"classification": classification,
+		}})
+	if err != nil {
+		return fmt.Errorf("failed to save conversion of unit %s: %w", code, err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("unit %s: %w", code, ErrNotFound)
+	}
+	return nil
+}
+
+// MarkSyncError flags a cached unit whose refresh failed; its cached data is kept
+func (r *UnitRepository) MarkSyncError(ctx context.Context, uri string) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"uri": uri},
+		bson.M{"$set": bson.M{"metadata.syncStatus": SyncStatusError}})
+	if err != nil {
+		return fmt.Errorf("failed to mark unit %s: %w", uri, err)
+	}
+	return nil
+}
+
+// MarkStale flags current units whose cache expired before now
+func (r *UnitRepository) MarkStale(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{
+			"metadata.syncStatus":  SyncStatusCurrent,
+			"metadata.cacheExpiry": bson.M{"$lt": now},
+		},
+		bson.M{"$set": bson.M{"metadata.syncStatus": SyncStatusStale}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark stale units: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// NeedsSync reports whether the cache is empty or holds units that are not current
+func (r *UnitRepository) NeedsSync(ctx context.Context, now time.Time) (bool, error) {
+	err := r.collection.FindOne(ctx, bson.M{}).Err()
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check unit cache: %w", err)
+	}
+
+	err = r.collection.FindOne(ctx, bson.M{"$or": bson.A{
+		bson.M{"metadata.syncStatus": bson.M{"$ne": SyncStatusCurrent}},
+		bson.M{"metadata.cacheExpiry": bson.M{"$lt": now}},
+	}}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check unit cache: %w", err)
+	}
+	return true, nil
+}
+
+// findOne decodes the unit matching filter
+func (r *UnitRepository) findOne(ctx context.Context, filter bson.M, key string) (*models.UnitOfMeasurement, error) {
+	var unit models.UnitOfMeasurement
+	err := r.collection.FindOne(ctx, filter).Decode(&unit)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("unit %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unit %s: %w", key, err)
+	}
+	return &unit, nil
+}
@@ -0,0 +1,126 @@
+package schemas
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/sirupsen/logrus"
+)
+
+// DimensionIndexes lists the indexes of the SensorThings dimension collections
+var DimensionIndexes = map[string][]mongo.IndexModel{
+	"datastreams": {
+		{
+			Keys:    bson.D{{Key: "thingId", Value: 1}},
+			Options: options.Index().SetName("idx_thing").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "sensorId", Value: 1}},
+			Options: options.Index().SetName("idx_sensor").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "observedPropertyId", Value: 1}},
+			Options: options.Index().SetName("idx_observed_property").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "is_current", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("idx_current").SetBackground(true),
+		},
+		{
+			Keys:    bson.M{"observedArea": "2dsphere"},
+			Options: options.Index().SetName("idx_observed_area_2dsphere").SetBackground(true).SetSparse(true),
+		},
+	},
+	"things": {
+		{
+			Keys:    bson.M{"currentLocation.location": "2dsphere"},
+			Options: options.Index().SetName("idx_current_location_2dsphere").SetBackground(true).SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "properties.status", Value: 1}},
+			Options: options.Index().SetName("idx_status").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetName("idx_text").SetBackground(true),
+		},
+	},
+	"sensors": {
+		{
+			Keys:    bson.D{{Key: "encodingType", Value: 1}},
+			Options: options.Index().SetName("idx_encoding_type").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "properties.manufacturer", Value: 1}, {Key: "properties.model", Value: 1}},
+			Options: options.Index().SetName("idx_manufacturer_model").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "nextCalibration", Value: 1}},
+			Options: options.Index().SetName("idx_next_calibration").SetBackground(true),
+		},
+	},
+	"observed_properties": {
+		{
+			Keys:    bson.D{{Key: "category", Value: 1}, {Key: "subcategory", Value: 1}},
+			Options: options.Index().SetName("idx_category").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetName("idx_text").SetBackground(true),
+		},
+	},
+	"historical_locations": {
+		{
+			Keys:    bson.D{{Key: "thingId", Value: 1}, {Key: "time", Value: -1}},
+			Options: options.Index().SetName("idx_thing_time").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "locationId", Value: 1}, {Key: "time", Value: -1}},
+			Options: options.Index().SetName("idx_location_time").SetBackground(true),
+		},
+	},
+	"locations": {
+		{
+			Keys:    bson.M{"location": "2dsphere"},
+			Options: options.Index().SetName("idx_location_2dsphere").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hierarchy.building", Value: 1}, {Key: "hierarchy.floor", Value: 1}},
+			Options: options.Index().SetName("idx_building_floor").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "things", Value: 1}},
+			Options: options.Index().SetName("idx_things").SetBackground(true),
+		},
+	},
+}
+
+// CreateDimensionIndexes creates the indexes of the dimension collections
+func CreateDimensionIndexes(ctx context.Context, db *mongo.Database, logger *logrus.Logger) error {
+	for name, indexes := range DimensionIndexes {
+		if err := createIndexes(ctx, db.Collection(name), indexes, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIndexes creates indexes one by one, tolerating those that already exist
+func createIndexes(ctx context.Context, collection *mongo.Collection, indexes []mongo.IndexModel, logger *logrus.Logger) error {
+	for _, index := range indexes {
+		if _, err := collection.Indexes().CreateOne(ctx, index); err != nil {
+			if !mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("failed to create index %s on %s: %w", *index.Options.Name, collection.Name(), err)
+			}
+			if logger != nil {
+				logger.Warnf("Index %s on %s already exists", *index.Options.Name, collection.Name())
+			}
+		} else if logger != nil {
+			logger.Infof("Created index: %s.%s", collection.Name(), *index.Options.Name)
+		}
+	}
+	return nil
+}
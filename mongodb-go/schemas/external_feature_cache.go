@@ -0,0 +1,128 @@
+package schemas
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/sirupsen/logrus"
+)
+
+// ExternalFeatureCacheSchema defines the validation schema for cached OGC API features
+var ExternalFeatureCacheSchema = bson.M{
+	"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"required": []string{"_id", "source", "feature", "cache"},
+		"properties": bson.M{
+			"_id": bson.M{
+				"bsonType":    "string",
+				"description": "Collection and feature identifier (e.g., parcels/12345)",
+			},
+			"source": bson.M{
+				"bsonType": "object",
+				"required": []string{"collection", "featureId"},
+				"properties": bson.M{
+					"api":        bson.M{"bsonType": "string"},
+					"collection": bson.M{"bsonType": "string"},
+					"featureId":  bson.M{"bsonType": "string"},
+					"fullUri":    bson.M{"bsonType": "string"},
+				},
+			},
+			"feature": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"type":       bson.M{"bsonType": "string", "enum": []string{"Feature"}},
+					"id":         bson.M{"bsonType": "string"},
+					"geometry":   bson.M{"bsonType": []string{"object", "null"}},
+					"properties": bson.M{"bsonType": "object"},
+					"bbox": bson.M{
+						"bsonType": "array",
+						"items":    bson.M{"bsonType": "number"},
+					},
+				},
+			},
+			"cache": bson.M{
+				"bsonType": "object",
+				"required": []string{"fetchedAt", "expires"},
+				"properties": bson.M{
+					"fetchedAt":   bson.M{"bsonType": "date"},
+					"expires":     bson.M{"bsonType": "date"},
+					"contentCrs":  bson.M{"bsonType": "string"},
+					"contentType": bson.M{"bsonType": "string"},
+					"size":        bson.M{"bsonType": "int"},
+					"hash":        bson.M{"bsonType": "string"},
+					"lastError":   bson.M{"bsonType": "string"},
+					"lastErrorAt": bson.M{"bsonType": "date"},
+				},
+			},
+			"usage": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"accessCount":  bson.M{"bsonType": []string{"int", "long"}},
+					"lastAccessed": bson.M{"bsonType": "date"},
+					"referencedByFOIs": bson.M{
+						"bsonType": "array",
+						"items":    bson.M{"bsonType": "string"},
+					},
+				},
+			},
+		},
+	},
+}
+
+// CreateExternalFeatureCacheIndexes creates indexes for the external_feature_cache collection
+func CreateExternalFeatureCacheIndexes(ctx context.Context, collection *mongo.Collection, logger *logrus.Logger) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "source.fullUri", Value: 1}},
+			Options: options.Index().SetName("idx_full_uri").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "cache.expires", Value: 1}},
+			Options: options.Index().SetName("idx_cache_expires").SetBackground(true),
+		},
+		{
+			Keys:    bson.M{"feature.geometry": "2dsphere"},
+			Options: options.Index().SetName("idx_geometry_2dsphere").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "usage.referencedByFOIs", Value: 1}},
+			Options: options.Index().SetName("idx_referenced_by_fois").SetBackground(true),
+		},
+	}
+
+	return createIndexes(ctx, collection, indexes, logger)
+}
+
+// CreateExternalFeatureCacheCollection creates the external_feature_cache collection with validation
+func CreateExternalFeatureCacheCollection(ctx context.Context, db *mongo.Database, logger *logrus.Logger) error {
+	opts := options.CreateCollection().
+		SetValidator(ExternalFeatureCacheSchema).
+		SetValidationLevel("moderate").
+		SetValidationAction("warn")
+
+	if err := db.CreateCollection(ctx, "external_feature_cache", opts); err != nil {
+		if !isNamespaceExists(err) {
+			return fmt.Errorf("failed to create external_feature_cache collection: %w", err)
+		}
+		if logger != nil {
+			logger.Warn("External feature cache collection already exists")
+		}
+	} else if logger != nil {
+		logger.Info("Created collection: external_feature_cache")
+	}
+
+	if err := CreateExternalFeatureCacheIndexes(ctx, db.Collection("external_feature_cache"), logger); err != nil {
+		return err
+	}
+
+	reportIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "startedAt", Value: -1}},
+			Options: options.Index().SetName("idx_started_at").SetBackground(true),
+		},
+	}
+	return createIndexes(ctx, db.Collection("feature_sync_reports"), reportIndexes, logger)
+}
@@ -0,0 +1,238 @@
+package schemas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/sirupsen/logrus"
+)
+
+// hierarchyNodeSchema validates a parent or child entry of a feature hierarchy
+var hierarchyNodeSchema = bson.M{
+	"bsonType": "array",
+	"items": bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"level": bson.M{"bsonType": "string"},
+			"foiId": bson.M{"bsonType": "string"},
+			"name":  bson.M{"bsonType": "string"},
+		},
+	},
+}
+
+// FeatureOfInterestSchema defines the validation schema for features of interest
+var FeatureOfInterestSchema = bson.M{
+	"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"required": []string{"_id", "name", "encodingType", "feature"},
+		"properties": bson.M{
+			"_id": bson.M{
+				"bsonType":    "string",
+				"description": "Unique identifier for the feature of interest",
+			},
+			"name": bson.M{
+				"bsonType":    "string",
+				"description": "Human-readable name",
+			},
+			"description": bson.M{
+				"bsonType":    "string",
+				"description": "Detailed description",
+			},
+			"encodingType": bson.M{
+				"bsonType":    "string",
+				"enum":        []string{"application/vnd.geo+json", "application/gml+xml"},
+				"description": "Encoding type of the feature",
+			},
+			"feature": bson.M{
+				"bsonType": "object",
+				"required": []string{"type"},
+				"properties": bson.M{
+					"type": bson.M{
+						"bsonType": "string",
+						"enum":     []string{"Feature"},
+					},
+					"geometry": bson.M{
+						"bsonType": "object",
+						"properties": bson.M{
+							"type": bson.M{
+								"bsonType": "string",
+								"enum":     []string{"Point", "LineString", "Polygon", "MultiPoint", "MultiLineString", "MultiPolygon"},
+							},
+							"coordinates": bson.M{"bsonType": "array"},
+						},
+					},
+					"properties": bson.M{"bsonType": "object"},
+				},
+			},
+			"externalFeatures": bson.M{
+				"bsonType": "array",
+				"items": bson.M{
+					"bsonType": "object",
+					"properties": bson.M{
+						"featureId": bson.M{"bsonType": "string"},
+						"featureAPI": bson.M{
+							"bsonType": "object",
+							"properties": bson.M{
+								"baseUrl":    bson.M{"bsonType": "string"},
+								"collection": bson.M{"bsonType": "string"},
+								"itemId":     bson.M{"bsonType": "string"},
+								"href":       bson.M{"bsonType": "string"},
+								"formats": bson.M{
+									"bsonType": "array",
+									"items":    bson.M{"bsonType": "string"},
+								},
+							},
+						},
+						"association": bson.M{
+							"bsonType": "object",
+							"properties": bson.M{
+								"type": bson.M{
+									"bsonType": "string",
+									"enum":     []string{"within", "contains", "intersects", "touches", "overlaps", "part_of"},
+								},
+								"role":          bson.M{"bsonType": "string"},
+								"confidence":    bson.M{"bsonType": "number", "minimum": 0, "maximum": 1},
+								"establishedAt": bson.M{"bsonType": "date"},
+								"establishedBy": bson.M{"bsonType": "string"},
+								"validFrom":     bson.M{"bsonType": "date"},
+								"validTo":       bson.M{"bsonType": []string{"date", "null"}},
+								"closedReason": bson.M{
+									"bsonType": "string",
+									"enum":     []string{"geometry_changed", "removed_upstream"},
+								},
+							},
+						},
+						"cachedMetadata": bson.M{
+							"bsonType": "object",
+							"properties": bson.M{
+								"lastFetched": bson.M{"bsonType": "date"},
+								"properties":  bson.M{"bsonType": "object"},
+								"bbox": bson.M{
+									"bsonType": "array",
+									"items":    bson.M{"bsonType": "number"},
+								},
+								"updateFrequency": bson.M{"bsonType": "string"},
+							},
+						},
+					},
+				},
+			},
+			"hierarchy": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"level": bson.M{
+						"bsonType":    "string",
+						"description": "Level of the feature itself (e.g., site, building, floor, room)",
+					},
+					"parents":  hierarchyNodeSchema,
+					"children": hierarchyNodeSchema,
+					"semanticRelations": bson.M{
+						"bsonType": "array",
+						"items": bson.M{
+							"bsonType": "object",
+							"properties": bson.M{
+								"predicate": bson.M{"bsonType": "string"},
+								"uri":       bson.M{"bsonType": "string"},
+								"source":    bson.M{"bsonType": "string"},
+							},
+						},
+					},
+				},
+			},
+			"tags": bson.M{
+				"bsonType": "array",
+				"items":    bson.M{"bsonType": "string"},
+			},
+			"created_at": bson.M{"bsonType": "date"},
+			"updated_at": bson.M{"bsonType": "date"},
+		},
+	},
+}
+
+// CreateFeatureOfInterestIndexes creates indexes for the features_of_interest collection
+func CreateFeatureOfInterestIndexes(ctx context.Context, collection *mongo.Collection, logger *logrus.Logger) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"feature.geometry": "2dsphere"},
+			Options: options.Index().SetName("idx_geometry_2dsphere").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "externalFeatures.featureId", Value: 1}},
+			Options: options.Index().SetName("idx_external_feature_id").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "externalFeatures.association.type", Value: 1}},
+			Options: options.Index().SetName("idx_association_type").SetBackground(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "externalFeatures.featureAPI.collection", Value: 1},
+				{Key: "externalFeatures.featureAPI.itemId", Value: 1},
+			},
+			Options: options.Index().SetName("idx_api_collection_item").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hierarchy.parents.foiId", Value: 1}},
+			Options: options.Index().SetName("idx_parent_foi").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hierarchy.children.foiId", Value: 1}},
+			Options: options.Index().SetName("idx_child_foi").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hierarchy.level", Value: 1}},
+			Options: options.Index().SetName("idx_hierarchy_level").SetBackground(true).SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "observationContext.relevantProperties", Value: 1}},
+			Options: options.Index().SetName("idx_relevant_properties").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "tags", Value: 1}},
+			Options: options.Index().SetName("idx_tags").SetBackground(true),
+		},
+		{
+			Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetName("idx_text_search").SetBackground(true).
+				SetWeights(bson.M{"name": 10, "description": 5}),
+		},
+		{
+			Keys:    bson.D{{Key: "updated_at", Value: -1}},
+			Options: options.Index().SetName("idx_updated_at").SetBackground(true),
+		},
+	}
+
+	return createIndexes(ctx, collection, indexes, logger)
+}
+
+// CreateFeatureOfInterestCollection creates the features_of_interest collection with validation
+func CreateFeatureOfInterestCollection(ctx context.Context, db *mongo.Database, logger *logrus.Logger) error {
+	opts := options.CreateCollection().
+		SetValidator(FeatureOfInterestSchema).
+		SetValidationLevel("moderate").
+		SetValidationAction("warn")
+
+	if err := db.CreateCollection(ctx, "features_of_interest", opts); err != nil {
+		if !isNamespaceExists(err) {
+			return fmt.Errorf("failed to create features_of_interest collection: %w", err)
+		}
+		if logger != nil {
+			logger.Warn("Features of interest collection already exists")
+		}
+	} else if logger != nil {
+		logger.Info("Created collection: features_of_interest")
+	}
+
+	// Indexes are created either way so new ones reach existing deployments
+	return CreateFeatureOfInterestIndexes(ctx, db.Collection("features_of_interest"), logger)
+}
+
+// isNamespaceExists reports whether a create failed because the collection already exists
+func isNamespaceExists(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceExists"
+}
@@ -100,6 +100,11 @@ func CreateObservationIndexes(ctx context.Context, collection *mongo.Collection,
 			Keys:    bson.D{{Key: "datastream.datastreamId", Value: 1}, {Key: "phenomenonTime", Value: -1}},
 			Options: options.Index().SetName("idx_datastream_time").SetBackground(true),
 		},
+		{
+			// Supports keyset pagination on (phenomenonTime, _id) in either direction
+			Keys:    bson.D{{Key: "datastream.datastreamId", Value: 1}, {Key: "phenomenonTime", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("idx_datastream_time_id").SetBackground(true),
+		},
 		{
 			Keys:    bson.D{{Key: "datastream.thingId", Value: 1}, {Key: "phenomenonTime", Value: -1}},
 			Options: options.Index().SetName("idx_thing_time").SetBackground(true),
@@ -0,0 +1,49 @@
+package schemas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/sirupsen/logrus"
+)
+
+// observationFeedTTLIndex is the index that expires old feed entries
+const observationFeedTTLIndex = "idx_stored_at_ttl"
+
+// CreateObservationFeedIndexes creates the TTL index that keeps the
+// observation_feed collection to the given retention, updating the
+// retention of an existing index
+func CreateObservationFeedIndexes(ctx context.Context, db *mongo.Database, retention time.Duration,
+	logger *logrus.Logger) error {
+
+	seconds := int32(retention / time.Second)
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "storedAt", Value: 1}},
+		Options: options.Index().SetName(observationFeedTTLIndex).SetExpireAfterSeconds(seconds),
+	}
+	_, err := db.Collection("observation_feed").Indexes().CreateOne(ctx, index)
+
+	// IndexOptionsConflict: the index exists with another retention
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 85 {
+		err = db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: "observation_feed"},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: observationFeedTTLIndex},
+				{Key: "expireAfterSeconds", Value: seconds},
+			}},
+		}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create index %s on observation_feed: %w", observationFeedTTLIndex, err)
+	}
+	if logger != nil {
+		logger.Infof("Observation feed entries expire after %s", retention)
+	}
+	return nil
+}
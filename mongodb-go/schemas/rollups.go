@@ -0,0 +1,54 @@
+package schemas
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/sirupsen/logrus"
+)
+
+// RollupIndexes lists the indexes of the pre-aggregated rollup collections
+var RollupIndexes = map[string][]mongo.IndexModel{
+	"hourly_aggregates": {
+		{
+			Keys:    bson.D{{Key: "_id.date_key", Value: 1}, {Key: "_id.datastreamId", Value: 1}},
+			Options: options.Index().SetName("idx_date_datastream").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "computed_at", Value: 1}},
+			Options: options.Index().SetName("idx_computed_at").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "_id.datastreamId", Value: 1}, {Key: "start_time", Value: 1}},
+			Options: options.Index().SetName("idx_datastream_start").SetBackground(true),
+		},
+	},
+	"daily_summaries": {
+		{
+			Keys:    bson.D{{Key: "_id.datastreamId", Value: 1}, {Key: "date", Value: 1}},
+			Options: options.Index().SetName("idx_datastream_date").SetBackground(true),
+		},
+	},
+	"rollup_pending": {
+		{
+			Keys:    bson.D{{Key: "_id.datastreamId", Value: 1}, {Key: "start", Value: 1}},
+			Options: options.Index().SetName("idx_datastream_start").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "marked_at", Value: 1}},
+			Options: options.Index().SetName("idx_marked_at").SetBackground(true),
+		},
+	},
+}
+
+// CreateRollupIndexes creates the indexes of the rollup collections
+func CreateRollupIndexes(ctx context.Context, db *mongo.Database, logger *logrus.Logger) error {
+	for name, indexes := range RollupIndexes {
+		if err := createIndexes(ctx, db.Collection(name), indexes, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package schemas
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/sirupsen/logrus"
+)
+
+// unitReferenceSchema validates a broader or narrower entry of a unit hierarchy
+var unitReferenceSchema = bson.M{
+	"bsonType": "array",
+	"items": bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"uri":      bson.M{"bsonType": "string"},
+			"ucumCode": bson.M{"bsonType": "string"},
+			"label":    bson.M{"bsonType": "string"},
+			"level":    bson.M{"bsonType": "int"},
+		},
+	},
+}
+
+// UnitOfMeasurementSchema defines the validation schema for cached UCUM units
+var UnitOfMeasurementSchema = bson.M{
+	"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"required": []string{"uri", "ucumCode"},
+		"properties": bson.M{
+			"uri": bson.M{
+				"bsonType":    "string",
+				"description": "External ontology URI (e.g., http://urn.fi/URN:NBN:fi:au:ucum:r133)",
+			},
+			"ucumCode": bson.M{
+				"bsonType":    "string",
+				"description": "UCUM notation/code (e.g., atm)",
+			},
+			"ucumCodeCaseSensitive": bson.M{
+				"bsonType":    "string",
+				"description": "Case-sensitive variant",
+			},
+			"labels": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"preferred": bson.M{
+						"bsonType":    "object",
+						"description": "Preferred labels by language",
+					},
+					"alternative": bson.M{
+						"bsonType": "array",
+						"items": bson.M{
+							"bsonType": "object",
+							"properties": bson.M{
+								"lang":  bson.M{"bsonType": "string"},
+								"value": bson.M{"bsonType": "string"},
+							},
+						},
+					},
+				},
+			},
+			"definition": bson.M{
+				"bsonType":    "object",
+				"description": "Unit definition by language",
+			},
+			"hierarchy": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"broader":  unitReferenceSchema,
+					"narrower": unitReferenceSchema,
+					"broaderTransitive": bson.M{
+						"bsonType":    "array",
+						"items":       bson.M{"bsonType": "string"},
+						"description": "All ancestor URIs",
+					},
+					"narrowerTransitive": bson.M{
+						"bsonType":    "array",
+						"items":       bson.M{"bsonType": "string"},
+						"description": "All descendant URIs",
+					},
+				},
+			},
+			"conversion": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"toBaseUnit": bson.M{
+						"bsonType": "object",
+						"properties": bson.M{
+							"factor":       bson.M{"bsonType": "number"},
+							"offset":       bson.M{"bsonType": "number"},
+							"baseUnitUri":  bson.M{"bsonType": "string"},
+							"baseUnitCode": bson.M{"bsonType": "string"},
+							"operation": bson.M{
+								"bsonType": "string",
+								"enum":     []string{"multiply", "divide", "add", "subtract"},
+							},
+						},
+					},
+					"formula":  bson.M{"bsonType": "string"},
+					"isMetric": bson.M{"bsonType": "bool"},
+				},
+			},
+			"classification": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"dimension": bson.M{
+						"bsonType":    "string",
+						"description": "Physical dimension (e.g., pressure, temperature)",
+					},
+					"quantityKind": bson.M{"bsonType": "string"},
+					"system": bson.M{
+						"bsonType": "string",
+						"enum":     []string{"SI", "SI-derived", "imperial", "US-customary", "other"},
+					},
+					"categories": bson.M{
+						"bsonType": "array",
+						"items":    bson.M{"bsonType": "string"},
+					},
+					"isBaseUnit":  bson.M{"bsonType": "bool"},
+					"isArbitrary": bson.M{"bsonType": "bool"},
+				},
+			},
+			"iso80000": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"compliant": bson.M{"bsonType": "bool"},
+					"part":      bson.M{"bsonType": "string"},
+					"section":   bson.M{"bsonType": "string"},
+					"status": bson.M{
+						"bsonType": "string",
+						"enum":     []string{"accepted", "deprecated", "obsolete"},
+					},
+					"alternativeSymbols": bson.M{
+						"bsonType": "array",
+						"items":    bson.M{"bsonType": "string"},
+					},
+				},
+			},
+			"metadata": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"fintoLastFetched": bson.M{"bsonType": "date"},
+					"fintoVersion":     bson.M{"bsonType": "string"},
+					"cacheExpiry":      bson.M{"bsonType": "date"},
+					"syncStatus": bson.M{
+						"bsonType": "string",
+						"enum":     []string{"current", "stale", "error"},
+					},
+					"lastModified": bson.M{"bsonType": "date"},
+				},
+			},
+			"sensorThings": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"observationCount": bson.M{"bsonType": "long"},
+					"datastreamCount":  bson.M{"bsonType": "int"},
+					"lastUsed":         bson.M{"bsonType": "date"},
+					"frequencyScore":   bson.M{"bsonType": "number", "minimum": 0, "maximum": 1},
+				},
+			},
+		},
+	},
+}
+
+// CreateUnitOfMeasurementIndexes creates indexes for the unit_of_measurement collection.
+// Unlike the mongosh setup script there is no TTL index on metadata.cacheExpiry:
+// expired units are marked stale and kept, since datastreams still refer to them.
+func CreateUnitOfMeasurementIndexes(ctx context.Context, collection *mongo.Collection, logger *logrus.Logger) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "uri", Value: 1}},
+			Options: options.Index().SetName("idx_uri").SetUnique(true).SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "ucumCode", Value: 1}},
+			Options: options.Index().SetName("idx_ucum_code").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "classification.dimension", Value: 1}},
+			Options: options.Index().SetName("idx_dimension").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hierarchy.broaderTransitive", Value: 1}},
+			Options: options.Index().SetName("idx_broader_transitive").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hierarchy.narrowerTransitive", Value: 1}},
+			Options: options.Index().SetName("idx_narrower_transitive").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "sensorThings.frequencyScore", Value: -1}},
+			Options: options.Index().SetName("idx_frequency_score").SetBackground(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "classification.dimension", Value: 1},
+				{Key: "classification.isBaseUnit", Value: 1},
+			},
+			Options: options.Index().SetName("idx_dimension_base").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "ucumCode", Value: "text"}, {Key: "labels.preferred.en", Value: "text"}},
+			Options: options.Index().SetName("idx_text_search").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "metadata.cacheExpiry", Value: 1}},
+			Options: options.Index().SetName("idx_cache_expiry").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "iso80000.compliant", Value: 1}},
+			Options: options.Index().SetName("idx_iso_compliant").SetBackground(true).SetSparse(true),
+		},
+	}
+
+	return createIndexes(ctx, collection, indexes, logger)
+}
+
+// CreateUnitOfMeasurementCollection creates the unit_of_measurement collection with validation
+func CreateUnitOfMeasurementCollection(ctx context.Context, db *mongo.Database, logger *logrus.Logger) error {
+	opts := options.CreateCollection().
+		SetValidator(UnitOfMeasurementSchema).
+		SetValidationLevel("moderate").
+		SetValidationAction("warn")
+
+	if err := db.CreateCollection(ctx, "unit_of_measurement", opts); err != nil {
+		if !isNamespaceExists(err) {
+			return fmt.Errorf("failed to create unit_of_measurement collection: %w", err)
+		}
+		if logger != nil {
+			logger.Warn("Unit of measurement collection already exists")
+		}
+	} else if logger != nil {
+		logger.Info("Created collection: unit_of_measurement")
+	}
+
+	return CreateUnitOfMeasurementIndexes(ctx, db.Collection("unit_of_measurement"), logger)
+}
+
+// CreateUnitHierarchyCacheIndexes creates indexes for the unit_hierarchy_cache collection
+func CreateUnitHierarchyCacheIndexes(ctx context.Context, db *mongo.Database, logger *logrus.Logger) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "rootUri", Value: 1}},
+			Options: options.Index().SetName("idx_root_uri").SetUnique(true).SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "dimension", Value: 1}},
+			Options: options.Index().SetName("idx_dimension").SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "allNodes.uri", Value: 1}},
+			Options: options.Index().SetName("idx_node_uri").SetBackground(true),
+		},
+	}
+
+	return createIndexes(ctx, db.Collection("unit_hierarchy_cache"), indexes, logger)
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/geo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// EstablishedByAuto marks associations created by spatial analysis
+const EstablishedByAuto = "auto"
+
+// DefaultAutoRelations are the relations recorded when AutoAssociateOptions lists none
+var DefaultAutoRelations = []string{geo.RelationWithin, geo.RelationContains, geo.RelationOverlaps, geo.RelationIntersects}
+
+// AutoAssociateOptions controls which spatial relations become associations
+type AutoAssociateOptions struct {
+	// Relations lists the relation types to record; touching features have no
+	// overlap and are only recorded when listed with a zero MinConfidence
+	Relations []string
+	// MinConfidence is the lowest overlap ratio recorded
+	MinConfidence float64
+	// Role is stored on each association created
+	Role string
+}
+
+// RelatedFeature is an external feature and its spatial relation to a geometry
+type RelatedFeature struct {
+	Feature  *ogcapi.Feature
+	Relation geo.Relation
+}
+
+// AutoAssociationResult lists the associations created for a feature of interest
+type AutoAssociationResult struct {
+	Created []models.ExternalFeature `json:"created"`
+	// Existing counts related features already associated
+	Existing int `json:"existing"`
+}
+
+// AssociationService associates features of interest with the external features
+// they are spatially related to
+type AssociationService struct {
+	client *ogcapi.Client
+	fois   *repository.FeatureOfInterestRepository
+	logger *logrus.Logger
+}
+
+// NewAssociationService creates a new association service
+func NewAssociationService(db *mongo.Database, client *ogcapi.Client, logger *logrus.Logger) *AssociationService {
+	return &AssociationService{
+		client: client,
+		fois:   repository.NewFeatureOfInterestRepository(db),
+		logger: logger,
+	}
+}
+
+// FindRelated returns the features of a collection related to a geometry. Candidates
+// are the features intersecting its bounding box; each is related in process.
+func (s *AssociationService) FindRelated(ctx context.Context, geometry *models.GeoJSON, collection string,
+	opts AutoAssociateOptions) ([]RelatedFeature, error) {
+
+	bbox, ok := geo.Bounds(geometry)
+	if !ok {
+		return nil, fmt.Errorf("geometry has no coordinates")
+	}
+	relations := opts.Relations
+	if len(relations) == 0 {
+		relations = DefaultAutoRelations
+	}
+
+	related := []RelatedFeature{}
+	err := s.client.AllItems(ctx, collection, ogcapi.ItemsQuery{BBox: bbox}, func(f *ogcapi.Feature) error {
+		relation, ok := geo.Relate(geometry, f.Geometry)
+		if !ok {
+			s.logger.WithField("feature", repository.ExternalFeatureCacheID(collection, f.ID)).
+				Debug("Skipping external feature without a usable geometry")
+			return nil
+		}
+		if slices.Contains(relations, relation.Type) && relation.Ratio >= opts.MinConfidence {
+			related = append(related, RelatedFeature{Feature: f, Relation: relation})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s features: %w", collection, err)
+	}
+	return related, nil
+}
+
+// Associate relates a feature of interest to the features of a collection and
+// records an association with each related feature it is not yet associated
+// with. Confidence is the overlap ratio.
+func (s *AssociationService) Associate(ctx context.Context, foiID, collection string,
+	opts AutoAssociateOptions) (*AutoAssociationResult, error) {
+
+	foi, err := s.fois.FindByID(ctx, foiID)
+	if err != nil {
+		return nil, err
+	}
+	if foi.Feature.Geometry == nil {
+		return nil, fmt.Errorf("feature of interest %s has no geometry", foiID)
+	}
+
+	related, err := s.FindRelated(ctx, foi.Feature.Geometry, collection, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	result := &AutoAssociationResult{Created: []models.ExternalFeature{}}
+	for _, r := range related {
+		ext := s.externalFeature(collection, r, opts.Role, now)
+		err := s.fois.AddAssociation(ctx, foiID, ext)
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			result.Existing++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Created = append(result.Created, ext)
+	}
+	return result, nil
+}
+
+// externalFeature builds the association of a related feature
+func (s *AssociationService) externalFeature(collection string, r RelatedFeature, role string, now time.Time) models.ExternalFeature {
+	bbox := r.Feature.BBox
+	if len(bbox) == 0 {
+		bbox, _ = geo.Bounds(r.Feature.Geometry)
+	}
+	return models.ExternalFeature{
+		FeatureID: repository.ExternalFeatureCacheID(collection, r.Feature.ID),
+		FeatureAPI: models.ExternalAPIConfig{
+			BaseURL:    s.client.BaseURL(),
+			Collection: collection,
+			ItemID:     r.Feature.ID,
+			Href:       s.client.ItemURL(collection, r.Feature.ID),
+			Formats:    []string{"application/geo+json"},
+		},
+		Association: models.Association{
+			Type:          r.Relation.Type,
+			Role:          role,
+			Confidence:    math.Round(r.Relation.Ratio*1000) / 1000,
+			EstablishedAt: now,
+			EstablishedBy: EstablishedByAuto,
+			ValidFrom:     now,
+		},
+		CachedMetadata: &models.CachedMetadata{
+			LastFetched: now,
+			Properties:  r.Feature.Properties,
+			BBox:        bbox,
+		},
+	}
+}
@@ -6,22 +6,29 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
-	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
 )
 
 // DateDimensionService handles date dimension operations
 type DateDimensionService struct {
-	db     *mongo.Database
-	logger *logrus.Logger
+	db       *mongo.Database
+	logger   *logrus.Logger
+	calendar *models.HolidayCalendar
 }
 
-// NewDateDimensionService creates a new date dimension service
-func NewDateDimensionService(db *mongo.Database, logger *logrus.Logger) *DateDimensionService {
+// NewDateDimensionService creates a new date dimension service using a holiday
+// calendar; a nil calendar selects the Finnish preset
+func NewDateDimensionService(db *mongo.Database, logger *logrus.Logger, calendar *models.HolidayCalendar) *DateDimensionService {
+	if calendar == nil {
+		calendar, _ = models.HolidayCalendarPreset("FI")
+	}
 	return &DateDimensionService{
-		db:     db,
-		logger: logger,
+		db:       db,
+		logger:   logger,
+		calendar: calendar,
 	}
 }
 
@@ -29,24 +36,24 @@ func NewDateDimensionService(db *mongo.Database, logger *logrus.Logger) *DateDim
 func (s *DateDimensionService) GenerateDateRange(ctx context.Context, 
 	startDate, endDate time.Time) ([]models.DateDimension, error) {
 	
-	// Define holidays (customize for your region)
-	holidays := map[string]string{
-		"01-01": "New Year's Day",
-		"07-01": "Canada Day",
-		"12-25": "Christmas Day",
-		"12-26": "Boxing Day",
-	}
+	// Holidays are resolved per year since movable feasts change dates
+	holidaysByYear := map[int]map[string]string{}
 
 	var dates []models.DateDimension
 	current := startDate
 
 	for !current.After(endDate) {
+		holidays, ok := holidaysByYear[current.Year()]
+		if !ok {
+			holidays = s.calendar.HolidaysInYear(current.Year())
+			holidaysByYear[current.Year()] = holidays
+		}
 		date := s.generateDateRecord(current, holidays)
 		dates = append(dates, date)
 		current = current.AddDate(0, 0, 1)
 	}
 
-	s.logger.Infof("Generated %d date records", len(dates))
+	s.logger.Infof("Generated %d date records using the %s holiday calendar", len(dates), s.calendar.Name)
 	return dates, nil
 }
 
@@ -157,35 +164,132 @@ func (s *DateDimensionService) dayOfQuarter(date time.Time, quarter int) int {
 	return int(date.Sub(quarterStart).Hours()/24) + 1
 }
 
-// InsertDateDimension inserts date dimension records into the database
-func (s *DateDimensionService) InsertDateDimension(ctx context.Context, dates []models.DateDimension) error {
+// UpsertDateDimension writes date dimension records into the database,
+// replacing existing records with the same date key so reruns are idempotent
+func (s *DateDimensionService) UpsertDateDimension(ctx context.Context, dates []models.DateDimension) error {
 	collection := s.db.Collection("date_dimension")
 	
-	// Convert to interface slice for insertion
-	docs := make([]interface{}, len(dates))
-	for i, date := range dates {
-		docs[i] = date
-	}
-	
-	// Insert in batches
+	// Upsert in batches
 	batchSize := 1000
-	for i := 0; i < len(docs); i += batchSize {
+	for i := 0; i < len(dates); i += batchSize {
 		end := i + batchSize
-		if end > len(docs) {
-			end = len(docs)
+		if end > len(dates) {
+			end = len(dates)
 		}
 		
-		batch := docs[i:end]
-		if _, err := collection.InsertMany(ctx, batch); err != nil {
-			return fmt.Errorf("failed to insert batch: %w", err)
+		writes := make([]mongo.WriteModel, 0, end-i)
+		for _, date := range dates[i:end] {
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": date.ID}).
+				SetReplacement(date).
+				SetUpsert(true))
+		}
+		if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to upsert batch: %w", err)
 		}
 		
-		s.logger.Infof("Inserted batch %d-%d of %d", i, end, len(docs))
+		s.logger.Infof("Upserted batch %d-%d of %d", i, end, len(dates))
 	}
 	
 	return nil
 }
 
+// ExtendForward generates the dates after the latest stored date up to and including
+// through, starting at from when the dimension is empty. It returns the number added.
+func (s *DateDimensionService) ExtendForward(ctx context.Context, from, through time.Time) (int, error) {
+	collection := s.db.Collection("date_dimension")
+	
+	var latest models.DateDimension
+	err := collection.FindOne(ctx, bson.M{},
+		options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&latest)
+	switch {
+	case err == mongo.ErrNoDocuments:
+	case err != nil:
+		return 0, fmt.Errorf("failed to find latest date: %w", err)
+	default:
+		from = models.DateKeyTime(latest.ID).AddDate(0, 0, 1)
+	}
+	
+	from = truncateDay(from)
+	through = truncateDay(through)
+	if from.After(through) {
+		return 0, nil
+	}
+	
+	dates, err := s.GenerateDateRange(ctx, from, through)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.UpsertDateDimension(ctx, dates); err != nil {
+		return 0, err
+	}
+	return len(dates), nil
+}
+
+// RefreshRelativeOffsets recomputes the offsets from today of every stored date in place
+func (s *DateDimensionService) RefreshRelativeOffsets(ctx context.Context, today time.Time) (int64, error) {
+	collection := s.db.Collection("date_dimension")
+	
+	// Integer division truncating towards zero, matching generateDateRecord
+	per := func(days int) bson.M {
+		return bson.M{"$toInt": bson.M{"$trunc": bson.M{"$divide": bson.A{"$days_from_today", days}}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"days_from_today": bson.M{"$dateDiff": bson.M{
+				"startDate": truncateDay(today),
+				"endDate":   "$full_date",
+				"unit":      "day",
+			}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"weeks_from_today":    per(7),
+			"months_from_today":   per(30),
+			"quarters_from_today": per(90),
+		}}},
+	}
+	
+	result, err := collection.UpdateMany(ctx, bson.M{}, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh relative offsets: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// StartDailyRefresh keeps the date dimension current until the context is cancelled:
+// it extends the dimension to horizonDays ahead and recomputes the relative offsets
+// right away, so a server that was down over midnight catches up, then again
+// shortly after every UTC midnight
+func (s *DateDimensionService) StartDailyRefresh(ctx context.Context, horizonDays int) {
+	for {
+		s.refresh(ctx, horizonDays)
+		
+		now := time.Now().UTC()
+		next := truncateDay(now).AddDate(0, 0, 1).Add(time.Minute)
+		
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+	}
+}
+
+// refresh extends the date dimension to horizonDays ahead and recomputes the relative offsets
+func (s *DateDimensionService) refresh(ctx context.Context, horizonDays int) {
+	today := truncateDay(time.Now().UTC())
+	added, err := s.ExtendForward(ctx, today, today.AddDate(0, 0, horizonDays))
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to extend date dimension")
+	}
+	refreshed, err := s.RefreshRelativeOffsets(ctx, today)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to refresh date dimension")
+		return
+	}
+	s.logger.Infof("Date dimension refreshed: %d dates added, %d updated", added, refreshed)
+}
+
 // GetDateDimension retrieves a date dimension record
 func (s *DateDimensionService) GetDateDimension(ctx context.Context, dateKey int) (*models.DateDimension, error) {
 	collection := s.db.Collection("date_dimension")
@@ -201,3 +305,9 @@ func (s *DateDimensionService) GetDateDimension(ctx context.Context, dateKey int
 	
 	return &date, nil
 }
+
+// truncateDay returns midnight UTC of the day containing t
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/geo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// FeatureCacheService serves external features from external_feature_cache.
+// Features are fetched from the OGC API on first use and again once they are
+// older than the cache TTL; concurrent requests for one feature share a fetch.
+type FeatureCacheService struct {
+	client   *ogcapi.Client
+	cache    *repository.ExternalFeatureCacheRepository
+	features *repository.FeatureOfInterestRepository
+	logger   *logrus.Logger
+	cacheTTL time.Duration
+
+	mu      sync.Mutex
+	fetches map[string]*featureFetch
+}
+
+// featureFetch is an upstream fetch shared by every caller waiting for it
+type featureFetch struct {
+	done  chan struct{}
+	entry *models.ExternalFeatureCache
+	err   error
+}
+
+// NewFeatureCacheService creates a new feature cache service; cached features expire after cacheTTL
+func NewFeatureCacheService(db *mongo.Database, client *ogcapi.Client, logger *logrus.Logger, cacheTTL time.Duration) *FeatureCacheService {
+	return &FeatureCacheService{
+		client:   client,
+		cache:    repository.NewExternalFeatureCacheRepository(db),
+		features: repository.NewFeatureOfInterestRepository(db),
+		logger:   logger,
+		cacheTTL: cacheTTL,
+		fetches:  map[string]*featureFetch{},
+	}
+}
+
+// Get returns a feature of a collection, from the cache while it is fresh and
+// from the API otherwise. If the API cannot be reached an expired entry is
+// served with Stale set; features the API no longer has are not.
+func (s *FeatureCacheService) Get(ctx context.Context, collection, featureID string) (*models.ExternalFeatureCache, error) {
+	return s.get(ctx, collection, featureID, "")
+}
+
+// Refresh fetches a feature from the API and stores it, whether or not the cached copy has expired
+func (s *FeatureCacheService) Refresh(ctx context.Context, collection, featureID string) (*models.ExternalFeatureCache, error) {
+	return s.fetch(ctx, collection, featureID)
+}
+
+// ResolveExternalFeatures returns a feature of interest with the cached metadata
+// of its external features brought up to date. Features that cannot be
+// resolved keep the metadata stored with the association.
+func (s *FeatureCacheService) ResolveExternalFeatures(ctx context.Context, foiID string) (*models.FeatureOfInterest, error) {
+	foi, err := s.features.FindByID(ctx, foiID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := map[string]*models.CachedMetadata{}
+	for i := range foi.ExternalFeatures {
+		ext := &foi.ExternalFeatures[i]
+		metadata, ok := resolved[ext.FeatureID]
+		if !ok {
+			metadata, err = s.resolve(ctx, foi.ID, ext)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				s.logger.WithError(err).WithFields(logrus.Fields{
+					"foi":     foi.ID,
+					"feature": ext.FeatureID,
+				}).Warn("Failed to resolve external feature")
+			}
+			resolved[ext.FeatureID] = metadata
+		}
+		if metadata != nil {
+			ext.CachedMetadata = metadata
+		}
+	}
+	return foi, nil
+}
+
+// resolve returns the current cached metadata of an external feature, saving it on the
+// feature of interest when it changed; nil if the feature is not served by this API
+func (s *FeatureCacheService) resolve(ctx context.Context, foiID string, ext *models.ExternalFeature) (*models.CachedMetadata, error) {
+	collection, featureID, ok := s.locate(ext)
+	if !ok {
+		return nil, nil
+	}
+
+	entry, err := s.get(ctx, collection, featureID, foiID)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &models.CachedMetadata{
+		LastFetched: entry.Cache.FetchedAt,
+		Properties:  entry.Feature.Properties,
+		BBox:        entry.Feature.BBox,
+		Stale:       entry.Stale,
+	}
+	if ext.CachedMetadata != nil {
+		metadata.UpdateFrequency = ext.CachedMetadata.UpdateFrequency
+		if ext.CachedMetadata.LastFetched.Equal(metadata.LastFetched) {
+			return metadata, nil
+		}
+	}
+	return metadata, s.features.UpdateCachedMetadata(ctx, foiID, ext.FeatureID, metadata)
+}
+
+// locate returns the collection and item identifier of an external feature served by this API
+func (s *FeatureCacheService) locate(ext *models.ExternalFeature) (string, string, bool) {
+	api := ext.FeatureAPI
+	if api.BaseURL != "" && strings.TrimRight(api.BaseURL, "/") != s.client.BaseURL() {
+		return "", "", false
+	}
+	featureID := api.ItemID
+	if featureID == "" {
+		featureID = ext.FeatureID
+	}
+	return api.Collection, featureID, api.Collection != "" && featureID != ""
+}
+
+// get serves a feature from the cache or the API and records the access
+func (s *FeatureCacheService) get(ctx context.Context, collection, featureID, foiID string) (*models.ExternalFeatureCache, error) {
+	id := repository.ExternalFeatureCacheID(collection, featureID)
+	cached, err := s.cache.FindByID(ctx, id)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if cached == nil || !cached.Fresh(now) {
+		fetched, err := s.fetch(ctx, collection, featureID)
+		switch {
+		case err == nil:
+			cached = fetched
+		case cached == nil, errors.Is(err, ogcapi.ErrNotFound), ctx.Err() != nil:
+			return nil, err
+		default:
+			s.logger.WithError(err).WithField("feature", id).Warn("Serving stale external feature")
+			cached.Stale = true
+		}
+	}
+
+	if err := s.cache.RecordAccess(ctx, id, foiID, now); err != nil {
+		s.logger.WithError(err).WithField("feature", id).Warn("Failed to record feature access")
+	}
+	return cached, nil
+}
+
+// fetch loads a feature from the API, joining a fetch of the same feature already in flight
+func (s *FeatureCacheService) fetch(ctx context.Context, collection, featureID string) (*models.ExternalFeatureCache, error) {
+	id := repository.ExternalFeatureCacheID(collection, featureID)
+
+	s.mu.Lock()
+	call, ok := s.fetches[id]
+	if !ok {
+		call = &featureFetch{done: make(chan struct{})}
+		s.fetches[id] = call
+		// The fetch outlives a caller that gives up, as others may be waiting for it
+		go s.load(context.WithoutCancel(ctx), id, collection, featureID, call)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	entry := *call.entry
+	return &entry, nil
+}
+
+// load fetches and stores a feature, completing call
+func (s *FeatureCacheService) load(ctx context.Context, id, collection, featureID string, call *featureFetch) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.fetches, id)
+		s.mu.Unlock()
+		close(call.done)
+	}()
+
+	now := time.Now().UTC()
+	feature, err := s.client.Item(ctx, collection, featureID, "")
+	if err != nil {
+		if !errors.Is(err, ogcapi.ErrNotFound) {
+			if markErr := s.cache.MarkFetchError(ctx, id, err, now); markErr != nil {
+				s.logger.WithError(markErr).WithField("feature", id).Warn("Failed to record fetch error")
+			}
+		}
+		call.err = err
+		return
+	}
+
+	entry := newCacheEntry(id, s.client.ItemURL(collection, featureID), s.client.BaseURL(), collection, featureID, feature)
+	entry.Cache.FetchedAt = now
+	entry.Cache.Expires = now.Add(s.cacheTTL)
+	if err := s.cache.Save(ctx, entry); err != nil {
+		call.err = err
+		return
+	}
+	call.entry = entry
+}
+
+// newCacheEntry maps a fetched feature onto the external_feature_cache schema
+func newCacheEntry(id, href, api, collection, featureID string, feature *ogcapi.Feature) *models.ExternalFeatureCache {
+	entry := &models.ExternalFeatureCache{
+		ID: id,
+		Source: models.ExternalFeatureSource{
+			API:        api,
+			Collection: collection,
+			FeatureID:  featureID,
+			FullURI:    href,
+		},
+		Feature: models.CachedFeature{
+			Type:       "Feature",
+			ID:         feature.ID,
+			Geometry:   feature.Geometry,
+			Properties: feature.Properties,
+			BBox:       feature.BBox,
+		},
+		Cache: models.FeatureCacheInfo{
+			ContentCRS:  feature.CRS,
+			ContentType: "application/geo+json",
+		},
+	}
+	if entry.Feature.ID == "" {
+		entry.Feature.ID = featureID
+	}
+	if len(entry.Feature.BBox) == 0 {
+		entry.Feature.BBox, _ = geo.Bounds(feature.Geometry)
+	}
+	for _, link := range feature.Links {
+		entry.Feature.Links = append(entry.Feature.Links, models.FeatureLink{Href: link.Href, Rel: link.Rel, Type: link.Type})
+	}
+	if data, err := json.Marshal(feature); err == nil {
+		sum := sha256.Sum256(data)
+		entry.Cache.Size = len(data)
+		entry.Cache.Hash = hex.EncodeToString(sum[:])
+	}
+	return entry
+}
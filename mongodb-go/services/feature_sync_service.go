@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/geo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/ogcapi"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// maxSyncErrors caps the failures listed individually in a sync report
+const maxSyncErrors = 100
+
+// Outcomes of refreshing one external feature
+const (
+	featureUpdated   = "updated"
+	featureUnchanged = "unchanged"
+	featureFailed    = "failed"
+	featureRemoved   = "removed"
+)
+
+// FeatureSyncService refreshes every external feature referenced by a feature
+// of interest, keeps the cached metadata of the associations current, and ends
+// associations whose external feature moved or disappeared
+type FeatureSyncService struct {
+	features  *FeatureCacheService
+	fois      *repository.FeatureOfInterestRepository
+	cache     *repository.ExternalFeatureCacheRepository
+	logger    *logrus.Logger
+	batchSize int64
+	tolerance float64
+}
+
+// featureOutcome is the result of refreshing one external feature during a run
+type featureOutcome struct {
+	status          string
+	geometryChanged bool
+	metadata        *models.CachedMetadata
+}
+
+// NewFeatureSyncService creates a new feature sync service. Features of interest
+// are read batchSize at a time; an association is ended when its feature's
+// geometry moves by more than tolerance meters.
+func NewFeatureSyncService(db *mongo.Database, features *FeatureCacheService, logger *logrus.Logger,
+	batchSize int, tolerance float64) *FeatureSyncService {
+
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &FeatureSyncService{
+		features:  features,
+		fois:      repository.NewFeatureOfInterestRepository(db),
+		cache:     repository.NewExternalFeatureCacheRepository(db),
+		logger:    logger,
+		batchSize: int64(batchSize),
+		tolerance: tolerance,
+	}
+}
+
+// Start syncs immediately and then every interval until the context is cancelled
+func (s *FeatureSyncService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync refreshes the external features of all features of interest and stores
+// a report of the run. Each feature is fetched once per run however many
+// features of interest refer to it; a feature that fails keeps its cached copy
+// and is retried on the next run.
+func (s *FeatureSyncService) Sync(ctx context.Context) (*models.FeatureSyncReport, error) {
+	report := &models.FeatureSyncReport{StartedAt: time.Now().UTC()}
+	outcomes := map[string]*featureOutcome{}
+
+	last := ""
+	for {
+		filter := bson.M{"externalFeatures.0": bson.M{"$exists": true}}
+		if last != "" {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+		batch, err := s.fois.Find(ctx, repository.Query{
+			Filter: filter,
+			Sort:   bson.D{{Key: "_id", Value: 1}},
+			Limit:  s.batchSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range batch {
+			if err := s.syncFeatureOfInterest(ctx, &batch[i], outcomes, report); err != nil {
+				return nil, err
+			}
+		}
+
+		if int64(len(batch)) < s.batchSize {
+			break
+		}
+		last = batch[len(batch)-1].ID
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if err := s.cache.SaveSyncReport(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// run performs a sync and logs its outcome
+func (s *FeatureSyncService) run(ctx context.Context) {
+	report, err := s.Sync(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.WithError(err).Error("Feature sync failed")
+		}
+		return
+	}
+	s.logger.WithFields(logrus.Fields{
+		"featuresOfInterest":      report.FeaturesOfInterest,
+		"features":                report.Features,
+		"updated":                 report.Updated,
+		"unchanged":               report.Unchanged,
+		"failed":                  report.Failed,
+		"removed":                 report.Removed,
+		"geometryChanged":         report.GeometryChanged,
+		"associationsInvalidated": report.AssociationsInvalidated,
+		"duration":                report.FinishedAt.Sub(report.StartedAt).String(),
+	}).Info("Feature sync completed")
+}
+
+// syncFeatureOfInterest refreshes the external features of one feature of interest
+// and applies the outcomes to its associations
+func (s *FeatureSyncService) syncFeatureOfInterest(ctx context.Context, foi *models.FeatureOfInterest,
+	outcomes map[string]*featureOutcome, report *models.FeatureSyncReport) error {
+
+	report.FeaturesOfInterest++
+	updated := map[string]bool{}
+	for i := range foi.ExternalFeatures {
+		ext := &foi.ExternalFeatures[i]
+		collection, featureID, ok := s.features.locate(ext)
+		if !ok {
+			continue
+		}
+
+		id := repository.ExternalFeatureCacheID(collection, featureID)
+		outcome, ok := outcomes[id]
+		if !ok {
+			outcome = s.refresh(ctx, collection, featureID, report)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			outcomes[id] = outcome
+		}
+
+		if outcome.metadata != nil && !updated[ext.FeatureID] {
+			updated[ext.FeatureID] = true
+			if err := s.updateMetadata(ctx, foi.ID, ext, outcome.metadata); err != nil {
+				return err
+			}
+		}
+
+		reason := ""
+		switch {
+		case outcome.status == featureRemoved:
+			reason = repository.AssociationClosedRemoved
+		case outcome.geometryChanged:
+			reason = repository.AssociationClosedGeometryChanged
+		}
+		if reason == "" || ext.Association.ValidTo != nil {
+			continue
+		}
+		closed, err := s.fois.InvalidateAssociation(ctx, foi.ID, ext.FeatureID, reason, report.StartedAt)
+		if err != nil {
+			return err
+		}
+		if closed {
+			report.AssociationsInvalidated++
+			s.logger.WithFields(logrus.Fields{
+				"foi":     foi.ID,
+				"feature": id,
+				"reason":  reason,
+			}).Info("Invalidated feature association")
+		}
+	}
+	return nil
+}
+
+// refresh fetches one external feature and compares it with the cached copy
+func (s *FeatureSyncService) refresh(ctx context.Context, collection, featureID string,
+	report *models.FeatureSyncReport) *featureOutcome {
+
+	report.Features++
+	id := repository.ExternalFeatureCacheID(collection, featureID)
+	previous, err := s.cache.FindByID(ctx, id)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return s.failed(collection, featureID, err, report)
+	}
+
+	entry, err := s.features.Refresh(ctx, collection, featureID)
+	if errors.Is(err, ogcapi.ErrNotFound) {
+		report.Removed++
+		if previous != nil {
+			if err := s.cache.Delete(ctx, id); err != nil && !errors.Is(err, repository.ErrNotFound) {
+				s.logger.WithError(err).WithField("feature", id).Warn("Failed to remove cached feature")
+			}
+		}
+		return &featureOutcome{status: featureRemoved}
+	}
+	if err != nil {
+		return s.failed(collection, featureID, err, report)
+	}
+
+	outcome := &featureOutcome{
+		status: featureUpdated,
+		metadata: &models.CachedMetadata{
+			LastFetched: entry.Cache.FetchedAt,
+			Properties:  entry.Feature.Properties,
+			BBox:        entry.Feature.BBox,
+		},
+	}
+	if previous != nil && previous.Cache.Hash != "" && previous.Cache.Hash == entry.Cache.Hash {
+		outcome.status = featureUnchanged
+		report.Unchanged++
+		return outcome
+	}
+	report.Updated++
+
+	if previous != nil && s.geometryChanged(previous.Feature.Geometry, entry.Feature.Geometry) {
+		outcome.geometryChanged = true
+		report.GeometryChanged++
+	}
+	return outcome
+}
+
+// geometryChanged reports whether a geometry moved by more than the tolerance
+func (s *FeatureSyncService) geometryChanged(previous, current *models.GeoJSON) bool {
+	if previous == nil || current == nil {
+		return previous != current
+	}
+	if previous.Type != current.Type {
+		return true
+	}
+	d, ok := geo.Displacement(previous, current)
+	return !ok || d > s.tolerance
+}
+
+// failed records a feature that could not be refreshed
+func (s *FeatureSyncService) failed(collection, featureID string, err error,
+	report *models.FeatureSyncReport) *featureOutcome {
+
+	report.Failed++
+	if len(report.Errors) < maxSyncErrors {
+		report.Errors = append(report.Errors, models.FeatureSyncError{
+			Collection: collection,
+			FeatureID:  featureID,
+			Error:      err.Error(),
+		})
+	}
+	s.logger.WithError(err).WithField("feature", repository.ExternalFeatureCacheID(collection, featureID)).
+		Warn("Failed to sync external feature")
+	return &featureOutcome{status: featureFailed}
+}
+
+// updateMetadata stores refreshed metadata on the associations of a feature of
+// interest with an external feature, keeping their update frequency
+func (s *FeatureSyncService) updateMetadata(ctx context.Context, foiID string, ext *models.ExternalFeature,
+	metadata *models.CachedMetadata) error {
+
+	if ext.CachedMetadata != nil {
+		if ext.CachedMetadata.LastFetched.Equal(metadata.LastFetched) {
+			return nil
+		}
+		copied := *metadata
+		copied.UpdateFrequency = ext.CachedMetadata.UpdateFrequency
+		metadata = &copied
+	}
+	return s.fois.UpdateCachedMetadata(ctx, foiID, ext.FeatureID, metadata)
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// LoadHolidayCalendar returns the holiday calendar selected by configuration.
+// With a rules file, the calendar is read from JSON or YAML (by extension) and
+// may extend a preset; otherwise the preset with the given country code is used.
+func LoadHolidayCalendar(preset, rulesFile string) (*models.HolidayCalendar, error) {
+	if rulesFile == "" {
+		return models.HolidayCalendarPreset(preset)
+	}
+
+	data, err := os.ReadFile(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read holiday rules: %w", err)
+	}
+
+	var custom models.HolidayCalendar
+	switch strings.ToLower(filepath.Ext(rulesFile)) {
+	case ".json":
+		err = json.Unmarshal(data, &custom)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &custom)
+	default:
+		return nil, fmt.Errorf("unsupported holiday rules format %q, use .json, .yaml or .yml", filepath.Ext(rulesFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse holiday rules %s: %w", rulesFile, err)
+	}
+
+	calendar, err := extendCalendar(&custom)
+	if err != nil {
+		return nil, err
+	}
+	if err := calendar.Validate(); err != nil {
+		return nil, err
+	}
+	return calendar, nil
+}
+
+// extendCalendar merges a custom calendar onto the preset it extends;
+// custom holidays replace preset holidays with the same name
+func extendCalendar(custom *models.HolidayCalendar) (*models.HolidayCalendar, error) {
+	if custom.Extends == "" {
+		return custom, nil
+	}
+
+	base, err := models.HolidayCalendarPreset(custom.Extends)
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := map[string]bool{}
+	for _, h := range custom.Holidays {
+		replaced[h.Name] = true
+	}
+
+	merged := &models.HolidayCalendar{Name: custom.Name, Extends: custom.Extends}
+	if merged.Name == "" {
+		merged.Name = base.Name
+	}
+	for _, h := range base.Holidays {
+		if !replaced[h.Name] {
+			merged.Holidays = append(merged.Holidays, h)
+		}
+	}
+	merged.Holidays = append(merged.Holidays, custom.Holidays...)
+	return merged, nil
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/rdf"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// semanticPredicates maps the bare predicate names used in semantic relations to their IRIs
+var semanticPredicates = map[string]string{
+	"sameAs":       rdf.OWL + "sameAs",
+	"seeAlso":      rdf.RDFS + "seeAlso",
+	"exactMatch":   rdf.SKOS + "exactMatch",
+	"closeMatch":   rdf.SKOS + "closeMatch",
+	"broadMatch":   rdf.SKOS + "broadMatch",
+	"narrowMatch":  rdf.SKOS + "narrowMatch",
+	"relatedMatch": rdf.SKOS + "relatedMatch",
+	"isPartOf":     rdf.DCTerms + "isPartOf",
+	"hasPart":      rdf.DCTerms + "hasPart",
+}
+
+// associationPredicates maps association types to GeoSPARQL simple feature relations
+var associationPredicates = map[string]string{
+	"within":     rdf.GeoSPARQL + "sfWithin",
+	"contains":   rdf.GeoSPARQL + "sfContains",
+	"intersects": rdf.GeoSPARQL + "sfIntersects",
+	"touches":    rdf.GeoSPARQL + "sfTouches",
+	"overlaps":   rdf.GeoSPARQL + "sfOverlaps",
+	"part_of":    rdf.DCTerms + "isPartOf",
+}
+
+// LinkedDataService exports features of interest and units of measurement as
+// RDF: features of interest as SOSA features with GeoSPARQL geometries, and
+// units as SKOS concepts
+type LinkedDataService struct {
+	fois      *repository.FeatureOfInterestRepository
+	units     *repository.UnitRepository
+	logger    *logrus.Logger
+	batchSize int64
+}
+
+// NewLinkedDataService creates a new linked data service
+func NewLinkedDataService(db *mongo.Database, logger *logrus.Logger) *LinkedDataService {
+	return &LinkedDataService{
+		fois:      repository.NewFeatureOfInterestRepository(db),
+		units:     repository.NewUnitRepository(db),
+		logger:    logger,
+		batchSize: 500,
+	}
+}
+
+// FeatureOfInterestIRI returns the IRI of a feature of interest below a SensorThings service root
+func FeatureOfInterestIRI(root, id string) string {
+	return fmt.Sprintf("%s/FeaturesOfInterest('%s')", root, strings.ReplaceAll(id, "'", "''"))
+}
+
+// Dataset exports every feature of interest and unit of measurement
+func (s *LinkedDataService) Dataset(ctx context.Context, root string) (*rdf.Graph, error) {
+	g, err := s.FeaturesOfInterest(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	units, err := s.Units(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.Merge(units)
+	return g, nil
+}
+
+// FeatureOfInterest exports one feature of interest. Its IRI and those of the
+// features of interest it links to are built from the service root.
+func (s *LinkedDataService) FeatureOfInterest(ctx context.Context, root, id string) (*rdf.Graph, error) {
+	foi, err := s.fois.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	g := rdf.NewGraph()
+	s.addFeatureOfInterest(g, root, foi)
+	return g, nil
+}
+
+// FeaturesOfInterest exports every feature of interest
+func (s *LinkedDataService) FeaturesOfInterest(ctx context.Context, root string) (*rdf.Graph, error) {
+	g := rdf.NewGraph()
+	last := ""
+	for {
+		filter := bson.M{}
+		if last != "" {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+		batch, err := s.fois.Find(ctx, repository.Query{
+			Filter: filter,
+			Sort:   bson.D{{Key: "_id", Value: 1}},
+			Limit:  s.batchSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			s.addFeatureOfInterest(g, root, &batch[i])
+		}
+		if int64(len(batch)) < s.batchSize {
+			return g, nil
+		}
+		last = batch[len(batch)-1].ID
+	}
+}
+
+// Units exports every cached unit of measurement with its broader and narrower units
+func (s *LinkedDataService) Units(ctx context.Context) (*rdf.Graph, error) {
+	g := rdf.NewGraph()
+	var last primitive.ObjectID
+	for {
+		filter := bson.M{}
+		if !last.IsZero() {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+		batch, err := s.units.Find(ctx, repository.Query{
+			Filter: filter,
+			Sort:   bson.D{{Key: "_id", Value: 1}},
+			Limit:  s.batchSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			addUnit(g, &batch[i])
+		}
+		if int64(len(batch)) < s.batchSize {
+			return g, nil
+		}
+		last = batch[len(batch)-1].ID
+	}
+}
+
+// addFeatureOfInterest describes a feature of interest, its geometry, its place
+// in the hierarchy, its semantic relations and its open external associations
+func (s *LinkedDataService) addFeatureOfInterest(g *rdf.Graph, root string, foi *models.FeatureOfInterest) {
+	subject := rdf.IRI(FeatureOfInterestIRI(root, foi.ID))
+	g.Add(subject, rdf.IRI(rdf.Type), rdf.IRI(rdf.SOSA+"FeatureOfInterest"))
+	g.Add(subject, rdf.IRI(rdf.Type), rdf.IRI(rdf.GeoSPARQL+"Feature"))
+	g.Add(subject, rdf.IRI(rdf.DCTerms+"identifier"), rdf.Literal(foi.ID))
+	g.Add(subject, rdf.IRI(rdf.RDFS+"label"), rdf.Literal(foi.Name))
+	if foi.Description != "" {
+		g.Add(subject, rdf.IRI(rdf.DCTerms+"description"), rdf.Literal(foi.Description))
+	}
+	for _, tag := range foi.Tags {
+		g.Add(subject, rdf.IRI(rdf.DCTerms+"subject"), rdf.Literal(tag))
+	}
+	if !foi.CreatedAt.IsZero() {
+		g.Add(subject, rdf.IRI(rdf.DCTerms+"created"), dateTime(foi.CreatedAt))
+	}
+	if !foi.UpdatedAt.IsZero() {
+		g.Add(subject, rdf.IRI(rdf.DCTerms+"modified"), dateTime(foi.UpdatedAt))
+	}
+
+	if foi.Feature.Geometry != nil {
+		if data, err := json.Marshal(foi.Feature.Geometry); err == nil {
+			geometry := g.BlankNode()
+			g.Add(subject, rdf.IRI(rdf.GeoSPARQL+"hasGeometry"), geometry)
+			g.Add(geometry, rdf.IRI(rdf.Type), rdf.IRI(rdf.GeoSPARQL+"Geometry"))
+			g.Add(geometry, rdf.IRI(rdf.GeoSPARQL+"asGeoJSON"), rdf.TypedLiteral(string(data), rdf.GeoSPARQL+"geoJSONLiteral"))
+		} else {
+			s.logger.WithError(err).WithField("foi", foi.ID).Warn("Skipping unencodable geometry")
+		}
+	}
+
+	if h := foi.Hierarchy; h != nil {
+		if h.Level != "" {
+			g.Add(subject, rdf.IRI(rdf.DCTerms+"type"), rdf.Literal(h.Level))
+		}
+		for _, parent := range h.Parents {
+			g.Add(subject, rdf.IRI(rdf.DCTerms+"isPartOf"), rdf.IRI(FeatureOfInterestIRI(root, parent.FoiID)))
+		}
+		for _, child := range h.Children {
+			g.Add(subject, rdf.IRI(rdf.DCTerms+"hasPart"), rdf.IRI(FeatureOfInterestIRI(root, child.FoiID)))
+		}
+		for _, relation := range h.SemanticRelations {
+			if relation.URI == "" {
+				continue
+			}
+			g.Add(subject, rdf.IRI(semanticPredicate(g, relation.Predicate)), rdf.IRI(relation.URI))
+		}
+	}
+
+	for _, ext := range foi.ExternalFeatures {
+		predicate, ok := associationPredicates[ext.Association.Type]
+		if !ok || ext.Association.ValidTo != nil || ext.FeatureAPI.Href == "" {
+			continue
+		}
+		g.Add(subject, rdf.IRI(predicate), rdf.IRI(ext.FeatureAPI.Href))
+	}
+}
+
+// addUnit describes a unit of measurement as a SKOS concept
+func addUnit(g *rdf.Graph, unit *models.UnitOfMeasurement) {
+	if unit.URI == "" {
+		return
+	}
+	subject := rdf.IRI(unit.URI)
+	g.Add(subject, rdf.IRI(rdf.Type), rdf.IRI(rdf.SKOS+"Concept"))
+	g.Add(subject, rdf.IRI(rdf.SKOS+"notation"), rdf.Literal(unit.UCUMCode))
+
+	for _, lang := range sortedKeys(unit.Labels.Preferred) {
+		g.Add(subject, rdf.IRI(rdf.SKOS+"prefLabel"), langLiteral(unit.Labels.Preferred[lang], lang))
+	}
+	for _, label := range unit.Labels.Alternative {
+		g.Add(subject, rdf.IRI(rdf.SKOS+"altLabel"), langLiteral(label.Value, label.Lang))
+	}
+	for _, lang := range sortedKeys(unit.Definition) {
+		g.Add(subject, rdf.IRI(rdf.SKOS+"definition"), langLiteral(unit.Definition[lang], lang))
+	}
+
+	if h := unit.Hierarchy; h != nil {
+		for _, broader := range h.Broader {
+			g.Add(subject, rdf.IRI(rdf.SKOS+"broader"), rdf.IRI(broader.URI))
+		}
+		for _, narrower := range h.Narrower {
+			g.Add(subject, rdf.IRI(rdf.SKOS+"narrower"), rdf.IRI(narrower.URI))
+		}
+		for _, uri := range h.BroaderTransitive {
+			g.Add(subject, rdf.IRI(rdf.SKOS+"broaderTransitive"), rdf.IRI(uri))
+		}
+		for _, uri := range h.NarrowerTransitive {
+			g.Add(subject, rdf.IRI(rdf.SKOS+"narrowerTransitive"), rdf.IRI(uri))
+		}
+	}
+}
+
+// semanticPredicate resolves the predicate of a semantic relation: a full IRI,
+// a compact IRI with a known prefix, or a known bare name. Other names fall
+// back to rdfs:seeAlso, the weakest link that still keeps the relation.
+func semanticPredicate(g *rdf.Graph, predicate string) string {
+	if strings.Contains(predicate, "://") {
+		return predicate
+	}
+	if iri, ok := g.Expand(predicate); ok {
+		return iri
+	}
+	if iri, ok := semanticPredicates[predicate]; ok {
+		return iri
+	}
+	return rdf.RDFS + "seeAlso"
+}
+
+// dateTime returns a time as an xsd:dateTime literal
+func dateTime(t time.Time) rdf.Term {
+	return rdf.TypedLiteral(t.UTC().Format(time.RFC3339), rdf.XSD+"dateTime")
+}
+
+// langLiteral returns a literal tagged with lang, or a plain literal when lang is empty
+func langLiteral(value, lang string) rdf.Term {
+	if lang == "" {
+		return rdf.Literal(value)
+	}
+	return rdf.LangLiteral(value, lang)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/geo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// ErrFeedLagged ends a subscription whose consumer fell behind the feed
+var ErrFeedLagged = errors.New("subscriber fell behind the observation feed")
+
+// ErrFeedStopped ends the subscriptions of a feed that has been stopped
+var ErrFeedStopped = errors.New("observation feed stopped")
+
+const (
+	// feedRetryMin and feedRetryMax bound the wait before reopening a failed change stream
+	feedRetryMin = time.Second
+	feedRetryMax = time.Minute
+	// feedAppendTimeout bounds recording stored observations in the feed
+	feedAppendTimeout = 30 * time.Second
+)
+
+// ObservationFeedEvent is an observation delivered by the feed, with the
+// resume token that continues the feed after it
+type ObservationFeedEvent struct {
+	Token       string
+	Observation models.Observation
+	bbox        []float64
+}
+
+// ObservationFeedFilter selects the observations a subscriber receives. Each
+// list matches any of its identifiers; empty lists and a nil BBox match all.
+type ObservationFeedFilter struct {
+	DatastreamIDs        []string
+	ThingIDs             []string
+	FeatureOfInterestIDs []string
+	// BBox is [minx, miny, maxx, maxy]; it matches observations whose
+	// location, or else the geometry of their feature of interest, intersects it
+	BBox []float64
+}
+
+// Matches reports whether an event passes the filter
+func (f *ObservationFeedFilter) Matches(event *ObservationFeedEvent) bool {
+	obs := &event.Observation
+	if !matchesAny(f.DatastreamIDs, obs.Datastream.DatastreamID) ||
+		!matchesAny(f.ThingIDs, obs.Datastream.ThingID) ||
+		!matchesAny(f.FeatureOfInterestIDs, obs.FeatureOfInterestID) {
+		return false
+	}
+	if f.BBox == nil {
+		return true
+	}
+	box := event.bbox
+	return len(box) == 4 && box[0] <= f.BBox[2] && box[2] >= f.BBox[0] && box[1] <= f.BBox[3] && box[3] >= f.BBox[1]
+}
+
+// matchesAny reports whether id is one of ids, or ids is empty
+func matchesAny(ids []string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ObservationFeedService delivers newly stored observations to subscribers in
+// real time. Time series collections have no change streams, so stored
+// observations are also recorded in the observation_feed collection, and one
+// change stream over it is fanned out to every subscriber. Each event carries
+// its resume token: a subscriber resuming within the recent events is caught
+// up from memory, one resuming further back follows a change stream of its own.
+// Subscribers that cannot keep up are ended with ErrFeedLagged rather than
+// slowing the feed, and may resume after the last event they received.
+type ObservationFeedService struct {
+	feed     *repository.ObservationFeedRepository
+	features *repository.FeatureOfInterestRepository
+	logger   *logrus.Logger
+	buffer   int
+
+	mu          sync.Mutex
+	subscribers map[*FeedSubscription]bool
+	recent      []ObservationFeedEvent
+	stopped     bool
+}
+
+// NewObservationFeedService creates a feed that queues up to buffer events
+// per subscriber and keeps as many recent events for resuming subscribers
+func NewObservationFeedService(db *mongo.Database, logger *logrus.Logger, buffer int) *ObservationFeedService {
+	if buffer <= 0 {
+		buffer = 256
+	}
+	return &ObservationFeedService{
+		feed:        repository.NewObservationFeedRepository(db),
+		features:    repository.NewFeatureOfInterestRepository(db),
+		logger:      logger,
+		buffer:      buffer,
+		subscribers: map[*FeedSubscription]bool{},
+	}
+}
+
+// PublishObservations records stored observations in the feed collection,
+// along with the bounds of their location or feature of interest. The
+// observations are already stored, so they are recorded even if the client
+// that wrote them has gone away.
+func (s *ObservationFeedService) PublishObservations(ctx context.Context, observations []models.Observation) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), feedAppendTimeout)
+	defer cancel()
+
+	bounds, err := s.featureBounds(ctx, observations)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to look up feature of interest geometries for the observation feed")
+	}
+
+	now := time.Now().UTC()
+	entries := make([]models.ObservationFeedEntry, len(observations))
+	for i, obs := range observations {
+		entries[i] = models.ObservationFeedEntry{ID: obs.ID, Observation: obs, StoredAt: now}
+		if box, ok := geo.Bounds(obs.Location); ok {
+			entries[i].BBox = box
+		} else {
+			entries[i].BBox = bounds[obs.FeatureOfInterestID]
+		}
+	}
+	if err := s.feed.Append(ctx, entries); err != nil {
+		s.logger.WithError(err).Error("Failed to record observations in the feed")
+	}
+}
+
+// featureBounds looks up the bounds of the features of interest of
+// observations that have no location of their own
+func (s *ObservationFeedService) featureBounds(ctx context.Context, observations []models.Observation) (map[string][]float64, error) {
+	ids := bson.A{}
+	seen := map[string]bool{}
+	for _, obs := range observations {
+		if obs.Location == nil && obs.FeatureOfInterestID != "" && !seen[obs.FeatureOfInterestID] {
+			seen[obs.FeatureOfInterestID] = true
+			ids = append(ids, obs.FeatureOfInterestID)
+		}
+	}
+	bounds := map[string][]float64{}
+	if len(ids) == 0 {
+		return bounds, nil
+	}
+
+	features, err := s.features.Find(ctx, repository.Query{Filter: bson.M{"_id": bson.M{"$in": ids}}})
+	if err != nil {
+		return bounds, err
+	}
+	for _, foi := range features {
+		if box, ok := geo.Bounds(foi.Feature.Geometry); ok {
+			bounds[foi.ID] = box
+		}
+	}
+	return bounds, nil
+}
+
+// Start follows the feed collection until the context is cancelled, then
+// ends every subscription. A failed change stream is reopened after the last
+// event delivered, so subscribers miss nothing.
+func (s *ObservationFeedService) Start(ctx context.Context) {
+	defer s.stop()
+
+	token := ""
+	delay := feedRetryMin
+	for {
+		stream, err := s.feed.Watch(ctx, token)
+		if err == nil {
+			var delivered int
+			delivered, err = s.follow(ctx, stream, &token)
+			stream.Close(context.Background())
+			if delivered > 0 {
+				delay = feedRetryMin
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, repository.ErrResumeTokenExpired) {
+			s.logger.WithError(err).Warn("Observation feed could not resume and continues from now")
+			token = ""
+		} else {
+			s.logger.WithError(err).Errorf("Observation feed failed, retrying in %s", delay)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, feedRetryMax)
+	}
+}
+
+// follow fans the events of a change stream out to the subscribers until it
+// fails, keeping token at the last event
+func (s *ObservationFeedService) follow(ctx context.Context, stream *repository.ObservationFeedStream, token *string) (int, error) {
+	for delivered := 0; ; delivered++ {
+		entry, next, err := stream.Next(ctx)
+		if err != nil {
+			return delivered, err
+		}
+		*token = next
+		s.broadcast(newFeedEvent(entry, next))
+	}
+}
+
+// newFeedEvent converts a feed entry into an event
+func newFeedEvent(entry *models.ObservationFeedEntry, token string) ObservationFeedEvent {
+	return ObservationFeedEvent{Token: token, Observation: entry.Observation, bbox: entry.BBox}
+}
+
+// broadcast delivers an event to the matching subscribers of the shared
+// change stream and keeps it for subscribers that resume after it
+func (s *ObservationFeedService) broadcast(event ObservationFeedEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recent = append(s.recent, event)
+	if len(s.recent) > s.buffer {
+		s.recent = append(s.recent[:0:0], s.recent[len(s.recent)-s.buffer:]...)
+	}
+	for sub := range s.subscribers {
+		if sub.dedicated || !sub.filter.Matches(&event) {
+			continue
+		}
+		if !sub.send(event) {
+			delete(s.subscribers, sub)
+			sub.end(ErrFeedLagged)
+		}
+	}
+}
+
+// Subscribe starts delivering the observations that pass filter. Without a
+// resume token delivery starts with the next stored observation; with one it
+// continues after the event the token belongs to.
+func (s *ObservationFeedService) Subscribe(ctx context.Context, filter ObservationFeedFilter,
+	resumeAfter string) (*FeedSubscription, error) {
+
+	sub := &FeedSubscription{
+		service: s,
+		filter:  filter,
+		events:  make(chan ObservationFeedEvent, s.buffer),
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil, ErrFeedStopped
+	}
+	if resumeAfter == "" {
+		s.subscribers[sub] = true
+		s.mu.Unlock()
+		return sub, nil
+	}
+	for i := range s.recent {
+		if s.recent[i].Token != resumeAfter {
+			continue
+		}
+		// At most buffer-1 events follow, so the replay fits the queue
+		for _, event := range s.recent[i+1:] {
+			if filter.Matches(&event) {
+				sub.send(event)
+			}
+		}
+		s.subscribers[sub] = true
+		s.mu.Unlock()
+		return sub, nil
+	}
+	s.mu.Unlock()
+
+	// The token is older than the recent events, so follow a stream of its own
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := s.feed.Watch(ctx, resumeAfter)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	sub.dedicated = true
+	sub.cancel = cancel
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		cancel()
+		stream.Close(context.Background())
+		return nil, ErrFeedStopped
+	}
+	s.subscribers[sub] = true
+	go s.followDedicated(ctx, stream, sub)
+	return sub, nil
+}
+
+// followDedicated delivers the events of a subscriber's own change stream
+func (s *ObservationFeedService) followDedicated(ctx context.Context, stream *repository.ObservationFeedStream,
+	sub *FeedSubscription) {
+
+	defer stream.Close(context.Background())
+	for {
+		entry, token, err := stream.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.WithError(err).Warn("Resumed observation feed subscription failed")
+			}
+			s.unsubscribe(sub, err)
+			return
+		}
+		event := newFeedEvent(entry, token)
+		if sub.filter.Matches(&event) && !sub.send(event) {
+			s.unsubscribe(sub, ErrFeedLagged)
+			return
+		}
+	}
+}
+
+// unsubscribe removes a subscriber and ends its subscription with err
+func (s *ObservationFeedService) unsubscribe(sub *FeedSubscription, err error) {
+	s.mu.Lock()
+	delete(s.subscribers, sub)
+	s.mu.Unlock()
+	sub.end(err)
+}
+
+// stop ends every subscription and refuses new ones
+func (s *ObservationFeedService) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for sub := range s.subscribers {
+		sub.end(ErrFeedStopped)
+	}
+	s.subscribers = map[*FeedSubscription]bool{}
+}
+
+// FeedSubscription receives the events of an observation feed subscriber
+type FeedSubscription struct {
+	service   *ObservationFeedService
+	filter    ObservationFeedFilter
+	events    chan ObservationFeedEvent
+	dedicated bool
+	cancel    context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// Events returns the delivered events; the channel is closed when the subscription ends
+func (sub *FeedSubscription) Events() <-chan ObservationFeedEvent {
+	return sub.events
+}
+
+// Err reports why a subscription ended: ErrFeedLagged, ErrFeedStopped, a
+// change stream failure, or nil once it was closed
+func (sub *FeedSubscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Close ends the subscription
+func (sub *FeedSubscription) Close() {
+	sub.service.unsubscribe(sub, nil)
+}
+
+// send queues an event without blocking, reporting whether there was room
+func (sub *FeedSubscription) send(event ObservationFeedEvent) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return true
+	}
+	select {
+	case sub.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// end closes the event channel, recording why
+func (sub *FeedSubscription) end(err error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.err = err
+	close(sub.events)
+	if sub.cancel != nil {
+		sub.cancel()
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// RollupService maintains the hourly_aggregates and daily_summaries collections.
+// Inserting observations queues their hours in rollup_pending; each pass re-rolls
+// the queued hours from raw data and then the days containing them, so late
+// observations simply queue their (old) hour again.
+type RollupService struct {
+	rollups   *repository.RollupRepository
+	logger    *logrus.Logger
+	batchSize int64
+}
+
+// NewRollupService creates a new rollup service
+func NewRollupService(db *mongo.Database, logger *logrus.Logger, batchSize int) *RollupService {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &RollupService{
+		rollups:   repository.NewRollupRepository(db),
+		logger:    logger,
+		batchSize: int64(batchSize),
+	}
+}
+
+// Start re-rolls pending buckets every interval until the context is cancelled
+func (s *RollupService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while full batches come back so a backlog drains quickly
+		for {
+			n, err := s.RunOnce(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.WithError(err).Error("Rollup pass failed")
+				}
+				break
+			}
+			if int64(n) < s.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce re-rolls one batch of pending hours and the days they belong to,
+// returning the number of hours processed
+func (s *RollupService) RunOnce(ctx context.Context) (int, error) {
+	buckets, err := s.rollups.PendingBatch(ctx, s.batchSize)
+	if err != nil || len(buckets) == 0 {
+		return 0, err
+	}
+
+	if err := s.initialize(ctx, buckets); err != nil {
+		return 0, err
+	}
+
+	days := map[models.DayKey]bool{}
+	for _, bucket := range buckets {
+		if err := s.rerollHour(ctx, bucket.Key); err != nil {
+			return 0, err
+		}
+		days[bucket.Key.Day()] = true
+	}
+
+	for day := range days {
+		if err := s.rollups.RerollDay(ctx, day); err != nil {
+			return 0, err
+		}
+	}
+
+	// Clear only after the days are rebuilt, so stats are never served from a stale summary
+	for _, bucket := range buckets {
+		if err := s.rollups.ClearPending(ctx, bucket); err != nil {
+			return 0, err
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"hours": len(buckets),
+		"days":  len(days),
+	}).Debug("Rolled up observations")
+	return len(buckets), nil
+}
+
+// Backfill queues every stored hour of a datastream so its existing data is rolled up
+func (s *RollupService) Backfill(ctx context.Context, datastreamID string) error {
+	return s.rollups.Initialize(ctx, datastreamID)
+}
+
+// initialize backfills datastreams seen for the first time, since their earlier
+// observations were stored before anything queued them
+func (s *RollupService) initialize(ctx context.Context, buckets []repository.PendingBucket) error {
+	seen := map[string]bool{}
+	for _, bucket := range buckets {
+		id := bucket.Key.DatastreamID
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		initialized, err := s.rollups.IsInitialized(ctx, id)
+		if err != nil {
+			return err
+		}
+		if initialized {
+			continue
+		}
+		if err := s.rollups.Initialize(ctx, id); err != nil {
+			return err
+		}
+		s.logger.WithField("datastream", id).Info("Queued historical observations for rollup")
+	}
+	return nil
+}
+
+// rerollHour recomputes one hourly aggregate from raw observations
+func (s *RollupService) rerollHour(ctx context.Context, key models.HourKey) error {
+	agg, err := s.rollups.ComputeHourly(ctx, key)
+	if err != nil {
+		return err
+	}
+	if agg == nil {
+		return s.rollups.DeleteHourly(ctx, key)
+	}
+	return s.rollups.SaveHourly(ctx, agg)
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/finto"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/repository"
+)
+
+// ucumPageSize is the number of concepts requested per search page
+const ucumPageSize = 100
+
+// UCUMSyncReport summarizes one synchronization run
+type UCUMSyncReport struct {
+	Version     string        `json:"version"`
+	Concepts    int           `json:"concepts"`
+	Synced      int           `json:"synced"`
+	Skipped     int           `json:"skipped"`
+	Failed      int           `json:"failed"`
+	Stale       int64         `json:"stale"`
+	Closures    int64         `json:"closures"`
+	Hierarchies int           `json:"hierarchies"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// UCUMSyncService caches the UCUM vocabulary from Finto in unit_of_measurement
+type UCUMSyncService struct {
+	client   *finto.Client
+	units    *repository.UnitRepository
+	logger   *logrus.Logger
+	cacheTTL time.Duration
+}
+
+// NewUCUMSyncService creates a new UCUM sync service; cached units expire after cacheTTL
+func NewUCUMSyncService(db *mongo.Database, client *finto.Client, logger *logrus.Logger, cacheTTL time.Duration) *UCUMSyncService {
+	return &UCUMSyncService{
+		client:   client,
+		units:    repository.NewUnitRepository(db),
+		logger:   logger,
+		cacheTTL: cacheTTL,
+	}
+}
+
+// Start syncs immediately if the cache is empty or out of date, then on every
+// scheduled time until the context is cancelled
+func (s *UCUMSyncService) Start(ctx context.Context, schedule *CronSchedule) {
+	needed, err := s.units.NeedsSync(ctx, time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to check UCUM cache")
+	}
+	if needed {
+		s.run(ctx)
+	}
+
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Error("UCUM sync schedule never fires")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		s.run(ctx)
+	}
+}
+
+// Sync fetches every concept of the vocabulary and upserts it into the cache.
+// A concept that cannot be fetched keeps its cached data and is marked as an
+// error; units not refreshed before their expiry are marked stale. Transitive
+// closures and the hierarchy cache are then rebuilt.
+func (s *UCUMSyncService) Sync(ctx context.Context) (*UCUMSyncReport, error) {
+	started := time.Now()
+	report := &UCUMSyncReport{}
+
+	vocab, err := s.client.Vocabulary(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read UCUM vocabulary: %w", err)
+	}
+	report.Version = vocab.Version
+
+	for offset := 0; ; offset += ucumPageSize {
+		results, err := s.client.Search(ctx, "*", "", ucumPageSize, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list UCUM concepts: %w", err)
+		}
+
+		for _, result := range results {
+			report.Concepts++
+			synced, err := s.syncConcept(ctx, result.URI, vocab.Version)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				report.Failed++
+				s.logger.WithError(err).WithField("uri", result.URI).Warn("Failed to sync UCUM concept")
+				if err := s.units.MarkSyncError(ctx, result.URI); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if synced {
+				report.Synced++
+			} else {
+				report.Skipped++
+			}
+		}
+
+		if len(results) < ucumPageSize {
+			break
+		}
+	}
+
+	now := time.Now().UTC()
+	report.Stale, err = s.units.MarkStale(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Links may have changed anywhere, so closures and trees are rebuilt in full
+	report.Closures, err = s.units.ComputeClosures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Hierarchies, err = s.units.RebuildHierarchyCache(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	report.Duration = time.Since(started)
+	return report, nil
+}
+
+// run performs a sync and logs its outcome
+func (s *UCUMSyncService) run(ctx context.Context) {
+	report, err := s.Sync(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.WithError(err).Error("UCUM sync failed")
+		}
+		return
+	}
+	s.logger.WithFields(logrus.Fields{
+		"version":     report.Version,
+		"concepts":    report.Concepts,
+		"synced":      report.Synced,
+		"failed":      report.Failed,
+		"stale":       report.Stale,
+		"hierarchies": report.Hierarchies,
+		"duration":    report.Duration.String(),
+	}).Info("UCUM sync completed")
+}
+
+// syncConcept fetches one concept and stores it as a unit, reporting whether it was one
+func (s *UCUMSyncService) syncConcept(ctx context.Context, uri, version string) (bool, error) {
+	concept, err := s.client.Concept(ctx, uri)
+	if err != nil {
+		return false, err
+	}
+	// Concepts without a notation are groupings, not units
+	if concept.Notation == "" {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	unit := conceptToUnit(concept)
+	unit.Metadata = &models.UnitMetadata{
+		FintoLastFetched: now,
+		FintoVersion:     version,
+		CacheExpiry:      now.Add(s.cacheTTL),
+		SyncStatus:       repository.SyncStatusCurrent,
+		LastModified:     now,
+	}
+	return true, s.units.SaveFromOntology(ctx, unit)
+}
+
+// conceptToUnit maps a SKOS concept onto the unit_of_measurement schema
+func conceptToUnit(concept *finto.Concept) *models.UnitOfMeasurement {
+	unit := &models.UnitOfMeasurement{
+		URI:      concept.URI,
+		UCUMCode: concept.Notation,
+		Labels: models.UnitLabels{
+			Preferred: concept.PrefLabels,
+		},
+		Hierarchy: &models.UnitHierarchy{
+			Broader:  unitReferences(concept.Broader),
+			Narrower: unitReferences(concept.Narrower),
+		},
+	}
+	for _, label := range concept.AltLabels {
+		unit.Labels.Alternative = append(unit.Labels.Alternative, models.AlternativeLabel{
+			Lang:  label.Lang,
+			Value: label.Value,
+		})
+	}
+	if len(concept.Definitions) > 0 {
+		unit.Definition = concept.Definitions
+	}
+	return unit
+}
+
+// unitReferences converts related concepts into direct (level 1) unit references
+func unitReferences(refs []finto.Reference) []models.UnitReference {
+	units := make([]models.UnitReference, 0, len(refs))
+	for _, ref := range refs {
+		units = append(units, models.UnitReference{
+			URI:      ref.URI,
+			UCUMCode: ref.Notation,
+			Label:    ref.Label,
+			Level:    1,
+		})
+	}
+	return units
+}
@@ -0,0 +1,270 @@
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+var (
+	// ErrUnknownUnit is returned when a unit code cannot be resolved
+	ErrUnknownUnit = errors.New("unknown unit")
+	// ErrNoConversion is returned when a unit is known but has no conversion to a base unit
+	ErrNoConversion = errors.New("unit has no conversion")
+	// ErrIncompatibleUnits is returned when two units measure different dimensions
+	ErrIncompatibleUnits = errors.New("incompatible units")
+)
+
+// Linear maps a value into another unit as value*Scale + Offset
+type Linear struct {
+	Scale  float64
+	Offset float64
+}
+
+// Identity leaves values unchanged
+var Identity = Linear{Scale: 1}
+
+// Apply converts a value
+func (l Linear) Apply(v float64) float64 {
+	return v*l.Scale + l.Offset
+}
+
+// Inverse returns the mapping back from the target unit
+func (l Linear) Inverse() Linear {
+	return Linear{Scale: 1 / l.Scale, Offset: -l.Offset / l.Scale}
+}
+
+// Then composes l with a following mapping
+func (l Linear) Then(next Linear) Linear {
+	return Linear{Scale: l.Scale * next.Scale, Offset: l.Offset*next.Scale + next.Offset}
+}
+
+// IsAffine reports whether the mapping shifts the zero point, as for °C or °F
+func (l Linear) IsAffine() bool {
+	return l.Offset != 0
+}
+
+// ToBase returns the mapping a conversion describes from a unit onto its base unit
+func ToBase(c models.BaseUnitConversion) (Linear, error) {
+	switch c.Operation {
+	case "multiply", "":
+		if c.Factor == 0 {
+			return Linear{}, fmt.Errorf("conversion to %s has a zero factor", c.BaseUnitCode)
+		}
+		return Linear{Scale: c.Factor, Offset: c.Offset}, nil
+	case "divide":
+		if c.Factor == 0 {
+			return Linear{}, fmt.Errorf("conversion to %s has a zero factor", c.BaseUnitCode)
+		}
+		return Linear{Scale: 1 / c.Factor, Offset: c.Offset}, nil
+	case "add":
+		return Linear{Scale: 1, Offset: c.Factor + c.Offset}, nil
+	case "subtract":
+		return Linear{Scale: 1, Offset: -c.Factor + c.Offset}, nil
+	default:
+		return Linear{}, fmt.Errorf("unsupported conversion operation %q", c.Operation)
+	}
+}
+
+// Unit is a unit resolved against its base units
+type Unit struct {
+	Code string
+	// Dimension is the classification dimension, e.g. "pressure"; empty for compound units
+	Dimension string
+	// Base is the canonical base unit expression: the UCUM dimension vector
+	// such as "g.m-3", or the cached base unit code if it is not valid UCUM
+	Base string
+	// ToBase maps values of the unit onto Base
+	ToBase Linear
+}
+
+// prefixes are the UCUM metric prefixes, longest first
+var prefixes = []struct {
+	symbol string
+	factor float64
+}{
+	{"da", 1e1},
+	{"Y", 1e24}, {"Z", 1e21}, {"E", 1e18}, {"P", 1e15}, {"T", 1e12},
+	{"G", 1e9}, {"M", 1e6}, {"k", 1e3}, {"h", 1e2},
+	{"d", 1e-1}, {"c", 1e-2}, {"m", 1e-3}, {"u", 1e-6}, {"n", 1e-9},
+	{"p", 1e-12}, {"f", 1e-15}, {"a", 1e-18}, {"z", 1e-21}, {"y", 1e-24},
+}
+
+// fromModel resolves a cached unit through its conversion, or as a base unit itself
+func fromModel(code string, u *models.UnitOfMeasurement) (*Unit, error) {
+	unit := &Unit{Code: code}
+	if u.Classification != nil {
+		unit.Dimension = u.Classification.Dimension
+	}
+
+	switch {
+	case u.Conversion != nil && u.Conversion.ToBaseUnit.BaseUnitCode != "":
+		toBase, err := ToBase(u.Conversion.ToBaseUnit)
+		if err != nil {
+			return nil, fmt.Errorf("unit %s: %w", code, err)
+		}
+		terms, err := splitTerms(u.Conversion.ToBaseUnit.BaseUnitCode)
+		if err != nil {
+			return nil, fmt.Errorf("unit %s: invalid base unit: %w", code, err)
+		}
+		unit.ToBase = toBase
+		unit.Base = canonical(terms)
+	case u.Classification != nil && u.Classification.IsBaseUnit:
+		unit.ToBase = Identity
+		unit.Base = canonical(map[string]int{code: 1})
+	default:
+		return nil, fmt.Errorf("unit %s: %w", code, ErrNoConversion)
+	}
+	return unit, nil
+}
+
+// normalize expresses a unit in UCUM base units when its base unit code is valid UCUM
+func normalize(unit *Unit) *Unit {
+	expr, err := ParseUCUM(unit.Base)
+	if err != nil || expr.Offset != 0 {
+		return unit
+	}
+	unit.ToBase = unit.ToBase.Then(Linear{Scale: expr.Scale})
+	unit.Base = expr.Dimension.String()
+	return unit
+}
+
+// isMetric reports whether a cached unit accepts metric prefixes
+func isMetric(u *models.UnitOfMeasurement) bool {
+	if u.Conversion != nil {
+		return u.Conversion.IsMetric
+	}
+	return u.Classification != nil && u.Classification.IsBaseUnit
+}
+
+// prefixed applies a metric prefix to a resolved unit
+func prefixed(code string, factor float64, atom *Unit) (*Unit, error) {
+	if atom.ToBase.IsAffine() {
+		return nil, fmt.Errorf("unit %s: affine unit %s cannot be prefixed", code, atom.Code)
+	}
+	return &Unit{
+		Code:      code,
+		Dimension: atom.Dimension,
+		Base:      atom.Base,
+		ToBase:    Linear{Scale: factor * atom.ToBase.Scale},
+	}, nil
+}
+
+// combine multiplies resolved terms raised to their exponents into one unit
+func combine(code string, terms []*Unit, exponents []int) (*Unit, error) {
+	base := map[string]int{}
+	scale := 1.0
+	for i, term := range terms {
+		if term.ToBase.IsAffine() {
+			return nil, fmt.Errorf("unit %s: affine unit %s cannot be combined", code, term.Code)
+		}
+		scale *= math.Pow(term.ToBase.Scale, float64(exponents[i]))
+
+		termBase, err := splitTerms(term.Base)
+		if err != nil {
+			return nil, err
+		}
+		for atom, exp := range termBase {
+			if atom != "1" {
+				base[atom] += exp * exponents[i]
+			}
+		}
+	}
+	return &Unit{Code: code, Base: canonical(base), ToBase: Linear{Scale: scale}}, nil
+}
+
+// splitTerms splits a UCUM product or quotient such as "kg.m-3" or "mg/L"
+// into its atoms and integer exponents
+func splitTerms(expr string) (map[string]int, error) {
+	terms := map[string]int{}
+	sign := 1
+	depth := 0
+	start := 0
+	flush := func(end int) error {
+		term := expr[start:end]
+		if term == "" {
+			if end == 0 {
+				return nil
+			}
+			return fmt.Errorf("empty term in %q", expr)
+		}
+		atom, exp := splitExponent(term)
+		if atom == "" {
+			return fmt.Errorf("invalid term %q in %q", term, expr)
+		}
+		terms[atom] += sign * exp
+		return nil
+	}
+
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.', '/':
+			if depth > 0 {
+				continue
+			}
+			if err := flush(i); err != nil {
+				return nil, err
+			}
+			sign = 1
+			if expr[i] == '/' {
+				sign = -1
+			}
+			start = i + 1
+		}
+	}
+	if err := flush(len(expr)); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// splitExponent separates a trailing signed integer exponent from an atom, as in "s-1" or "m3"
+func splitExponent(term string) (string, int) {
+	i := len(term)
+	for i > 0 && term[i-1] >= '0' && term[i-1] <= '9' {
+		i--
+	}
+	if i == len(term) {
+		return term, 1
+	}
+	if i > 0 && (term[i-1] == '-' || term[i-1] == '+') {
+		i--
+	}
+	exp, err := strconv.Atoi(term[i:])
+	if err != nil || i == 0 {
+		return term, 1
+	}
+	return term[:i], exp
+}
+
+// canonical writes base atoms in a stable order, dropping cancelled ones
+func canonical(terms map[string]int) string {
+	atoms := make([]string, 0, len(terms))
+	for atom, exp := range terms {
+		if exp != 0 {
+			atoms = append(atoms, atom)
+		}
+	}
+	if len(atoms) == 0 {
+		return "1"
+	}
+	sort.Strings(atoms)
+
+	parts := make([]string, len(atoms))
+	for i, atom := range atoms {
+		parts[i] = atom
+		if exp := terms[atom]; exp != 1 {
+			parts[i] += strconv.Itoa(exp)
+		}
+	}
+	return strings.Join(parts, ".")
+}
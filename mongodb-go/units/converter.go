@@ -0,0 +1,217 @@
+package units
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/timoruohomaki/geospatial-data-as-data-lake/mongodb-go/models"
+)
+
+// Catalog looks up cached units, returning nil for units it does not hold
+type Catalog interface {
+	LookupCode(ctx context.Context, code string) (*models.UnitOfMeasurement, error)
+	LookupURI(ctx context.Context, uri string) (*models.UnitOfMeasurement, error)
+}
+
+// Converter converts values between units using the conversions held in a catalog.
+// Codes missing from the catalog resolve as a metric prefix on a cached unit
+// (e.g. "mbar"), as a UCUM expression of the built-in atoms (e.g. "kg.m/s2"),
+// or as a product or quotient of cached units.
+type Converter struct {
+	catalog Catalog
+}
+
+// NewConverter creates a converter backed by catalog
+func NewConverter(catalog Catalog) *Converter {
+	return &Converter{catalog: catalog}
+}
+
+// Resolve resolves a unit code against the UCUM base units
+func (c *Converter) Resolve(ctx context.Context, code string) (*Unit, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, fmt.Errorf("empty unit code: %w", ErrUnknownUnit)
+	}
+
+	unit, err := c.resolveAtom(ctx, code)
+	if err == nil {
+		return normalize(unit), nil
+	}
+	if !errors.Is(err, ErrUnknownUnit) {
+		return nil, err
+	}
+
+	expr, parseErr := ParseUCUM(code)
+	if parseErr == nil {
+		return &Unit{Code: code, Base: expr.Dimension.String(), ToBase: expr.ToBase()}, nil
+	}
+
+	terms, splitErr := splitTerms(code)
+	if splitErr != nil || len(terms) == 1 && terms[code] == 1 {
+		return nil, parseErr
+	}
+
+	resolved := make([]*Unit, 0, len(terms))
+	exponents := make([]int, 0, len(terms))
+	for atom, exp := range terms {
+		term, err := c.resolveAtom(ctx, atom)
+		if errors.Is(err, ErrUnknownUnit) {
+			return nil, parseErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, normalize(term))
+		exponents = append(exponents, exp)
+	}
+	unit, err = combine(code, resolved, exponents)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(unit), nil
+}
+
+// ResolveUnitOfMeasure resolves the free-text unit of a datastream. The
+// definition is tried first, as a cached ontology URI or a URI ending in a UCUM
+// code; then the symbol, with common spellings such as "°C" or "µg/m³" mapped
+// to UCUM.
+func (c *Converter) ResolveUnitOfMeasure(ctx context.Context, uom *models.UnitOfMeasure) (*Unit, error) {
+	if uom == nil {
+		return nil, fmt.Errorf("no unit of measurement: %w", ErrUnknownUnit)
+	}
+
+	if uom.Definition != "" {
+		cached, err := c.catalog.LookupURI(ctx, uom.Definition)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			return c.Resolve(ctx, cached.UCUMCode)
+		}
+		if code := definitionCode(uom.Definition); code != "" {
+			if unit, err := c.Resolve(ctx, code); err == nil {
+				return unit, nil
+			}
+		}
+	}
+
+	if uom.Symbol == "" {
+		return nil, fmt.Errorf("unit %q has no symbol: %w", uom.Name, ErrUnknownUnit)
+	}
+	return c.Resolve(ctx, SymbolToUCUM(uom.Symbol))
+}
+
+// Between returns the mapping of values from one unit into another,
+// refusing units of different dimensions
+func (c *Converter) Between(ctx context.Context, from, to string) (Linear, error) {
+	if from == to {
+		return Identity, nil
+	}
+
+	source, err := c.Resolve(ctx, from)
+	if err != nil {
+		return Linear{}, err
+	}
+	target, err := c.Resolve(ctx, to)
+	if err != nil {
+		return Linear{}, err
+	}
+	return Mapping(source, target)
+}
+
+// Mapping returns the mapping of values between two resolved units,
+// refusing units of different dimensions
+func Mapping(from, to *Unit) (Linear, error) {
+	if from.Dimension != "" && to.Dimension != "" && from.Dimension != to.Dimension {
+		return Linear{}, fmt.Errorf("%s (%s) and %s (%s): %w",
+			from.Code, from.Dimension, to.Code, to.Dimension, ErrIncompatibleUnits)
+	}
+	if from.Base != to.Base {
+		return Linear{}, fmt.Errorf("%s (%s) and %s (%s): %w",
+			from.Code, from.Base, to.Code, to.Base, ErrIncompatibleUnits)
+	}
+	return from.ToBase.Then(to.ToBase.Inverse()), nil
+}
+
+// Convert converts a value from one unit into another
+func (c *Converter) Convert(ctx context.Context, value float64, from, to string) (float64, error) {
+	l, err := c.Between(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return l.Apply(value), nil
+}
+
+// ConvertResult converts a numeric observation result from one unit into another
+func (c *Converter) ConvertResult(ctx context.Context, result interface{}, from, to string) (float64, error) {
+	value, ok := Numeric(result)
+	if !ok {
+		return 0, fmt.Errorf("cannot convert non-numeric result %v", result)
+	}
+	return c.Convert(ctx, value, from, to)
+}
+
+// resolveAtom resolves a code held in the catalog, or a metric prefix on one
+func (c *Converter) resolveAtom(ctx context.Context, code string) (*Unit, error) {
+	u, err := c.catalog.LookupCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		return fromModel(code, u)
+	}
+
+	for _, p := range prefixes {
+		if !strings.HasPrefix(code, p.symbol) || len(code) == len(p.symbol) {
+			continue
+		}
+		atom := code[len(p.symbol):]
+		u, err := c.catalog.LookupCode(ctx, atom)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil || !isMetric(u) {
+			continue
+		}
+		resolved, err := fromModel(atom, u)
+		if err != nil {
+			return nil, err
+		}
+		return prefixed(code, p.factor, resolved)
+	}
+	return nil, fmt.Errorf("unit %s: %w", code, ErrUnknownUnit)
+}
+
+// ConvertStats converts aggregated statistics in place
+func ConvertStats(stats []models.ObservationStats, l Linear) {
+	for i := range stats {
+		s := &stats[i]
+		s.Average = l.Apply(s.Average)
+		s.Min, s.Max = l.Apply(s.Min), l.Apply(s.Max)
+		if l.Scale < 0 {
+			s.Min, s.Max = s.Max, s.Min
+		}
+		s.StdDev *= math.Abs(l.Scale)
+	}
+}
+
+// Numeric returns an observation result as a float64 if it is a number
+func Numeric(result interface{}) (float64, bool) {
+	switch v := result.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
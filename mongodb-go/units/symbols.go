@@ -0,0 +1,53 @@
+package units
+
+import (
+	"net/url"
+	"strings"
+)
+
+// symbolReplacer rewrites typographic characters common in unit symbols
+var symbolReplacer = strings.NewReplacer(
+	"µ", "u", "μ", "u", "²", "2", "³", "3", "⁻¹", "-1", "·", ".", "⋅", ".", " ", ".",
+	"°C", "Cel", "℃", "Cel", "°F", "[degF]", "℉", "[degF]", "‰", "[ppth]",
+)
+
+// symbolAliases maps frequent non-UCUM spellings of whole symbols to UCUM codes
+var symbolAliases = map[string]string{
+	"degC": "Cel", "degF": "[degF]", "°": "deg", "ppm": "[ppm]", "ppb": "[ppb]",
+	"psi": "[psi]", "in": "[in_i]", "ft": "[ft_i]", "mi": "[mi_i]", "kn": "[kn_i]",
+	"kt": "[kn_i]", "lb": "[lb_av]", "oz": "[oz_av]", "gal": "[gal_us]", "mmHg": "mm[Hg]",
+	"sec": "s", "hr": "h", "yr": "a",
+}
+
+// SymbolToUCUM maps a free-text unit symbol to its likely UCUM code
+func SymbolToUCUM(symbol string) string {
+	symbol = strings.TrimSpace(symbol)
+	if code, ok := symbolAliases[symbol]; ok {
+		return code
+	}
+	symbol = symbolReplacer.Replace(symbol)
+	if code, ok := symbolAliases[symbol]; ok {
+		return code
+	}
+	return symbol
+}
+
+// definitionCode extracts the UCUM code from a unit definition URI such as
+// http://www.opengis.net/def/uom/UCUM/degC or http://unitsofmeasure.org/ucum?code=m/s
+func definitionCode(definition string) string {
+	u, err := url.Parse(definition)
+	if err != nil {
+		return ""
+	}
+	if code := u.Query().Get("code"); code != "" {
+		return SymbolToUCUM(code)
+	}
+	if i := strings.Index(definition, "/UCUM/"); i >= 0 {
+		code, err := url.PathUnescape(definition[i+len("/UCUM/"):])
+		if err != nil {
+			return ""
+		}
+		return SymbolToUCUM(code)
+	}
+	return ""
+}
@@ -0,0 +1,423 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DimensionVector holds the exponents of the UCUM base units m, s, g, rad, K, C and cd
+type DimensionVector [7]int
+
+// baseSymbols names the base unit of each dimension vector entry
+var baseSymbols = [7]string{"m", "s", "g", "rad", "K", "C", "cd"}
+
+// String writes the vector as a UCUM product of base units, e.g. "m.s-2", or "1"
+func (v DimensionVector) String() string {
+	var parts []string
+	for i, exp := range v {
+		switch exp {
+		case 0:
+		case 1:
+			parts = append(parts, baseSymbols[i])
+		default:
+			parts = append(parts, baseSymbols[i]+strconv.Itoa(exp))
+		}
+	}
+	if len(parts) == 0 {
+		return "1"
+	}
+	return strings.Join(parts, ".")
+}
+
+// add returns the vector of a product, raising other to exp
+func (v DimensionVector) add(other DimensionVector, exp int) DimensionVector {
+	for i := range v {
+		v[i] += other[i] * exp
+	}
+	return v
+}
+
+// Expression is a UCUM unit expression resolved to base units: a value x in the
+// unit equals x*Scale + Offset in the base units given by Dimension
+type Expression struct {
+	Code      string
+	Dimension DimensionVector
+	Scale     float64
+	// Offset is non-zero only for the temperature scales Cel and [degF]
+	Offset float64
+}
+
+// ToBase returns the mapping of values of the expression onto its base units
+func (e *Expression) ToBase() Linear {
+	return Linear{Scale: e.Scale, Offset: e.Offset}
+}
+
+// ParseUCUM parses a case-sensitive UCUM code such as "kg.m/s2", "umol/mol",
+// "mm[Hg]" or "10*3/uL" into its dimension vector and scale
+func ParseUCUM(code string) (*Expression, error) {
+	p := &ucumParser{input: code}
+	if strings.TrimSpace(code) != code || code == "" {
+		return nil, fmt.Errorf("invalid UCUM code %q: %w", code, ErrUnknownUnit)
+	}
+
+	term, err := p.mainTerm()
+	if err != nil {
+		return nil, fmt.Errorf("invalid UCUM code %q: %w", code, err)
+	}
+	term.Code = code
+	return term, nil
+}
+
+// ValidateUCUM reports whether code is a valid UCUM expression of known units
+func ValidateUCUM(code string) error {
+	_, err := ParseUCUM(code)
+	return err
+}
+
+// atom is a UCUM unit atom resolved to base units
+type atom struct {
+	dimension DimensionVector
+	scale     float64
+	offset    float64
+	metric    bool
+}
+
+// definition defines an atom as value times a UCUM expression
+type definition struct {
+	value  float64
+	unit   string
+	metric bool
+}
+
+// baseAtoms are the UCUM base units
+var baseAtoms = map[string]DimensionVector{
+	"m": {1}, "s": {0, 1}, "g": {0, 0, 1}, "rad": {0, 0, 0, 1},
+	"K": {0, 0, 0, 0, 1}, "C": {0, 0, 0, 0, 0, 1}, "cd": {0, 0, 0, 0, 0, 0, 1},
+}
+
+// definitions are the derived atoms supported, after the UCUM tables
+var definitions = map[string]definition{
+	// Dimensionless
+	"10*":    {10, "1", false},
+	"10^":    {10, "1", false},
+	"%":      {1, "10*-2", false},
+	"[ppth]": {1, "10*-3", false},
+	"[ppm]":  {1, "10*-6", false},
+	"[ppb]":  {1, "10*-9", false},
+	"[pptr]": {1, "10*-12", false},
+	"mol":    {6.0221367, "10*23", true},
+	"sr":     {1, "rad2", true},
+	"deg":    {math.Pi / 180, "rad", false},
+	"gon":    {0.9, "deg", false},
+	"'":      {1, "deg/60", false},
+	"''":     {1, "'/60", false},
+
+	// SI derived units
+	"Hz":  {1, "s-1", true},
+	"N":   {1, "kg.m/s2", true},
+	"Pa":  {1, "N/m2", true},
+	"J":   {1, "N.m", true},
+	"W":   {1, "J/s", true},
+	"A":   {1, "C/s", true},
+	"V":   {1, "J/C", true},
+	"F":   {1, "C/V", true},
+	"Ohm": {1, "V/A", true},
+	"S":   {1, "Ohm-1", true},
+	"Wb":  {1, "V.s", true},
+	"T":   {1, "Wb/m2", true},
+	"H":   {1, "Wb/A", true},
+	"lm":  {1, "cd.sr", true},
+	"lx":  {1, "lm/m2", true},
+	"Bq":  {1, "s-1", true},
+	"Gy":  {1, "J/kg", true},
+	"Sv":  {1, "J/kg", true},
+	"kat": {1, "mol/s", true},
+
+	// Other metric and ISO 1000 units
+	"l":      {1, "dm3", true},
+	"L":      {1, "l", true},
+	"ar":     {100, "m2", true},
+	"t":      {1e3, "kg", true},
+	"bar":    {1e5, "Pa", true},
+	"u":      {1.6605402e-24, "g", true},
+	"eV":     {1.60217733e-19, "J", true},
+	"cal":    {4.184, "J", true},
+	"m[Hg]":  {133.322, "kPa", true},
+	"m[H2O]": {9.80665, "kPa", true},
+	"[g]":    {9.80665, "m/s2", true},
+	"atm":    {101325, "Pa", false},
+	"[degR]": {5, "K/9", false},
+
+	// Time
+	"min": {60, "s", false},
+	"h":   {60, "min", false},
+	"d":   {24, "h", false},
+	"wk":  {7, "d", false},
+	"a":   {365.25, "d", false},
+	"mo":  {1, "a/12", false},
+
+	// International customary units
+	"[in_i]":   {2.54, "cm", false},
+	"[ft_i]":   {12, "[in_i]", false},
+	"[yd_i]":   {3, "[ft_i]", false},
+	"[mi_i]":   {5280, "[ft_i]", false},
+	"[nmi_i]":  {1852, "m", false},
+	"[kn_i]":   {1, "[nmi_i]/h", false},
+	"[gr]":     {64.79891, "mg", false},
+	"[lb_av]":  {7000, "[gr]", false},
+	"[oz_av]":  {1, "[lb_av]/16", false},
+	"[lbf_av]": {1, "[lb_av].[g]", false},
+	"[psi]":    {1, "[lbf_av]/[in_i]2", false},
+	"[gal_us]": {231, "[in_i]3", false},
+}
+
+// specialAtoms are the temperature scales with a shifted zero point
+var specialAtoms = map[string]atom{
+	"Cel":    {dimension: DimensionVector{0, 0, 0, 0, 1}, scale: 1, offset: 273.15, metric: true},
+	"[degF]": {dimension: DimensionVector{0, 0, 0, 0, 1}, scale: 5.0 / 9, offset: 459.67 * 5 / 9},
+}
+
+// atoms holds every supported atom resolved to base units
+var atoms = map[string]atom{}
+
+func init() {
+	for symbol, dim := range baseAtoms {
+		atoms[symbol] = atom{dimension: dim, scale: 1, metric: true}
+	}
+	for symbol, a := range specialAtoms {
+		atoms[symbol] = a
+	}
+	for symbol := range definitions {
+		if _, err := resolveAtom(symbol, map[string]bool{}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// resolveAtom resolves a defined atom, resolving the atoms of its definition first
+func resolveAtom(symbol string, visiting map[string]bool) (atom, error) {
+	if a, ok := atoms[symbol]; ok {
+		return a, nil
+	}
+	def, ok := definitions[symbol]
+	if !ok {
+		return atom{}, fmt.Errorf("unknown atom %q", symbol)
+	}
+	if visiting[symbol] {
+		return atom{}, fmt.Errorf("circular definition of %q", symbol)
+	}
+	visiting[symbol] = true
+
+	p := &ucumParser{input: def.unit, visiting: visiting}
+	term, err := p.mainTerm()
+	if err != nil {
+		return atom{}, fmt.Errorf("invalid definition of %q: %w", symbol, err)
+	}
+	a := atom{dimension: term.Dimension, scale: def.value * term.Scale, metric: def.metric}
+	atoms[symbol] = a
+	return a, nil
+}
+
+// ucumParser is a recursive descent parser over the UCUM grammar:
+//
+//	mainTerm  = "/" term | term
+//	term      = component { ("." | "/") component }
+//	component = annotatable [annotation] | annotation | factor | "(" term ")"
+type ucumParser struct {
+	input    string
+	pos      int
+	visiting map[string]bool
+}
+
+// mainTerm parses the whole input
+func (p *ucumParser) mainTerm() (*Expression, error) {
+	var term *Expression
+	var err error
+	if p.peek() == '/' {
+		p.pos++
+		if term, err = p.term(); err != nil {
+			return nil, err
+		}
+		if term.Offset != 0 {
+			return nil, fmt.Errorf("%s cannot be inverted", p.input)
+		}
+		term = &Expression{Dimension: DimensionVector{}.add(term.Dimension, -1), Scale: 1 / term.Scale}
+	} else if term, err = p.term(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return term, nil
+}
+
+// term parses components joined by multiplication and division
+func (p *ucumParser) term() (*Expression, error) {
+	result, err := p.component()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == '.' || p.peek() == '/' {
+		sign := 1
+		if p.input[p.pos] == '/' {
+			sign = -1
+		}
+		p.pos++
+
+		next, err := p.component()
+		if err != nil {
+			return nil, err
+		}
+		if result.Offset != 0 || next.Offset != 0 {
+			return nil, fmt.Errorf("%s cannot be combined with other units", p.input)
+		}
+		result = &Expression{
+			Dimension: result.Dimension.add(next.Dimension, sign),
+			Scale:     result.Scale * math.Pow(next.Scale, float64(sign)),
+		}
+	}
+	return result, nil
+}
+
+// component parses a unit with its exponent, an annotation, a factor or a parenthesized term
+func (p *ucumParser) component() (*Expression, error) {
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+		}
+		p.pos++
+		return term, p.skipAnnotation()
+	case c == '{':
+		return &Expression{Scale: 1}, p.skipAnnotation()
+	case c >= '0' && c <= '9' && p.isFactor():
+		start := p.pos
+		for p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid factor %q", p.input[start:p.pos])
+		}
+		return &Expression{Scale: n}, p.skipAnnotation()
+	}
+
+	symbol, exp, err := p.annotatable()
+	if err != nil {
+		return nil, err
+	}
+	a, err := p.simpleUnit(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if a.offset != 0 && exp != 1 {
+		return nil, fmt.Errorf("%s cannot be raised to a power", symbol)
+	}
+	return &Expression{
+		Dimension: DimensionVector{}.add(a.dimension, exp),
+		Scale:     math.Pow(a.scale, float64(exp)),
+		Offset:    a.offset,
+	}, p.skipAnnotation()
+}
+
+// isFactor reports whether the digits at the current position form a plain number
+func (p *ucumParser) isFactor() bool {
+	i := p.pos
+	for i < len(p.input) && p.input[i] >= '0' && p.input[i] <= '9' {
+		i++
+	}
+	return i == len(p.input) || strings.IndexByte("./(){", p.input[i]) >= 0
+}
+
+// annotatable reads a unit symbol and its optional signed integer exponent
+func (p *ucumParser) annotatable() (string, int, error) {
+	start, depth := p.pos, 0
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if depth == 0 && strings.IndexByte("./(){", c) >= 0 {
+			break
+		}
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		p.pos++
+	}
+	if depth != 0 {
+		return "", 0, fmt.Errorf("unbalanced brackets in %q", p.input[start:p.pos])
+	}
+
+	symbol, exp := splitExponent(p.input[start:p.pos])
+	if symbol == "" {
+		return "", 0, fmt.Errorf("missing unit at position %d", start)
+	}
+	return symbol, exp, nil
+}
+
+// simpleUnit resolves an atom, optionally preceded by a metric prefix
+func (p *ucumParser) simpleUnit(symbol string) (atom, error) {
+	if a, ok := p.atom(symbol); ok {
+		return a, nil
+	}
+	for _, prefix := range prefixes {
+		rest := strings.TrimPrefix(symbol, prefix.symbol)
+		if rest == symbol || rest == "" {
+			continue
+		}
+		a, ok := p.atom(rest)
+		if !ok || !a.metric {
+			continue
+		}
+		if a.offset != 0 {
+			return atom{}, fmt.Errorf("%s cannot be prefixed", rest)
+		}
+		a.scale *= prefix.factor
+		return a, nil
+	}
+	return atom{}, fmt.Errorf("unit %q: %w", symbol, ErrUnknownUnit)
+}
+
+// atom looks up a resolved atom, resolving definitions while the table is built
+func (p *ucumParser) atom(symbol string) (atom, bool) {
+	if a, ok := atoms[symbol]; ok {
+		return a, true
+	}
+	if p.visiting == nil {
+		return atom{}, false
+	}
+	a, err := resolveAtom(symbol, p.visiting)
+	return a, err == nil
+}
+
+// skipAnnotation skips a curly-brace annotation, which does not affect the unit
+func (p *ucumParser) skipAnnotation() error {
+	if p.peek() != '{' {
+		return nil
+	}
+	end := strings.IndexByte(p.input[p.pos:], '}')
+	if end < 0 {
+		return fmt.Errorf("unterminated annotation at position %d", p.pos)
+	}
+	p.pos += end + 1
+	return nil
+}
+
+// peek returns the current character, or 0 at the end of input
+func (p *ucumParser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
@@ -98,6 +98,7 @@ const COLLECTION_SCHEMA = {
           bsonType: 'object',
           properties: {
             factor: { bsonType: 'number' },
+            offset: { bsonType: 'number' },
             baseUnitUri: { bsonType: 'string' },
             baseUnitCode: { bsonType: 'string' },
             operation: {
@@ -448,7 +449,7 @@ function insertSampleUnits() {
       },
       conversion: {
         toBaseUnit: {
-          factor: 1,
+          factor: 273.15,
           baseUnitUri: 'http://urn.fi/URN:NBN:fi:au:ucum:r27',
           baseUnitCode: 'K',
           operation: 'add'